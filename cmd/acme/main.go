@@ -0,0 +1,10 @@
+package acme
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var Cmd = &cobra.Command{
+	Use:   "acme",
+	Short: "Commands related to ACME.",
+}
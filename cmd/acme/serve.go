@@ -0,0 +1,107 @@
+package acme
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run an ACME (RFC 8555) server that issues from a cert-helper CA.",
+	Long: "Run an ACME (RFC 8555) server that issues from a cert-helper CA.\n\n" +
+		"certbot, lego, cert-manager and other ACME clients can register accounts, place orders and\n" +
+		"prove control with http-01, dns-01 or tls-alpn-01 challenges. Use --skip-validation in sandboxes\n" +
+		"where the server cannot reach the clients. Accounts and orders are kept in acme/state.json, and\n" +
+		"issued certificates are stored with the others of the selected CA so they appear in the dashboard.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		host, _ := cmd.Flags().GetString("host")
+		port, _ := cmd.Flags().GetString("port")
+		baseURL, _ := cmd.Flags().GetString("base-url")
+		tlsCert, _ := cmd.Flags().GetString("tls-cert")
+		issuerType, _ := cmd.Flags().GetString("issuer-type")
+		issuerName, _ := cmd.Flags().GetString("issuer-name")
+		issuerRoot, _ := cmd.Flags().GetString("issuer-root")
+		validityDays, _ := cmd.Flags().GetInt("validity-days")
+		skipValidation, _ := cmd.Flags().GetBool("skip-validation")
+		httpPort, _ := cmd.Flags().GetInt("http-port")
+		tlsPort, _ := cmd.Flags().GetInt("tls-port")
+		dnsResolver, _ := cmd.Flags().GetString("dns-resolver")
+
+		scheme := "http"
+		var tlsConfig *tls.Config
+		if tlsCert != "" {
+			certPath, err := internal.ResolveOutputPath(outputDir, tlsCert)
+			if err != nil {
+				return err
+			}
+			serverCert, err := internal.LoadServerCertificate(outputDir, certPath)
+			if err != nil {
+				return errors.Wrap(err, "Failed to load TLS certificate")
+			}
+			tlsConfig = &tls.Config{Certificates: []tls.Certificate{serverCert}, MinVersion: tls.VersionTLS12}
+			scheme = "https"
+		}
+		address := net.JoinHostPort(host, port)
+		if baseURL == "" {
+			baseURL = fmt.Sprintf("%s://%s", scheme, address)
+		}
+
+		server, err := internal.NewACMEServer(outputDir, internal.ACMEServerOptions{
+			BaseURL:        baseURL,
+			IssuerType:     issuerType,
+			RootName:       issuerRoot,
+			IssuerName:     issuerName,
+			ValidityDays:   validityDays,
+			SkipValidation: skipValidation,
+			HTTPPort:       httpPort,
+			TLSPort:        tlsPort,
+			DNSResolver:    dnsResolver,
+			Logf:           log.Printf,
+		})
+		if err != nil {
+			return errors.Wrap(err, "Failed to start ACME server")
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle(server.Prefix()+"/", server)
+		httpServer := &http.Server{Addr: address, Handler: mux, TLSConfig: tlsConfig}
+
+		fmt.Printf("Starting ACME server on %s://%s\n", scheme, address)
+		fmt.Printf("ACME directory: %s\n", server.DirectoryURL())
+		if skipValidation {
+			fmt.Println("WARNING: Challenge validation is disabled; every order is authorized.")
+		}
+		if tlsConfig != nil {
+			return httpServer.ListenAndServeTLS("", "")
+		}
+		return httpServer.ListenAndServe()
+	},
+}
+
+func init() {
+	Cmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringP("host", "l", "localhost", "Host to serve on")
+	serveCmd.Flags().StringP("port", "p", "14000", "Port to serve on")
+	serveCmd.Flags().String("base-url", "", "Externally visible URL of the server (defaults to the listen address)")
+	serveCmd.Flags().String("tls-cert", "", "Serve HTTPS with this certificate from the output directory (key must sit next to it)")
+	serveCmd.Flags().String("issuer-type", "root", "Issuer type: root or intermediate")
+	serveCmd.Flags().String("issuer-name", "default", "Issuer name (root CA name or intermediate CA name)")
+	serveCmd.Flags().String("issuer-root", "default", "Root CA name when issuer type is intermediate")
+	serveCmd.Flags().IntP("validity-days", "v", 90, "Validity period of issued certificates in days")
+	serveCmd.Flags().Bool("skip-validation", false, "Mark every challenge as valid without contacting the client")
+	serveCmd.Flags().Int("http-port", 80, "Port used to reach http-01 challenge responders")
+	serveCmd.Flags().Int("tls-port", 443, "Port used to reach tls-alpn-01 challenge responders")
+	serveCmd.Flags().String("dns-resolver", "", "DNS server (host[:port]) for dns-01 lookups instead of the system resolver")
+}
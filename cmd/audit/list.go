@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Show the most recent audit log entries.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+		limit, _ := cmd.Flags().GetInt("limit")
+		action, _ := cmd.Flags().GetString("action")
+
+		entries, err := internal.ReadAuditLog(outputDir, 0)
+		if err != nil {
+			return errors.Wrap(err, "Failed to read audit log")
+		}
+		var shown []internal.AuditEntry
+		for _, entry := range entries {
+			if action != "" && entry.Action != action && !strings.HasPrefix(entry.Action, action+".") {
+				continue
+			}
+			shown = append(shown, entry)
+			if limit > 0 && len(shown) == limit {
+				break
+			}
+		}
+		if len(shown) == 0 {
+			fmt.Println("No audit entries found.")
+			return nil
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "SEQ\tTIME\tACTOR\tSOURCE\tACTION\tTARGET\tRESULT\tPARAMETERS")
+		for _, entry := range shown {
+			result := entry.Result
+			if entry.Error != "" {
+				result += ": " + entry.Error
+			}
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.Seq, entry.Time.Local().Format("2006-01-02 15:04:05"),
+				entry.Actor, entry.Source, entry.Action, entry.Target, result, formatParams(entry.Params))
+		}
+		return writer.Flush()
+	},
+}
+
+func formatParams(params map[string]string) string {
+	var parts []string
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		if params[name] != "" {
+			parts = append(parts, name+"="+params[name])
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func init() {
+	Cmd.AddCommand(listCmd)
+	listCmd.Flags().IntP("limit", "n", 50, "Number of entries to show, newest first (0 shows all)")
+	listCmd.Flags().String("action", "", "Only show this action or action group (e.g. certificate, scep.enroll)")
+}
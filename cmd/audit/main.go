@@ -0,0 +1,10 @@
+package audit
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var Cmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect and verify the audit log.",
+}
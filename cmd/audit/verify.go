@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the hash chain of the audit log for tampering.",
+	Long: "Check the hash chain of the audit log for tampering.\n\n" +
+		"Every entry carries the hash of the entry before it, so editing, removing or reordering\n" +
+		"entries is detected. Entries removed from the end of the log leave a valid chain: record\n" +
+		"the last hash printed by this command and pass it with --anchor later to detect that.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+		anchor, _ := cmd.Flags().GetString("anchor")
+
+		result, err := internal.VerifyAuditLog(outputDir)
+		if err != nil {
+			return errors.Wrap(err, "Audit log verification failed")
+		}
+		if anchor != "" {
+			found, err := auditLogContainsHash(outputDir, strings.ToLower(strings.TrimSpace(anchor)))
+			if err != nil {
+				return errors.Wrap(err, "Failed to read audit log")
+			}
+			if !found {
+				return errors.Errorf("anchor %s is not in the audit log; entries were removed or replaced", anchor)
+			}
+		}
+
+		fmt.Printf("Audit log: %s\n", internal.AuditLogPath(outputDir))
+		fmt.Printf("Entries: %d\n", result.Entries)
+		if result.Entries > 0 {
+			fmt.Printf("Last hash: %s\n", result.LastHash)
+		}
+		fmt.Println("Hash chain intact.")
+		return nil
+	},
+}
+
+func auditLogContainsHash(outputDir, hash string) (bool, error) {
+	entries, err := internal.ReadAuditLog(outputDir, 0)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.Hash == hash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func init() {
+	Cmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().String("anchor", "", "Hash of an entry recorded earlier that must still be in the log")
+}
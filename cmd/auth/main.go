@@ -0,0 +1,10 @@
+package auth
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var Cmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Manage dashboard users and API tokens.",
+}
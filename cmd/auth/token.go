@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage API tokens.",
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create an API token; send it as 'Authorization: Bearer <token>'.",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		var name string
+		if len(args) > 0 {
+			name = args[0]
+		}
+		role, _ := cmd.Flags().GetString("role")
+		token, secret, err := internal.NewAuthStore(outputDir).CreateToken(name, role)
+		if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{
+			Action: internal.AuditCreateToken,
+			Target: token.ID,
+			Params: map[string]string{"name": name, "role": role},
+			Err:    err,
+		}); auditErr != nil {
+			cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Failed to create token")
+		}
+		fmt.Printf("Token %s (%s) created. It is only shown once:\n%s\n", token.ID, token.Role, secret)
+		return nil
+	},
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API tokens.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		tokens, err := internal.NewAuthStore(outputDir).Tokens()
+		if err != nil {
+			return errors.Wrap(err, "Failed to read tokens")
+		}
+		if len(tokens) == 0 {
+			fmt.Println("No tokens found.")
+			return nil
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tNAME\tROLE\tCREATED")
+		for _, token := range tokens {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", token.ID, token.Name, token.Role, token.CreatedAt.Local().Format("2006-01-02 15:04"))
+		}
+		return writer.Flush()
+	},
+}
+
+var tokenDeleteCmd = &cobra.Command{
+	Use:   "delete <id>",
+	Short: "Delete an API token.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		err = internal.NewAuthStore(outputDir).DeleteToken(args[0])
+		if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{Action: internal.AuditDeleteToken, Target: args[0], Err: err}); auditErr != nil {
+			cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Failed to delete token")
+		}
+		fmt.Printf("Token %s deleted.\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	Cmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenCreateCmd, tokenListCmd, tokenDeleteCmd)
+	tokenCreateCmd.Flags().String("role", internal.RoleViewer, "Role: "+strings.Join(internal.Roles, ", "))
+}
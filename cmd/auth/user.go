@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage dashboard users.",
+}
+
+var userAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add a dashboard user, or change the password and role of an existing one.",
+	Long: "Add a dashboard user, or change the password and role of an existing one.\n\n" +
+		"Passwords are stored as bcrypt hashes in auth.json in the output directory. --client-cert pins a\n" +
+		"PEM client certificate (relative to the output directory or absolute) to the user for\n" +
+		"'serve --auth client-cert'; its common name does not matter. A user added without a password can\n" +
+		"only sign in with a pinned client certificate.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		role, _ := cmd.Flags().GetString("role")
+		clientCerts, _ := cmd.Flags().GetStringArray("client-cert")
+		password, _ := cmd.Flags().GetString("password")
+		passwordStdin, _ := cmd.Flags().GetBool("password-stdin")
+		if passwordStdin {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return errors.Wrap(err, "Failed to read password from stdin")
+			}
+			password = strings.TrimRight(line, "\r\n")
+		}
+
+		certs, err := loadClientCertificates(outputDir, clientCerts)
+		if err != nil {
+			return err
+		}
+		store := internal.NewAuthStore(outputDir)
+		user, err := store.AddUser(args[0], password, role)
+		var fingerprints []string
+		for i := 0; err == nil && i < len(certs); i++ {
+			if err = store.PinClientCertificate(user.Name, certs[i]); err == nil {
+				fingerprints = append(fingerprints, internal.CertificateFingerprint(certs[i]))
+			}
+		}
+		params := map[string]string{"role": role}
+		if len(fingerprints) > 0 {
+			params["client_cert_sha256"] = strings.Join(fingerprints, ", ")
+		}
+		if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{Action: internal.AuditAddUser, Target: args[0], Params: params, Err: err}); auditErr != nil {
+			cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Failed to add user")
+		}
+		for _, fingerprint := range fingerprints {
+			fmt.Printf("Pinned client certificate %s\n", fingerprint)
+		}
+		if user.PasswordHash == "" {
+			fmt.Printf("User %s (%s) saved; it can only sign in with a pinned client certificate.\n", user.Name, user.Role)
+		} else {
+			fmt.Printf("User %s (%s) saved.\n", user.Name, user.Role)
+		}
+		return nil
+	},
+}
+
+// loadClientCertificates loads the PEM client certificates at certPaths, relative to the output
+// directory or absolute, before anything is saved.
+func loadClientCertificates(outputDir string, certPaths []string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, certPath := range certPaths {
+		if !filepath.IsAbs(certPath) {
+			certPath = filepath.Join(outputDir, certPath)
+		}
+		cert, err := internal.LoadCertificate(certPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to load client certificate %s", certPath)
+		}
+		if cert.IsCA {
+			return nil, errors.Errorf("%s is a CA certificate and cannot be used as a client certificate", certPath)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+var userListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List dashboard users.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		users, err := internal.NewAuthStore(outputDir).Users()
+		if err != nil {
+			return errors.Wrap(err, "Failed to read users")
+		}
+		if len(users) == 0 {
+			fmt.Println("No users found.")
+			return nil
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tROLE\tPASSWORD\tCLIENT CERTS\tCREATED")
+		for _, user := range users {
+			password := "yes"
+			if user.PasswordHash == "" {
+				password = "no"
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%s\n", user.Name, user.Role, password, len(user.ClientCertificates), user.CreatedAt.Local().Format("2006-01-02 15:04"))
+		}
+		return writer.Flush()
+	},
+}
+
+var userDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete a dashboard user; its sessions end with its next request.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		err = internal.NewAuthStore(outputDir).DeleteUser(args[0])
+		if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{Action: internal.AuditDeleteUser, Target: args[0], Err: err}); auditErr != nil {
+			cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Failed to delete user")
+		}
+		fmt.Printf("User %s deleted.\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	Cmd.AddCommand(userCmd)
+	userCmd.AddCommand(userAddCmd, userListCmd, userDeleteCmd)
+	userAddCmd.Flags().String("role", internal.RoleViewer, "Role: "+strings.Join(internal.Roles, ", "))
+	userAddCmd.Flags().String("password", "", "Password (visible in the process list; prefer --password-stdin)")
+	userAddCmd.Flags().Bool("password-stdin", false, "Read the password from the first line of stdin")
+	userAddCmd.Flags().StringArray("client-cert", nil, "PEM client certificate the user signs in with (repeatable)")
+	userAddCmd.MarkFlagsMutuallyExclusive("password", "password-stdin")
+}
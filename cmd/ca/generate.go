@@ -2,6 +2,7 @@ package ca
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/Ctere1/cert-helper/internal"
 	"github.com/pkg/errors"
@@ -53,6 +54,14 @@ var caGenerateCmd = &cobra.Command{
 		}
 
 		certPath, keyPath, err := internal.GenerateRootCA(outputDir, caName, subject, caValidityDays)
+		if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{
+			Action: internal.AuditCreateRoot,
+			Target: internal.NormalizeName(caName, "default"),
+			Params: map[string]string{"subject": subject.PKIXName().String(), "validity_days": strconv.Itoa(caValidityDays)},
+			Err:    err,
+		}); auditErr != nil {
+			cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+		}
 		if err != nil {
 			return errors.Wrap(err, "Failed to generate CA")
 		}
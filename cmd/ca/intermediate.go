@@ -2,6 +2,7 @@ package ca
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/Ctere1/cert-helper/internal"
 	"github.com/pkg/errors"
@@ -54,6 +55,14 @@ var intermediateGenerateCmd = &cobra.Command{
 		}
 
 		certPath, keyPath, err := internal.GenerateIntermediateCA(outputDir, intermediateRootName, intermediateName, subject, intermediateValidityDays)
+		if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{
+			Action: internal.AuditCreateIntermediate,
+			Target: intermediateRootName + "/" + intermediateName,
+			Params: map[string]string{"subject": subject.PKIXName().String(), "validity_days": strconv.Itoa(intermediateValidityDays)},
+			Err:    err,
+		}); auditErr != nil {
+			cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+		}
 		if err != nil {
 			return errors.Wrap(err, "Failed to generate intermediate CA")
 		}
@@ -0,0 +1,166 @@
+package ca
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorGreen  = "\033[32m"
+	colorDim    = "\033[2m"
+)
+
+var treeCmd = &cobra.Command{
+	Use:   "tree",
+	Short: "Show the CA hierarchy with every certificate below the CA that signed it.",
+	Long: `Show the CA hierarchy of the output directory. Certificates are placed under the CA whose
+key signed them, matched by authority and subject key identifiers, so imported and
+cross-signed certificates appear where they belong regardless of the folder they are in.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+		showPaths, _ := cmd.Flags().GetBool("paths")
+		caOnly, _ := cmd.Flags().GetBool("ca-only")
+		noColor, _ := cmd.Flags().GetBool("no-color")
+
+		tree, err := internal.BuildCertificateTree(outputDir)
+		if err != nil {
+			return errors.Wrap(err, "Failed to read certificates")
+		}
+		if len(tree.Roots) == 0 {
+			fmt.Println("No certificates found.")
+			return nil
+		}
+
+		printer := treePrinter{
+			out:       os.Stdout,
+			color:     !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout),
+			showPaths: showPaths,
+			caOnly:    caOnly,
+		}
+		for _, root := range tree.Roots {
+			if caOnly && !root.Certificate.IsCA {
+				continue
+			}
+			printer.print(root, "", "")
+		}
+
+		fmt.Println()
+		summary := fmt.Sprintf("%s, %s, %s",
+			plural(tree.RootCAs, "root CA", "root CAs"),
+			plural(tree.Intermediates, "intermediate CA", "intermediate CAs"),
+			plural(tree.Leaves, "certificate", "certificates"))
+		if tree.Orphans > 0 {
+			summary += fmt.Sprintf(" (%d without an issuer in the output directory)", tree.Orphans)
+		}
+		fmt.Println(summary)
+		fmt.Printf("%d valid, %d expiring within %d days, %d expired\n", tree.Valid, tree.Expiring, internal.ExpiringSoonDays, tree.Expired)
+		return nil
+	},
+}
+
+type treePrinter struct {
+	out       io.Writer
+	color     bool
+	showPaths bool
+	caOnly    bool
+}
+
+func (p treePrinter) print(node *internal.CertificateTreeNode, prefix, branch string) {
+	line := prefix + branch + node.Name
+	details := []string{node.Kind(), node.KeyType, p.expiry(node)}
+	if node.Orphan {
+		details = append(details, "issuer not found")
+	}
+	line += " [" + strings.Join(details, ", ") + "]"
+	if counts := nodeCounts(node); counts != "" {
+		line += " (" + counts + ")"
+	}
+	if p.showPaths {
+		line += " " + p.paint(colorDim, node.Path)
+	}
+	fmt.Fprintln(p.out, line)
+
+	children := node.Children
+	if p.caOnly {
+		children = nil
+		for _, child := range node.Children {
+			if child.Certificate.IsCA {
+				children = append(children, child)
+			}
+		}
+	}
+	switch branch {
+	case "├── ":
+		prefix += "│   "
+	case "└── ":
+		prefix += "    "
+	}
+	for i, child := range children {
+		if i == len(children)-1 {
+			p.print(child, prefix, "└── ")
+		} else {
+			p.print(child, prefix, "├── ")
+		}
+	}
+}
+
+func (p treePrinter) expiry(node *internal.CertificateTreeNode) string {
+	date := node.Certificate.NotAfter.Format("2006-01-02")
+	switch node.Status {
+	case internal.ExpiryExpired:
+		return p.paint(colorRed, "expired "+date)
+	case internal.ExpiryExpiring:
+		return p.paint(colorYellow, "expires "+date)
+	default:
+		return p.paint(colorGreen, "valid until "+date)
+	}
+}
+
+func (p treePrinter) paint(color, text string) string {
+	if !p.color {
+		return text
+	}
+	return color + text + colorReset
+}
+
+func nodeCounts(node *internal.CertificateTreeNode) string {
+	var parts []string
+	if node.Intermediates > 0 {
+		parts = append(parts, plural(node.Intermediates, "intermediate", "intermediates"))
+	}
+	if node.Leaves > 0 {
+		parts = append(parts, plural(node.Leaves, "certificate", "certificates"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func plural(count int, singular, pluralForm string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, singular)
+	}
+	return fmt.Sprintf("%d %s", count, pluralForm)
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func init() {
+	Cmd.AddCommand(treeCmd)
+	treeCmd.Flags().Bool("paths", false, "Show the file of every certificate")
+	treeCmd.Flags().Bool("ca-only", false, "Only show CA certificates; end-entity certificates are still counted")
+	treeCmd.Flags().Bool("no-color", false, "Do not colour expiry dates (also disabled by NO_COLOR or when not writing to a terminal)")
+}
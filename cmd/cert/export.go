@@ -0,0 +1,148 @@
+package cert
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var certExportCmd = &cobra.Command{
+	Use:   "export [certificate path]",
+	Short: "Export a certificate with its chain in another format.",
+	Long: "Export a certificate with its chain in another format.\n\n" +
+		"The certificate is selected by its path relative to the output directory, or by --common-name\n" +
+		"together with the issuer flags used for 'cert generate'. Truststore formats take CAs from --ca\n" +
+		"(root:<name> or intermediate:<root>:<name>) and default to every root CA. Supported formats: " +
+		strings.Join(internal.ExportFormats, ", ") + ".",
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		format, _ := cmd.Flags().GetString("format")
+		out, _ := cmd.Flags().GetString("out")
+		cn, _ := cmd.Flags().GetString("common-name")
+		issuerType, _ := cmd.Flags().GetString("issuer-type")
+		issuerName, _ := cmd.Flags().GetString("issuer-name")
+		issuerRoot, _ := cmd.Flags().GetString("issuer-root")
+		pfxPassword, _ := cmd.Flags().GetString("pfx-password")
+		friendlyName, _ := cmd.Flags().GetString("friendly-name")
+		pfxEncoding, _ := cmd.Flags().GetString("pfx-encoding")
+		alias, _ := cmd.Flags().GetString("alias")
+		storePassword, _ := cmd.Flags().GetString("store-password")
+		caSelectors, _ := cmd.Flags().GetStringSlice("ca")
+
+		format, err = internal.NormalizeExportFormat(format)
+		if err != nil {
+			return err
+		}
+		if len(caSelectors) > 0 {
+			switch {
+			case format != internal.ExportTruststoreJKS && format != internal.ExportTruststorePKCS12:
+				return errors.New("--ca only applies to the truststore-jks and truststore-p12 formats")
+			case len(args) > 0 || cn != "":
+				return errors.New("--ca cannot be combined with a certificate path or --common-name")
+			}
+		}
+
+		options := internal.ExportOptions{
+			Format:       format,
+			Password:     pfxPassword,
+			FriendlyName: friendlyName,
+			Alias:        alias,
+			PFXEncoding:  pfxEncoding,
+		}
+		if storePassword != "" {
+			options.Password = storePassword
+		}
+
+		var result internal.ExportResult
+		var certDir string
+		target := "trust store"
+		switch {
+		case format == internal.ExportTrustBundle:
+			result, err = internal.ExportTrustBundleFile(outputDir)
+			certDir = outputDir
+		case internal.IsStoreFormat(format) && len(args) == 0:
+			var caPaths []string
+			for _, selector := range caSelectors {
+				ca, findErr := internal.FindCA(outputDir, selector)
+				if findErr != nil {
+					return findErr
+				}
+				caPaths = append(caPaths, ca.CertPath)
+			}
+			result, err = internal.ExportTruststore(outputDir, caPaths, format, options)
+			certDir = outputDir
+		default:
+			certPath, resolveErr := resolveCertificatePath(outputDir, args, cn, issuerType, issuerRoot, issuerName)
+			if resolveErr != nil {
+				return resolveErr
+			}
+			result, err = internal.ExportCertificate(outputDir, certPath, options)
+			if rel, relErr := filepath.Rel(outputDir, certPath); relErr == nil {
+				target = filepath.ToSlash(rel)
+			}
+			certDir = filepath.Dir(certPath)
+		}
+		if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{
+			Action: internal.AuditExportCertificate,
+			Target: target,
+			Params: map[string]string{"format": format},
+			Err:    err,
+		}); auditErr != nil {
+			cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Failed to export certificate")
+		}
+
+		if out == "" {
+			out = filepath.Join(certDir, result.FileName)
+		}
+		if err := internal.WriteExport(outputDir, out, result); err != nil {
+			return errors.Wrap(err, "Failed to write export")
+		}
+
+		fmt.Printf("Exported %s: %s\n", format, out)
+		if result.PFXEncoding != "" {
+			fmt.Printf("PKCS#12 encoding: %s (%s)\n", result.PFXEncoding, internal.PFXEncodingDescription(result.PFXEncoding))
+		}
+		return nil
+	},
+}
+
+func resolveCertificatePath(outputDir string, args []string, cn, issuerType, issuerRoot, issuerName string) (string, error) {
+	if len(args) > 0 {
+		return internal.ResolveOutputPath(outputDir, args[0])
+	}
+	if cn == "" {
+		return "", errors.New("certificate path or --common-name is required")
+	}
+	if issuerType == "intermediate" && issuerRoot == "" {
+		issuerRoot = "default"
+	}
+	return internal.CertificatePath(outputDir, issuerType, issuerRoot, issuerName, cn), nil
+}
+
+func init() {
+	Cmd.AddCommand(certExportCmd)
+	certExportCmd.Flags().StringP("format", "f", internal.ExportFullChain, "Export format: "+strings.Join(internal.ExportFormats, ", "))
+	certExportCmd.Flags().String("out", "", "Output file (defaults to a file next to the certificate)")
+	certExportCmd.Flags().String("common-name", "", "Common Name (CN) of the certificate to export")
+	certExportCmd.Flags().String("issuer-type", "root", "Issuer type: root or intermediate")
+	certExportCmd.Flags().String("issuer-name", "default", "Issuer name (root CA name or intermediate CA name)")
+	certExportCmd.Flags().String("issuer-root", "default", "Root CA name when issuer type is intermediate")
+	certExportCmd.Flags().String("pfx-password", "", "Password for the PKCS#12 bundle")
+	certExportCmd.Flags().String("pfx-encoding", internal.PFXEncodingLegacy, "PKCS#12 encoding: "+strings.Join(internal.PFXEncodings, ", "))
+	certExportCmd.Flags().String("friendly-name", "", "Friendly name stored in the PKCS#12 bundle (defaults to the CN)")
+	certExportCmd.Flags().String("alias", "", "Keystore or truststore alias (defaults to the CN or CA name)")
+	certExportCmd.Flags().String("store-password", "", "Keystore/truststore password (JKS defaults to '"+internal.DefaultStorePassword+"')")
+	certExportCmd.Flags().StringSlice("ca", []string{}, "CAs to include in a truststore (root:<name> or intermediate:<root>:<name>)")
+}
@@ -2,6 +2,8 @@ package cert
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/Ctere1/cert-helper/internal"
 	"github.com/pkg/errors"
@@ -29,6 +31,7 @@ var certGenerateCmd = &cobra.Command{
 		issuerRoot, _ := cmd.Flags().GetString("issuer-root")
 		subjectAltNames, _ := cmd.Flags().GetStringSlice("subject-alt-names")
 		pfxPassword, _ := cmd.Flags().GetString("pfx-password")
+		pfxEncoding, _ := cmd.Flags().GetString("pfx-encoding")
 		validityDays, _ := cmd.Flags().GetInt("validity-days")
 
 		subject := internal.Subject{
@@ -50,7 +53,28 @@ var certGenerateCmd = &cobra.Command{
 			issuerRoot = "default"
 		}
 
-		certPath, keyPath, pfxPath, err := internal.GenerateCertificate(outputDir, issuerType, issuerRoot, issuerName, subject, subjectAltNames, validityDays, pfxPassword)
+		pfxEncoding, err = internal.NormalizePFXEncoding(pfxEncoding)
+		if err != nil {
+			return err
+		}
+
+		options := internal.DefaultCertificateOptions()
+		options.PFXEncoding = pfxEncoding
+		certPath, keyPath, pfxPath, err := internal.GenerateCertificateWithOptions(outputDir, issuerType, issuerRoot, issuerName, subject, subjectAltNames, validityDays, pfxPassword, options)
+		if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{
+			Action: internal.AuditIssueCertificate,
+			Target: subject.CommonName,
+			Params: map[string]string{
+				"issuer_type":       issuerType,
+				"issuer_root":       issuerRoot,
+				"issuer_name":       issuerName,
+				"subject_alt_names": strings.Join(subjectAltNames, ", "),
+				"validity_days":     strconv.Itoa(validityDays),
+			},
+			Err: err,
+		}); auditErr != nil {
+			cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+		}
 		if err != nil {
 			return errors.Wrap(err, "Failed to generate certificate")
 		}
@@ -58,6 +82,7 @@ var certGenerateCmd = &cobra.Command{
 		fmt.Printf("Certificate generated successfully: %s\n", certPath)
 		fmt.Printf("Certificate private key: %s\n", keyPath)
 		fmt.Printf("Certificate PFX bundle: %s\n", pfxPath)
+		fmt.Printf("Certificate PFX encoding: %s (%s)\n", pfxEncoding, internal.PFXEncodingDescription(pfxEncoding))
 		return nil
 	},
 }
@@ -66,6 +91,7 @@ func init() {
 	Cmd.AddCommand(certGenerateCmd)
 	certGenerateCmd.Flags().StringSlice("subject-alt-names", []string{}, "Subject Alternative Names")
 	certGenerateCmd.Flags().String("pfx-password", "", "Password for PFX file")
+	certGenerateCmd.Flags().String("pfx-encoding", internal.PFXEncodingLegacy, "PFX encoding: "+strings.Join(internal.PFXEncodings, ", "))
 	certGenerateCmd.Flags().IntP("validity-days", "v", 365, "Validity period in days")
 	certGenerateCmd.Flags().String("common-name", "", "Common Name (CN)")
 	certGenerateCmd.Flags().String("organization", "", "Organization (O)")
@@ -0,0 +1,130 @@
+package cert
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var certGenerateInvalidCmd = &cobra.Command{
+	Use:   "generate-invalid [common name]",
+	Short: "Generate a deliberately broken certificate for negative testing.",
+	Long: "Generate a deliberately broken certificate for negative testing.\n\n" +
+		"The certificate is issued from the selected CA with exactly one defect, so servers and\n" +
+		"supplicants can be checked for rejecting it. Use --defect all to generate every variant.\n\n" +
+		"Defects:\n" + defectHelp(),
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		defect, _ := cmd.Flags().GetString("defect")
+		cn, _ := cmd.Flags().GetString("common-name")
+		org, _ := cmd.Flags().GetString("organization")
+		orgUnit, _ := cmd.Flags().GetString("organizational-unit")
+		country, _ := cmd.Flags().GetString("country")
+		state, _ := cmd.Flags().GetString("state")
+		locality, _ := cmd.Flags().GetString("locality")
+		issuerType, _ := cmd.Flags().GetString("issuer-type")
+		issuerName, _ := cmd.Flags().GetString("issuer-name")
+		issuerRoot, _ := cmd.Flags().GetString("issuer-root")
+		subjectAltNames, _ := cmd.Flags().GetStringSlice("subject-alt-names")
+		pfxPassword, _ := cmd.Flags().GetString("pfx-password")
+		pfxEncoding, _ := cmd.Flags().GetString("pfx-encoding")
+		validityDays, _ := cmd.Flags().GetInt("validity-days")
+
+		subject := internal.Subject{
+			CommonName:         cn,
+			Organization:       org,
+			OrganizationalUnit: orgUnit,
+			Country:            country,
+			Province:           state,
+			Locality:           locality,
+		}
+		if subject.CommonName == "" && len(args) > 0 {
+			subject.CommonName = args[0]
+		}
+
+		defects := []string{defect}
+		if strings.EqualFold(strings.TrimSpace(defect), "all") {
+			if subject.CommonName != "" {
+				return errors.New("--defect all names each certificate after its defect; omit the common name")
+			}
+			defects = internal.CertificateDefects
+		} else if defect == "" {
+			return errors.New("--defect is required")
+		}
+
+		if issuerType == "intermediate" && issuerRoot == "" {
+			issuerRoot = "default"
+		}
+
+		for i, defect := range defects {
+			result, err := internal.GenerateInvalidCertificate(outputDir, internal.InvalidCertificateOptions{
+				Defect:          defect,
+				IssuerType:      issuerType,
+				RootName:        issuerRoot,
+				IssuerName:      issuerName,
+				Subject:         subject,
+				SubjectAltNames: subjectAltNames,
+				ValidityDays:    validityDays,
+				PFXPassword:     pfxPassword,
+				PFXEncoding:     pfxEncoding,
+			})
+			if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{
+				Action: internal.AuditIssueInvalid,
+				Target: defect,
+				Params: map[string]string{"common_name": subject.CommonName, "issuer_type": issuerType, "issuer_name": issuerName},
+				Err:    err,
+			}); auditErr != nil {
+				cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "Failed to generate %s certificate", defect)
+			}
+
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("Defect: %s (%s)\n", result.Defect, internal.CertificateDefectDescription(result.Defect))
+			fmt.Printf("Certificate generated successfully: %s\n", result.CertPath)
+			fmt.Printf("Certificate private key: %s\n", result.KeyPath)
+			fmt.Printf("Certificate PFX bundle: %s\n", result.PFXPath)
+			if result.CRLPath != "" {
+				fmt.Printf("Issuer CRL: %s\n", result.CRLPath)
+			}
+		}
+		return nil
+	},
+}
+
+func defectHelp() string {
+	var builder strings.Builder
+	for _, defect := range internal.CertificateDefects {
+		fmt.Fprintf(&builder, "  %-24s %s\n", defect, internal.CertificateDefectDescription(defect))
+	}
+	return builder.String()
+}
+
+func init() {
+	Cmd.AddCommand(certGenerateInvalidCmd)
+	certGenerateInvalidCmd.Flags().String("defect", "", "Defect to introduce: "+strings.Join(internal.CertificateDefects, ", ")+" or all")
+	certGenerateInvalidCmd.Flags().StringSlice("subject-alt-names", []string{}, "Subject Alternative Names")
+	certGenerateInvalidCmd.Flags().String("pfx-password", "", "Password for PFX file")
+	certGenerateInvalidCmd.Flags().String("pfx-encoding", internal.PFXEncodingLegacy, "PFX encoding: "+strings.Join(internal.PFXEncodings, ", "))
+	certGenerateInvalidCmd.Flags().IntP("validity-days", "v", 365, "Validity period in days")
+	certGenerateInvalidCmd.Flags().String("common-name", "", "Common Name (CN), defaults to invalid-<defect>")
+	certGenerateInvalidCmd.Flags().String("organization", "", "Organization (O)")
+	certGenerateInvalidCmd.Flags().String("organizational-unit", "", "Organizational Unit (OU)")
+	certGenerateInvalidCmd.Flags().String("country", "", "Country (C)")
+	certGenerateInvalidCmd.Flags().String("state", "", "State/Province (ST)")
+	certGenerateInvalidCmd.Flags().String("locality", "", "Locality (L)")
+	certGenerateInvalidCmd.Flags().String("issuer-type", "root", "Issuer type: root or intermediate")
+	certGenerateInvalidCmd.Flags().String("issuer-name", "default", "Issuer name (root CA name or intermediate CA name)")
+	certGenerateInvalidCmd.Flags().String("issuer-root", "default", "Root CA name when issuer type is intermediate")
+}
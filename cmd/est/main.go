@@ -0,0 +1,10 @@
+package est
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var Cmd = &cobra.Command{
+	Use:   "est",
+	Short: "Commands related to EST.",
+}
@@ -0,0 +1,102 @@
+package est
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run an EST (RFC 7030) enrollment server that issues from a cert-helper CA.",
+	Long: "Run an EST (RFC 7030) enrollment server that issues from a cert-helper CA.\n\n" +
+		"The server presents --cert over TLS and answers /cacerts, /simpleenroll, /simplereenroll and\n" +
+		"/csrattrs under /.well-known/est. Enrollment is authorized by HTTP basic credentials from --user\n" +
+		"or, with --client-cert-auth, by a client certificate issued by a CA selected with --ca (default\n" +
+		"all). Re-enrollment always requires the certificate being renewed. Issued certificates are stored\n" +
+		"with the others of the selected CA so they appear in the dashboard.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		certFlag, _ := cmd.Flags().GetString("cert")
+		listen, _ := cmd.Flags().GetString("listen")
+		issuerType, _ := cmd.Flags().GetString("issuer-type")
+		issuerName, _ := cmd.Flags().GetString("issuer-name")
+		issuerRoot, _ := cmd.Flags().GetString("issuer-root")
+		validityDays, _ := cmd.Flags().GetInt("validity-days")
+		userFlags, _ := cmd.Flags().GetStringArray("user")
+		clientCertAuth, _ := cmd.Flags().GetBool("client-cert-auth")
+		trustCAs, _ := cmd.Flags().GetStringSlice("ca")
+		crlFiles, _ := cmd.Flags().GetStringSlice("crl-file")
+
+		if certFlag == "" {
+			return errors.New("--cert is required")
+		}
+		certPath, err := internal.ResolveOutputPath(outputDir, certFlag)
+		if err != nil {
+			return err
+		}
+		trustPaths, err := internal.FindCACertPaths(outputDir, trustCAs)
+		if err != nil {
+			return err
+		}
+		users := make(map[string]string)
+		for _, user := range userFlags {
+			name, password, ok := strings.Cut(user, ":")
+			if !ok || name == "" {
+				return errors.Errorf("invalid --user %q, expected name:password", user)
+			}
+			users[name] = password
+		}
+
+		server, err := internal.ListenEST(outputDir, internal.ESTServerOptions{
+			CertPath:       certPath,
+			Address:        listen,
+			IssuerType:     issuerType,
+			RootName:       issuerRoot,
+			IssuerName:     issuerName,
+			ValidityDays:   validityDays,
+			Users:          users,
+			ClientCertAuth: clientCertAuth,
+			TrustCertPaths: trustPaths,
+			CRLFiles:       crlFiles,
+			Logf:           log.Printf,
+		})
+		if err != nil {
+			return errors.Wrap(err, "Failed to start EST server")
+		}
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			_ = server.Close()
+		}()
+
+		fmt.Printf("EST server listening on https://%s/.well-known/est/. Press Ctrl+C to stop.\n", server.Addr())
+		return server.Serve()
+	},
+}
+
+func init() {
+	Cmd.AddCommand(serveCmd)
+	serveCmd.Flags().String("cert", "", "Server certificate path relative to the output directory (key must sit next to it)")
+	serveCmd.Flags().String("listen", "127.0.0.1:9443", "Address to listen on")
+	serveCmd.Flags().String("issuer-type", "root", "Issuer type: root or intermediate")
+	serveCmd.Flags().String("issuer-name", "default", "Issuer name (root CA name or intermediate CA name)")
+	serveCmd.Flags().String("issuer-root", "default", "Root CA name when issuer type is intermediate")
+	serveCmd.Flags().IntP("validity-days", "v", 365, "Validity period of issued certificates in days")
+	serveCmd.Flags().StringArray("user", []string{}, "HTTP basic credentials accepted for enrollment as name:password (repeatable)")
+	serveCmd.Flags().Bool("client-cert-auth", false, "Accept a trusted client certificate instead of HTTP basic credentials for enrollment")
+	serveCmd.Flags().StringSlice("ca", []string{}, "CAs trusted for client certificates (root:<name> or intermediate:<root>:<name>, defaults to all)")
+	serveCmd.Flags().StringSlice("crl-file", []string{}, "PEM or DER CRL files checked against client chains")
+}
@@ -0,0 +1,10 @@
+package metrics
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var Cmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Export certificate metrics to Prometheus.",
+}
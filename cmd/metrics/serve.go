@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve Prometheus metrics for the certificates of the output directory.",
+	Long: "Serve Prometheus metrics for the certificates of the output directory at /metrics.\n\n" +
+		"This is the endpoint 'cert-helper serve' also provides, for output directories that are not served\n" +
+		"by a dashboard. It reports the expiry time of every certificate and the number of valid, expiring\n" +
+		"and expired certificates. Issuance and revocation counters cover the changes seen between scrapes\n" +
+		"since the command started; SCEP request metrics are only available from 'cert-helper serve'.\n\n" +
+		"The endpoint has no authentication. Certificate names and paths are visible to anyone who can\n" +
+		"reach it, so keep the default localhost listener or a trusted network.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+		host, _ := cmd.Flags().GetString("host")
+		port, _ := cmd.Flags().GetString("port")
+
+		metrics := internal.NewMetrics()
+		index := internal.NewCertificateIndex(outputDir, metrics.RecordIndexUpdate)
+		if err := index.Refresh(time.Now()); err != nil {
+			return errors.Wrap(err, "Failed to index certificates")
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler(index))
+		fmt.Printf("Serving metrics for %s on http://%s:%s/metrics\n", outputDir, host, port)
+		return http.ListenAndServe(host+":"+port, mux)
+	},
+}
+
+func init() {
+	Cmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringP("port", "p", "8002", "Port to serve on")
+	serveCmd.Flags().StringP("host", "l", "localhost", "Host to serve on")
+}
@@ -0,0 +1,88 @@
+package radius
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var freeRADIUSConfigCmd = &cobra.Command{
+	Use:   "freeradius-config",
+	Short: "Generate a FreeRADIUS EAP-TLS configuration from the output directory.",
+	Long: "Generate a FreeRADIUS EAP-TLS configuration from the output directory.\n\n" +
+		"The destination is laid out like a raddb directory (mods-available/eap, sites-available/check-eap-tls,\n" +
+		"policy.d/cert-helper and certs/cert-helper) so it can be mounted into a FreeRADIUS container.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		serverCert, _ := cmd.Flags().GetString("server-cert")
+		trustCAs, _ := cmd.Flags().GetStringSlice("ca")
+		clientIssuers, _ := cmd.Flags().GetStringSlice("client-issuer")
+		crlFile, _ := cmd.Flags().GetString("crl-file")
+		ocspURL, _ := cmd.Flags().GetString("ocsp-url")
+		tlsMinVersion, _ := cmd.Flags().GetString("tls-min-version")
+		destination, _ := cmd.Flags().GetString("dest")
+
+		if serverCert == "" {
+			return errors.New("--server-cert is required")
+		}
+		serverCertPath, err := internal.ResolveOutputPath(outputDir, serverCert)
+		if err != nil {
+			return err
+		}
+		trustPaths, err := internal.FindCACertPaths(outputDir, trustCAs)
+		if err != nil {
+			return err
+		}
+		issuerPaths, err := internal.FindCACertPaths(outputDir, clientIssuers)
+		if err != nil {
+			return err
+		}
+		if destination == "" {
+			destination = filepath.Join(outputDir, "radius", "freeradius")
+		}
+
+		files, err := internal.GenerateFreeRADIUSConfig(outputDir, internal.FreeRADIUSOptions{
+			ServerCertPath:    serverCertPath,
+			TrustCertPaths:    trustPaths,
+			ClientIssuerPaths: issuerPaths,
+			CRLFile:           crlFile,
+			OCSPURL:           ocspURL,
+			TLSMinVersion:     tlsMinVersion,
+			Destination:       destination,
+		})
+		if err != nil {
+			return errors.Wrap(err, "Failed to generate FreeRADIUS configuration")
+		}
+
+		for _, file := range files {
+			fmt.Printf("Wrote %s\n", file)
+		}
+		fmt.Println()
+		fmt.Println("Mount the generated files into a FreeRADIUS 3 container, for example:")
+		fmt.Printf("  docker run --rm -p 1812:1812/udp \\\n")
+		for _, dir := range []string{"mods-available/eap", "sites-available/check-eap-tls", "policy.d/cert-helper", "certs/cert-helper"} {
+			fmt.Printf("    -v %s:/etc/raddb/%s \\\n", filepath.Join(destination, filepath.FromSlash(dir)), dir)
+		}
+		fmt.Println("    freeradius/freeradius-server -X")
+		fmt.Println("and link sites-available/check-eap-tls into sites-enabled.")
+		return nil
+	},
+}
+
+func init() {
+	Cmd.AddCommand(freeRADIUSConfigCmd)
+	freeRADIUSConfigCmd.Flags().String("server-cert", "", "RADIUS server certificate, relative to the output directory")
+	freeRADIUSConfigCmd.Flags().StringSlice("ca", []string{}, "CAs trusted for client certificates (root:<name> or intermediate:<root>:<name>); defaults to the server chain")
+	freeRADIUSConfigCmd.Flags().StringSlice("client-issuer", []string{}, "CAs allowed to issue client certificates; defaults to the trusted intermediates")
+	freeRADIUSConfigCmd.Flags().String("crl-file", "", "PEM CRL of the client issuing CA, appended to the CA file; enables check_crl")
+	freeRADIUSConfigCmd.Flags().String("ocsp-url", "", "OCSP responder URL; enables OCSP checking")
+	freeRADIUSConfigCmd.Flags().String("tls-min-version", "1.2", "Minimum TLS version (1.0, 1.1, 1.2 or 1.3)")
+	freeRADIUSConfigCmd.Flags().String("dest", "", "Destination directory (defaults to <output-dir>/radius/freeradius)")
+}
@@ -0,0 +1,10 @@
+package radius
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var Cmd = &cobra.Command{
+	Use:   "radius",
+	Short: "Commands related to RADIUS and EAP-TLS.",
+}
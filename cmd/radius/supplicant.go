@@ -0,0 +1,121 @@
+package radius
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var supplicantConfigCmd = &cobra.Command{
+	Use:   "supplicant-config [certificate path]",
+	Short: "Generate an EAP-TLS Wi-Fi profile for a client certificate.",
+	Long: "Generate an EAP-TLS Wi-Fi profile for a client certificate.\n\n" +
+		"The profile embeds the client certificate, its private key and the root CA so it can be copied\n" +
+		"straight onto a test device. Supported formats: " + strings.Join(internal.SupplicantFormats, ", ") + ".\n" +
+		"NetworkManager keyfiles require --pfx-password; mobileconfig profiles can be signed with --sign-with.",
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		format, _ := cmd.Flags().GetString("format")
+		ssid, _ := cmd.Flags().GetString("ssid")
+		serverNames, _ := cmd.Flags().GetStringSlice("server-name")
+		identity, _ := cmd.Flags().GetString("identity")
+		pfxPassword, _ := cmd.Flags().GetString("pfx-password")
+		pfxEncoding, _ := cmd.Flags().GetString("pfx-encoding")
+		signWith, _ := cmd.Flags().GetString("sign-with")
+		out, _ := cmd.Flags().GetString("out")
+		cn, _ := cmd.Flags().GetString("common-name")
+		issuerType, _ := cmd.Flags().GetString("issuer-type")
+		issuerName, _ := cmd.Flags().GetString("issuer-name")
+		issuerRoot, _ := cmd.Flags().GetString("issuer-root")
+
+		var certPath string
+		switch {
+		case len(args) > 0:
+			certPath, err = internal.ResolveOutputPath(outputDir, args[0])
+			if err != nil {
+				return err
+			}
+		case cn != "":
+			if issuerType == "intermediate" && issuerRoot == "" {
+				issuerRoot = "default"
+			}
+			certPath = internal.CertificatePath(outputDir, issuerType, issuerRoot, issuerName, cn)
+		default:
+			return errors.New("certificate path or --common-name is required")
+		}
+
+		var signerPath string
+		if signWith != "" {
+			signerPath, err = internal.ResolveOutputPath(outputDir, signWith)
+			if err != nil {
+				return err
+			}
+		}
+
+		result, err := internal.ExportSupplicantConfig(outputDir, certPath, internal.SupplicantOptions{
+			Format:         format,
+			SSID:           ssid,
+			ServerNames:    serverNames,
+			Identity:       identity,
+			Password:       pfxPassword,
+			PFXEncoding:    pfxEncoding,
+			SignerCertPath: signerPath,
+		})
+		target := certPath
+		if absDir, absErr := filepath.Abs(outputDir); absErr == nil {
+			if rel, relErr := filepath.Rel(absDir, certPath); relErr == nil {
+				target = filepath.ToSlash(rel)
+			}
+		}
+		if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{
+			Action: internal.AuditExportSupplicant,
+			Target: target,
+			Params: map[string]string{"format": format, "ssid": ssid},
+			Err:    err,
+		}); auditErr != nil {
+			cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Failed to generate supplicant configuration")
+		}
+
+		if out == "" {
+			out = filepath.Join(filepath.Dir(certPath), result.FileName)
+		}
+		if err := internal.WriteExport(outputDir, out, result); err != nil {
+			return errors.Wrap(err, "Failed to write supplicant configuration")
+		}
+
+		fmt.Printf("Wrote %s\n", out)
+		if result.PFXEncoding != "" {
+			fmt.Printf("PKCS#12 encoding: %s (%s)\n", result.PFXEncoding, internal.PFXEncodingDescription(result.PFXEncoding))
+		}
+		fmt.Println("The file contains the private key; remove it from shared locations after installing.")
+		return nil
+	},
+}
+
+func init() {
+	Cmd.AddCommand(supplicantConfigCmd)
+	supplicantConfigCmd.Flags().StringP("format", "f", internal.SupplicantWPA, "Profile format: "+strings.Join(internal.SupplicantFormats, ", "))
+	supplicantConfigCmd.Flags().String("ssid", "", "Wi-Fi network name (required)")
+	supplicantConfigCmd.Flags().StringSlice("server-name", []string{}, "RADIUS server names the client should accept")
+	supplicantConfigCmd.Flags().String("identity", "", "EAP identity (defaults to the certificate CN)")
+	supplicantConfigCmd.Flags().String("pfx-password", "", "Password for the embedded PKCS#12 bundle")
+	supplicantConfigCmd.Flags().String("pfx-encoding", internal.PFXEncodingLegacy, "PKCS#12 encoding: "+strings.Join(internal.PFXEncodings, ", "))
+	supplicantConfigCmd.Flags().String("sign-with", "", "Certificate path (with key) used to sign mobileconfig profiles")
+	supplicantConfigCmd.Flags().String("out", "", "Output file (defaults to a file next to the certificate)")
+	supplicantConfigCmd.Flags().String("common-name", "", "Common Name (CN) of the client certificate")
+	supplicantConfigCmd.Flags().String("issuer-type", "root", "Issuer type: root or intermediate")
+	supplicantConfigCmd.Flags().String("issuer-name", "default", "Issuer name (root CA name or intermediate CA name)")
+	supplicantConfigCmd.Flags().String("issuer-root", "default", "Root CA name when issuer type is intermediate")
+}
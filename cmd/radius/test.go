@@ -0,0 +1,98 @@
+package radius
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var testCmd = &cobra.Command{
+	Use:   "test [client certificate]",
+	Short: "Authenticate a client certificate against an in-process EAP-TLS RADIUS server.",
+	Long: "Authenticate a client certificate against an in-process EAP-TLS RADIUS server.\n\n" +
+		"A RADIUS server using --server-cert and the CAs from the output directory is started on loopback,\n" +
+		"and a built-in supplicant authenticates with the client certificate (a .pfx/.p12 bundle or a PEM\n" +
+		"certificate with its key next to it). The exact reason for a rejection is reported, for example a\n" +
+		"missing ClientAuth EKU, an untrusted chain, an expired or a revoked certificate.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		serverCert, _ := cmd.Flags().GetString("server-cert")
+		pfxPassword, _ := cmd.Flags().GetString("pfx-password")
+		trustCAs, _ := cmd.Flags().GetStringSlice("ca")
+		crlFiles, _ := cmd.Flags().GetStringSlice("crl-file")
+		serverName, _ := cmd.Flags().GetString("server-name")
+		identity, _ := cmd.Flags().GetString("identity")
+		tlsVersion, _ := cmd.Flags().GetString("tls-version")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		if serverCert == "" {
+			return errors.New("--server-cert is required")
+		}
+		serverCertPath, err := internal.ResolveOutputPath(outputDir, serverCert)
+		if err != nil {
+			return err
+		}
+		clientCertPath, err := internal.ResolveOutputPath(outputDir, args[0])
+		if err != nil {
+			return err
+		}
+		trustPaths, err := internal.FindCACertPaths(outputDir, trustCAs)
+		if err != nil {
+			return err
+		}
+
+		result, err := internal.RunEAPTLSTest(outputDir, internal.EAPTLSTestOptions{
+			ServerCertPath: serverCertPath,
+			ClientCertPath: clientCertPath,
+			ClientPassword: pfxPassword,
+			TrustCertPaths: trustPaths,
+			CRLFiles:       crlFiles,
+			ServerName:     serverName,
+			Identity:       identity,
+			TLSVersion:     tlsVersion,
+			Timeout:        timeout,
+		})
+		if err != nil {
+			return errors.Wrap(err, "Failed to run EAP-TLS test")
+		}
+
+		fmt.Printf("Identity:    %s\n", result.Identity)
+		fmt.Printf("Client:      %s\n", result.ClientSubject)
+		fmt.Printf("Round trips: %d\n", result.RoundTrips)
+		if result.Accepted {
+			fmt.Printf("TLS:         %s, %s\n", result.TLSVersion, result.CipherSuite)
+			fmt.Println("Result:      Access-Accept")
+			return nil
+		}
+
+		fmt.Println("Result:      Access-Reject")
+		if result.Reason != "" {
+			fmt.Printf("Server:      %s\n", result.Reason)
+		}
+		if result.ClientError != "" {
+			fmt.Printf("Supplicant:  %s\n", result.ClientError)
+		}
+		cmd.SilenceUsage = true
+		return errors.New("access rejected")
+	},
+}
+
+func init() {
+	Cmd.AddCommand(testCmd)
+	testCmd.Flags().String("server-cert", "", "RADIUS server certificate path relative to the output directory (key must sit next to it)")
+	testCmd.Flags().String("pfx-password", "", "Password for a PKCS#12 client bundle")
+	testCmd.Flags().StringSlice("ca", []string{}, "CAs trusted for client certificates (root:<name> or intermediate:<root>:<name>, defaults to all)")
+	testCmd.Flags().StringSlice("crl-file", []string{}, "PEM or DER CRL files checked against the client chain")
+	testCmd.Flags().String("server-name", "", "Name the supplicant expects in the server certificate")
+	testCmd.Flags().String("identity", "", "EAP identity (defaults to the client certificate CN)")
+	testCmd.Flags().String("tls-version", "1.2", "TLS version for the EAP-TLS tunnel: 1.0, 1.1, 1.2 or 1.3")
+	testCmd.Flags().Duration("timeout", 10*time.Second, "Timeout for each RADIUS round trip")
+}
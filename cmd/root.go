@@ -4,9 +4,16 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/Ctere1/cert-helper/cmd/acme"
+	"github.com/Ctere1/cert-helper/cmd/audit"
+	"github.com/Ctere1/cert-helper/cmd/auth"
 	"github.com/Ctere1/cert-helper/cmd/ca"
 	"github.com/Ctere1/cert-helper/cmd/cert"
+	"github.com/Ctere1/cert-helper/cmd/est"
+	"github.com/Ctere1/cert-helper/cmd/metrics"
+	"github.com/Ctere1/cert-helper/cmd/radius"
 	"github.com/Ctere1/cert-helper/cmd/scep"
+	"github.com/Ctere1/cert-helper/cmd/tls"
 	"github.com/adrg/xdg"
 	"github.com/spf13/cobra"
 )
@@ -40,4 +47,11 @@ func init() {
 	rootCmd.AddCommand(ca.Cmd)
 	rootCmd.AddCommand(cert.Cmd)
 	rootCmd.AddCommand(scep.Cmd)
+	rootCmd.AddCommand(radius.Cmd)
+	rootCmd.AddCommand(tls.Cmd)
+	rootCmd.AddCommand(acme.Cmd)
+	rootCmd.AddCommand(est.Cmd)
+	rootCmd.AddCommand(auth.Cmd)
+	rootCmd.AddCommand(audit.Cmd)
+	rootCmd.AddCommand(metrics.Cmd)
 }
@@ -0,0 +1,124 @@
+package scep
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var challengeCmd = &cobra.Command{
+	Use:   "challenge",
+	Short: "Manage one-time challenge passwords for 'scep serve --challenge-mode dynamic'.",
+}
+
+var challengeCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a one-time challenge password.",
+	Long: "Create a one-time challenge password.\n\n" +
+		"The password is accepted for a single enrollment before it expires. With --common-name and\n" +
+		"--san, it is only accepted for CSRs carrying that common name and exactly those subject alternative\n" +
+		"names; with --common-name alone, the CSR may not request any SAN other than the common name.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		commonName, _ := cmd.Flags().GetString("common-name")
+		sans, _ := cmd.Flags().GetStringSlice("san")
+
+		challenge, err := internal.NewSCEPChallengeStore(outputDir).Create(internal.SCEPChallengeOptions{
+			TTL:        ttl,
+			CommonName: commonName,
+			SANs:       sans,
+		})
+		if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{
+			Action: internal.AuditSCEPCreateChallenge,
+			Target: challenge.ID,
+			Params: map[string]string{"ttl": ttl.String(), "common_name": commonName, "san": strings.Join(sans, ", ")},
+			Err:    err,
+		}); auditErr != nil {
+			cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Failed to create challenge")
+		}
+
+		fmt.Printf("Challenge password: %s\n", challenge.Password)
+		fmt.Printf("ID: %s\n", challenge.ID)
+		fmt.Printf("Expires: %s\n", challenge.ExpiresAt.Local().Format(time.RFC3339))
+		if challenge.CommonName != "" {
+			fmt.Printf("Bound to common name: %s\n", challenge.CommonName)
+		}
+		for _, san := range challenge.SANs {
+			fmt.Printf("Requires SAN: %s\n", san)
+		}
+		return nil
+	},
+}
+
+var challengeListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List challenge passwords.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		challenges, err := internal.NewSCEPChallengeStore(outputDir).List()
+		if err != nil {
+			return errors.Wrap(err, "Failed to read challenges")
+		}
+		if len(challenges) == 0 {
+			fmt.Println("No challenges found.")
+			return nil
+		}
+
+		now := time.Now()
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tPASSWORD\tSTATUS\tEXPIRES\tBOUND TO\tUSED BY")
+		for _, challenge := range challenges {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", challenge.ID, challenge.Password, challenge.Status(now),
+				challenge.ExpiresAt.Local().Format("2006-01-02 15:04"), challenge.Binding(), challenge.UsedBy)
+		}
+		return writer.Flush()
+	},
+}
+
+var challengeDeleteCmd = &cobra.Command{
+	Use:   "delete <id>",
+	Short: "Delete a challenge password.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		err = internal.NewSCEPChallengeStore(outputDir).Delete(args[0])
+		if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{Action: internal.AuditSCEPDeleteChallenge, Target: args[0], Err: err}); auditErr != nil {
+			cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Failed to delete challenge")
+		}
+		fmt.Printf("Challenge %s deleted.\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	Cmd.AddCommand(challengeCmd)
+	challengeCmd.AddCommand(challengeCreateCmd, challengeListCmd, challengeDeleteCmd)
+	challengeCreateCmd.Flags().Duration("ttl", internal.DefaultSCEPChallengeTTL, "Time until the challenge expires")
+	challengeCreateCmd.Flags().String("common-name", "", "Only accept CSRs with this common name")
+	challengeCreateCmd.Flags().StringSlice("san", []string{}, "Only accept CSRs with exactly these subject alternative names")
+}
@@ -0,0 +1,128 @@
+package scep
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var requestsCmd = &cobra.Command{
+	Use:   "requests",
+	Short: "Manage enrollments held by 'scep serve --manual-approval'.",
+}
+
+var requestsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List queued enrollment requests.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		requests, err := internal.NewSCEPRequestQueue(outputDir).List()
+		if err != nil {
+			return errors.Wrap(err, "Failed to read requests")
+		}
+		if len(requests) == 0 {
+			fmt.Println("No requests found.")
+			return nil
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tSTATUS\tRECEIVED\tSUBJECT\tSANS\tREASON")
+		for _, request := range requests {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", request.ID, request.Status,
+				request.ReceivedAt.Local().Format("2006-01-02 15:04"), request.Subject, strings.Join(request.SANs, ", "), request.Reason)
+		}
+		return writer.Flush()
+	},
+}
+
+var requestsApproveCmd = &cobra.Command{
+	Use:   "approve <id>",
+	Short: "Approve a queued request; the certificate is issued on the client's next poll.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		request, err := internal.NewSCEPRequestQueue(outputDir).Approve(args[0])
+		if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{
+			Action: internal.AuditSCEPApproveRequest,
+			Target: args[0],
+			Params: map[string]string{"subject": request.Subject},
+			Err:    err,
+		}); auditErr != nil {
+			cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Failed to approve request")
+		}
+		fmt.Printf("Request %s from %s approved.\n", request.ID, request.Subject)
+		return nil
+	},
+}
+
+var requestsRejectCmd = &cobra.Command{
+	Use:   "reject <id>",
+	Short: "Reject a queued request; the client receives a failure on its next poll.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		reason, _ := cmd.Flags().GetString("reason")
+		request, err := internal.NewSCEPRequestQueue(outputDir).Reject(args[0], reason)
+		if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{
+			Action: internal.AuditSCEPRejectRequest,
+			Target: args[0],
+			Params: map[string]string{"subject": request.Subject, "reason": reason},
+			Err:    err,
+		}); auditErr != nil {
+			cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Failed to reject request")
+		}
+		fmt.Printf("Request %s from %s rejected.\n", request.ID, request.Subject)
+		return nil
+	},
+}
+
+var requestsDeleteCmd = &cobra.Command{
+	Use:   "delete <id>",
+	Short: "Delete a request from the queue.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		err = internal.NewSCEPRequestQueue(outputDir).Delete(args[0])
+		if auditErr := internal.RecordCLIAudit(outputDir, internal.AuditEvent{Action: internal.AuditSCEPDeleteRequest, Target: args[0], Err: err}); auditErr != nil {
+			cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Failed to delete request")
+		}
+		fmt.Printf("Request %s deleted.\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	Cmd.AddCommand(requestsCmd)
+	requestsCmd.AddCommand(requestsListCmd, requestsApproveCmd, requestsRejectCmd, requestsDeleteCmd)
+	requestsRejectCmd.Flags().String("reason", "", "Reason recorded with the rejection")
+}
@@ -1,32 +1,55 @@
 package scep
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
 	"fmt"
-	"math/big"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strings"
 
 	"github.com/Ctere1/cert-helper/internal"
 	"github.com/pkg/errors"
 
 	"github.com/go-kit/log"
-	scepdepot "github.com/micromdm/scep/v2/depot"
-	scepserver "github.com/micromdm/scep/v2/server"
 	"github.com/spf13/cobra"
 )
 
 var (
-	serverPort string
-	serverHost string
-	challenge  string
+	serverPort       string
+	serverHost       string
+	challenge        string
+	challengeMode    string
+	challengeWebhook string
+	challengeExec    string
+	issuerType       string
+	issuerName       string
+	issuerRoot       string
+	raCertPath       string
+
+	validityDays     int
+	allowRenewalDays int
+	revokeSuperseded bool
+	manualApproval   bool
 )
 
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Run a SCEP server.",
+	Long: "Run a SCEP server.\n\n" +
+		"Certificates are signed by the CA selected with --issuer-type, --issuer-root and --issuer-name,\n" +
+		"and GetCACert returns its full chain. By default the signing CA also decrypts and signs SCEP\n" +
+		"messages; use --ra-cert to hand that role to a separate RSA registration authority certificate.\n\n" +
+		"Issued certificates are stored with the other certificates of the signing CA. When a subject\n" +
+		"re-enrolls, its previous certificate is kept under its serial number; re-enrollment is refused\n" +
+		"until the previous certificate is within --allow-renewal-days of expiry, and --revoke-superseded\n" +
+		"adds replaced certificates to the CA's CRL.\n\n" +
+		"Challenge passwords are checked according to --challenge-mode:\n" +
+		"  static   every request uses --challenge\n" +
+		"  dynamic  one-time passwords from 'scep challenge create' or the dashboard\n" +
+		"  webhook  requests are POSTed as JSON to --challenge-webhook; a 2xx response accepts them\n" +
+		"  exec     --challenge-exec runs with the CSR on stdin; exit status 0 accepts the request\n\n" +
+		"With --manual-approval, requests with a valid challenge are answered with PENDING and queued\n" +
+		"until they are approved or rejected with 'scep requests' or the dashboard. The certificate is\n" +
+		"issued and returned when the client polls again.",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		outputDir, err := cmd.Flags().GetString("output-dir")
 		if err != nil {
@@ -40,82 +63,52 @@ var serveCmd = &cobra.Command{
 func serveSCEP(outputDir string) error {
 	logger := log.NewLogfmtLogger(os.Stderr)
 
-	depot := &Depot{outputDir}
-
-	// Load CA private key
-	caPrivateKey, err := internal.LoadCAPrivateKey(filepath.Join(outputDir, "ca.key"))
-	if err != nil {
-		return errors.Wrap(err, "Failed to load CA private key")
-	}
-
-	// Load CA certificate
-	caCert, err := internal.LoadCACertificate(filepath.Join(outputDir, "ca.pem"))
-	if err != nil {
-		return errors.Wrap(err, "Failed to load CA certificate")
-	}
-
-	var signer scepserver.CSRSignerContext = scepserver.SignCSRAdapter(scepdepot.NewSigner(depot))
-	signer = scepserver.StaticChallengeMiddleware(challenge, signer)
-
-	svc, err := scepserver.NewService(caCert, caPrivateKey, signer, scepserver.WithLogger(logger))
-	if err != nil {
-		return err
-	}
-
-	e := scepserver.MakeServerEndpoints(svc)
-	h := scepserver.MakeHTTPHandler(e, svc, log.With(logger, "component", "http"))
-	fmt.Printf("Starting SCEP on http://%s:%s\n", serverHost, serverPort)
-
-	return http.ListenAndServe(serverHost+":"+serverPort, h)
-}
-
-type Depot struct {
-	dir string
-}
-
-func (d *Depot) CA(pass []byte) ([]*x509.Certificate, *rsa.PrivateKey, error) {
-	// Load CA private key
-	caPrivateKey, err := internal.LoadCAPrivateKey(filepath.Join(d.dir, "ca.key"))
+	server, err := internal.NewSCEPServer(outputDir, internal.SCEPServerOptions{
+		IssuerType:       issuerType,
+		RootName:         issuerRoot,
+		IssuerName:       issuerName,
+		RACertPath:       raCertPath,
+		ValidityDays:     validityDays,
+		AllowRenewalDays: allowRenewalDays,
+		RevokeSuperseded: revokeSuperseded,
+		ChallengeMode:    challengeMode,
+		Challenge:        challenge,
+		ChallengeWebhook: challengeWebhook,
+		ChallengeExec:    challengeExec,
+		ManualApproval:   manualApproval,
+		Logger:           logger,
+	})
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "Failed to load CA private key")
+		return errors.Wrap(err, "Failed to start SCEP server")
 	}
 
-	// Load CA certificate
-	caCert, err := internal.LoadCACertificate(filepath.Join(d.dir, "ca.pem"))
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "Failed to load CA certificate")
+	fmt.Printf("Starting SCEP on http://%s:%s/scep\n", serverHost, serverPort)
+	fmt.Printf("Signing CA: %s\n", server.SigningCA().Subject.CommonName)
+	fmt.Printf("Challenge mode: %s\n", challengeMode)
+	if manualApproval {
+		fmt.Println("Manual approval: enabled")
 	}
-
-	return []*x509.Certificate{caCert}, caPrivateKey, nil
-}
-
-func (d *Depot) Serial() (*big.Int, error) {
-	return internal.GenerateSerialNumber(), nil
-}
-
-func (d *Depot) HasCN(cn string, allowTime int, cert *x509.Certificate, revokeOldCertificate bool) (bool, error) {
-	_, err := os.Stat(filepath.Join(d.dir, fmt.Sprintf("cert_%s.pem", cert.Subject.CommonName)))
-	if err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
-		}
-		return false, err
+	if raCert := server.RACertificate(); raCert != nil {
+		fmt.Printf("RA certificate: %s\n", raCert.Subject.CommonName)
 	}
-	return true, nil
-}
 
-func (d *Depot) Put(name string, crt *x509.Certificate) error {
-	// Write certificate to file
-	certPath := filepath.Join(d.dir, fmt.Sprintf("cert_%s.pem", crt.Subject.CommonName))
-	if err := internal.WriteCertificatePEM(certPath, crt.Raw); err != nil {
-		return errors.Wrap(err, "Failed to write certificate")
-	}
-	return nil
+	return http.ListenAndServe(serverHost+":"+serverPort, server)
 }
 
 func init() {
 	Cmd.AddCommand(serveCmd)
 	serveCmd.Flags().StringVarP(&serverPort, "port", "p", "8001", "Port to serve on")
 	serveCmd.Flags().StringVarP(&serverHost, "host", "l", "localhost", "Host to serve on")
-	serveCmd.Flags().StringVar(&challenge, "challenge", "very-secure-challenge", "SCEP challenge")
+	serveCmd.Flags().StringVar(&challenge, "challenge", internal.DefaultSCEPChallenge, "SCEP challenge in static mode")
+	serveCmd.Flags().StringVar(&challengeMode, "challenge-mode", internal.SCEPChallengeModeStatic, "Challenge validation: "+strings.Join(internal.SCEPChallengeModes, ", "))
+	serveCmd.Flags().StringVar(&challengeWebhook, "challenge-webhook", "", "URL that validates challenges in webhook mode")
+	serveCmd.Flags().StringVar(&challengeExec, "challenge-exec", "", "Command that validates challenges in exec mode (arguments split on spaces)")
+	serveCmd.Flags().StringVar(&issuerType, "issuer-type", "root", "Issuer type: root or intermediate")
+	serveCmd.Flags().StringVar(&issuerName, "issuer-name", "default", "Issuer name (root CA name or intermediate CA name)")
+	serveCmd.Flags().StringVar(&issuerRoot, "issuer-root", "default", "Root CA name when issuer type is intermediate")
+	serveCmd.Flags().IntVarP(&validityDays, "validity-days", "v", 365, "Validity period of issued certificates in days")
+	serveCmd.Flags().IntVar(&allowRenewalDays, "allow-renewal-days", 14, "Refuse re-enrollment until the previous certificate expires within this many days (0 allows it any time)")
+	serveCmd.Flags().BoolVar(&revokeSuperseded, "revoke-superseded", false, "Revoke the previous certificates of a subject when it re-enrolls")
+	serveCmd.Flags().BoolVar(&manualApproval, "manual-approval", false, "Answer requests with PENDING and issue them once approved with 'scep requests' or the dashboard")
+	serveCmd.Flags().StringVar(&raCertPath, "ra-cert", "", "RA certificate relative to the output directory used for SCEP message encryption (RSA key must sit next to it)")
 }
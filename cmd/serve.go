@@ -8,9 +8,11 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/Ctere1/cert-helper/internal"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
@@ -18,12 +20,32 @@ import (
 var (
 	serverPort string
 	serverHost string
+	serveSCEP  bool
+
+	serveAuthMethods  []string
+	serveAuthClientCA []string
+
+	serveTLS           bool
+	serveTLSCA         string
+	serveTLSCert       string
+	serveTLSKey        string
+	serveTLSClientAuth string
 )
 
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Serve certificate management UI and generated files on the network.",
-	Long:  "Serve certificate management UI and generated files on the network.\n\nWARNING: Exposing this dashboard to a network grants access to certificate files and operations without authentication.",
+	Long: "Serve certificate management UI and generated files on the network.\n\n" +
+		"--auth enables authentication with the users and API tokens managed by 'cert-helper auth'. Roles are\n" +
+		"enforced on every route: viewers can read and download certificates, issuers can also issue and revoke\n" +
+		"end-entity certificates and download their keys, and CA admins can also create CAs, download CA keys\n" +
+		"and control the SCEP server.\n\n" +
+		"--tls serves the dashboard over HTTPS. Unless --tls-cert is given, a server certificate for the listen\n" +
+		"host is issued from --tls-ca and reused on later runs until it nears expiry; trust the printed root\n" +
+		"fingerprint in your browser. --tls-client-auth require only accepts clients with a certificate from a\n" +
+		"CA selected with --auth-client-ca (default every CA).\n\n" +
+		"WARNING: Without --auth, exposing this dashboard to a network grants access to certificate files and\n" +
+		"operations to anyone who can reach it.",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		outputDir, err := cmd.Flags().GetString("output-dir")
 		if err != nil {
@@ -41,22 +63,97 @@ var serveCmd = &cobra.Command{
 			return errors.Wrap(err, "Failed to get absolute path")
 		}
 
-		if serverHost != "localhost" && serverHost != "127.0.0.1" && serverHost != "" {
+		clientCertAuth := slices.Contains(serveAuthMethods, internal.AuthMethodClientCert)
+		var auth *dashboardAuth
+		if len(serveAuthMethods) > 0 {
+			auth, err = newDashboardAuth(absDir, serveAuthMethods, serveAuthClientCA)
+			if err != nil {
+				return errors.Wrap(err, "Failed to enable authentication")
+			}
+			if clientCertAuth && !serveTLS {
+				log.Printf("Note: client certificates are only requested over HTTPS connections; use --tls.")
+			}
+		} else if serverHost != "localhost" && serverHost != "127.0.0.1" && serverHost != "" {
 			log.Printf("WARNING: The dashboard is exposed without authentication. Use trusted networks only.")
 		}
 
-		printBanner()
-		fmt.Printf("Starting certificate dashboard on http://%s:%s\n", serverHost, serverPort)
-		fmt.Printf("File browser available at http://%s:%s/#files\n", serverHost, serverPort)
+		scheme := "http"
+		var tlsServer *internal.ServeTLS
+		if serveTLS {
+			clientAuth := serveTLSClientAuth
+			if clientCertAuth && clientAuth == internal.ClientAuthNone {
+				clientAuth = internal.ClientAuthRequest
+			}
+			trustPaths, err := internal.FindCACertPaths(absDir, serveAuthClientCA)
+			if err != nil {
+				return errors.Wrap(err, "Failed to resolve client CAs")
+			}
+			options := internal.ServeTLSOptions{
+				CA:             serveTLSCA,
+				Hosts:          internal.ServeTLSHosts(serverHost),
+				ClientAuth:     clientAuth,
+				TrustCertPaths: trustPaths,
+			}
+			if serveTLSCert != "" {
+				options.CertPath = resolveServePath(absDir, serveTLSCert)
+				if serveTLSKey != "" {
+					options.KeyPath = resolveServePath(absDir, serveTLSKey)
+				}
+			} else if serveTLSKey != "" {
+				return errors.New("--tls-key requires --tls-cert")
+			}
+			tlsServer, err = internal.NewServeTLS(absDir, options)
+			if err != nil {
+				return errors.Wrap(err, "Failed to enable HTTPS")
+			}
+			if tlsServer.Issued {
+				if auditErr := internal.RecordCLIAudit(absDir, internal.AuditEvent{
+					Action: internal.AuditIssueCertificate,
+					Target: tlsServer.Leaf.Subject.CommonName,
+					Params: map[string]string{
+						"ca":                serveTLSCA,
+						"subject_alt_names": strings.Join(options.Hosts, ", "),
+					},
+				}); auditErr != nil {
+					cmd.PrintErrf("Warning: failed to write audit log: %v\n", auditErr)
+				}
+			}
+			scheme = "https"
+		} else if serveTLSCert != "" || serveTLSKey != "" || serveTLSClientAuth != internal.ClientAuthNone {
+			return errors.New("--tls-cert, --tls-key and --tls-client-auth require --tls")
+		}
+
+		printBanner(serveAuthMethods, tlsServer)
+		fmt.Printf("Starting certificate dashboard on %s://%s:%s\n", scheme, serverHost, serverPort)
+		fmt.Printf("File browser available at %s://%s:%s/#files\n", scheme, serverHost, serverPort)
 		fmt.Printf("Serving directory: %s\n", absDir)
 
+		scep := newSCEPController(absDir)
+		if serveSCEP {
+			if err := scep.start(defaultSCEPConfig()); err != nil {
+				return errors.Wrap(err, "Failed to start SCEP server")
+			}
+			fmt.Printf("SCEP server available at %s://%s:%s/scep\n", scheme, serverHost, serverPort)
+		}
+
+		events := newEventBroker()
+		metrics := internal.NewMetrics()
+		index := internal.NewCertificateIndex(absDir, func(update internal.IndexUpdate) {
+			events.publishIndexUpdate(update)
+			metrics.RecordIndexUpdate(update)
+		})
+		if err := index.Refresh(time.Now()); err != nil {
+			return errors.Wrap(err, "Failed to index certificates")
+		}
+		go watchOutputDir(index)
+
 		mux := http.NewServeMux()
 		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 			if r.URL.Path != "/" {
 				http.NotFound(w, r)
 				return
 			}
-			handleDashboard(w, r, absDir)
+			handleDashboard(w, r, absDir, index, scep)
 		})
 		mux.HandleFunc("/generate/root", func(w http.ResponseWriter, r *http.Request) {
 			handleGenerateRoot(w, r, absDir)
@@ -67,6 +164,53 @@ var serveCmd = &cobra.Command{
 		mux.HandleFunc("/generate/cert", func(w http.ResponseWriter, r *http.Request) {
 			handleGenerateCert(w, r, absDir)
 		})
+		mux.HandleFunc("/generate/invalid", func(w http.ResponseWriter, r *http.Request) {
+			handleGenerateInvalid(w, r, absDir)
+		})
+		mux.HandleFunc("/export", func(w http.ResponseWriter, r *http.Request) {
+			handleExport(w, r, absDir)
+		})
+		mux.HandleFunc("/export/supplicant", func(w http.ResponseWriter, r *http.Request) {
+			handleExportSupplicant(w, r, absDir)
+		})
+		mux.Handle("/events", events)
+		mux.Handle("/metrics", metrics.Handler(index))
+		mux.HandleFunc("/certificate", func(w http.ResponseWriter, r *http.Request) {
+			handleCertificateDetail(w, r, absDir, index)
+		})
+		mux.HandleFunc("/certificate/renew", func(w http.ResponseWriter, r *http.Request) {
+			handleRenewCertificate(w, r, absDir)
+		})
+		mux.HandleFunc("/certificate/revoke", func(w http.ResponseWriter, r *http.Request) {
+			handleRevokeCertificate(w, r, absDir)
+		})
+		mux.Handle("/scep", metrics.InstrumentSCEP(scep))
+		mux.HandleFunc("/scep/start", func(w http.ResponseWriter, r *http.Request) {
+			handleStartSCEP(w, r, scep)
+		})
+		mux.HandleFunc("/scep/stop", func(w http.ResponseWriter, r *http.Request) {
+			handleStopSCEP(w, r, scep)
+		})
+		mux.HandleFunc("/scep/challenges", func(w http.ResponseWriter, r *http.Request) {
+			handleCreateSCEPChallenge(w, r, absDir)
+		})
+		mux.HandleFunc("/scep/challenges/delete", func(w http.ResponseWriter, r *http.Request) {
+			handleDeleteSCEPChallenge(w, r, absDir)
+		})
+		mux.HandleFunc("/scep/requests/approve", func(w http.ResponseWriter, r *http.Request) {
+			handleApproveSCEPRequest(w, r, absDir)
+		})
+		mux.HandleFunc("/scep/requests/reject", func(w http.ResponseWriter, r *http.Request) {
+			handleRejectSCEPRequest(w, r, absDir)
+		})
+		mux.HandleFunc("/scep/requests/delete", func(w http.ResponseWriter, r *http.Request) {
+			handleDeleteSCEPRequest(w, r, absDir)
+		})
+		registerAPIHandlers(mux, absDir, index)
+		if auth != nil {
+			mux.HandleFunc("/login", auth.handleLogin)
+			mux.HandleFunc("/logout", auth.handleLogout)
+		}
 		registerAssetHandlers(mux)
 		mux.HandleFunc("/open", func(w http.ResponseWriter, r *http.Request) {
 			handleOpenInExplorer(w, r, absDir)
@@ -90,7 +234,7 @@ var serveCmd = &cobra.Command{
 			}
 			fsPath = filepath.Clean(fsPath)
 			relPath, err := filepath.Rel(absDir, fsPath)
-			if err != nil || strings.HasPrefix(relPath, "..") {
+			if err != nil || strings.HasPrefix(relPath, "..") || internal.IsAuthStore(relPath) {
 				http.Error(w, "Access denied", http.StatusForbidden)
 				return
 			}
@@ -113,11 +257,11 @@ var serveCmd = &cobra.Command{
 				return
 			}
 			r.URL.Path = urlPath
-			serveFileBrowser(w, r, absDir, "/files")
+			serveFileBrowser(w, r, absDir, "/files", index)
 		})
 
 		// Auto-open browser
-		serverURL := fmt.Sprintf("http://%s:%s", serverHost, serverPort)
+		serverURL := fmt.Sprintf("%s://%s:%s", scheme, serverHost, serverPort)
 		go func() {
 			// Wait for the server to start
 			time.Sleep(1 * time.Second)
@@ -126,16 +270,54 @@ var serveCmd = &cobra.Command{
 			}
 		}()
 
-		return http.ListenAndServe(serverHost+":"+serverPort, mux)
+		guard, err := newRequestGuard(serverHost)
+		if err != nil {
+			return errors.Wrap(err, "Failed to initialize CSRF protection")
+		}
+		var handler http.Handler = mux
+		if auth != nil {
+			handler = auth.middleware(mux)
+		}
+		handler = guard.middleware(handler)
+		if tlsServer == nil {
+			return http.ListenAndServe(serverHost+":"+serverPort, handler)
+		}
+		server := &http.Server{
+			Addr:      serverHost + ":" + serverPort,
+			Handler:   handler,
+			TLSConfig: tlsServer.Config,
+		}
+		return server.ListenAndServeTLS("", "")
 	},
 }
 
-func printBanner() {
+// resolveServePath resolves a --tls-cert or --tls-key value; relative paths are taken from the
+// output directory, but files elsewhere are accepted since they are only read.
+func resolveServePath(outputDir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(outputDir, path)
+}
+
+func printBanner(authMethods []string, tlsServer *internal.ServeTLS) {
 	fmt.Println("╔══════════════════════════════════════════════════╗")
 	fmt.Println("║                CERTIFICATE HELPER                ║")
 	fmt.Println("╚══════════════════════════════════════════════════╝")
-	fmt.Println("[!] WARNING: No authentication enabled.")
-	fmt.Println("[!] Operate only within trusted environments.")
+	if len(authMethods) > 0 {
+		fmt.Printf("[i] Authentication enabled: %s\n", strings.Join(authMethods, ", "))
+	} else {
+		fmt.Println("[!] WARNING: No authentication enabled.")
+		fmt.Println("[!] Operate only within trusted environments.")
+	}
+	if tlsServer != nil {
+		action := "Using"
+		if tlsServer.Issued {
+			action = "Issued"
+		}
+		fmt.Printf("[i] HTTPS enabled. %s server certificate %s\n", action, tlsServer.CertPath)
+		fmt.Printf("[i] Trust %q, SHA-256 fingerprint:\n    %s\n", tlsServer.Root.Subject.CommonName, internal.CertificateFingerprint(tlsServer.Root))
+	}
 	fmt.Println()
 }
 
@@ -162,4 +344,12 @@ func init() {
 	rootCmd.AddCommand(serveCmd)
 	serveCmd.Flags().StringVarP(&serverPort, "port", "p", "8000", "Port to serve on")
 	serveCmd.Flags().StringVar(&serverHost, "host", "localhost", "Host to serve on (default localhost)")
+	serveCmd.Flags().StringSliceVar(&serveAuthMethods, "auth", nil, "Require authentication with these methods: "+strings.Join(internal.AuthMethods, ", "))
+	serveCmd.Flags().StringArrayVar(&serveAuthClientCA, "auth-client-ca", nil, "CA that client certificates must chain to, as root:<name> or intermediate:<root>:<name> (repeatable, default: every CA)")
+	serveCmd.Flags().BoolVar(&serveTLS, "tls", false, "Serve over HTTPS")
+	serveCmd.Flags().StringVar(&serveTLSCA, "tls-ca", "root:default", "CA that issues the HTTPS server certificate, as root:<name> or intermediate:<root>:<name>")
+	serveCmd.Flags().StringVar(&serveTLSCert, "tls-cert", "", "Explicit HTTPS server certificate, relative to the output directory or absolute")
+	serveCmd.Flags().StringVar(&serveTLSKey, "tls-key", "", "Private key of --tls-cert (default: the .key file next to it)")
+	serveCmd.Flags().StringVar(&serveTLSClientAuth, "tls-client-auth", internal.ClientAuthNone, "Client certificates over HTTPS: none, request or require (--auth client-cert implies request)")
+	serveCmd.Flags().BoolVar(&serveSCEP, "scep", false, "Start the SCEP server at /scep with the default configuration (it can also be started from the dashboard)")
 }
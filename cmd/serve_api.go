@@ -0,0 +1,682 @@
+package cmd
+
+import (
+	"crypto/x509"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ctere1/cert-helper/internal"
+)
+
+const apiMaxBodyBytes = 1 << 20
+
+//go:embed templates/openapi.json
+var openAPIDocument []byte
+
+type apiErrorBody struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type apiList[T any] struct {
+	Items []T `json:"items"`
+}
+
+type apiCertificate struct {
+	Serial           string    `json:"serial"`
+	Name             string    `json:"name"`
+	Type             string    `json:"type"`
+	Subject          string    `json:"subject"`
+	Issuer           string    `json:"issuer"`
+	SubjectAltNames  []string  `json:"subject_alt_names,omitempty"`
+	NotBefore        time.Time `json:"not_before"`
+	NotAfter         time.Time `json:"not_after"`
+	DaysLeft         int       `json:"days_left"`
+	Status           string    `json:"status"`
+	IsCA             bool      `json:"is_ca"`
+	KeyUsage         []string  `json:"key_usage,omitempty"`
+	ExtendedKeyUsage []string  `json:"extended_key_usage,omitempty"`
+	Path             string    `json:"path"`
+	HasPrivateKey    bool      `json:"has_private_key"`
+	Source           string    `json:"source,omitempty"`
+	Defect           string    `json:"defect,omitempty"`
+}
+
+type apiCA struct {
+	Selector    string         `json:"selector"`
+	Type        string         `json:"type"`
+	RootName    string         `json:"root_name,omitempty"`
+	Name        string         `json:"name"`
+	Certificate apiCertificate `json:"certificate"`
+}
+
+type apiRootRequest struct {
+	Name string `json:"name"`
+	subjectFields
+	ValidityDays int      `json:"validity_days"`
+	KeyBits      int      `json:"key_bits"`
+	KeyUsage     []string `json:"key_usage"`
+}
+
+type apiIntermediateRequest struct {
+	RootName string `json:"root_name"`
+	apiRootRequest
+}
+
+type apiCertificateRequest struct {
+	Issuer string `json:"issuer"`
+	subjectFields
+	SubjectAltNames  []string `json:"subject_alt_names"`
+	ValidityDays     int      `json:"validity_days"`
+	KeyType          string   `json:"key_type"`
+	KeyBits          int      `json:"key_bits"`
+	KeyUsage         []string `json:"key_usage"`
+	ExtendedKeyUsage []string `json:"extended_key_usage"`
+	PFXPassword      string   `json:"pfx_password"`
+	PFXEncoding      string   `json:"pfx_encoding"`
+	ExportPrivateKey bool     `json:"export_private_key"`
+}
+
+type apiRevokeRequest struct {
+	Reason string `json:"reason"`
+}
+
+type apiDownloadRequest struct {
+	Format       string `json:"format"`
+	PFXPassword  string `json:"pfx_password"`
+	PFXEncoding  string `json:"pfx_encoding"`
+	FriendlyName string `json:"friendly_name"`
+	Alias        string `json:"alias"`
+}
+
+// registerAPIHandlers serves the versioned JSON API under /api/v1. Errors are returned as
+// {"error": {"status", "code", "message"}} bodies.
+func registerAPIHandlers(mux *http.ServeMux, outputDir string, index *internal.CertificateIndex) {
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "unknown API endpoint %s", r.URL.Path)
+	})
+	mux.HandleFunc("/api/v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		if !allowAPIMethods(w, r, http.MethodGet) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(openAPIDocument)
+	})
+	mux.HandleFunc("/api/v1/roots", func(w http.ResponseWriter, r *http.Request) {
+		handleAPIRoots(w, r, outputDir)
+	})
+	mux.HandleFunc("/api/v1/roots/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if allowAPIMethods(w, r, http.MethodGet) {
+			writeAPICA(w, outputDir, "root:"+r.PathValue("name"))
+		}
+	})
+	mux.HandleFunc("/api/v1/intermediates", func(w http.ResponseWriter, r *http.Request) {
+		handleAPIIntermediates(w, r, outputDir)
+	})
+	mux.HandleFunc("/api/v1/intermediates/{root}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if allowAPIMethods(w, r, http.MethodGet) {
+			writeAPICA(w, outputDir, "intermediate:"+r.PathValue("root")+":"+r.PathValue("name"))
+		}
+	})
+	mux.HandleFunc("/api/v1/certificates", func(w http.ResponseWriter, r *http.Request) {
+		handleAPICertificates(w, r, outputDir, index)
+	})
+	mux.HandleFunc("/api/v1/certificates/{serial}", func(w http.ResponseWriter, r *http.Request) {
+		if !allowAPIMethods(w, r, http.MethodGet) {
+			return
+		}
+		if cert, certPath, ok := findAPICertificate(w, index, r.PathValue("serial")); ok {
+			writeJSON(w, http.StatusOK, newAPICertificate(outputDir, certPath, cert, nil))
+		}
+	})
+	mux.HandleFunc("/api/v1/certificates/{serial}/revoke", func(w http.ResponseWriter, r *http.Request) {
+		handleAPIRevoke(w, r, outputDir, index)
+	})
+	mux.HandleFunc("/api/v1/certificates/{serial}/download", func(w http.ResponseWriter, r *http.Request) {
+		handleAPIDownload(w, r, outputDir, index)
+	})
+}
+
+func handleAPIRoots(w http.ResponseWriter, r *http.Request, outputDir string) {
+	switch r.Method {
+	case http.MethodGet:
+		writeAPICAs(w, outputDir, internal.IssuerTypeRoot, "")
+	case http.MethodPost:
+		var request apiRootRequest
+		if !decodeAPIRequest(w, r, &request) {
+			return
+		}
+		name, ok := validateAPICARequest(w, request)
+		if !ok {
+			return
+		}
+		selector := "root:" + name
+		if _, err := internal.FindCA(outputDir, selector); err == nil {
+			writeAPIError(w, http.StatusConflict, "already_exists", "root CA %q already exists", name)
+			return
+		}
+		validityDays := request.ValidityDays
+		if validityDays == 0 {
+			validityDays = 3600
+		}
+		_, _, err := internal.GenerateRootCAWithOptions(outputDir, name, request.subject(), validityDays,
+			internal.NormalizeKeyBits(request.KeyBits), parseKeyUsage(request.KeyUsage, internal.DefaultCAKeyUsage))
+		auditRequest(r, outputDir, internal.AuditEvent{Action: internal.AuditCreateRoot, Target: name, Params: request.auditParams(validityDays), Err: err})
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "generation_failed", "failed to create root CA: %v", err)
+			return
+		}
+		writeAPICACreated(w, outputDir, selector)
+	default:
+		writeAPIMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func handleAPIIntermediates(w http.ResponseWriter, r *http.Request, outputDir string) {
+	switch r.Method {
+	case http.MethodGet:
+		writeAPICAs(w, outputDir, internal.IssuerTypeIntermediate, strings.TrimSpace(r.URL.Query().Get("root")))
+	case http.MethodPost:
+		var request apiIntermediateRequest
+		if !decodeAPIRequest(w, r, &request) {
+			return
+		}
+		name, ok := validateAPICARequest(w, request.apiRootRequest)
+		if !ok {
+			return
+		}
+		rootName := internal.NormalizeName(request.RootName, "default")
+		if _, err := internal.FindCA(outputDir, "root:"+rootName); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request", "root CA %q not found", rootName)
+			return
+		}
+		selector := "intermediate:" + rootName + ":" + name
+		if _, err := internal.FindCA(outputDir, selector); err == nil {
+			writeAPIError(w, http.StatusConflict, "already_exists", "intermediate CA %q already exists under %q", name, rootName)
+			return
+		}
+		validityDays := request.ValidityDays
+		if validityDays == 0 {
+			validityDays = 1800
+		}
+		_, _, err := internal.GenerateIntermediateCAWithOptions(outputDir, rootName, name, request.subject(), validityDays,
+			internal.NormalizeKeyBits(request.KeyBits), parseKeyUsage(request.KeyUsage, internal.DefaultCAKeyUsage))
+		auditRequest(r, outputDir, internal.AuditEvent{Action: internal.AuditCreateIntermediate, Target: rootName + "/" + name, Params: request.auditParams(validityDays), Err: err})
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "generation_failed", "failed to create intermediate CA: %v", err)
+			return
+		}
+		writeAPICACreated(w, outputDir, selector)
+	default:
+		writeAPIMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func handleAPICertificates(w http.ResponseWriter, r *http.Request, outputDir string, index *internal.CertificateIndex) {
+	switch r.Method {
+	case http.MethodGet:
+		snapshot, err := index.Snapshot()
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "internal_error", "failed to list certificates: %v", err)
+			return
+		}
+		status := strings.TrimSpace(r.URL.Query().Get("status"))
+		items := []apiCertificate{}
+		for _, indexed := range snapshot.Certificates {
+			item := describeAPICertificate(indexed.Path, indexed.FullPath, indexed.Certificate, snapshot.Inventory, indexed.Revoked)
+			if status == "" || item.Status == status {
+				items = append(items, item)
+			}
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].NotAfter.Before(items[j].NotAfter)
+		})
+		writeJSON(w, http.StatusOK, apiList[apiCertificate]{Items: items})
+	case http.MethodPost:
+		handleAPICreateCertificate(w, r, outputDir)
+	default:
+		writeAPIMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func handleAPICreateCertificate(w http.ResponseWriter, r *http.Request, outputDir string) {
+	var request apiCertificateRequest
+	if !decodeAPIRequest(w, r, &request) {
+		return
+	}
+	issuerType, issuerRoot, issuerName, err := parseIssuerSelection(request.Issuer)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "issuer must be root:<name> or intermediate:<root>:<name>")
+		return
+	}
+	if _, err := internal.FindCA(outputDir, request.Issuer); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "%v", err)
+		return
+	}
+	if !validateAPISubject(w, request.subjectFields) || !validateAPIKey(w, request.KeyBits, request.KeyUsage) {
+		return
+	}
+	if request.ValidityDays < 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "validity_days must be positive")
+		return
+	}
+	for _, value := range request.ExtendedKeyUsage {
+		if _, ok := extKeyUsageNames[cleanExtKeyUsage(value)]; !ok {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request", "unknown extended key usage %q", value)
+			return
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(request.KeyType)) {
+	case "", internal.KeyTypeRSA, internal.KeyTypeECDSAP256, "ecdsa":
+	default:
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "unknown key type %q (use %s or %s)", request.KeyType, internal.KeyTypeRSA, internal.KeyTypeECDSAP256)
+		return
+	}
+	if _, err := internal.NormalizePFXEncoding(request.PFXEncoding); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "%v", err)
+		return
+	}
+	validityDays := request.ValidityDays
+	if validityDays == 0 {
+		validityDays = 365
+	}
+
+	options := internal.CertificateOptions{
+		KeyBits:          internal.NormalizeKeyBits(request.KeyBits),
+		KeyUsage:         parseKeyUsage(request.KeyUsage, 0),
+		ExtKeyUsage:      parseExtKeyUsage(request.ExtendedKeyUsage),
+		KeyType:          parseKeyType(request.KeyType),
+		ExportPrivateKey: request.ExportPrivateKey,
+		PFXEncoding:      request.PFXEncoding,
+	}
+	certPath, _, _, err := internal.GenerateCertificateWithOptions(outputDir, issuerType, issuerRoot, issuerName, request.subject(),
+		request.SubjectAltNames, validityDays, request.PFXPassword, options)
+	auditRequest(r, outputDir, internal.AuditEvent{
+		Action: internal.AuditIssueCertificate,
+		Target: request.CommonName,
+		Params: map[string]string{
+			"issuer":             request.Issuer,
+			"subject_alt_names":  strings.Join(request.SubjectAltNames, ", "),
+			"validity_days":      strconv.Itoa(validityDays),
+			"key_type":           request.KeyType,
+			"key_bits":           strconv.Itoa(options.KeyBits),
+			"extended_key_usage": strings.Join(request.ExtendedKeyUsage, ", "),
+		},
+		Err: err,
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "generation_failed", "failed to create certificate: %v", err)
+		return
+	}
+	cert, err := internal.LoadCertificate(certPath)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "failed to load certificate: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newAPICertificate(outputDir, certPath, cert, nil))
+}
+
+func handleAPIRevoke(w http.ResponseWriter, r *http.Request, outputDir string, index *internal.CertificateIndex) {
+	if !allowAPIMethods(w, r, http.MethodPost) {
+		return
+	}
+	var request apiRevokeRequest
+	if r.ContentLength != 0 && !decodeAPIRequest(w, r, &request) {
+		return
+	}
+	reasonName := strings.TrimSpace(request.Reason)
+	if reasonName == "" {
+		reasonName = "unspecified"
+	}
+	reason, ok := internal.RevocationReasons[reasonName]
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "unknown revocation reason %q", request.Reason)
+		return
+	}
+	cert, certPath, ok := findAPICertificate(w, index, r.PathValue("serial"))
+	if !ok {
+		return
+	}
+	if cert.IsCA && !allowedRole(r, internal.RoleCAAdmin) {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "the %s role is required to revoke CA certificates", internal.RoleCAAdmin)
+		return
+	}
+
+	_, err := internal.RevokeIssuedCertificate(outputDir, cert, reason)
+	auditRequest(r, outputDir, internal.AuditEvent{
+		Action: internal.AuditRevokeCertificate,
+		Target: cert.SerialNumber.Text(16),
+		Params: map[string]string{"subject": cert.Subject.CommonName, "reason": reasonName},
+		Err:    err,
+	})
+	if errors.Is(err, internal.ErrAlreadyRevoked) {
+		writeAPIError(w, http.StatusConflict, "already_revoked", "%v", err)
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "revocation_failed", "%v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPICertificate(outputDir, certPath, cert, nil))
+}
+
+func handleAPIDownload(w http.ResponseWriter, r *http.Request, outputDir string, index *internal.CertificateIndex) {
+	var request apiDownloadRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		request = apiDownloadRequest{
+			Format:       query.Get("format"),
+			PFXEncoding:  query.Get("pfx_encoding"),
+			FriendlyName: query.Get("friendly_name"),
+			Alias:        query.Get("alias"),
+		}
+	case http.MethodPost:
+		// Passwords for PKCS#12 and JKS bundles are only accepted in the request body.
+		if !decodeAPIRequest(w, r, &request) {
+			return
+		}
+	default:
+		writeAPIMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	}
+
+	if strings.TrimSpace(request.Format) == "" {
+		request.Format = internal.ExportFullChain
+	}
+	format, err := internal.NormalizeExportFormat(request.Format)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "%v", err)
+		return
+	}
+	if internal.IsStoreFormat(format) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "format %q does not export a single certificate", format)
+		return
+	}
+	cert, certPath, ok := findAPICertificate(w, index, r.PathValue("serial"))
+	if !ok {
+		return
+	}
+	if format == internal.ExportPKCS12 || format == internal.ExportJKS {
+		role := internal.RoleIssuer
+		if cert.IsCA {
+			role = internal.RoleCAAdmin
+		}
+		if !allowedRole(r, role) {
+			writeAPIError(w, http.StatusForbidden, "forbidden", "the %s role is required to export this private key", role)
+			return
+		}
+	}
+	result, err := internal.ExportCertificate(outputDir, certPath, internal.ExportOptions{
+		Format:       format,
+		Password:     request.PFXPassword,
+		FriendlyName: strings.TrimSpace(request.FriendlyName),
+		Alias:        strings.TrimSpace(request.Alias),
+		PFXEncoding:  request.PFXEncoding,
+	})
+	auditRequest(r, outputDir, internal.AuditEvent{
+		Action: internal.AuditExportCertificate,
+		Target: cert.SerialNumber.Text(16),
+		Params: map[string]string{"subject": cert.Subject.CommonName, "format": format},
+		Err:    err,
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "export_failed", "export failed: %v", err)
+		return
+	}
+	writeExportResult(w, result)
+}
+
+func (request apiRootRequest) auditParams(validityDays int) map[string]string {
+	return map[string]string{
+		"common_name":   request.CommonName,
+		"validity_days": strconv.Itoa(validityDays),
+		"key_bits":      strconv.Itoa(internal.NormalizeKeyBits(request.KeyBits)),
+		"key_usage":     strings.Join(request.KeyUsage, ", "),
+	}
+}
+
+func validateAPICARequest(w http.ResponseWriter, request apiRootRequest) (string, bool) {
+	if !validateAPISubject(w, request.subjectFields) || !validateAPIKey(w, request.KeyBits, request.KeyUsage) {
+		return "", false
+	}
+	if request.ValidityDays < 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "validity_days must be positive")
+		return "", false
+	}
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		name = request.subject().CommonName
+	}
+	return internal.NormalizeName(name, "default"), true
+}
+
+func validateAPISubject(w http.ResponseWriter, fields subjectFields) bool {
+	if fields.subject().CommonName == "" {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "common_name is required")
+		return false
+	}
+	return true
+}
+
+// validateAPIKey rejects key sizes and usages that the dashboard forms would silently replace
+// with their defaults.
+func validateAPIKey(w http.ResponseWriter, keyBits int, keyUsage []string) bool {
+	if keyBits != 0 && internal.NormalizeKeyBits(keyBits) != keyBits {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "unsupported key_bits %d (use 2048, 3072 or 4096)", keyBits)
+		return false
+	}
+	for _, value := range keyUsage {
+		if _, ok := keyUsageNames[strings.TrimSpace(value)]; !ok {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request", "unknown key usage %q", value)
+			return false
+		}
+	}
+	return true
+}
+
+func writeAPICAs(w http.ResponseWriter, outputDir, caType, rootName string) {
+	cas, err := internal.ListCAs(outputDir)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "failed to list CAs: %v", err)
+		return
+	}
+	inventory, _ := internal.LoadInventory(outputDir)
+	items := []apiCA{}
+	for _, ca := range cas {
+		if ca.Type != caType || (rootName != "" && ca.RootName != rootName) {
+			continue
+		}
+		items = append(items, newAPICA(outputDir, ca, inventory))
+	}
+	writeJSON(w, http.StatusOK, apiList[apiCA]{Items: items})
+}
+
+func writeAPICA(w http.ResponseWriter, outputDir, selector string) {
+	ca, err := internal.FindCA(outputDir, selector)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "not_found", "%v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPICA(outputDir, ca, nil))
+}
+
+func writeAPICACreated(w http.ResponseWriter, outputDir, selector string) {
+	ca, err := internal.FindCA(outputDir, selector)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "%v", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newAPICA(outputDir, ca, nil))
+}
+
+func newAPICA(outputDir string, ca internal.CAInfo, inventory map[string]internal.InventoryRecord) apiCA {
+	item := apiCA{
+		Selector:    ca.Selector(),
+		Type:        ca.Type,
+		Name:        ca.Name,
+		Certificate: newAPICertificate(outputDir, ca.CertPath, ca.Cert, inventory),
+	}
+	if ca.Type == internal.IssuerTypeIntermediate {
+		item.RootName = ca.RootName
+	}
+	return item
+}
+
+// newAPICertificate describes the certificate stored at certPath. The inventory is loaded when nil.
+func newAPICertificate(outputDir, certPath string, cert *x509.Certificate, inventory map[string]internal.InventoryRecord) apiCertificate {
+	if inventory == nil {
+		inventory, _ = internal.LoadInventory(outputDir)
+	}
+	relPath, err := filepath.Rel(outputDir, certPath)
+	if err != nil {
+		relPath = certPath
+	}
+	return describeAPICertificate(filepath.ToSlash(relPath), certPath, cert, inventory, internal.IsCertificateRevoked(outputDir, cert))
+}
+
+// describeAPICertificate builds the API view of a certificate whose revocation state is known, such
+// as one from the certificate index. relPath is slash-separated and relative to the output directory.
+func describeAPICertificate(relPath, certPath string, cert *x509.Certificate, inventory map[string]internal.InventoryRecord, revoked bool) apiCertificate {
+	_, status, daysLeft := certificateStatus(cert.NotAfter, time.Now())
+	if revoked {
+		status = "revoked"
+	}
+	name := cert.Subject.CommonName
+	if name == "" {
+		name = filepath.Base(certPath)
+	}
+
+	item := apiCertificate{
+		Serial:        cert.SerialNumber.Text(16),
+		Name:          name,
+		Type:          apiCertificateType(certificateType(cert, relPath)),
+		Subject:       cert.Subject.String(),
+		Issuer:        cert.Issuer.String(),
+		NotBefore:     cert.NotBefore,
+		NotAfter:      cert.NotAfter,
+		DaysLeft:      daysLeft,
+		Status:        status,
+		IsCA:          cert.IsCA,
+		Path:          relPath,
+		HasPrivateKey: fileExists(internal.PrivateKeyPathForCertificate(certPath)),
+		Source:        inventory[relPath].Source,
+		Defect:        inventory[relPath].Defect,
+	}
+	item.SubjectAltNames = append(item.SubjectAltNames, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		item.SubjectAltNames = append(item.SubjectAltNames, ip.String())
+	}
+	item.SubjectAltNames = append(item.SubjectAltNames, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		item.SubjectAltNames = append(item.SubjectAltNames, uri.String())
+	}
+	for name, usage := range keyUsageNames {
+		if cert.KeyUsage&usage != 0 {
+			item.KeyUsage = append(item.KeyUsage, name)
+		}
+	}
+	for name, usage := range extKeyUsageNames {
+		for _, certUsage := range cert.ExtKeyUsage {
+			if certUsage == usage {
+				item.ExtendedKeyUsage = append(item.ExtendedKeyUsage, name)
+			}
+		}
+	}
+	sort.Strings(item.KeyUsage)
+	sort.Strings(item.ExtendedKeyUsage)
+	return item
+}
+
+func apiCertificateType(entryType string) string {
+	switch entryType {
+	case "Root CA":
+		return "root_ca"
+	case "Intermediate CA":
+		return "intermediate_ca"
+	default:
+		return "certificate"
+	}
+}
+
+// findAPICertificate looks up a stored certificate by its hexadecimal serial number, with or
+// without colons, and writes a 404 when there is none.
+func findAPICertificate(w http.ResponseWriter, index *internal.CertificateIndex, value string) (*x509.Certificate, string, bool) {
+	serial, ok := new(big.Int).SetString(strings.ReplaceAll(strings.TrimSpace(value), ":", ""), 16)
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "serial %q is not a hexadecimal number", value)
+		return nil, "", false
+	}
+	snapshot, err := index.Snapshot()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "failed to list certificates: %v", err)
+		return nil, "", false
+	}
+	for _, indexed := range snapshot.Certificates {
+		if indexed.Certificate.SerialNumber.Cmp(serial) == 0 {
+			return indexed.Certificate, indexed.FullPath, true
+		}
+	}
+	writeAPIError(w, http.StatusNotFound, "not_found", "certificate with serial %s not found", serial.Text(16))
+	return nil, "", false
+}
+
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request, target any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "too_large", "request body exceeds %d bytes", apiMaxBodyBytes)
+			return false
+		}
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", "invalid JSON body: %v", err)
+		return false
+	}
+	return true
+}
+
+func allowAPIMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	writeAPIMethodNotAllowed(w, methods...)
+	return false
+}
+
+func writeAPIMethodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed (use %s)", strings.Join(methods, " or "))
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, format string, args ...any) {
+	writeJSON(w, status, apiErrorBody{Error: apiError{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		log.Printf("Failed to write API response: %v", err)
+	}
+}
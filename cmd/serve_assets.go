@@ -5,9 +5,11 @@ import (
 	"embed"
 	"encoding/hex"
 	"fmt"
+	"html/template"
 	"io/fs"
 	"log"
 	"net/http"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -15,12 +17,15 @@ import (
 const (
 	dashboardTemplateFile   = "templates/dashboard.html"
 	fileBrowserTemplateFile = "templates/file_browser.html"
+	loginTemplateFile       = "templates/login.html"
+	certificateTemplateFile = "templates/certificate.html"
 	sharedScriptFile        = "templates/shared.js"
 	sharedStylesFile        = "templates/shared.css"
 	dashboardScriptFile     = "templates/dashboard.js"
 	dashboardStylesFile     = "templates/dashboard.css"
 	fileBrowserScriptFile   = "templates/file_browser.js"
 	fileBrowserStylesFile   = "templates/file_browser.css"
+	certificateScriptFile   = "templates/certificate.js"
 )
 
 type assetConfig struct {
@@ -42,6 +47,7 @@ var (
 		"/assets/dashboard.css":    {file: dashboardStylesFile, contentType: "text/css; charset=utf-8"},
 		"/assets/file_browser.js":  {file: fileBrowserScriptFile, contentType: "text/javascript; charset=utf-8"},
 		"/assets/file_browser.css": {file: fileBrowserStylesFile, contentType: "text/css; charset=utf-8"},
+		"/assets/certificate.js":   {file: certificateScriptFile, contentType: "text/javascript; charset=utf-8"},
 	}
 
 	// Cache initialization
@@ -49,6 +55,28 @@ var (
 	assetsCached    bool
 )
 
+var (
+	pageTemplatesMutex sync.Mutex
+	pageTemplates      = map[string]*template.Template{}
+)
+
+// pageTemplate returns the parsed page template in file, parsing it with funcs on first use. The
+// templates are embedded in the binary, so they never change while the server runs.
+func pageTemplate(file string, funcs template.FuncMap) (*template.Template, error) {
+	pageTemplatesMutex.Lock()
+	defer pageTemplatesMutex.Unlock()
+
+	if tmpl, ok := pageTemplates[file]; ok {
+		return tmpl, nil
+	}
+	tmpl, err := template.New(filepath.Base(file)).Funcs(funcs).ParseFS(templateFS, file)
+	if err != nil {
+		return nil, err
+	}
+	pageTemplates[file] = tmpl
+	return tmpl, nil
+}
+
 // initAssetCache pre-loads and caches all assets with ETags
 func initAssetCache() error {
 	assetCacheMutex.Lock()
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Ctere1/cert-helper/internal"
+)
+
+// dashboardAuditEntries is how many of the most recent audit entries the dashboard shows.
+const dashboardAuditEntries = 200
+
+// auditRequest records an operation performed through the dashboard or the JSON API, attributed to
+// the signed-in user (anonymous without --auth) and the client address.
+func auditRequest(r *http.Request, outputDir string, event internal.AuditEvent) {
+	if principal := principalFromRequest(r); principal != nil {
+		event.Actor = principal.Name
+	}
+	event.Source = internal.RemoteIP(r)
+	if err := internal.RecordAudit(outputDir, event); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
+
+// auditFormParams returns the submitted form fields; RecordAudit redacts passwords.
+func auditFormParams(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	for name, values := range r.Form {
+		if name != csrfFormField {
+			params[name] = strings.Join(values, ", ")
+		}
+	}
+	return params
+}
+
+func buildAuditView(outputDir string) (AuditView, error) {
+	var view AuditView
+	entries, err := internal.ReadAuditLog(outputDir, dashboardAuditEntries)
+	if err != nil {
+		return view, err
+	}
+	for _, entry := range entries {
+		view.Entries = append(view.Entries, AuditEntryView{AuditEntry: entry, Params: formatAuditParams(entry.Params)})
+	}
+	verification, err := internal.VerifyAuditLog(outputDir)
+	var chainErr *internal.AuditChainError
+	switch {
+	case errors.As(err, &chainErr):
+		view.VerifyError = chainErr.Error()
+	case err != nil:
+		return view, err
+	default:
+		view.Verified = true
+	}
+	view.Total = verification.Entries
+	view.LastHash = verification.LastHash
+	return view, nil
+}
+
+func formatAuditParams(params map[string]string) string {
+	var parts []string
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		if params[name] != "" {
+			parts = append(parts, name+"="+params[name])
+		}
+	}
+	return strings.Join(parts, ", ")
+}
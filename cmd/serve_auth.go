@@ -0,0 +1,375 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ctere1/cert-helper/internal"
+)
+
+const (
+	sessionCookieName = "cert_helper_session"
+	sessionLifetime   = 12 * time.Hour
+
+	// rolePublic marks routes served without credentials; roleDenied marks files served to nobody.
+	rolePublic = ""
+	roleDenied = "denied"
+)
+
+type principalContextKey struct{}
+
+type dashboardSession struct {
+	user    string
+	expires time.Time
+}
+
+// dashboardAuth authenticates every dashboard request and checks the role its route requires.
+type dashboardAuth struct {
+	outputDir      string
+	store          *internal.AuthStore
+	methods        []string
+	authenticators []internal.Authenticator
+
+	mu       sync.Mutex
+	sessions map[string]dashboardSession
+}
+
+func newDashboardAuth(outputDir string, methods, clientCASelectors []string) (*dashboardAuth, error) {
+	trustCertPaths, err := internal.FindCACertPaths(outputDir, clientCASelectors)
+	if err != nil {
+		return nil, err
+	}
+	authenticators, err := internal.NewAuthenticators(outputDir, methods, trustCertPaths)
+	if err != nil {
+		return nil, err
+	}
+	store := internal.NewAuthStore(outputDir)
+	users, err := store.Users()
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := store.Tokens()
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 && len(tokens) == 0 {
+		return nil, fmt.Errorf("no users or tokens configured; add one with 'cert-helper auth user add' or 'cert-helper auth token create'")
+	}
+	return &dashboardAuth{
+		outputDir:      outputDir,
+		store:          store,
+		methods:        methods,
+		authenticators: authenticators,
+		sessions:       make(map[string]dashboardSession),
+	}, nil
+}
+
+func (a *dashboardAuth) passwordLogin() bool {
+	return slices.Contains(a.methods, internal.AuthMethodPassword)
+}
+
+func (a *dashboardAuth) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := requiredRole(r)
+		if role == rolePublic {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if role == roleDenied {
+			writeForbidden(w, r, "Access denied")
+			return
+		}
+
+		principal, err := a.authenticate(r)
+		if err != nil {
+			log.Printf("Authentication failed for %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+		}
+		if principal == nil {
+			a.challenge(w, r)
+			return
+		}
+		if !internal.RoleAllows(principal.Role, role) {
+			writeForbidden(w, r, fmt.Sprintf("The %s role is required", role))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, principal)))
+	})
+}
+
+// authenticate tries the session cookie first, then the configured authenticators in order.
+func (a *dashboardAuth) authenticate(r *http.Request) (*internal.AuthPrincipal, error) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if principal := a.sessionPrincipal(cookie.Value); principal != nil {
+			return principal, nil
+		}
+	}
+	for _, authenticator := range a.authenticators {
+		principal, err := authenticator.Authenticate(r)
+		if err != nil || principal != nil {
+			return principal, err
+		}
+	}
+	return nil, nil
+}
+
+// challenge answers a request without valid credentials: browsers are sent to the login page,
+// API clients get a 401.
+func (a *dashboardAuth) challenge(w http.ResponseWriter, r *http.Request) {
+	var schemes []string
+	if slices.Contains(a.methods, internal.AuthMethodToken) {
+		schemes = append(schemes, "Bearer")
+	}
+	if a.passwordLogin() {
+		schemes = append(schemes, `Basic realm="cert-helper"`)
+	}
+	if len(schemes) > 0 {
+		w.Header().Set("WWW-Authenticate", strings.Join(schemes, ", "))
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "authentication required")
+		return
+	}
+	if r.Method == http.MethodGet && a.passwordLogin() {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+	http.Error(w, "Authentication required", http.StatusUnauthorized)
+}
+
+func (a *dashboardAuth) sessionPrincipal(id string) *internal.AuthPrincipal {
+	a.mu.Lock()
+	session, ok := a.sessions[id]
+	if ok && time.Now().After(session.expires) {
+		delete(a.sessions, id)
+		ok = false
+	}
+	a.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	// The role is looked up on every request so deleted users and role changes apply at once.
+	user, err := a.store.User(session.user)
+	if err != nil || user == nil {
+		return nil
+	}
+	return &internal.AuthPrincipal{Name: user.Name, Role: user.Role, Method: internal.AuthMethodSession}
+}
+
+func (a *dashboardAuth) createSession(user string) (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	id := base64.RawURLEncoding.EncodeToString(random)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for existing, session := range a.sessions {
+		if now.After(session.expires) {
+			delete(a.sessions, existing)
+		}
+	}
+	a.sessions[id] = dashboardSession{user: user, expires: now.Add(sessionLifetime)}
+	return id, nil
+}
+
+func (a *dashboardAuth) handleLogin(w http.ResponseWriter, r *http.Request) {
+	data := LoginData{
+		Title:         "Sign in - Certificate Helper",
+		Next:          safeRedirectTarget(r.FormValue("next")),
+		PasswordLogin: a.passwordLogin(),
+		ClientCert:    slices.Contains(a.methods, internal.AuthMethodClientCert),
+		CSRFToken:     csrfToken(r),
+	}
+	switch r.Method {
+	case http.MethodGet:
+		// A valid client certificate needs no login form.
+		if principal, _ := a.authenticate(r); principal != nil {
+			http.Redirect(w, r, data.Next, http.StatusSeeOther)
+			return
+		}
+	case http.MethodPost:
+		if !data.PasswordLogin {
+			http.Error(w, "Password sign-in is disabled", http.StatusForbidden)
+			return
+		}
+		username := strings.TrimSpace(r.FormValue("username"))
+		user, err := a.store.VerifyPassword(username, r.FormValue("password"))
+		auditRequest(r, a.outputDir, internal.AuditEvent{Actor: username, Action: internal.AuditLogin, Target: username, Err: err})
+		if err != nil {
+			if !errors.Is(err, internal.ErrInvalidCredentials) {
+				log.Printf("Sign-in failed: %v", err)
+			}
+			log.Printf("Failed sign-in for %q from %s", r.FormValue("username"), r.RemoteAddr)
+			data.Error = "Invalid user name or password."
+			w.WriteHeader(http.StatusUnauthorized)
+			break
+		}
+		id, err := a.createSession(user.Name)
+		if err != nil {
+			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    id,
+			Path:     "/",
+			MaxAge:   int(sessionLifetime.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, data.Next, http.StatusSeeOther)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	renderLogin(w, data)
+}
+
+func (a *dashboardAuth) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		a.mu.Lock()
+		delete(a.sessions, cookie.Value)
+		a.mu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func renderLogin(w http.ResponseWriter, data LoginData) {
+	tmpl, err := pageTemplate(loginTemplateFile, nil)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.ExecuteTemplate(w, filepath.Base(loginTemplateFile), data); err != nil {
+		log.Printf("Template execution error: %v", err)
+	}
+}
+
+// requiredRole returns the role needed for a request. Reading is open to viewers; private keys and
+// bundles containing them need the issuer role, or the CA admin role for CA keys.
+func requiredRole(r *http.Request) string {
+	urlPath := r.URL.Path
+	switch {
+	case urlPath == "/login" || urlPath == "/logout" || urlPath == "/scep" || strings.HasPrefix(urlPath, "/assets/"):
+		// SCEP clients authenticate with their challenge password.
+		return rolePublic
+	case urlPath == "/generate/root" || urlPath == "/generate/intermediate" || urlPath == "/open" ||
+		urlPath == "/scep/start" || urlPath == "/scep/stop":
+		return internal.RoleCAAdmin
+	case urlPath == "/generate/cert" || urlPath == "/generate/invalid" ||
+		urlPath == "/certificate/renew" || urlPath == "/certificate/revoke" || strings.HasPrefix(urlPath, "/scep/"):
+		return internal.RoleIssuer
+	case urlPath == "/export":
+		format, _ := internal.NormalizeExportFormat(r.FormValue("format"))
+		if format == internal.ExportPKCS12 || format == internal.ExportJKS {
+			return keyMaterialRole(strings.TrimPrefix(r.FormValue("path"), "/files/"))
+		}
+		return internal.RoleViewer
+	case urlPath == "/export/supplicant":
+		// Supplicant profiles embed the private key of the certificate.
+		return keyMaterialRole(strings.TrimPrefix(r.FormValue("path"), "/files/"))
+	case strings.HasPrefix(urlPath, "/files/"):
+		return fileRole(strings.TrimPrefix(normalizeURLPath(strings.TrimPrefix(urlPath, "/files")), "/"))
+	case strings.HasPrefix(urlPath, "/api/"):
+		return apiRole(r)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return internal.RoleViewer
+	default:
+		return internal.RoleCAAdmin
+	}
+}
+
+func apiRole(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return internal.RoleViewer
+	}
+	switch {
+	case r.URL.Path == "/api/v1/roots" || r.URL.Path == "/api/v1/intermediates":
+		return internal.RoleCAAdmin
+	case strings.HasSuffix(r.URL.Path, "/download"):
+		// The download handler checks the role for formats that contain the private key.
+		return internal.RoleViewer
+	case r.URL.Path == "/api/v1/certificates" || strings.HasSuffix(r.URL.Path, "/revoke"):
+		return internal.RoleIssuer
+	default:
+		return internal.RoleCAAdmin
+	}
+}
+
+// fileRole returns the role needed to download a file of the output directory. The path is folded
+// first so that case and Windows name variants of a file need the same role as the file itself.
+func fileRole(relPath string) string {
+	if internal.IsAuthStore(relPath) {
+		return roleDenied
+	}
+	relPath = internal.FoldPath(relPath)
+	switch path.Ext(relPath) {
+	case ".key", ".pfx", ".p12", ".jks":
+		return keyMaterialRole(relPath)
+	}
+	// Enrollment state holds challenge passwords and account details.
+	if strings.HasPrefix(relPath, "scep/") || strings.HasPrefix(relPath, "acme/") {
+		return internal.RoleIssuer
+	}
+	return internal.RoleViewer
+}
+
+// keyMaterialRole returns the role needed for the private key of the certificate or key at relPath.
+// CA material is always stored as ca.pem and ca.key.
+func keyMaterialRole(relPath string) string {
+	base := path.Base(internal.FoldPath(relPath))
+	if strings.TrimSuffix(base, path.Ext(base)) == "ca" {
+		return internal.RoleCAAdmin
+	}
+	return internal.RoleIssuer
+}
+
+// principalFromRequest returns the authenticated client, or nil when authentication is disabled.
+func principalFromRequest(r *http.Request) *internal.AuthPrincipal {
+	principal, _ := r.Context().Value(principalContextKey{}).(*internal.AuthPrincipal)
+	return principal
+}
+
+// allowedRole is the check for handlers whose required role depends on the certificate involved.
+func allowedRole(r *http.Request, role string) bool {
+	principal := principalFromRequest(r)
+	return principal == nil || internal.RoleAllows(principal.Role, role)
+}
+
+func writeForbidden(w http.ResponseWriter, r *http.Request, message string) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "%s", message)
+		return
+	}
+	http.Error(w, message, http.StatusForbidden)
+}
+
+// safeRedirectTarget only allows redirects to paths of this server.
+func safeRedirectTarget(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.Contains(target, "\\") {
+		return "/"
+	}
+	return target
+}
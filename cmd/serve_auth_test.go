@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Ctere1/cert-helper/internal"
+)
+
+func TestRequiredRole(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		form   url.Values
+		want   string
+	}{
+		{"login", http.MethodGet, "/login", nil, rolePublic},
+		{"scep", http.MethodPost, "/scep", nil, rolePublic},
+		{"assets", http.MethodGet, "/assets/dashboard.js", nil, rolePublic},
+		{"dashboard", http.MethodGet, "/", nil, internal.RoleViewer},
+		{"generate root", http.MethodPost, "/generate/root", nil, internal.RoleCAAdmin},
+		{"generate cert", http.MethodPost, "/generate/cert", nil, internal.RoleIssuer},
+		{"unknown post", http.MethodPost, "/unknown", nil, internal.RoleCAAdmin},
+
+		{"certificate file", http.MethodGet, "/files/certs/root/default/cert_web.pem", nil, internal.RoleViewer},
+		{"certificate key", http.MethodGet, "/files/certs/root/default/cert_web.key", nil, internal.RoleIssuer},
+		{"certificate pfx", http.MethodGet, "/files/certs/root/default/cert_web.pfx", nil, internal.RoleIssuer},
+		{"ca certificate", http.MethodGet, "/files/ca.pem", nil, internal.RoleViewer},
+		{"ca key", http.MethodGet, "/files/ca.key", nil, internal.RoleCAAdmin},
+		{"upper case ca key", http.MethodGet, "/files/CA.KEY", nil, internal.RoleCAAdmin},
+		{"ca key with trailing dot", http.MethodGet, "/files/ca.key.", nil, internal.RoleCAAdmin},
+		{"ca key stream", http.MethodGet, "/files/ca.key::$DATA", nil, internal.RoleCAAdmin},
+		{"intermediate ca key", http.MethodGet, "/files/ca/root/default/intermediates/issuing/ca.key", nil, internal.RoleCAAdmin},
+		{"ca key through dot dot", http.MethodGet, "/files/certs/../ca.key", nil, internal.RoleCAAdmin},
+		{"auth store", http.MethodGet, "/files/auth.json", nil, roleDenied},
+		{"upper case auth store", http.MethodGet, "/files/AUTH.json", nil, roleDenied},
+		{"auth store with trailing dot", http.MethodGet, "/files/Auth.JSON.", nil, roleDenied},
+		{"auth store temporary file", http.MethodGet, "/files/auth.json.tmp", nil, roleDenied},
+		{"scep state", http.MethodGet, "/files/scep/challenges.json", nil, internal.RoleIssuer},
+		{"upper case scep state", http.MethodGet, "/files/SCEP/challenges.json", nil, internal.RoleIssuer},
+
+		{"export chain", http.MethodPost, "/export", url.Values{"path": {"/files/ca.pem"}, "format": {"fullchain"}}, internal.RoleViewer},
+		{"export pfx", http.MethodPost, "/export", url.Values{"path": {"/files/certs/cert_web.pem"}, "format": {"pfx"}}, internal.RoleIssuer},
+		{"export ca pfx", http.MethodPost, "/export", url.Values{"path": {"/files/ca.pem"}, "format": {"pfx"}}, internal.RoleCAAdmin},
+		{"export upper case ca jks", http.MethodPost, "/export", url.Values{"path": {"/files/CA.pem"}, "format": {"jks"}}, internal.RoleCAAdmin},
+		{"supplicant", http.MethodPost, "/export/supplicant", url.Values{"path": {"/files/certs/cert_web.pem"}}, internal.RoleIssuer},
+		{"supplicant for ca", http.MethodPost, "/export/supplicant", url.Values{"path": {"/files/ca.pem"}}, internal.RoleCAAdmin},
+		{"supplicant for upper case ca", http.MethodPost, "/export/supplicant", url.Values{"path": {"/files/ca/root/x/CA.PEM"}}, internal.RoleCAAdmin},
+
+		{"api read", http.MethodGet, "/api/v1/certificates", nil, internal.RoleViewer},
+		{"api issue", http.MethodPost, "/api/v1/certificates", nil, internal.RoleIssuer},
+		{"api create root", http.MethodPost, "/api/v1/roots", nil, internal.RoleCAAdmin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.form.Encode()))
+			if tt.form != nil {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if got := requiredRole(r); got != tt.want {
+				t.Errorf("requiredRole(%s %s %v) = %q, want %q", tt.method, tt.target, tt.form, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyMaterialRole(t *testing.T) {
+	tests := []struct {
+		relPath string
+		want    string
+	}{
+		{"ca.pem", internal.RoleCAAdmin},
+		{"ca.key", internal.RoleCAAdmin},
+		{"Ca.Pem", internal.RoleCAAdmin},
+		{"ca/root/default/ca.pem", internal.RoleCAAdmin},
+		{"certs/root/default/cert_web.pem", internal.RoleIssuer},
+		{"certs/root/default/cert_ca.pem", internal.RoleIssuer},
+		{"cacert.pem", internal.RoleIssuer},
+	}
+	for _, tt := range tests {
+		if got := keyMaterialRole(tt.relPath); got != tt.want {
+			t.Errorf("keyMaterialRole(%q) = %q, want %q", tt.relPath, got, tt.want)
+		}
+	}
+}
+
+func TestFileRole(t *testing.T) {
+	tests := []struct {
+		relPath string
+		want    string
+	}{
+		{"inventory.json", internal.RoleViewer},
+		{"ca.pem", internal.RoleViewer},
+		{"ca.key", internal.RoleCAAdmin},
+		{"CA.Key", internal.RoleCAAdmin},
+		{"ca.p12", internal.RoleCAAdmin},
+		{"certs/root/default/cert_web.jks", internal.RoleIssuer},
+		{"certs/root/default/cert_web.PFX", internal.RoleIssuer},
+		{"acme/account.json", internal.RoleIssuer},
+		{"Acme/account.json", internal.RoleIssuer},
+		{"auth.json", roleDenied},
+		{"AUTH.JSON", roleDenied},
+		{"auth.json.tmp", roleDenied},
+		{"certs/auth.json", internal.RoleViewer},
+	}
+	for _, tt := range tests {
+		if got := fileRole(tt.relPath); got != tt.want {
+			t.Errorf("fileRole(%q) = %q, want %q", tt.relPath, got, tt.want)
+		}
+	}
+}
+
+func TestClientCertAuthentication(t *testing.T) {
+	outputDir := t.TempDir()
+	if _, _, err := internal.GenerateRootCA(outputDir, "default", internal.Subject{CommonName: "Test Root"}, 30); err != nil {
+		t.Fatal(err)
+	}
+	issue := func(commonName string) *x509.Certificate {
+		options := internal.DefaultCertificateOptions()
+		options.ExportPrivateKey = false
+		certPath, _, _, err := internal.GenerateCertificateWithOptions(outputDir, internal.IssuerTypeRoot, "", "default", internal.Subject{CommonName: commonName}, nil, 30, "", options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := internal.LoadCertificate(certPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	store := internal.NewAuthStore(outputDir)
+	if _, err := store.AddUser("boss", "a long passphrase", internal.RoleCAAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddUser("alice", "", internal.RoleIssuer); err != nil {
+		t.Fatal(err)
+	}
+	laptop := issue("alice-laptop")
+	if err := store.PinClientCertificate("alice", laptop); err != nil {
+		t.Fatal(err)
+	}
+	// Anyone allowed to enroll can ask for a certificate carrying another user's name.
+	impostor := issue("boss")
+	unpinned := issue("alice")
+
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		wantUser string
+		wantRole string
+	}{
+		{name: "pinned certificate", cert: laptop, wantUser: "alice", wantRole: internal.RoleIssuer},
+		{name: "common name of another user", cert: impostor},
+		{name: "common name of the user without pin", cert: unpinned},
+	}
+	authenticator := internal.ClientCertAuthenticator{Store: store, OutputDir: outputDir}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+			principal, err := authenticator.Authenticate(r)
+			if tt.wantUser == "" {
+				if err == nil {
+					t.Fatalf("Authenticate() accepted %q as %+v", tt.cert.Subject.CommonName, principal)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if principal.Name != tt.wantUser || principal.Role != tt.wantRole {
+				t.Errorf("Authenticate() = %s (%s), want %s (%s)", principal.Name, principal.Role, tt.wantUser, tt.wantRole)
+			}
+		})
+	}
+
+	if err := store.PinClientCertificate("boss", laptop); err == nil {
+		t.Error("PinClientCertificate() pinned a certificate already pinned to another user")
+	}
+}
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"crypto/x509"
-	"encoding/pem"
 	"fmt"
 	"math"
 	"os"
@@ -11,70 +10,42 @@ import (
 	"sort"
 	"strings"
 	"time"
-)
 
-const expiringSoonDays = 30
+	"github.com/Ctere1/cert-helper/internal"
+)
 
-func collectCertificates(outputDir string) ([]CertificateEntry, CertificateSummary, error) {
+// collectCertificates lists the certificates of an index snapshot for the dashboard, soonest
+// expiry first.
+func collectCertificates(snapshot internal.IndexSnapshot) ([]CertificateEntry, CertificateSummary) {
 	var entries []CertificateEntry
 	now := time.Now()
-
-	err := filepath.WalkDir(outputDir, func(filePath string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if d.IsDir() {
-			return nil
-		}
-		if strings.ToLower(filepath.Ext(d.Name())) != ".pem" {
-			return nil
-		}
-
-		data, err := os.ReadFile(filePath)
-		if err != nil {
-			return nil
-		}
-		block, _ := pem.Decode(data)
-		if block == nil || block.Type != "CERTIFICATE" {
-			return nil
-		}
-		cert, err := x509.ParseCertificate(block.Bytes)
-		if err != nil {
-			return nil
-		}
-
-		relPath, err := filepath.Rel(outputDir, filePath)
-		if err != nil {
-			return nil
-		}
+	for _, file := range snapshot.Certificates {
+		cert := file.Certificate
 		status, statusClass, daysLeft := certificateStatus(cert.NotAfter, now)
-		entryType := certificateType(cert, relPath)
-		name := cert.Subject.CommonName
-		if name == "" {
-			name = filepath.Base(filePath)
-		}
 		issuer := cert.Issuer.CommonName
 		if issuer == "" {
 			issuer = cert.Issuer.String()
 		}
 		entries = append(entries, CertificateEntry{
-			Name:             name,
-			Type:             entryType,
+			Name:             file.Name,
+			Type:             certificateType(cert, file.Path),
 			Issuer:           issuer,
 			NotBefore:        cert.NotBefore,
 			NotAfter:         cert.NotAfter,
 			DaysLeft:         daysLeft,
 			Status:           status,
 			StatusClass:      statusClass,
-			Path:             path.Join("/files", filepath.ToSlash(relPath)),
-			SystemPath:       filePath,
-			FolderPath:       normalizeURLPath(path.Dir(path.Join("/files", filepath.ToSlash(relPath)))),
-			SystemFolderPath: filepath.Dir(filePath),
+			Path:             path.Join("/files", file.Path),
+			ExportPath:       file.Path,
+			DetailPath:       certificateDetailURL(file.Path),
+			HasPrivateKey:    fileExists(internal.PrivateKeyPathForCertificate(file.FullPath)),
+			IsCA:             cert.IsCA,
+			SystemPath:       file.FullPath,
+			FolderPath:       normalizeURLPath(path.Dir(path.Join("/files", file.Path))),
+			SystemFolderPath: filepath.Dir(file.FullPath),
+			Defect:           snapshot.Inventory[file.Path].Defect,
+			Source:           snapshot.Inventory[file.Path].Source,
 		})
-		return nil
-	})
-	if err != nil {
-		return nil, CertificateSummary{}, err
 	}
 
 	sort.Slice(entries, func(i, j int) bool {
@@ -82,11 +53,11 @@ func collectCertificates(outputDir string) ([]CertificateEntry, CertificateSumma
 	})
 
 	summary := buildCertificateSummary(entries)
-	return entries, summary, nil
+	return entries, summary
 }
 
 func certificateType(cert *x509.Certificate, relPath string) string {
-	if cert.IsCA {
+	if cert.IsCA && !strings.HasPrefix(filepath.ToSlash(relPath), "certs/") {
 		if strings.Contains(filepath.ToSlash(relPath), "ca/intermediate/") {
 			return "Intermediate CA"
 		}
@@ -97,19 +68,20 @@ func certificateType(cert *x509.Certificate, relPath string) string {
 
 func certificateStatus(expiry, now time.Time) (string, string, int) {
 	daysLeft := int(math.Max(0, expiry.Sub(now).Hours()/24))
-	if expiry.Before(now) {
-		return "Expired", "expired", daysLeft
-	}
-	if expiry.Before(now.AddDate(0, 0, expiringSoonDays)) {
-		return "Expiring Soon", "expiring", daysLeft
+	switch status := internal.ExpiryStatus(expiry, now); status {
+	case internal.ExpiryExpired:
+		return "Expired", status, daysLeft
+	case internal.ExpiryExpiring:
+		return "Expiring Soon", status, daysLeft
+	default:
+		return "Valid", status, daysLeft
 	}
-	return "Valid", "valid", daysLeft
 }
 
 func buildCertificateSummary(entries []CertificateEntry) CertificateSummary {
 	summary := CertificateSummary{
 		Total:            len(entries),
-		ExpiringDaysHint: fmt.Sprintf("Renew within %d days", expiringSoonDays),
+		ExpiringDaysHint: fmt.Sprintf("Renew within %d days", internal.ExpiringSoonDays),
 	}
 	for _, entry := range entries {
 		switch entry.StatusClass {
@@ -144,3 +116,8 @@ func percent(part, total int) int {
 	}
 	return int(float64(part) / float64(total) * 100)
 }
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	csrfCookieName = "cert_helper_csrf"
+	csrfFormField  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+
+	// maxRequestBytes bounds the body of every mutating request, including SCEP messages.
+	maxRequestBytes = 1 << 20
+)
+
+type csrfContextKey struct{}
+
+// requestGuard protects the dashboard against cross-site requests. Every mutating request must
+// come from a page of the dashboard itself (checked with the Origin or Referer header) and, outside
+// the JSON API, carry the CSRF token the templates embed. Tokens are derived from a random cookie
+// and the session, so signing in or out invalidates the forms rendered before. When the server
+// only listens on loopback, requests for other host names are refused to stop DNS rebinding.
+type requestGuard struct {
+	key []byte
+	// allowedHosts are the Host header values accepted; empty accepts any.
+	allowedHosts []string
+}
+
+func newRequestGuard(listenHost string) (*requestGuard, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	guard := &requestGuard{key: key}
+	if isLoopbackHost(listenHost) {
+		guard.allowedHosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	return guard, nil
+}
+
+func (g *requestGuard) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.hostAllowed(r.Host) {
+			log.Printf("Refused request for host %q from %s", r.Host, r.RemoteAddr)
+			http.Error(w, "Host not allowed", http.StatusMisdirectedRequest)
+			return
+		}
+		token := g.token(g.seed(w, r), r)
+		r = r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, token))
+		if isSafeMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+		// SCEP clients are devices, not browsers, and authenticate with their challenge.
+		if r.URL.Path == "/scep" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !sameOrigin(r) {
+			log.Printf("Refused cross-origin %s %s from %s (origin %q)", r.Method, r.URL.Path, r.RemoteAddr, requestOrigin(r))
+			writeForbidden(w, r, "Cross-origin request refused")
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			// HTML forms cannot send JSON, and cross-origin scripts cannot without a CORS preflight
+			// this server never grants.
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if r.ContentLength != 0 && mediaType != "application/json" {
+				writeAPIError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "request body must be application/json")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		submitted := r.Header.Get(csrfHeaderName)
+		if submitted == "" {
+			submitted = r.PostForm.Get(csrfFormField)
+		}
+		if !hmac.Equal([]byte(submitted), []byte(token)) {
+			log.Printf("Refused %s %s from %s: missing or invalid CSRF token", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "Invalid or expired form token. Reload the page and try again.", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// seed returns the random value of the CSRF cookie, setting a new cookie when the browser has none.
+func (g *requestGuard) seed(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(csrfCookieName); err == nil && len(cookie.Value) >= 32 {
+		return cookie.Value
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return ""
+	}
+	seed := base64.RawURLEncoding.EncodeToString(random)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    seed,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return seed
+}
+
+func (g *requestGuard) token(seed string, r *http.Request) string {
+	mac := hmac.New(sha256.New, g.key)
+	mac.Write([]byte(seed))
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		mac.Write([]byte{0})
+		mac.Write([]byte(cookie.Value))
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (g *requestGuard) hostAllowed(host string) bool {
+	if len(g.allowedHosts) == 0 {
+		return true
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.Trim(host, "[]")
+	for _, allowed := range g.allowedHosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// csrfToken returns the token forms rendered for r must submit.
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey{}).(string)
+	return token
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// sameOrigin reports whether a request was sent by a page of this server. Requests without Origin
+// and Referer come from non-browser clients and are allowed.
+func sameOrigin(r *http.Request) bool {
+	origin := requestOrigin(r)
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	return strings.EqualFold(parsed.Host, r.Host)
+}
+
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return origin
+	}
+	return r.Header.Get("Referer")
+}
+
+func isLoopbackHost(host string) bool {
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRequestGuard(t *testing.T) {
+	guard, err := newRequestGuard("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := guard.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	seed := strings.Repeat("s", 43)
+	seeded := httptest.NewRequest(http.MethodGet, "/", nil)
+	seeded.AddCookie(&http.Cookie{Name: csrfCookieName, Value: seed})
+	token := guard.token(seed, seeded)
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		host    string
+		origin  string
+		cookie  bool
+		session string
+		token   string
+		json    bool
+		want    int
+	}{
+		{name: "get", method: http.MethodGet, target: "/", want: http.StatusNoContent},
+		{name: "get for localhost with port", method: http.MethodGet, target: "/", host: "LOCALHOST:8080", want: http.StatusNoContent},
+		{name: "get for ipv6 loopback", method: http.MethodGet, target: "/", host: "[::1]:8080", want: http.StatusNoContent},
+		{name: "get for rebound host", method: http.MethodGet, target: "/", host: "attacker.example", want: http.StatusMisdirectedRequest},
+		{name: "post for rebound host", method: http.MethodPost, target: "/generate/cert", host: "attacker.example:8080", cookie: true, token: token, want: http.StatusMisdirectedRequest},
+
+		{name: "post with token", method: http.MethodPost, target: "/generate/cert", cookie: true, token: token, want: http.StatusNoContent},
+		{name: "post with token and same origin", method: http.MethodPost, target: "/generate/cert", origin: "http://localhost:8080", cookie: true, token: token, want: http.StatusNoContent},
+		{name: "post without token", method: http.MethodPost, target: "/generate/cert", cookie: true, want: http.StatusForbidden},
+		{name: "post with wrong token", method: http.MethodPost, target: "/generate/cert", cookie: true, token: token[1:] + "A", want: http.StatusForbidden},
+		{name: "post with token of another cookie", method: http.MethodPost, target: "/generate/cert", token: token, want: http.StatusForbidden},
+		{name: "post with token of signed out session", method: http.MethodPost, target: "/generate/cert", cookie: true, session: "session", token: token, want: http.StatusForbidden},
+		{name: "post from other origin", method: http.MethodPost, target: "/generate/cert", origin: "http://attacker.example", cookie: true, token: token, want: http.StatusForbidden},
+		{name: "post from other port", method: http.MethodPost, target: "/generate/cert", origin: "http://localhost:9090", cookie: true, token: token, want: http.StatusForbidden},
+		{name: "post with null origin", method: http.MethodPost, target: "/generate/cert", origin: "null", cookie: true, token: token, want: http.StatusForbidden},
+
+		{name: "scep without token", method: http.MethodPost, target: "/scep", origin: "http://attacker.example", want: http.StatusNoContent},
+		{name: "api without token", method: http.MethodPost, target: "/api/v1/certificates", json: true, want: http.StatusNoContent},
+		{name: "api from other origin", method: http.MethodPost, target: "/api/v1/certificates", origin: "http://attacker.example", json: true, want: http.StatusForbidden},
+		{name: "api with form body", method: http.MethodPost, target: "/api/v1/certificates", want: http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body string
+			contentType := "application/x-www-form-urlencoded"
+			if tt.json {
+				body, contentType = `{}`, "application/json"
+			} else if tt.method == http.MethodPost {
+				body = url.Values{csrfFormField: {tt.token}}.Encode()
+			}
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(body))
+			r.Host = "localhost:8080"
+			if tt.host != "" {
+				r.Host = tt.host
+			}
+			if body != "" {
+				r.Header.Set("Content-Type", contentType)
+			}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.cookie {
+				r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: seed})
+			}
+			if tt.session != "" {
+				r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: tt.session})
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("%s %s returned %d, want %d", tt.method, tt.target, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestGuardAcceptsAnyHostOffLoopback(t *testing.T) {
+	guard, err := newRequestGuard("0.0.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"localhost", "ca.example:8443", "192.0.2.10"} {
+		if !guard.hostAllowed(host) {
+			t.Errorf("hostAllowed(%q) = false, want true", host)
+		}
+	}
+}
@@ -5,10 +5,8 @@ import (
 	"fmt"
 	"html/template"
 	"log"
-	"net"
 	"net/http"
 	"net/url"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -17,7 +15,7 @@ import (
 	"github.com/Ctere1/cert-helper/internal"
 )
 
-func handleDashboard(w http.ResponseWriter, r *http.Request, outputDir string) {
+func handleDashboard(w http.ResponseWriter, r *http.Request, outputDir string, index *internal.CertificateIndex, scep *scepController) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -39,45 +37,62 @@ func handleDashboard(w http.ResponseWriter, r *http.Request, outputDir string) {
 		errorMessage = "Could not read file list."
 	}
 
-	fileBrowserData, err := buildDashboardFileBrowser(outputDir, r.URL.Query().Get("files"))
+	snapshot, err := index.Snapshot()
+	if err != nil && errorMessage == "" {
+		errorMessage = "Could not read certificate status."
+	}
+	certificates, summary := collectCertificates(snapshot)
+
+	fileBrowserData, err := buildDashboardFileBrowser(outputDir, r.URL.Query().Get("files"), snapshot)
 	if err != nil && errorMessage == "" {
 		errorMessage = "Could not read file browser."
 	}
 	if err != nil {
-		fileBrowserData, _ = buildDashboardFileBrowser(outputDir, "/")
+		fileBrowserData, _ = buildDashboardFileBrowser(outputDir, "/", snapshot)
 	}
 
-	certificates, summary, err := collectCertificates(outputDir)
+	scepChallenges, err := buildSCEPChallengeEntries(outputDir)
 	if err != nil && errorMessage == "" {
-		errorMessage = "Could not read certificate status."
+		errorMessage = "Could not read SCEP challenges."
+	}
+	scepRequests, err := buildSCEPRequestEntries(outputDir)
+	if err != nil && errorMessage == "" {
+		errorMessage = "Could not read SCEP requests."
+	}
+	audit, err := buildAuditView(outputDir)
+	if err != nil && errorMessage == "" {
+		errorMessage = "Could not read the audit log."
 	}
-
-	scepRunning, scepURL, scepPort := detectSCEPStatus()
 
 	data := DashboardData{
-		Title:         "Certificate Helper Dashboard",
-		Message:       r.URL.Query().Get("message"),
-		Error:         errorMessage,
-		RootCAs:       rootCAs,
-		IssuerOptions: buildIssuerOptions(rootCAs, intermediateCAs),
-		Files:         fileInfos,
-		Defaults:      defaultFormValues(),
-		Summary:       summary,
-		Certificates:  certificates,
-		OutputDir:     outputDir,
-		FileSummary:   buildFileSummary(fileInfos),
-		FileBrowser:   fileBrowserData,
-		SCEPRunning:   scepRunning,
-		SCEPURL:       scepURL,
-		SCEPPort:      scepPort,
-	}
-
-	tmpl := template.New("dashboard").Funcs(template.FuncMap{
+		Title:          "Certificate Helper Dashboard",
+		Message:        r.URL.Query().Get("message"),
+		Error:          errorMessage,
+		RootCAs:        rootCAs,
+		IssuerOptions:  buildIssuerOptions(rootCAs, intermediateCAs),
+		DefectOptions:  buildDefectOptions(),
+		Files:          fileInfos,
+		Defaults:       defaultFormValues(),
+		Summary:        summary,
+		Certificates:   certificates,
+		Hierarchy:      snapshot.Tree(time.Now()),
+		OutputDir:      outputDir,
+		FileSummary:    buildFileSummary(fileInfos),
+		FileBrowser:    fileBrowserData,
+		SCEP:           scep.status(requestBaseURL(r)),
+		SCEPChallenges: scepChallenges,
+		SCEPRequests:   scepRequests,
+		User:           principalFromRequest(r),
+		CSRFToken:      csrfToken(r),
+		Audit:          audit,
+	}
+
+	tmpl, err := pageTemplate(dashboardTemplateFile, template.FuncMap{
 		"formatSize": internal.FormatSize,
 		"fileExt":    filepath.Ext,
 		"js":         template.JSEscapeString,
+		"detailURL":  certificateDetailURL,
 	})
-	tmpl, err = tmpl.ParseFS(templateFS, dashboardTemplateFile)
 	if err != nil {
 		http.Error(w, "Template error", http.StatusInternalServerError)
 		return
@@ -105,6 +120,7 @@ func handleGenerateRoot(w http.ResponseWriter, r *http.Request, outputDir string
 	keyUsage := parseKeyUsage(r.Form["key_usage"], internal.DefaultCAKeyUsage)
 
 	_, _, err := internal.GenerateRootCAWithOptions(outputDir, name, subject, validityDays, keyBits, keyUsage)
+	auditRequest(r, outputDir, internal.AuditEvent{Action: internal.AuditCreateRoot, Target: name, Params: auditFormParams(r), Err: err})
 	if err != nil {
 		redirectWithMessage(w, r, fmt.Sprintf("Failed to create root CA: %v", err), true)
 		return
@@ -133,6 +149,7 @@ func handleGenerateIntermediate(w http.ResponseWriter, r *http.Request, outputDi
 	keyUsage := parseKeyUsage(r.Form["key_usage"], internal.DefaultCAKeyUsage)
 
 	_, _, err := internal.GenerateIntermediateCAWithOptions(outputDir, rootName, name, subject, validityDays, keyBits, keyUsage)
+	auditRequest(r, outputDir, internal.AuditEvent{Action: internal.AuditCreateIntermediate, Target: rootName + "/" + name, Params: auditFormParams(r), Err: err})
 	if err != nil {
 		redirectWithMessage(w, r, fmt.Sprintf("Failed to create intermediate CA: %v", err), true)
 		return
@@ -160,6 +177,7 @@ func handleGenerateCert(w http.ResponseWriter, r *http.Request, outputDir string
 	extKeyUsage := parseExtKeyUsage(r.Form["extended_key_usage"])
 	keyType := parseKeyType(r.FormValue("key_type"))
 	exportPrivateKey := r.FormValue("export_private_key") != ""
+	pfxEncoding := r.FormValue("pfx_encoding")
 
 	options := internal.CertificateOptions{
 		KeyBits:          keyBits,
@@ -167,8 +185,10 @@ func handleGenerateCert(w http.ResponseWriter, r *http.Request, outputDir string
 		ExtKeyUsage:      extKeyUsage,
 		KeyType:          keyType,
 		ExportPrivateKey: exportPrivateKey,
+		PFXEncoding:      pfxEncoding,
 	}
 	_, _, _, err = internal.GenerateCertificateWithOptions(outputDir, issuerType, issuerRoot, issuerName, subject, sans, validityDays, pfxPassword, options)
+	auditRequest(r, outputDir, internal.AuditEvent{Action: internal.AuditIssueCertificate, Target: subject.CommonName, Params: auditFormParams(r), Err: err})
 	if err != nil {
 		redirectWithMessage(w, r, fmt.Sprintf("Failed to create certificate: %v", err), true)
 		return
@@ -176,17 +196,69 @@ func handleGenerateCert(w http.ResponseWriter, r *http.Request, outputDir string
 	redirectWithMessage(w, r, "Certificate created successfully.", false)
 }
 
-func subjectFromForm(r *http.Request) internal.Subject {
+func handleGenerateInvalid(w http.ResponseWriter, r *http.Request, outputDir string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	issuerType, issuerRoot, issuerName, err := parseIssuerSelection(r.FormValue("issuer"))
+	if err != nil {
+		redirectWithMessage(w, r, "Signing CA selection is invalid.", true)
+		return
+	}
+
+	result, err := internal.GenerateInvalidCertificate(outputDir, internal.InvalidCertificateOptions{
+		Defect:          r.FormValue("defect"),
+		IssuerType:      issuerType,
+		RootName:        issuerRoot,
+		IssuerName:      issuerName,
+		Subject:         subjectFromForm(r),
+		SubjectAltNames: parseSANs(r.FormValue("subject_alt_names")),
+		ValidityDays:    parseValidityDays(r.FormValue("validity_days"), 365),
+		PFXPassword:     r.FormValue("pfx_password"),
+		PFXEncoding:     r.FormValue("pfx_encoding"),
+	})
+	auditRequest(r, outputDir, internal.AuditEvent{Action: internal.AuditIssueInvalid, Target: r.FormValue("common_name"), Params: auditFormParams(r), Err: err})
+	if err != nil {
+		redirectWithMessage(w, r, fmt.Sprintf("Failed to create invalid certificate: %v", err), true)
+		return
+	}
+	redirectWithMessage(w, r, fmt.Sprintf("Invalid certificate (%s) created: %s", result.Defect, filepath.Base(result.CertPath)), false)
+}
+
+// subjectFields holds the subject inputs shared by the generate forms and the JSON API.
+type subjectFields struct {
+	CommonName         string `json:"common_name"`
+	Organization       string `json:"organization,omitempty"`
+	OrganizationalUnit string `json:"organizational_unit,omitempty"`
+	Country            string `json:"country,omitempty"`
+	State              string `json:"state,omitempty"`
+	Locality           string `json:"locality,omitempty"`
+}
+
+func (f subjectFields) subject() internal.Subject {
 	return internal.Subject{
-		CommonName:         strings.TrimSpace(r.FormValue("common_name")),
-		Organization:       strings.TrimSpace(r.FormValue("organization")),
-		OrganizationalUnit: strings.TrimSpace(r.FormValue("organizational_unit")),
-		Country:            strings.TrimSpace(r.FormValue("country")),
-		Province:           strings.TrimSpace(r.FormValue("state")),
-		Locality:           strings.TrimSpace(r.FormValue("locality")),
+		CommonName:         strings.TrimSpace(f.CommonName),
+		Organization:       strings.TrimSpace(f.Organization),
+		OrganizationalUnit: strings.TrimSpace(f.OrganizationalUnit),
+		Country:            strings.TrimSpace(f.Country),
+		Province:           strings.TrimSpace(f.State),
+		Locality:           strings.TrimSpace(f.Locality),
 	}
 }
 
+func subjectFromForm(r *http.Request) internal.Subject {
+	return subjectFields{
+		CommonName:         r.FormValue("common_name"),
+		Organization:       r.FormValue("organization"),
+		OrganizationalUnit: r.FormValue("organizational_unit"),
+		Country:            r.FormValue("country"),
+		State:              r.FormValue("state"),
+		Locality:           r.FormValue("locality"),
+	}.subject()
+}
+
 func parseValidityDays(value string, fallback int) int {
 	trimmed := strings.TrimSpace(value)
 	if trimmed == "" {
@@ -211,26 +283,31 @@ func parseKeyBits(value string, fallback int) int {
 	return internal.NormalizeKeyBits(parsed)
 }
 
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digital_signature": x509.KeyUsageDigitalSignature,
+	"key_encipherment":  x509.KeyUsageKeyEncipherment,
+	"data_encipherment": x509.KeyUsageDataEncipherment,
+	"key_agreement":     x509.KeyUsageKeyAgreement,
+	"cert_sign":         x509.KeyUsageCertSign,
+	"crl_sign":          x509.KeyUsageCRLSign,
+}
+
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"server_auth":      x509.ExtKeyUsageServerAuth,
+	"client_auth":      x509.ExtKeyUsageClientAuth,
+	"code_signing":     x509.ExtKeyUsageCodeSigning,
+	"email_protection": x509.ExtKeyUsageEmailProtection,
+	"time_stamping":    x509.ExtKeyUsageTimeStamping,
+	"ocsp_signing":     x509.ExtKeyUsageOCSPSigning,
+}
+
 func parseKeyUsage(values []string, fallback x509.KeyUsage) x509.KeyUsage {
 	if len(values) == 0 {
 		return fallback
 	}
 	var usage x509.KeyUsage
 	for _, value := range values {
-		switch strings.TrimSpace(value) {
-		case "digital_signature":
-			usage |= x509.KeyUsageDigitalSignature
-		case "key_encipherment":
-			usage |= x509.KeyUsageKeyEncipherment
-		case "data_encipherment":
-			usage |= x509.KeyUsageDataEncipherment
-		case "key_agreement":
-			usage |= x509.KeyUsageKeyAgreement
-		case "cert_sign":
-			usage |= x509.KeyUsageCertSign
-		case "crl_sign":
-			usage |= x509.KeyUsageCRLSign
-		}
+		usage |= keyUsageNames[strings.TrimSpace(value)]
 	}
 	if usage == 0 {
 		return fallback
@@ -243,31 +320,22 @@ func parseExtKeyUsage(values []string) []x509.ExtKeyUsage {
 		return nil
 	}
 	var usages []x509.ExtKeyUsage
-	replacer := strings.NewReplacer("\n", " ", "\r", " ")
 	for _, value := range values {
-		cleaned := strings.ToLower(replacer.Replace(strings.TrimSpace(value)))
-		switch cleaned {
-		case "server_auth":
-			usages = append(usages, x509.ExtKeyUsageServerAuth)
-		case "client_auth":
-			usages = append(usages, x509.ExtKeyUsageClientAuth)
-		case "code_signing":
-			usages = append(usages, x509.ExtKeyUsageCodeSigning)
-		case "email_protection":
-			usages = append(usages, x509.ExtKeyUsageEmailProtection)
-		case "time_stamping":
-			usages = append(usages, x509.ExtKeyUsageTimeStamping)
-		case "ocsp_signing":
-			usages = append(usages, x509.ExtKeyUsageOCSPSigning)
-		default:
-			if cleaned != "" {
-				log.Printf("Ignoring unknown extended key usage value: %s", cleaned)
-			}
+		cleaned := cleanExtKeyUsage(value)
+		if usage, ok := extKeyUsageNames[cleaned]; ok {
+			usages = append(usages, usage)
+		} else if cleaned != "" {
+			log.Printf("Ignoring unknown extended key usage value: %s", cleaned)
 		}
 	}
 	return usages
 }
 
+func cleanExtKeyUsage(value string) string {
+	replacer := strings.NewReplacer("\n", " ", "\r", " ")
+	return strings.ToLower(replacer.Replace(strings.TrimSpace(value)))
+}
+
 func parseKeyType(value string) string {
 	return internal.NormalizeKeyType(value)
 }
@@ -305,30 +373,6 @@ func parseIssuerSelection(value string) (string, string, string, error) {
 	}
 }
 
-const (
-	defaultSCEPPort          = "8001"
-	scepDetectionDialTimeout = 100 * time.Millisecond
-)
-
-func detectSCEPStatus() (bool, string, string) {
-	host := strings.TrimSpace(os.Getenv("CERT_HELPER_SCEP_HOST"))
-	configuredPort := strings.TrimSpace(os.Getenv("CERT_HELPER_SCEP_PORT"))
-	if host == "" {
-		host = "localhost"
-	}
-	port := configuredPort
-	if port == "" {
-		port = defaultSCEPPort
-	}
-	address := net.JoinHostPort(host, port)
-	conn, err := net.DialTimeout("tcp", address, scepDetectionDialTimeout)
-	if err == nil {
-		conn.Close()
-		return true, fmt.Sprintf("http://%s", address), port
-	}
-	return false, fmt.Sprintf("http://%s", address), port
-}
-
 func buildIssuerOptions(roots []string, intermediates []internal.IntermediateCAInfo) []IssuerOption {
 	var options []IssuerOption
 	for _, root := range roots {
@@ -346,6 +390,14 @@ func buildIssuerOptions(roots []string, intermediates []internal.IntermediateCAI
 	return options
 }
 
+func buildDefectOptions() []DefectOption {
+	options := make([]DefectOption, 0, len(internal.CertificateDefects))
+	for _, defect := range internal.CertificateDefects {
+		options = append(options, DefectOption{Value: defect, Description: internal.CertificateDefectDescription(defect)})
+	}
+	return options
+}
+
 func redirectWithMessage(w http.ResponseWriter, r *http.Request, message string, isError bool) {
 	key := "message"
 	if isError {
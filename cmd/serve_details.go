@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ctere1/cert-helper/internal"
+)
+
+// certificateDetailURL returns the detail page of the certificate at relPath in the output directory.
+func certificateDetailURL(relPath string) string {
+	return "/certificate?path=" + url.QueryEscape(relPath)
+}
+
+func handleCertificateDetail(w http.ResponseWriter, r *http.Request, outputDir string, index *internal.CertificateIndex) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	relPath, certPath, ok := resolveCertificateTarget(w, outputDir, r.FormValue("path"))
+	if !ok {
+		return
+	}
+	cert, err := internal.LoadCertificate(certPath)
+	if err != nil {
+		http.Error(w, "Not a certificate", http.StatusNotFound)
+		return
+	}
+
+	snapshot, err := index.Snapshot()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read certificates: %v", err), http.StatusInternalServerError)
+		return
+	}
+	inventory := snapshot.Inventory
+	status, statusClass, daysLeft := certificateStatus(cert.NotAfter, time.Now())
+	revoked := internal.IsCertificateRevoked(outputDir, cert)
+	if revoked {
+		status, statusClass = "Revoked", "expired"
+	}
+	name := cert.Subject.CommonName
+	if name == "" {
+		name = filepath.Base(certPath)
+	}
+	data := CertificateDetailData{
+		Title:       name + " - Certificate Helper",
+		Message:     r.URL.Query().Get("message"),
+		Error:       r.URL.Query().Get("error"),
+		Name:        name,
+		Type:        certificateType(cert, relPath),
+		Path:        relPath,
+		Status:      status,
+		StatusClass: statusClass,
+		DaysLeft:    daysLeft,
+		Revoked:     revoked,
+		IsCA:        cert.IsCA,
+		SelfSigned:  cert.CheckSignatureFrom(cert) == nil,
+		HasKey:      fileExists(internal.PrivateKeyPathForCertificate(certPath)),
+		Defect:      inventory[relPath].Defect,
+		Source:      inventory[relPath].Source,
+		Details:     internal.DescribeCertificate(cert),
+		Files:       certificateFiles(outputDir, certPath, snapshot),
+		Reasons:     revocationReasonNames(),
+		User:        principalFromRequest(r),
+		CSRFToken:   csrfToken(r),
+	}
+	data.Chain, data.ChainError = buildChainView(outputDir, cert)
+
+	tmpl, err := pageTemplate(certificateTemplateFile, template.FuncMap{
+		"formatSize": internal.FormatSize,
+		"join":       strings.Join,
+	})
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.ExecuteTemplate(w, filepath.Base(certificateTemplateFile), data); err != nil {
+		log.Printf("Template execution error: %v", err)
+	}
+}
+
+func handleRenewCertificate(w http.ResponseWriter, r *http.Request, outputDir string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	relPath, certPath, ok := resolveCertificateTarget(w, outputDir, r.FormValue("path"))
+	if !ok {
+		return
+	}
+	result, err := internal.RenewCertificate(outputDir, certPath, internal.RenewOptions{
+		PFXPassword:    r.FormValue("pfx_password"),
+		RevokePrevious: r.FormValue("revoke_previous") != "",
+	})
+	auditRequest(r, outputDir, internal.AuditEvent{Action: internal.AuditRenewCertificate, Target: relPath, Params: auditFormParams(r), Err: err})
+	if err != nil && result.CertPath == "" {
+		redirectToCertificate(w, r, relPath, fmt.Sprintf("Failed to renew certificate: %v", err), true)
+		return
+	}
+	newPath, relErr := filepath.Rel(outputDir, result.CertPath)
+	if relErr != nil {
+		newPath = relPath
+	}
+	if err != nil {
+		redirectToCertificate(w, r, filepath.ToSlash(newPath), err.Error(), true)
+		return
+	}
+	redirectToCertificate(w, r, filepath.ToSlash(newPath), "Certificate renewed with a new key pair.", false)
+}
+
+func handleRevokeCertificate(w http.ResponseWriter, r *http.Request, outputDir string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	relPath, certPath, ok := resolveCertificateTarget(w, outputDir, r.FormValue("path"))
+	if !ok {
+		return
+	}
+	cert, err := internal.LoadCertificate(certPath)
+	if err != nil {
+		http.Error(w, "Not a certificate", http.StatusNotFound)
+		return
+	}
+	if cert.IsCA && !allowedRole(r, internal.RoleCAAdmin) {
+		writeForbidden(w, r, fmt.Sprintf("The %s role is required to revoke CA certificates", internal.RoleCAAdmin))
+		return
+	}
+	reasonName := r.FormValue("reason")
+	if reasonName == "" {
+		reasonName = "unspecified"
+	}
+	reason, ok := internal.RevocationReasons[reasonName]
+	if !ok {
+		redirectToCertificate(w, r, relPath, fmt.Sprintf("Unknown revocation reason %q.", reasonName), true)
+		return
+	}
+
+	_, err = internal.RevokeIssuedCertificate(outputDir, cert, reason)
+	auditRequest(r, outputDir, internal.AuditEvent{
+		Action: internal.AuditRevokeCertificate,
+		Target: cert.SerialNumber.Text(16),
+		Params: map[string]string{"subject": cert.Subject.CommonName, "reason": reasonName},
+		Err:    err,
+	})
+	if errors.Is(err, internal.ErrAlreadyRevoked) {
+		redirectToCertificate(w, r, relPath, "The certificate is already revoked.", true)
+		return
+	}
+	if err != nil {
+		redirectToCertificate(w, r, relPath, fmt.Sprintf("Failed to revoke certificate: %v", err), true)
+		return
+	}
+	redirectToCertificate(w, r, relPath, "Certificate revoked and CRL updated.", false)
+}
+
+// resolveCertificateTarget resolves the path form value of a certificate request and writes an
+// error when it does not name a certificate file in the output directory. Exported bundles are
+// rejected like any other file that is not a single certificate.
+func resolveCertificateTarget(w http.ResponseWriter, outputDir, value string) (string, string, bool) {
+	relPath := strings.TrimPrefix(strings.TrimPrefix(value, "/files"), "/")
+	if relPath == "" || strings.Contains(relPath, "\\") || strings.ToLower(path.Ext(relPath)) != ".pem" {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return "", "", false
+	}
+	certPath, err := internal.ResolveOutputPath(outputDir, relPath)
+	if err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return "", "", false
+	}
+	if !fileExists(certPath) {
+		http.NotFound(w, nil)
+		return "", "", false
+	}
+	relPath = path.Clean(relPath)
+	inventory, err := internal.LoadInventory(outputDir)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read inventory: %v", err), http.StatusInternalServerError)
+		return "", "", false
+	}
+	if _, ok := internal.LoadCertificateFile(outputDir, certPath); !ok || inventory[relPath].Kind == internal.InventoryKindExport {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return "", "", false
+	}
+	return relPath, certPath, true
+}
+
+func redirectToCertificate(w http.ResponseWriter, r *http.Request, relPath, message string, isError bool) {
+	key := "message"
+	if isError {
+		key = "error"
+	}
+	http.Redirect(w, r, certificateDetailURL(relPath)+"&"+key+"="+url.QueryEscape(message), http.StatusSeeOther)
+}
+
+// buildChainView validates the chain of cert and links every CA of the output directory in it to
+// its own detail page.
+func buildChainView(outputDir string, cert *x509.Certificate) ([]ChainLinkView, string) {
+	cas, _ := internal.ListCAs(outputDir)
+	validation := internal.ValidateChain(outputDir, cert)
+	links := make([]ChainLinkView, 0, len(validation.Chain))
+	for i, link := range validation.Chain {
+		view := ChainLinkView{
+			Name:     link.Certificate.Subject.CommonName,
+			Subject:  link.Certificate.Subject.String(),
+			NotAfter: link.Certificate.NotAfter,
+			Problem:  link.Problem,
+			Current:  i == 0,
+		}
+		if view.Name == "" {
+			view.Name = view.Subject
+		}
+		for _, ca := range cas {
+			if ca.Cert == nil || !ca.Cert.Equal(link.Certificate) {
+				continue
+			}
+			if relPath, err := filepath.Rel(outputDir, ca.CertPath); err == nil {
+				view.DetailURL = certificateDetailURL(filepath.ToSlash(relPath))
+			}
+			break
+		}
+		links = append(links, view)
+	}
+	if validation.Err != nil {
+		return links, validation.Err.Error()
+	}
+	return links, ""
+}
+
+// certificateFiles lists the files stored with the certificate at certPath: its key, PKCS#12
+// bundle and exports, which share its file name.
+func certificateFiles(outputDir, certPath string, snapshot internal.IndexSnapshot) []FileInfo {
+	dir := filepath.Dir(certPath)
+	relDir, err := filepath.Rel(outputDir, dir)
+	if err != nil {
+		return nil
+	}
+	files, err := listFileInfos(dir, path.Join("/files", filepath.ToSlash(relDir)))
+	if err != nil {
+		return nil
+	}
+	annotateFileInfos(outputDir, snapshot, files)
+	stem := strings.TrimSuffix(filepath.Base(certPath), filepath.Ext(certPath))
+	var related []FileInfo
+	for _, file := range files {
+		base := strings.TrimSuffix(file.Name, filepath.Ext(file.Name))
+		if !file.IsDir && (base == stem || strings.HasPrefix(base, stem+"_")) {
+			related = append(related, file)
+		}
+	}
+	return related
+}
+
+func revocationReasonNames() []string {
+	names := make([]string, 0, len(internal.RevocationReasons))
+	for name := range internal.RevocationReasons {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return internal.RevocationReasons[names[i]] < internal.RevocationReasons[names[j]]
+	})
+	return names
+}
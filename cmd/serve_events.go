@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Ctere1/cert-helper/internal"
+)
+
+const (
+	// eventPollInterval is how often the certificate index is refreshed while no pages are loaded.
+	// Polling picks up certificates written by other processes, such as CLI batch jobs or a separate
+	// ACME server.
+	eventPollInterval = 2 * time.Second
+	// eventKeepAlive keeps idle event streams open through proxies.
+	eventKeepAlive = 25 * time.Second
+	// eventBuffer is how many events a slow client may fall behind before events are dropped.
+	eventBuffer = 32
+)
+
+// Dashboard event types.
+const (
+	eventCertificate = "certificate"
+	eventFiles       = "files"
+)
+
+// dashboardEvent is sent to dashboards as a server-sent event named after its Type. Certificate
+// events carry one of the internal.Change* actions.
+type dashboardEvent struct {
+	Type   string `json:"type"`
+	Action string `json:"action,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+// eventBroker fans dashboard events out to every connected event stream.
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan dashboardEvent]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subscribers: make(map[chan dashboardEvent]struct{})}
+}
+
+func (b *eventBroker) subscribe() chan dashboardEvent {
+	ch := make(chan dashboardEvent, eventBuffer)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan dashboardEvent) {
+	b.mu.Lock()
+	delete(b.subscribers, ch)
+	b.mu.Unlock()
+}
+
+// publish never blocks; a client that is too far behind misses the event and catches up on the
+// next one, since every event makes the dashboard reload its data.
+func (b *eventBroker) publish(event dashboardEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// ServeHTTP streams events to a dashboard until it disconnects.
+func (b *eventBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", (5 * time.Second).Milliseconds())
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	events := b.subscribe()
+	defer b.unsubscribe(events)
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// publishIndexUpdate turns the changes found by a certificate index refresh into dashboard events.
+func (b *eventBroker) publishIndexUpdate(update internal.IndexUpdate) {
+	for _, change := range update.Certificates {
+		b.publish(dashboardEvent{Type: eventCertificate, Action: change.Action, Path: change.Path, Name: change.Name})
+	}
+	if update.FilesChanged {
+		b.publish(dashboardEvent{Type: eventFiles})
+	}
+}
+
+// watchOutputDir refreshes the index periodically, so that changes made outside the dashboard reach
+// open pages and certificates entering the expiring or expired state are reported on time.
+func watchOutputDir(index *internal.CertificateIndex) {
+	ticker := time.NewTicker(eventPollInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := index.Refresh(now); err != nil {
+			log.Printf("Failed to scan output directory: %v", err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Ctere1/cert-helper/internal"
+)
+
+func handleExport(w http.ResponseWriter, r *http.Request, outputDir string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	format, err := internal.NormalizeExportFormat(r.FormValue("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	options := internal.ExportOptions{
+		Format:       format,
+		Password:     r.FormValue("pfx_password"),
+		FriendlyName: strings.TrimSpace(r.FormValue("friendly_name")),
+		Alias:        strings.TrimSpace(r.FormValue("alias")),
+		PFXEncoding:  r.FormValue("pfx_encoding"),
+	}
+	target := strings.TrimPrefix(r.FormValue("path"), "/files/")
+
+	var result internal.ExportResult
+	switch {
+	case format == internal.ExportTrustBundle:
+		result, err = internal.ExportTrustBundleFile(outputDir)
+	case internal.IsStoreFormat(format) && target == "":
+		result, err = internal.ExportTruststore(outputDir, nil, format, options)
+	default:
+		if strings.Contains(target, "\\") {
+			http.Error(w, "Invalid path", http.StatusBadRequest)
+			return
+		}
+		certPath, resolveErr := internal.ResolveOutputPath(outputDir, target)
+		if resolveErr != nil {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		result, err = internal.ExportCertificate(outputDir, certPath, options)
+	}
+	auditRequest(r, outputDir, internal.AuditEvent{Action: internal.AuditExportCertificate, Target: target, Params: auditFormParams(r), Err: err})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Export failed: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	writeExportResult(w, result)
+}
+
+func handleExportSupplicant(w http.ResponseWriter, r *http.Request, outputDir string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	target := strings.TrimPrefix(r.FormValue("path"), "/files/")
+	if target == "" || strings.Contains(target, "\\") {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	certPath, err := internal.ResolveOutputPath(outputDir, target)
+	if err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	var serverNames []string
+	for _, name := range strings.Split(r.FormValue("server_names"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			serverNames = append(serverNames, name)
+		}
+	}
+
+	result, err := internal.ExportSupplicantConfig(outputDir, certPath, internal.SupplicantOptions{
+		Format:      r.FormValue("format"),
+		SSID:        strings.TrimSpace(r.FormValue("ssid")),
+		ServerNames: serverNames,
+		Identity:    strings.TrimSpace(r.FormValue("identity")),
+		Password:    r.FormValue("pfx_password"),
+		PFXEncoding: r.FormValue("pfx_encoding"),
+	})
+	auditRequest(r, outputDir, internal.AuditEvent{Action: internal.AuditExportSupplicant, Target: target, Params: auditFormParams(r), Err: err})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Export failed: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	writeExportResult(w, result)
+}
+
+func writeExportResult(w http.ResponseWriter, result internal.ExportResult) {
+	w.Header().Set("Content-Type", result.ContentType)
+	if result.PFXEncoding != "" {
+		w.Header().Set("X-PFX-Encoding", result.PFXEncoding)
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", result.FileName))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(result.Data)))
+	_, _ = w.Write(result.Data)
+}
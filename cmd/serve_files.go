@@ -32,7 +32,7 @@ func fileBrowserSectionURL(rawPath string) string {
 	return fmt.Sprintf("/?files=%s#files", url.QueryEscape(cleaned))
 }
 
-func buildDashboardFileBrowser(baseDir, rawPath string) (PageData, error) {
+func buildDashboardFileBrowser(baseDir, rawPath string, snapshot internal.IndexSnapshot) (PageData, error) {
 	if rawPath == "" {
 		rawPath = "/"
 	}
@@ -71,6 +71,7 @@ func buildDashboardFileBrowser(baseDir, rawPath string) (PageData, error) {
 	if err != nil {
 		return PageData{}, err
 	}
+	annotateFileInfos(baseDir, snapshot, fileInfos)
 
 	var parentPath string
 	if urlPath != "/" {
@@ -141,7 +142,7 @@ func listFileInfos(fsPath, urlPath string) ([]FileInfo, error) {
 	return fileInfos, nil
 }
 
-func serveFileBrowser(w http.ResponseWriter, r *http.Request, baseDir, urlPrefix string) {
+func serveFileBrowser(w http.ResponseWriter, r *http.Request, baseDir, urlPrefix string, index *internal.CertificateIndex) {
 	// Clean the URL path
 	// Reject malformed URLs with backslashes to prevent inconsistent URL vs. filesystem path interpretation.
 	rawPath := r.URL.Path
@@ -194,6 +195,7 @@ func serveFileBrowser(w http.ResponseWriter, r *http.Request, baseDir, urlPrefix
 		}
 
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filepath.Base(fsPath)))
+		auditRequest(r, baseDir, internal.AuditEvent{Action: internal.AuditDownloadFile, Target: filepath.ToSlash(relPath)})
 		http.ServeFile(w, r, fsPath)
 		return
 	}
@@ -204,6 +206,12 @@ func serveFileBrowser(w http.ResponseWriter, r *http.Request, baseDir, urlPrefix
 		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
 		return
 	}
+	snapshot, err := index.Snapshot()
+	if err != nil {
+		http.Error(w, "Failed to read certificates", http.StatusInternalServerError)
+		return
+	}
+	annotateFileInfos(baseDir, snapshot, fileInfos)
 
 	// Prepare template data
 	var parentPath string
@@ -231,15 +239,13 @@ func serveFileBrowser(w http.ResponseWriter, r *http.Request, baseDir, urlPrefix
 		Title:       "Certificate Browser - " + urlPath,
 		RootPath:    rootPath,
 		Summary:     buildFileSummary(fileInfos),
+		CSRFToken:   csrfToken(r),
 	}
 
-	// Create template with helper functions
-	tmpl := template.New("browser").Funcs(template.FuncMap{
+	tmpl, err := pageTemplate(fileBrowserTemplateFile, template.FuncMap{
 		"formatSize": internal.FormatSize,
 		"fileExt":    filepath.Ext,
 	})
-
-	tmpl, err = tmpl.ParseFS(templateFS, fileBrowserTemplateFile)
 	if err != nil {
 		http.Error(w, "Template error", http.StatusInternalServerError)
 		return
@@ -251,6 +257,33 @@ func serveFileBrowser(w http.ResponseWriter, r *http.Request, baseDir, urlPrefix
 	}
 }
 
+// annotateFileInfos adds key availability, whether certificates are CAs and inventory metadata,
+// such as the PKCS#12 encoding of bundles, to a listing. Certificates and the inventory are taken
+// from an index snapshot rather than read again.
+func annotateFileInfos(baseDir string, snapshot internal.IndexSnapshot, fileInfos []FileInfo) {
+	for i := range fileInfos {
+		if fileInfos[i].IsDir {
+			continue
+		}
+		if strings.ToLower(filepath.Ext(fileInfos[i].Name)) == ".pem" {
+			fileInfos[i].HasPrivateKey = fileExists(internal.PrivateKeyPathForCertificate(fileInfos[i].SystemPath))
+		}
+		relPath, err := filepath.Rel(baseDir, fileInfos[i].SystemPath)
+		if err != nil {
+			continue
+		}
+		relPath = filepath.ToSlash(relPath)
+		if record, ok := snapshot.Inventory[relPath]; ok {
+			fileInfos[i].PFXEncoding = record.PFXEncoding
+			fileInfos[i].Defect = record.Defect
+			fileInfos[i].Source = record.Source
+		}
+		if certificate, ok := snapshot.Certificate(relPath); ok {
+			fileInfos[i].IsCA = certificate.Certificate.IsCA
+		}
+	}
+}
+
 func buildFileSummary(fileInfos []FileInfo) FileSummary {
 	summary := FileSummary{
 		Total: len(fileInfos),
@@ -9,6 +9,8 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+
+	"github.com/Ctere1/cert-helper/internal"
 )
 
 func handleOpenInExplorer(w http.ResponseWriter, r *http.Request, baseDir string) {
@@ -44,7 +46,9 @@ func handleOpenInExplorer(w http.ResponseWriter, r *http.Request, baseDir string
 		http.Error(w, "Path not found", http.StatusNotFound)
 		return
 	}
-	if err := openInExplorer(absTarget); err != nil {
+	err = openInExplorer(absTarget)
+	auditRequest(r, baseDir, internal.AuditEvent{Action: internal.AuditOpenFolder, Target: filepath.ToSlash(rel), Err: err})
+	if err != nil {
 		http.Error(w, "Failed to open path", http.StatusInternalServerError)
 		return
 	}
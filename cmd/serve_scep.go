@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ctere1/cert-helper/internal"
+	kitlog "github.com/go-kit/log"
+)
+
+// scepRecentEvents is the number of enrollment outcomes kept for the dashboard.
+const scepRecentEvents = 50
+
+// scepController runs the SCEP server inside the dashboard process at /scep. It can be started,
+// reconfigured and stopped from the dashboard; requests to /scep fail while it is stopped.
+type scepController struct {
+	outputDir string
+
+	mu        sync.Mutex
+	server    *internal.SCEPServer
+	config    SCEPConfigForm
+	startedAt time.Time
+	issued    int
+	events    []SCEPEventEntry
+}
+
+func newSCEPController(outputDir string) *scepController {
+	return &scepController{outputDir: outputDir, config: defaultSCEPConfig()}
+}
+
+func defaultSCEPConfig() SCEPConfigForm {
+	return SCEPConfigForm{
+		Issuer:           "root:default",
+		ValidityDays:     365,
+		AllowRenewalDays: 14,
+		ChallengeMode:    internal.SCEPChallengeModeStatic,
+		Challenge:        internal.DefaultSCEPChallenge,
+	}
+}
+
+func (c *scepController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	server := c.server
+	c.mu.Unlock()
+	if server == nil {
+		http.Error(w, "SCEP server is stopped", http.StatusServiceUnavailable)
+		return
+	}
+	server.ServeHTTP(w, r)
+}
+
+// start (re)starts the server with config. A running server keeps serving when the new
+// configuration cannot be loaded.
+func (c *scepController) start(config SCEPConfigForm) error {
+	issuerType, rootName, issuerName, err := parseIssuerSelection(config.Issuer)
+	if err != nil {
+		return err
+	}
+	server, err := internal.NewSCEPServer(c.outputDir, internal.SCEPServerOptions{
+		IssuerType:       issuerType,
+		RootName:         rootName,
+		IssuerName:       issuerName,
+		RACertPath:       config.RACertPath,
+		ValidityDays:     config.ValidityDays,
+		AllowRenewalDays: config.AllowRenewalDays,
+		RevokeSuperseded: config.RevokeSuperseded,
+		ChallengeMode:    config.ChallengeMode,
+		Challenge:        config.Challenge,
+		ChallengeWebhook: config.ChallengeWebhook,
+		ChallengeExec:    config.ChallengeExec,
+		ManualApproval:   config.ManualApproval,
+		Logger:           kitlog.With(kitlog.NewLogfmtLogger(os.Stderr), "service", "scep"),
+		OnEvent:          c.record,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.server == nil {
+		c.startedAt = time.Now()
+		c.issued = 0
+	}
+	c.server = server
+	c.config = config
+	return nil
+}
+
+func (c *scepController) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.server = nil
+}
+
+func (c *scepController) record(event internal.SCEPEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if event.Action == internal.SCEPEventIssued {
+		c.issued++
+	}
+	entry := SCEPEventEntry{Time: event.Time.Local(), Action: event.Action, Subject: event.Subject, Detail: event.Detail}
+	c.events = append([]SCEPEventEntry{entry}, c.events...)
+	if len(c.events) > scepRecentEvents {
+		c.events = c.events[:scepRecentEvents]
+	}
+}
+
+// status describes the server as seen by a client of the dashboard at baseURL.
+func (c *scepController) status(baseURL string) SCEPStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := SCEPStatus{
+		Running:        c.server != nil,
+		URL:            baseURL + "/scep",
+		Config:         c.config,
+		ChallengeModes: internal.SCEPChallengeModes,
+		Events:         append([]SCEPEventEntry(nil), c.events...),
+	}
+	if c.server != nil {
+		status.StartedAt = c.startedAt
+		status.Issued = c.issued
+		status.SigningCA = c.server.SigningCA().Subject.CommonName
+		if raCert := c.server.RACertificate(); raCert != nil {
+			status.RACert = raCert.Subject.CommonName
+		}
+	}
+	return status
+}
+
+func handleStartSCEP(w http.ResponseWriter, r *http.Request, controller *scepController) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config := SCEPConfigForm{
+		Issuer:           r.FormValue("issuer"),
+		RACertPath:       strings.TrimSpace(r.FormValue("ra_cert")),
+		RevokeSuperseded: r.FormValue("revoke_superseded") != "",
+		ManualApproval:   r.FormValue("manual_approval") != "",
+		ChallengeMode:    r.FormValue("challenge_mode"),
+		Challenge:        r.FormValue("challenge"),
+		ChallengeWebhook: strings.TrimSpace(r.FormValue("challenge_webhook")),
+		ChallengeExec:    strings.TrimSpace(r.FormValue("challenge_exec")),
+	}
+	var err error
+	if config.ValidityDays, err = strconv.Atoi(strings.TrimSpace(r.FormValue("validity_days"))); err != nil || config.ValidityDays <= 0 {
+		redirectWithMessage(w, r, "Validity must be a positive number of days.", true)
+		return
+	}
+	if config.AllowRenewalDays, err = strconv.Atoi(strings.TrimSpace(r.FormValue("allow_renewal_days"))); err != nil || config.AllowRenewalDays < 0 {
+		redirectWithMessage(w, r, "Renewal window must be zero or a positive number of days.", true)
+		return
+	}
+
+	running := controller.status(requestBaseURL(r)).Running
+	err = controller.start(config)
+	auditRequest(r, controller.outputDir, internal.AuditEvent{Action: internal.AuditSCEPStart, Target: config.Issuer, Params: auditFormParams(r), Err: err})
+	if err != nil {
+		redirectWithMessage(w, r, fmt.Sprintf("Failed to start SCEP server: %v", err), true)
+		return
+	}
+	if running {
+		redirectWithMessage(w, r, "SCEP server reconfigured.", false)
+		return
+	}
+	redirectWithMessage(w, r, fmt.Sprintf("SCEP server started at %s/scep.", requestBaseURL(r)), false)
+}
+
+func handleStopSCEP(w http.ResponseWriter, r *http.Request, controller *scepController) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	controller.stop()
+	auditRequest(r, controller.outputDir, internal.AuditEvent{Action: internal.AuditSCEPStop})
+	redirectWithMessage(w, r, "SCEP server stopped.", false)
+}
+
+func handleCreateSCEPChallenge(w http.ResponseWriter, r *http.Request, outputDir string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ttl := internal.DefaultSCEPChallengeTTL
+	if value := strings.TrimSpace(r.FormValue("ttl_minutes")); value != "" {
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes <= 0 {
+			redirectWithMessage(w, r, "Challenge lifetime must be a positive number of minutes.", true)
+			return
+		}
+		ttl = time.Duration(minutes) * time.Minute
+	}
+
+	challenge, err := internal.NewSCEPChallengeStore(outputDir).Create(internal.SCEPChallengeOptions{
+		TTL:        ttl,
+		CommonName: r.FormValue("common_name"),
+		SANs:       parseSANs(r.FormValue("subject_alt_names")),
+	})
+	auditRequest(r, outputDir, internal.AuditEvent{Action: internal.AuditSCEPCreateChallenge, Target: challenge.ID, Params: auditFormParams(r), Err: err})
+	if err != nil {
+		redirectWithMessage(w, r, fmt.Sprintf("Failed to create challenge: %v", err), true)
+		return
+	}
+	redirectWithMessage(w, r, fmt.Sprintf("Challenge %s created, valid until %s.", challenge.ID, challenge.ExpiresAt.Local().Format("2006-01-02 15:04")), false)
+}
+
+func handleDeleteSCEPChallenge(w http.ResponseWriter, r *http.Request, outputDir string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.FormValue("id")
+	err := internal.NewSCEPChallengeStore(outputDir).Delete(id)
+	auditRequest(r, outputDir, internal.AuditEvent{Action: internal.AuditSCEPDeleteChallenge, Target: id, Err: err})
+	if err != nil {
+		redirectWithMessage(w, r, fmt.Sprintf("Failed to delete challenge: %v", err), true)
+		return
+	}
+	redirectWithMessage(w, r, fmt.Sprintf("Challenge %s deleted.", id), false)
+}
+
+func buildSCEPChallengeEntries(outputDir string) ([]SCEPChallengeEntry, error) {
+	challenges, err := internal.NewSCEPChallengeStore(outputDir).List()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	entries := make([]SCEPChallengeEntry, 0, len(challenges))
+	for _, challenge := range challenges {
+		entries = append(entries, SCEPChallengeEntry{
+			ID:        challenge.ID,
+			Password:  challenge.Password,
+			Status:    challenge.Status(now),
+			ExpiresAt: challenge.ExpiresAt.Local(),
+			Binding:   challenge.Binding(),
+			UsedBy:    challenge.UsedBy,
+		})
+	}
+	return entries, nil
+}
+
+func handleApproveSCEPRequest(w http.ResponseWriter, r *http.Request, outputDir string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	request, err := internal.NewSCEPRequestQueue(outputDir).Approve(r.FormValue("id"))
+	auditRequest(r, outputDir, internal.AuditEvent{Action: internal.AuditSCEPApproveRequest, Target: r.FormValue("id"), Params: map[string]string{"subject": request.Subject}, Err: err})
+	if err != nil {
+		redirectWithMessage(w, r, fmt.Sprintf("Failed to approve request: %v", err), true)
+		return
+	}
+	redirectWithMessage(w, r, fmt.Sprintf("Request from %s approved; the certificate is issued on the client's next poll.", request.Subject), false)
+}
+
+func handleRejectSCEPRequest(w http.ResponseWriter, r *http.Request, outputDir string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	request, err := internal.NewSCEPRequestQueue(outputDir).Reject(r.FormValue("id"), r.FormValue("reason"))
+	auditRequest(r, outputDir, internal.AuditEvent{Action: internal.AuditSCEPRejectRequest, Target: r.FormValue("id"), Params: map[string]string{"subject": request.Subject, "reason": r.FormValue("reason")}, Err: err})
+	if err != nil {
+		redirectWithMessage(w, r, fmt.Sprintf("Failed to reject request: %v", err), true)
+		return
+	}
+	redirectWithMessage(w, r, fmt.Sprintf("Request from %s rejected.", request.Subject), false)
+}
+
+func handleDeleteSCEPRequest(w http.ResponseWriter, r *http.Request, outputDir string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.FormValue("id")
+	err := internal.NewSCEPRequestQueue(outputDir).Delete(id)
+	auditRequest(r, outputDir, internal.AuditEvent{Action: internal.AuditSCEPDeleteRequest, Target: id, Err: err})
+	if err != nil {
+		redirectWithMessage(w, r, fmt.Sprintf("Failed to delete request: %v", err), true)
+		return
+	}
+	redirectWithMessage(w, r, fmt.Sprintf("Request %s deleted.", id), false)
+}
+
+func buildSCEPRequestEntries(outputDir string) ([]SCEPRequestEntry, error) {
+	requests, err := internal.NewSCEPRequestQueue(outputDir).List()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]SCEPRequestEntry, 0, len(requests))
+	for _, request := range requests {
+		entries = append(entries, SCEPRequestEntry{
+			ID:         request.ID,
+			Status:     request.Status,
+			Subject:    request.Subject,
+			SANs:       strings.Join(request.SANs, ", "),
+			Reason:     request.Reason,
+			ReceivedAt: request.ReceivedAt.Local(),
+		})
+	}
+	return entries, nil
+}
+
+// requestBaseURL is the scheme and host the client used to reach the dashboard.
+func requestBaseURL(r *http.Request) string {
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
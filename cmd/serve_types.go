@@ -1,6 +1,10 @@
 package cmd
 
-import "time"
+import (
+	"time"
+
+	"github.com/Ctere1/cert-helper/internal"
+)
 
 type FileInfo struct {
 	Name              string
@@ -13,6 +17,11 @@ type FileInfo struct {
 	FolderPath        string
 	BrowserFolderPath string
 	SystemFolderPath  string
+	PFXEncoding       string
+	Defect            string
+	Source            string
+	HasPrivateKey     bool
+	IsCA              bool
 }
 
 type FileSummary struct {
@@ -31,6 +40,8 @@ type PageData struct {
 	Title       string
 	RootPath    string
 	Summary     FileSummary
+	// CSRFToken must accompany every form the page submits.
+	CSRFToken string
 }
 
 type IssuerOption struct {
@@ -38,6 +49,11 @@ type IssuerOption struct {
 	Value string
 }
 
+type DefectOption struct {
+	Value       string
+	Description string
+}
+
 type DefaultFormValues struct {
 	Organization           string
 	OrganizationalUnit     string
@@ -75,25 +91,146 @@ type CertificateEntry struct {
 	Status           string
 	StatusClass      string
 	Path             string
+	ExportPath       string
+	DetailPath       string
+	HasPrivateKey    bool
+	IsCA             bool
 	SystemPath       string
 	FolderPath       string
 	SystemFolderPath string
+	Defect           string
+	Source           string
 }
 
 type DashboardData struct {
+	Title          string
+	Message        string
+	Error          string
+	RootCAs        []string
+	IssuerOptions  []IssuerOption
+	DefectOptions  []DefectOption
+	Files          []FileInfo
+	Defaults       DefaultFormValues
+	Summary        CertificateSummary
+	Certificates   []CertificateEntry
+	Hierarchy      internal.CertificateTree
+	OutputDir      string
+	FileSummary    FileSummary
+	FileBrowser    PageData
+	SCEP           SCEPStatus
+	SCEPChallenges []SCEPChallengeEntry
+	SCEPRequests   []SCEPRequestEntry
+	// User is the signed-in client, nil when authentication is disabled.
+	User      *internal.AuthPrincipal
+	CSRFToken string
+	Audit     AuditView
+}
+
+type AuditView struct {
+	Entries []AuditEntryView
+	// Total counts every entry of the log, Entries only the most recent ones.
+	Total       int
+	LastHash    string
+	Verified    bool
+	VerifyError string
+}
+
+type AuditEntryView struct {
+	internal.AuditEntry
+	Params string
+}
+
+type CertificateDetailData struct {
+	Title       string
+	Message     string
+	Error       string
+	Name        string
+	Type        string
+	Path        string
+	Status      string
+	StatusClass string
+	DaysLeft    int
+	Revoked     bool
+	IsCA        bool
+	SelfSigned  bool
+	HasKey      bool
+	Defect      string
+	Source      string
+	Details     internal.CertificateDetails
+	Chain       []ChainLinkView
+	// ChainError explains why the chain does not validate, empty when it does.
+	ChainError string
+	Files      []FileInfo
+	Reasons    []string
+	User       *internal.AuthPrincipal
+	CSRFToken  string
+}
+
+type ChainLinkView struct {
+	Name      string
+	Subject   string
+	NotAfter  time.Time
+	Problem   string
+	DetailURL string
+	// Current marks the certificate the page is about.
+	Current bool
+}
+
+type LoginData struct {
 	Title         string
-	Message       string
 	Error         string
-	RootCAs       []string
-	IssuerOptions []IssuerOption
-	Files         []FileInfo
-	Defaults      DefaultFormValues
-	Summary       CertificateSummary
-	Certificates  []CertificateEntry
-	OutputDir     string
-	FileSummary   FileSummary
-	FileBrowser   PageData
-	SCEPRunning   bool
-	SCEPURL       string
-	SCEPPort      string
+	Next          string
+	PasswordLogin bool
+	ClientCert    bool
+	CSRFToken     string
+}
+
+type SCEPStatus struct {
+	Running        bool
+	URL            string
+	StartedAt      time.Time
+	SigningCA      string
+	RACert         string
+	Issued         int
+	Config         SCEPConfigForm
+	ChallengeModes []string
+	Events         []SCEPEventEntry
+}
+
+type SCEPConfigForm struct {
+	Issuer           string
+	RACertPath       string
+	ValidityDays     int
+	AllowRenewalDays int
+	RevokeSuperseded bool
+	ManualApproval   bool
+	ChallengeMode    string
+	Challenge        string
+	ChallengeWebhook string
+	ChallengeExec    string
+}
+
+type SCEPEventEntry struct {
+	Time    time.Time
+	Action  string
+	Subject string
+	Detail  string
+}
+
+type SCEPChallengeEntry struct {
+	ID        string
+	Password  string
+	Status    string
+	ExpiresAt time.Time
+	Binding   string
+	UsedBy    string
+}
+
+type SCEPRequestEntry struct {
+	ID         string
+	Status     string
+	Subject    string
+	SANs       string
+	Reason     string
+	ReceivedAt time.Time
 }
@@ -0,0 +1,15 @@
+document.querySelectorAll("[data-export-format]").forEach((button) => {
+    button.addEventListener("click", () => {
+        exportCertificate(button.dataset.exportPath, button.dataset.exportFormat, button.dataset.pfxEncoding);
+    });
+});
+
+document.querySelectorAll("form[data-confirm]").forEach((form) => {
+    form.addEventListener("submit", (event) => {
+        if (!window.confirm(form.dataset.confirm)) {
+            event.preventDefault();
+        }
+    });
+});
+
+setupCopyButtons();
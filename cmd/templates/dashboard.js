@@ -49,10 +49,12 @@ if (initialSection && validSections.has(initialSection)) {
 }
 
 const certContextMenu = document.getElementById("certContextMenu");
+const certMenuDetails = document.getElementById("cert-menu-details");
 const certMenuDownload = document.getElementById("cert-menu-download");
 const certMenuOpenFolder = document.getElementById("cert-menu-open-folder");
 const certMenuOpenLocation = document.getElementById("cert-menu-open-location");
 const certMenuCopy = document.getElementById("cert-menu-copy");
+const certMenuExports = document.querySelectorAll("#certContextMenu [data-export-format]");
 
 function hideCertMenu() {
     if (!certContextMenu) {
@@ -68,10 +70,39 @@ function showCertMenu(event, row) {
     }
     event.preventDefault();
     event.stopPropagation();
+    const detailUrl = row.dataset.detailUrl || "";
     const downloadUrl = row.dataset.downloadUrl || "";
     const folderUrl = row.dataset.folderUrl || "";
     const systemPath = row.dataset.systemPath || "";
     const systemFolder = row.dataset.systemFolder || "";
+    const exportPath = row.dataset.exportPath || "";
+    const hasKey = row.dataset.hasKey === "true";
+    const isCA = row.dataset.isCa === "true";
+
+    certMenuExports.forEach((item) => {
+        const available = exportPath &&
+            (item.dataset.requiresKey !== "true" || hasKey) &&
+            (item.dataset.requiresCa !== "true" || isCA);
+        item.style.display = available ? "block" : "none";
+        item.onclick = () => {
+            if (available) {
+                if (item.dataset.supplicantFormat) {
+                    exportSupplicantConfig(exportPath, item.dataset.supplicantFormat);
+                } else {
+                    exportCertificate(exportPath, item.dataset.exportFormat, item.dataset.pfxEncoding);
+                }
+            }
+            hideCertMenu();
+        };
+    });
+
+    certMenuDetails.style.display = detailUrl ? "block" : "none";
+    certMenuDetails.onclick = () => {
+        if (detailUrl) {
+            window.location.href = detailUrl;
+        }
+        hideCertMenu();
+    };
 
     certMenuDownload.style.display = downloadUrl ? "block" : "none";
     certMenuDownload.onclick = () => {
@@ -116,14 +147,13 @@ function showCertMenu(event, row) {
     certContextMenu.style.top = `${Math.max(8, top)}px`;
 }
 
-document.querySelectorAll(".certificate-row").forEach((row) => {
-    const trigger = row.querySelector(".action-trigger");
+// Delegated so that rows replaced by live updates keep working.
+document.addEventListener("click", (event) => {
+    const trigger = event.target.closest(".certificate-row .action-trigger");
     if (trigger) {
-        trigger.addEventListener("click", (event) => showCertMenu(event, row));
+        showCertMenu(event, trigger.closest(".certificate-row"));
+        return;
     }
-});
-
-document.addEventListener("click", (event) => {
     if (certContextMenu && !certContextMenu.contains(event.target)) {
         hideCertMenu();
     }
@@ -137,3 +167,68 @@ document.addEventListener("keydown", (event) => {
 
 setupCopyButtons();
 setupOpenButtons();
+
+const liveNotice = document.getElementById("liveNotice");
+const liveActions = {
+    issued: "issued",
+    renewed: "renewed",
+    removed: "removed",
+    revoked: "revoked",
+    expiring: "is expiring soon",
+    expired: "has expired"
+};
+let liveRefreshTimer = null;
+let liveNoticeTimer = null;
+
+// refreshDashboard reloads the page in the background and swaps in every element marked with
+// data-live, leaving forms, open tabs and the search box untouched.
+async function refreshDashboard() {
+    let response;
+    try {
+        response = await fetch(window.location.pathname + window.location.search, {
+            headers: { Accept: "text/html" },
+            credentials: "same-origin"
+        });
+    } catch (error) {
+        return;
+    }
+    if (!response.ok || response.redirected) {
+        return;
+    }
+    const fresh = new DOMParser().parseFromString(await response.text(), "text/html");
+    document.querySelectorAll("[data-live]").forEach((element) => {
+        const replacement = fresh.querySelector(`[data-live="${element.dataset.live}"]`);
+        if (replacement) {
+            element.replaceWith(document.adoptNode(replacement));
+        }
+    });
+    document.dispatchEvent(new CustomEvent("dashboard:updated"));
+}
+
+function scheduleRefresh() {
+    clearTimeout(liveRefreshTimer);
+    liveRefreshTimer = setTimeout(refreshDashboard, 300);
+}
+
+function showLiveNotice(event) {
+    if (!liveNotice || !event.name || !liveActions[event.action]) {
+        return;
+    }
+    liveNotice.textContent = `${event.name} ${liveActions[event.action]}.`;
+    liveNotice.classList.toggle("error", event.action === "revoked" || event.action === "expired");
+    liveNotice.classList.toggle("success", event.action !== "revoked" && event.action !== "expired");
+    liveNotice.hidden = false;
+    clearTimeout(liveNoticeTimer);
+    liveNoticeTimer = setTimeout(() => {
+        liveNotice.hidden = true;
+    }, 8000);
+}
+
+if (window.EventSource) {
+    const events = new EventSource("/events");
+    events.addEventListener("certificate", (message) => {
+        showLiveNotice(JSON.parse(message.data));
+        scheduleRefresh();
+    });
+    events.addEventListener("files", scheduleRefresh);
+}
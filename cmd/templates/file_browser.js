@@ -1,5 +1,4 @@
 const searchInput = document.getElementById("fileSearch");
-const items = Array.from(document.querySelectorAll(".file-item"));
 const searchEmpty = document.getElementById("searchEmpty");
 const contextMenu = document.getElementById("contextMenu");
 const menuOpen = document.getElementById("menu-open");
@@ -7,6 +6,7 @@ const menuDownload = document.getElementById("menu-download");
 const menuOpenFolder = document.getElementById("menu-open-folder");
 const menuOpenLocation = document.getElementById("menu-open-location");
 const menuCopy = document.getElementById("menu-copy");
+const menuExports = contextMenu.querySelectorAll("[data-export-format]");
 
 function hideContextMenu() {
     contextMenu.classList.remove("active");
@@ -22,6 +22,9 @@ function showContextMenu(event, item) {
     const folderUrl = item.dataset.folderUrl || url;
     const systemPath = item.dataset.systemPath || "";
     const systemFolder = item.dataset.systemFolder || systemPath;
+    const exportPath = item.dataset.exportPath || "";
+    const hasKey = item.dataset.hasKey === "true";
+    const isCA = item.dataset.isCa === "true";
 
     menuOpen.style.display = url ? "block" : "none";
     menuOpen.textContent = isDir ? "Open" : "Open in browser";
@@ -40,6 +43,23 @@ function showContextMenu(event, item) {
         hideContextMenu();
     };
 
+    menuExports.forEach((exportItem) => {
+        const available = exportPath &&
+            (exportItem.dataset.requiresKey !== "true" || hasKey) &&
+            (exportItem.dataset.requiresCa !== "true" || isCA);
+        exportItem.style.display = available ? "block" : "none";
+        exportItem.onclick = () => {
+            if (available) {
+                if (exportItem.dataset.supplicantFormat) {
+                    exportSupplicantConfig(exportPath, exportItem.dataset.supplicantFormat);
+                } else {
+                    exportCertificate(exportPath, exportItem.dataset.exportFormat, exportItem.dataset.pfxEncoding);
+                }
+            }
+            hideContextMenu();
+        };
+    });
+
     menuOpenFolder.style.display = !isDir && folderUrl ? "block" : "none";
     menuOpenFolder.onclick = () => {
         if (folderUrl) {
@@ -75,36 +95,53 @@ function showContextMenu(event, item) {
     contextMenu.style.top = `${Math.max(8, top)}px`;
 }
 
-if (searchInput) {
-    searchInput.addEventListener("input", (event) => {
-        const query = event.target.value.toLowerCase();
-        let visibleCount = 0;
-        items.forEach((item) => {
-            const name = item.dataset.name.toLowerCase();
-            const visible = name.includes(query);
-            item.style.display = visible ? "" : "none";
-            if (visible) {
-                visibleCount += 1;
-            }
-        });
-        searchEmpty.style.display = visibleCount === 0 ? "block" : "none";
+function filterFiles() {
+    if (!searchInput) {
+        return;
+    }
+    const query = searchInput.value.toLowerCase();
+    let visibleCount = 0;
+    document.querySelectorAll(".file-item").forEach((item) => {
+        const name = item.dataset.name.toLowerCase();
+        const visible = name.includes(query);
+        item.style.display = visible ? "" : "none";
+        if (visible) {
+            visibleCount += 1;
+        }
     });
+    searchEmpty.style.display = visibleCount === 0 ? "block" : "none";
 }
 
-items.forEach((item) => {
-    item.addEventListener("contextmenu", (event) => showContextMenu(event, item));
-    const trigger = item.querySelector(".action-trigger");
-    if (trigger) {
-        trigger.addEventListener("click", (event) => showContextMenu(event, item));
+if (searchInput) {
+    searchInput.addEventListener("input", filterFiles);
+}
+
+// Handlers are delegated so that file items replaced by live updates keep working.
+document.addEventListener("contextmenu", (event) => {
+    const item = event.target.closest(".file-item");
+    if (item) {
+        showContextMenu(event, item);
     }
 });
 
 document.addEventListener("click", (event) => {
+    const trigger = event.target.closest(".file-item .action-trigger");
+    if (trigger) {
+        showContextMenu(event, trigger.closest(".file-item"));
+        return;
+    }
     if (!contextMenu.contains(event.target)) {
         hideContextMenu();
     }
 });
 
+document.addEventListener("dashboard:updated", () => {
+    hideContextMenu();
+    if (searchInput && searchInput.value) {
+        filterFiles();
+    }
+});
+
 document.addEventListener("keydown", (event) => {
     if (event.key === "Escape") {
         hideContextMenu();
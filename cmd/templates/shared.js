@@ -1,5 +1,11 @@
 const FEEDBACK_TIMEOUT_MS = 1200;
 
+// csrfToken returns the token the server embedded in the page; every POST must send it.
+function csrfToken() {
+    const meta = document.querySelector('meta[name="csrf-token"]');
+    return meta ? meta.getAttribute("content") : "";
+}
+
 function ensureClipboardLiveRegion() {
     let region = document.getElementById("clipboard-status");
     if (!region) {
@@ -77,7 +83,8 @@ function openInExplorer(rawPath) {
     fetch("/open", {
         method: "POST",
         headers: {
-            "Content-Type": "application/x-www-form-urlencoded"
+            "Content-Type": "application/x-www-form-urlencoded",
+            "X-CSRF-Token": csrfToken()
         },
         body: new URLSearchParams({ path })
     }).then((response) => {
@@ -98,3 +105,68 @@ function setupOpenButtons() {
         });
     });
 }
+
+const PROTECTED_EXPORT_FORMATS = new Set(["pfx", "jks", "truststore-jks", "truststore-p12"]);
+
+function exportCertificate(exportPath, format, pfxEncoding) {
+    if (!PROTECTED_EXPORT_FORMATS.has(format)) {
+        const params = new URLSearchParams({ path: exportPath, format });
+        window.location.href = `/export?${params.toString()}`;
+        return;
+    }
+    let password = "";
+    if (pfxEncoding !== "passwordless") {
+        const isJKS = format === "jks" || format === "truststore-jks";
+        password = window.prompt(
+            isJKS ? "Keystore password:" : "Password for the PKCS#12 file (leave empty for none):",
+            isJKS ? "changeit" : ""
+        );
+        if (password === null) {
+            return;
+        }
+    }
+    submitHiddenForm("/export", { path: exportPath, format, pfx_password: password, pfx_encoding: pfxEncoding || "" });
+}
+
+function exportSupplicantConfig(exportPath, supplicantFormat) {
+    const ssid = window.prompt("Wi-Fi network name (SSID):", "");
+    if (!ssid) {
+        return;
+    }
+    const serverNames = window.prompt("RADIUS server names the device should trust (comma separated, optional):", "");
+    if (serverNames === null) {
+        return;
+    }
+    const password = window.prompt(
+        supplicantFormat === "networkmanager"
+            ? "Password for the embedded PKCS#12 key (required):"
+            : "Password for the embedded PKCS#12 key (leave empty for none):",
+        ""
+    );
+    if (password === null) {
+        return;
+    }
+    submitHiddenForm("/export/supplicant", {
+        path: exportPath,
+        format: supplicantFormat,
+        ssid,
+        server_names: serverNames,
+        pfx_password: password,
+    });
+}
+
+function submitHiddenForm(action, fields) {
+    const form = document.createElement("form");
+    form.method = "post";
+    form.action = action;
+    Object.entries({ ...fields, csrf_token: csrfToken() }).forEach(([name, value]) => {
+        const input = document.createElement("input");
+        input.type = "hidden";
+        input.name = name;
+        input.value = value;
+        form.appendChild(input);
+    });
+    document.body.appendChild(form);
+    form.submit();
+    form.remove();
+}
@@ -0,0 +1,10 @@
+package tls
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var Cmd = &cobra.Command{
+	Use:   "tls",
+	Short: "Mutual TLS test server and client.",
+}
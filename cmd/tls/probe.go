@@ -0,0 +1,84 @@
+package tls
+
+import (
+	"time"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var probeCmd = &cobra.Command{
+	Use:   "probe [address]",
+	Short: "Connect to a TLS server with a client certificate and report the handshake.",
+	Long: "Connect to a TLS server with a client certificate and report the handshake.\n\n" +
+		"The client certificate is a .pfx/.p12 bundle or a PEM certificate with its key next to it. The\n" +
+		"server chain is validated against the root CAs in the output directory (or --ca), and against\n" +
+		"--server-name when given. The negotiated version, cipher suite, server chain and any verification\n" +
+		"error are printed.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		clientCert, _ := cmd.Flags().GetString("client-cert")
+		pfxPassword, _ := cmd.Flags().GetString("pfx-password")
+		trustCAs, _ := cmd.Flags().GetStringSlice("ca")
+		serverName, _ := cmd.Flags().GetString("server-name")
+		insecure, _ := cmd.Flags().GetBool("insecure")
+		minVersion, _ := cmd.Flags().GetString("min-version")
+		maxVersion, _ := cmd.Flags().GetString("max-version")
+		ciphers, _ := cmd.Flags().GetStringSlice("cipher-suites")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		var clientCertPath string
+		if clientCert != "" {
+			clientCertPath, err = internal.ResolveOutputPath(outputDir, clientCert)
+			if err != nil {
+				return err
+			}
+		}
+		trustPaths, err := internal.FindCACertPaths(outputDir, trustCAs)
+		if err != nil {
+			return err
+		}
+
+		report, err := internal.ProbeTLS(outputDir, internal.MTLSProbeOptions{
+			Address:        args[0],
+			ClientCertPath: clientCertPath,
+			ClientPassword: pfxPassword,
+			TrustCertPaths: trustPaths,
+			ServerName:     serverName,
+			Insecure:       insecure,
+			MinVersion:     minVersion,
+			MaxVersion:     maxVersion,
+			CipherSuites:   ciphers,
+			Timeout:        timeout,
+		})
+		if err != nil {
+			return errors.Wrap(err, "Failed to probe TLS server")
+		}
+
+		printReport(report, "Server")
+		if report.HandshakeError != nil {
+			cmd.SilenceUsage = true
+			return errors.New("handshake failed")
+		}
+		return nil
+	},
+}
+
+func init() {
+	Cmd.AddCommand(probeCmd)
+	probeCmd.Flags().String("client-cert", "", "Client certificate (.pfx/.p12 or PEM) relative to the output directory")
+	probeCmd.Flags().String("pfx-password", "", "Password for a PKCS#12 client bundle")
+	probeCmd.Flags().StringSlice("ca", []string{}, "CAs trusted for the server certificate (root:<name> or intermediate:<root>:<name>, defaults to all roots)")
+	probeCmd.Flags().String("server-name", "", "Server name sent as SNI and checked against the server certificate")
+	probeCmd.Flags().Bool("insecure", false, "Complete the handshake with an untrusted server and only report the error")
+	probeCmd.Flags().String("min-version", "1.2", "Minimum TLS version: 1.0, 1.1, 1.2, 1.3")
+	probeCmd.Flags().String("max-version", "1.3", "Maximum TLS version: 1.0, 1.1, 1.2, 1.3")
+	probeCmd.Flags().StringSlice("cipher-suites", []string{}, "TLS 1.0-1.2 cipher suites by IANA name (defaults to Go's secure set)")
+	probeCmd.Flags().Duration("timeout", 10*time.Second, "Connection and handshake timeout")
+}
@@ -0,0 +1,45 @@
+package tls
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Ctere1/cert-helper/internal"
+)
+
+func printReport(report internal.TLSHandshakeReport, peer string) {
+	fmt.Printf("Remote:      %s\n", report.Remote)
+	if report.ServerName != "" {
+		fmt.Printf("SNI:         %s\n", report.ServerName)
+	}
+	fmt.Printf("Handshake:   %s\n", report.Duration.Round(time.Microsecond))
+	if report.HandshakeError == nil {
+		fmt.Printf("Version:     %s\n", report.Version)
+		fmt.Printf("Cipher:      %s\n", report.CipherSuite)
+	}
+	if len(report.PeerChain) == 0 {
+		fmt.Printf("%s chain: none\n", peer)
+	} else {
+		fmt.Printf("%s chain:\n", peer)
+		for i, cert := range report.PeerChain {
+			fmt.Printf("  %d: %s\n", i, cert.Subject.String())
+			fmt.Printf("     issuer:  %s\n", cert.Issuer.String())
+			fmt.Printf("     valid:   %s to %s\n", cert.NotBefore.UTC().Format(time.RFC3339), cert.NotAfter.UTC().Format(time.RFC3339))
+			if usages := internal.ExtKeyUsageNames(cert); len(usages) > 0 {
+				fmt.Printf("     EKU:     %s\n", strings.Join(usages, ", "))
+			}
+		}
+	}
+	if report.VerifyError != nil {
+		fmt.Printf("Verify:      FAILED: %v\n", report.VerifyError)
+	} else if len(report.PeerChain) > 0 && report.HandshakeError == nil {
+		fmt.Println("Verify:      OK")
+	}
+	if report.HandshakeError != nil && (report.VerifyError == nil || report.HandshakeError.Error() != report.VerifyError.Error()) {
+		fmt.Printf("Error:       %v\n", report.HandshakeError)
+	}
+	if report.Response != "" {
+		fmt.Printf("Response:    %s\n", report.Response)
+	}
+}
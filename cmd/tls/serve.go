@@ -0,0 +1,95 @@
+package tls
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+
+	"github.com/Ctere1/cert-helper/internal"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run a TLS server that requires client certificates.",
+	Long: "Run a TLS server that requires client certificates.\n\n" +
+		"The server presents --cert (with its chain) from the output directory and validates client\n" +
+		"certificates against the CAs selected with --ca (root:<name> or intermediate:<root>:<name>, default\n" +
+		"all). Every handshake is printed with the negotiated version, cipher suite, client chain and any\n" +
+		"verification error. Clients that connect receive a one-line summary, e.g. with 'openssl s_client'.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+
+		certFlag, _ := cmd.Flags().GetString("cert")
+		listen, _ := cmd.Flags().GetString("listen")
+		trustCAs, _ := cmd.Flags().GetStringSlice("ca")
+		crlFiles, _ := cmd.Flags().GetStringSlice("crl-file")
+		clientAuth, _ := cmd.Flags().GetString("client-auth")
+		allowUntrusted, _ := cmd.Flags().GetBool("allow-untrusted")
+		minVersion, _ := cmd.Flags().GetString("min-version")
+		maxVersion, _ := cmd.Flags().GetString("max-version")
+		ciphers, _ := cmd.Flags().GetStringSlice("cipher-suites")
+
+		if certFlag == "" {
+			return errors.New("--cert is required")
+		}
+		certPath, err := internal.ResolveOutputPath(outputDir, certFlag)
+		if err != nil {
+			return err
+		}
+		trustPaths, err := internal.FindCACertPaths(outputDir, trustCAs)
+		if err != nil {
+			return err
+		}
+
+		server, err := internal.ListenMTLS(outputDir, internal.MTLSServerOptions{
+			CertPath:       certPath,
+			TrustCertPaths: trustPaths,
+			CRLFiles:       crlFiles,
+			Address:        listen,
+			ClientAuth:     clientAuth,
+			AllowUntrusted: allowUntrusted,
+			MinVersion:     minVersion,
+			MaxVersion:     maxVersion,
+			CipherSuites:   ciphers,
+		})
+		if err != nil {
+			return errors.Wrap(err, "Failed to start TLS server")
+		}
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			_ = server.Close()
+		}()
+
+		fmt.Printf("Listening on %s (client auth: %s). Press Ctrl+C to stop.\n", server.Addr(), clientAuth)
+		var mu sync.Mutex
+		return server.Serve(func(report internal.TLSHandshakeReport) {
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Println(strings.Repeat("-", 60))
+			printReport(report, "Client")
+		})
+	},
+}
+
+func init() {
+	Cmd.AddCommand(serveCmd)
+	serveCmd.Flags().String("cert", "", "Server certificate path relative to the output directory (key must sit next to it)")
+	serveCmd.Flags().String("listen", "127.0.0.1:8443", "Address to listen on")
+	serveCmd.Flags().StringSlice("ca", []string{}, "CAs trusted for client certificates (root:<name> or intermediate:<root>:<name>, defaults to all)")
+	serveCmd.Flags().StringSlice("crl-file", []string{}, "PEM or DER CRL files checked against client chains")
+	serveCmd.Flags().String("client-auth", internal.ClientAuthRequire, "Client certificate mode: none, request, require")
+	serveCmd.Flags().Bool("allow-untrusted", false, "Complete handshakes with invalid client certificates and only report the error")
+	serveCmd.Flags().String("min-version", "1.2", "Minimum TLS version: 1.0, 1.1, 1.2, 1.3")
+	serveCmd.Flags().String("max-version", "1.3", "Maximum TLS version: 1.0, 1.1, 1.2, 1.3")
+	serveCmd.Flags().StringSlice("cipher-suites", []string{}, "TLS 1.0-1.2 cipher suites by IANA name (defaults to Go's secure set)")
+}
@@ -0,0 +1,1332 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	ACMEChallengeHTTP01    = "http-01"
+	ACMEChallengeDNS01     = "dns-01"
+	ACMEChallengeTLSALPN01 = "tls-alpn-01"
+
+	acmeStatePath             = "acme/state.json"
+	acmeErrorPrefix           = "urn:ietf:params:acme:error:"
+	acmeNonceLifetime         = time.Hour
+	acmeAuthorizationLifetime = 7 * 24 * time.Hour
+	acmeValidationTimeout     = 10 * time.Second
+	acmeMaxRequestSize        = 64 << 10
+	acmeTLSALPNProtocol       = "acme-tls/1"
+
+	acmeStatusPending     = "pending"
+	acmeStatusProcessing  = "processing"
+	acmeStatusReady       = "ready"
+	acmeStatusValid       = "valid"
+	acmeStatusInvalid     = "invalid"
+	acmeStatusDeactivated = "deactivated"
+)
+
+// oidACMEIdentifier is the id-pe-acmeIdentifier extension of tls-alpn-01 certificates (RFC 8737).
+var oidACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+var acmeDNSNamePattern = regexp.MustCompile(`^(\*\.)?([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+type ACMEServerOptions struct {
+	// BaseURL is the externally visible URL the server is mounted under, for example
+	// "https://localhost:14000/acme". Clients sign every request for its full URL.
+	BaseURL      string
+	IssuerType   string
+	RootName     string
+	IssuerName   string
+	ValidityDays int
+	// SkipValidation marks every challenge valid without contacting the client, for sandboxes.
+	SkipValidation bool
+	// HTTPPort and TLSPort are where http-01 and tls-alpn-01 responders are reached (80 and 443).
+	HTTPPort int
+	TLSPort  int
+	// DNSResolver is the host:port used for dns-01 lookups instead of the system resolver.
+	DNSResolver string
+	Logf        func(format string, args ...any)
+}
+
+// ACMEServer is an RFC 8555 server that issues certificates from a cert-helper CA. Its state
+// (accounts, orders, authorizations and certificates) is kept in acme/state.json in the output
+// directory, and issued certificates are stored next to the ones created by "cert generate".
+type ACMEServer struct {
+	outputDir string
+	options   ACMEServerOptions
+	baseURL   string
+	prefix    string
+	issuer    *CSRIssuer
+
+	mutex  sync.Mutex
+	state  acmeState
+	nonces map[string]time.Time
+}
+
+type acmeState struct {
+	Accounts       map[string]*acmeAccount       `json:"accounts"`
+	Orders         map[string]*acmeOrder         `json:"orders"`
+	Authorizations map[string]*acmeAuthorization `json:"authorizations"`
+	Certificates   map[string]*acmeCertificate   `json:"certificates"`
+}
+
+type acmeAccount struct {
+	ID                   string          `json:"id"`
+	Status               string          `json:"status"`
+	Contact              []string        `json:"contact,omitempty"`
+	TermsOfServiceAgreed bool            `json:"terms_of_service_agreed,omitempty"`
+	Key                  json.RawMessage `json:"key"`
+	Thumbprint           string          `json:"thumbprint"`
+	CreatedAt            time.Time       `json:"created_at"`
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeOrder struct {
+	ID             string           `json:"id"`
+	AccountID      string           `json:"account_id"`
+	Status         string           `json:"status"`
+	Expires        time.Time        `json:"expires"`
+	Identifiers    []acmeIdentifier `json:"identifiers"`
+	NotBefore      time.Time        `json:"not_before,omitzero"`
+	NotAfter       time.Time        `json:"not_after,omitzero"`
+	Authorizations []string         `json:"authorizations"`
+	CertificateID  string           `json:"certificate_id,omitempty"`
+	Error          *acmeProblem     `json:"error,omitempty"`
+}
+
+type acmeAuthorization struct {
+	ID         string           `json:"id"`
+	AccountID  string           `json:"account_id"`
+	Identifier acmeIdentifier   `json:"identifier"`
+	Wildcard   bool             `json:"wildcard,omitempty"`
+	Status     string           `json:"status"`
+	Expires    time.Time        `json:"expires"`
+	Challenges []*acmeChallenge `json:"challenges"`
+}
+
+type acmeChallenge struct {
+	ID        string       `json:"id"`
+	Type      string       `json:"type"`
+	Token     string       `json:"token"`
+	Status    string       `json:"status"`
+	Validated time.Time    `json:"validated,omitzero"`
+	Error     *acmeProblem `json:"error,omitempty"`
+}
+
+type acmeCertificate struct {
+	ID        string `json:"id"`
+	AccountID string `json:"account_id"`
+	OrderID   string `json:"order_id"`
+	Serial    string `json:"serial"`
+	Path      string `json:"path"`
+	DER       []byte `json:"der"`
+	Revoked   bool   `json:"revoked,omitempty"`
+}
+
+// acmeProblem is an RFC 7807 problem document with an ACME error type.
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status,omitempty"`
+}
+
+func (p *acmeProblem) Error() string {
+	return p.Detail
+}
+
+func acmeError(status int, kind, format string, args ...any) *acmeProblem {
+	return &acmeProblem{Type: acmeErrorPrefix + kind, Detail: fmt.Sprintf(format, args...), Status: status}
+}
+
+// acmeRequest is an authenticated POST: the JWS was signed for this URL with a fresh nonce,
+// either by a registered account (kid) or by an embedded key (jwk).
+type acmeRequest struct {
+	header  jwsHeader
+	payload []byte
+	key     crypto.PublicKey
+	account *acmeAccount
+	source  string
+}
+
+func (r *acmeRequest) postAsGet() bool {
+	return len(r.payload) == 0
+}
+
+func NewACMEServer(outputDir string, options ACMEServerOptions) (*ACMEServer, error) {
+	base, err := url.Parse(strings.TrimSuffix(strings.TrimSpace(options.BaseURL), "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("base URL must be an absolute http or https URL, got %q", options.BaseURL)
+	}
+	if options.HTTPPort == 0 {
+		options.HTTPPort = 80
+	}
+	if options.TLSPort == 0 {
+		options.TLSPort = 443
+	}
+	if options.ValidityDays <= 0 {
+		options.ValidityDays = 90
+	}
+	if options.Logf == nil {
+		options.Logf = func(string, ...any) {}
+	}
+	issuer, err := NewCSRIssuer(outputDir, options.IssuerType, options.RootName, options.IssuerName)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &ACMEServer{
+		outputDir: outputDir,
+		options:   options,
+		baseURL:   base.String(),
+		prefix:    base.Path,
+		issuer:    issuer,
+		nonces:    map[string]time.Time{},
+	}
+	if err := server.loadState(); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// DirectoryURL is the URL ACME clients are configured with.
+func (s *ACMEServer) DirectoryURL() string {
+	return s.url("/directory")
+}
+
+// Prefix is the path the handler must be mounted under.
+func (s *ACMEServer) Prefix() string {
+	return s.prefix
+}
+
+func (s *ACMEServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, s.prefix)
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Add("Link", fmt.Sprintf(`<%s>;rel="index"`, s.DirectoryURL()))
+
+	switch path {
+	case "/directory":
+		if r.Method != http.MethodGet {
+			s.writeProblem(w, acmeError(http.StatusMethodNotAllowed, "malformed", "use GET for the directory"))
+			return
+		}
+		s.writeJSON(w, http.StatusOK, "", map[string]any{
+			"newNonce":   s.url("/new-nonce"),
+			"newAccount": s.url("/new-account"),
+			"newOrder":   s.url("/new-order"),
+			"revokeCert": s.url("/revoke-cert"),
+			"keyChange":  s.url("/key-change"),
+			"meta":       map[string]any{"externalAccountRequired": false},
+		})
+		return
+	case "/new-nonce":
+		w.Header().Set("Cache-Control", "no-store")
+		switch r.Method {
+		case http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case http.MethodGet:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			s.writeProblem(w, acmeError(http.StatusMethodNotAllowed, "malformed", "use HEAD or GET for new nonces"))
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		s.writeProblem(w, acmeError(http.StatusMethodNotAllowed, "malformed", "ACME resources only accept POST requests"))
+		return
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	jwkAllowed := path == "/new-account" || path == "/revoke-cert"
+	request, err := s.readRequest(r, path, jwkAllowed)
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+
+	switch {
+	case path == "/new-account":
+		err = s.handleNewAccount(w, request)
+	case path == "/new-order":
+		err = s.handleNewOrder(w, request)
+	case path == "/revoke-cert":
+		err = s.handleRevokeCertificate(w, request)
+	case path == "/key-change":
+		err = s.handleKeyChange(w, request)
+	case len(segments) == 2 && segments[0] == "account":
+		err = s.handleAccount(w, request, segments[1])
+	case len(segments) == 3 && segments[0] == "account" && segments[2] == "orders":
+		err = s.handleAccountOrders(w, request, segments[1])
+	case len(segments) == 2 && segments[0] == "order":
+		err = s.handleOrder(w, request, segments[1])
+	case len(segments) == 3 && segments[0] == "order" && segments[2] == "finalize":
+		err = s.handleFinalize(w, request, segments[1])
+	case len(segments) == 2 && segments[0] == "authz":
+		err = s.handleAuthorization(w, request, segments[1])
+	case len(segments) == 3 && segments[0] == "chall":
+		err = s.handleChallenge(w, request, segments[1], segments[2])
+	case len(segments) == 2 && segments[0] == "cert":
+		err = s.handleCertificate(w, request, segments[1])
+	default:
+		err = acmeError(http.StatusNotFound, "malformed", "unknown ACME resource %s", path)
+	}
+	if err != nil {
+		s.writeProblem(w, err)
+	}
+}
+
+func (s *ACMEServer) readRequest(r *http.Request, path string, jwkAllowed bool) (*acmeRequest, error) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/jose+json" {
+		return nil, acmeError(http.StatusUnsupportedMediaType, "malformed", "requests must use Content-Type application/jose+json")
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, acmeMaxRequestSize))
+	if err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "failed to read request: %v", err)
+	}
+	message, header, payload, err := parseJWS(body)
+	if err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "%v", err)
+	}
+	if header.Alg == "" || header.Alg == "none" || strings.HasPrefix(header.Alg, "HS") {
+		return nil, acmeError(http.StatusBadRequest, "badSignatureAlgorithm", "algorithm %q is not accepted", header.Alg)
+	}
+	if header.URL != s.url(path) {
+		return nil, acmeError(http.StatusUnauthorized, "unauthorized", "JWS url %q does not match the request URL %q", header.URL, s.url(path))
+	}
+	if !s.consumeNonce(header.Nonce) {
+		return nil, acmeError(http.StatusBadRequest, "badNonce", "nonce is missing, unknown or already used")
+	}
+
+	request := &acmeRequest{header: header, payload: payload, source: RemoteIP(r)}
+	switch {
+	case len(header.JWK) > 0 && header.KeyID != "":
+		return nil, acmeError(http.StatusBadRequest, "malformed", "JWS must contain either jwk or kid, not both")
+	case len(header.JWK) > 0:
+		if !jwkAllowed {
+			return nil, acmeError(http.StatusBadRequest, "malformed", "this resource must be signed with an account kid")
+		}
+		if request.key, err = parseJWK(header.JWK); err != nil {
+			return nil, acmeError(http.StatusBadRequest, "badPublicKey", "%v", err)
+		}
+	case header.KeyID != "":
+		s.mutex.Lock()
+		account := s.state.Accounts[strings.TrimPrefix(header.KeyID, s.url("/account/"))]
+		s.mutex.Unlock()
+		if account == nil || !strings.HasPrefix(header.KeyID, s.url("/account/")) {
+			return nil, acmeError(http.StatusBadRequest, "accountDoesNotExist", "account %s does not exist", header.KeyID)
+		}
+		if account.Status != acmeStatusValid {
+			return nil, acmeError(http.StatusUnauthorized, "unauthorized", "account is %s", account.Status)
+		}
+		if request.key, err = parseJWK(account.Key); err != nil {
+			return nil, err
+		}
+		request.account = account
+	default:
+		return nil, acmeError(http.StatusBadRequest, "malformed", "JWS must contain a jwk or kid header")
+	}
+	if err := verifyJWS(message, header.Alg, request.key); err != nil {
+		if errors.Is(err, errJWSSignature) {
+			return nil, acmeError(http.StatusBadRequest, "malformed", "%v", err)
+		}
+		return nil, acmeError(http.StatusBadRequest, "badSignatureAlgorithm", "%v", err)
+	}
+	return request, nil
+}
+
+func (s *ACMEServer) handleNewAccount(w http.ResponseWriter, request *acmeRequest) error {
+	var payload struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := decodeACMEPayload(request.payload, &payload); err != nil {
+		return err
+	}
+	thumbprint, err := jwkThumbprint(request.key)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, account := range s.state.Accounts {
+		if account.Thumbprint == thumbprint {
+			s.writeJSON(w, http.StatusOK, s.url("/account/"+account.ID), s.accountView(account))
+			return nil
+		}
+	}
+	if payload.OnlyReturnExisting {
+		return acmeError(http.StatusBadRequest, "accountDoesNotExist", "no account exists for this key")
+	}
+	if err := validateACMEContacts(payload.Contact); err != nil {
+		return err
+	}
+	account := &acmeAccount{
+		ID:                   rand.Text(),
+		Status:               acmeStatusValid,
+		Contact:              payload.Contact,
+		TermsOfServiceAgreed: payload.TermsOfServiceAgreed,
+		Key:                  request.header.JWK,
+		Thumbprint:           thumbprint,
+		CreatedAt:            time.Now().UTC(),
+	}
+	s.state.Accounts[account.ID] = account
+	if err := s.saveState(); err != nil {
+		return err
+	}
+	s.options.Logf("ACME account %s registered (contact: %s)", account.ID, strings.Join(account.Contact, ", "))
+	s.writeJSON(w, http.StatusCreated, s.url("/account/"+account.ID), s.accountView(account))
+	return nil
+}
+
+func (s *ACMEServer) handleAccount(w http.ResponseWriter, request *acmeRequest, id string) error {
+	if request.account.ID != id {
+		return acmeError(http.StatusUnauthorized, "unauthorized", "requests for an account must be signed by that account")
+	}
+	var payload struct {
+		Contact *[]string `json:"contact"`
+		Status  string    `json:"status"`
+	}
+	if !request.postAsGet() {
+		if err := decodeACMEPayload(request.payload, &payload); err != nil {
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	account := request.account
+	if payload.Contact != nil {
+		if err := validateACMEContacts(*payload.Contact); err != nil {
+			return err
+		}
+		account.Contact = *payload.Contact
+	}
+	switch payload.Status {
+	case "":
+	case acmeStatusDeactivated:
+		account.Status = acmeStatusDeactivated
+		s.options.Logf("ACME account %s deactivated", account.ID)
+	default:
+		return acmeError(http.StatusBadRequest, "malformed", "accounts can only be deactivated")
+	}
+	if !request.postAsGet() {
+		if err := s.saveState(); err != nil {
+			return err
+		}
+	}
+	s.writeJSON(w, http.StatusOK, s.url("/account/"+account.ID), s.accountView(account))
+	return nil
+}
+
+func (s *ACMEServer) handleAccountOrders(w http.ResponseWriter, request *acmeRequest, id string) error {
+	if request.account.ID != id {
+		return acmeError(http.StatusUnauthorized, "unauthorized", "requests for an account must be signed by that account")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	orders := []string{}
+	for _, order := range s.state.Orders {
+		if order.AccountID == id {
+			orders = append(orders, s.url("/order/"+order.ID))
+		}
+	}
+	s.writeJSON(w, http.StatusOK, "", map[string]any{"orders": orders})
+	return nil
+}
+
+func (s *ACMEServer) handleNewOrder(w http.ResponseWriter, request *acmeRequest) error {
+	var payload struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+		NotBefore   string           `json:"notBefore"`
+		NotAfter    string           `json:"notAfter"`
+	}
+	if err := decodeACMEPayload(request.payload, &payload); err != nil {
+		return err
+	}
+	if len(payload.Identifiers) == 0 {
+		return acmeError(http.StatusBadRequest, "malformed", "an order needs at least one identifier")
+	}
+	var identifiers []acmeIdentifier
+	seen := map[acmeIdentifier]bool{}
+	for _, identifier := range payload.Identifiers {
+		normalized, err := normalizeACMEIdentifier(identifier)
+		if err != nil {
+			return err
+		}
+		if !seen[normalized] {
+			seen[normalized] = true
+			identifiers = append(identifiers, normalized)
+		}
+	}
+
+	notBefore, err := parseACMETime(payload.NotBefore)
+	if err != nil {
+		return err
+	}
+	notAfter, err := parseACMETime(payload.NotAfter)
+	if err != nil {
+		return err
+	}
+	order := &acmeOrder{
+		ID:          rand.Text(),
+		AccountID:   request.account.ID,
+		Status:      acmeStatusPending,
+		Expires:     time.Now().Add(acmeAuthorizationLifetime).UTC().Truncate(time.Second),
+		Identifiers: identifiers,
+		NotBefore:   notBefore,
+		NotAfter:    notAfter,
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, identifier := range identifiers {
+		authorization := &acmeAuthorization{
+			ID:         rand.Text(),
+			AccountID:  request.account.ID,
+			Identifier: identifier,
+			Status:     acmeStatusPending,
+			Expires:    order.Expires,
+		}
+		if identifier.Type == "dns" && strings.HasPrefix(identifier.Value, "*.") {
+			authorization.Wildcard = true
+			authorization.Identifier.Value = strings.TrimPrefix(identifier.Value, "*.")
+		}
+		for _, challengeType := range acmeChallengeTypes(identifier, authorization.Wildcard) {
+			authorization.Challenges = append(authorization.Challenges, &acmeChallenge{
+				ID:     rand.Text(),
+				Type:   challengeType,
+				Token:  acmeToken(),
+				Status: acmeStatusPending,
+			})
+		}
+		s.state.Authorizations[authorization.ID] = authorization
+		order.Authorizations = append(order.Authorizations, authorization.ID)
+	}
+	s.state.Orders[order.ID] = order
+	if err := s.saveState(); err != nil {
+		return err
+	}
+	s.options.Logf("ACME order %s created for %s", order.ID, describeACMEIdentifiers(identifiers))
+	s.writeJSON(w, http.StatusCreated, s.url("/order/"+order.ID), s.orderView(order))
+	return nil
+}
+
+func (s *ACMEServer) handleOrder(w http.ResponseWriter, request *acmeRequest, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	order, err := s.accountOrder(request, id)
+	if err != nil {
+		return err
+	}
+	s.refreshOrder(order)
+	s.writeJSON(w, http.StatusOK, s.url("/order/"+order.ID), s.orderView(order))
+	return nil
+}
+
+func (s *ACMEServer) handleFinalize(w http.ResponseWriter, request *acmeRequest, id string) error {
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if err := decodeACMEPayload(request.payload, &payload); err != nil {
+		return err
+	}
+	der, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "badCSR", "CSR is not base64url encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "badCSR", "failed to parse CSR: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return acmeError(http.StatusBadRequest, "badCSR", "CSR signature is invalid: %v", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	order, err := s.accountOrder(request, id)
+	if err != nil {
+		return err
+	}
+	s.refreshOrder(order)
+	if order.Status != acmeStatusReady {
+		return acmeError(http.StatusForbidden, "orderNotReady", "order is %s, not ready", order.Status)
+	}
+	dnsNames, ipAddresses, err := matchCSRToOrder(csr, order.Identifiers)
+	if err != nil {
+		return err
+	}
+	commonName := csr.Subject.CommonName
+	if commonName == "" && len(dnsNames) > 0 {
+		commonName = dnsNames[0]
+	}
+
+	issued, err := s.issuer.Issue(CSRIssueRequest{
+		CSR:          csr,
+		Subject:      &pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		IPAddresses:  ipAddresses,
+		NotBefore:    order.NotBefore,
+		NotAfter:     order.NotAfter,
+		ValidityDays: s.options.ValidityDays,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		Source:       InventorySourceACME,
+	})
+	var names []string
+	for _, identifier := range order.Identifiers {
+		names = append(names, identifier.Value)
+	}
+	params := map[string]string{"order": order.ID, "names": strings.Join(names, ", ")}
+	if err == nil {
+		params["serial"] = issued.Certificate.SerialNumber.Text(16)
+	}
+	s.audit(request, AuditACMEIssue, commonName, params, err)
+	if err != nil {
+		order.Status = acmeStatusInvalid
+		order.Error = acmeError(http.StatusInternalServerError, "serverInternal", "issuance failed: %v", err)
+		_ = s.saveState()
+		return order.Error
+	}
+	relPath, err := filepath.Rel(s.outputDir, issued.Path)
+	if err != nil {
+		relPath = issued.Path
+	}
+	certificate := &acmeCertificate{
+		ID:        rand.Text(),
+		AccountID: order.AccountID,
+		OrderID:   order.ID,
+		Serial:    issued.Certificate.SerialNumber.Text(16),
+		Path:      filepath.ToSlash(relPath),
+		DER:       issued.Certificate.Raw,
+	}
+	s.state.Certificates[certificate.ID] = certificate
+	order.Status = acmeStatusValid
+	order.CertificateID = certificate.ID
+	if err := s.saveState(); err != nil {
+		return err
+	}
+	s.options.Logf("ACME order %s issued %s (serial %s)", order.ID, certificate.Path, certificate.Serial)
+	s.writeJSON(w, http.StatusOK, s.url("/order/"+order.ID), s.orderView(order))
+	return nil
+}
+
+func (s *ACMEServer) handleAuthorization(w http.ResponseWriter, request *acmeRequest, id string) error {
+	var payload struct {
+		Status string `json:"status"`
+	}
+	if !request.postAsGet() {
+		if err := decodeACMEPayload(request.payload, &payload); err != nil {
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	authorization := s.state.Authorizations[id]
+	if authorization == nil || authorization.AccountID != request.account.ID {
+		return acmeError(http.StatusNotFound, "malformed", "authorization %s does not exist", id)
+	}
+	switch payload.Status {
+	case "":
+	case acmeStatusDeactivated:
+		authorization.Status = acmeStatusDeactivated
+		if err := s.saveState(); err != nil {
+			return err
+		}
+	default:
+		return acmeError(http.StatusBadRequest, "malformed", "authorizations can only be deactivated")
+	}
+	if authorization.Status == acmeStatusPending && time.Now().After(authorization.Expires) {
+		authorization.Status = "expired"
+	}
+	s.writeJSON(w, http.StatusOK, "", s.authorizationView(authorization))
+	return nil
+}
+
+func (s *ACMEServer) handleChallenge(w http.ResponseWriter, request *acmeRequest, authorizationID, challengeID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	authorization := s.state.Authorizations[authorizationID]
+	if authorization == nil || authorization.AccountID != request.account.ID {
+		return acmeError(http.StatusNotFound, "malformed", "authorization %s does not exist", authorizationID)
+	}
+	var challenge *acmeChallenge
+	for _, candidate := range authorization.Challenges {
+		if candidate.ID == challengeID {
+			challenge = candidate
+		}
+	}
+	if challenge == nil {
+		return acmeError(http.StatusNotFound, "malformed", "challenge %s does not exist", challengeID)
+	}
+
+	// An empty JWS payload is a POST-as-GET; any JSON object asks the server to validate.
+	if !request.postAsGet() && challenge.Status == acmeStatusPending && authorization.Status == acmeStatusPending {
+		if time.Now().After(authorization.Expires) {
+			return acmeError(http.StatusForbidden, "malformed", "authorization has expired")
+		}
+		challenge.Status = acmeStatusProcessing
+		go s.validateChallenge(authorization, challenge, request.account.Thumbprint)
+	}
+	w.Header().Add("Link", fmt.Sprintf(`<%s>;rel="up"`, s.url("/authz/"+authorization.ID)))
+	s.writeJSON(w, http.StatusOK, "", s.challengeView(authorization, challenge))
+	return nil
+}
+
+func (s *ACMEServer) handleCertificate(w http.ResponseWriter, request *acmeRequest, id string) error {
+	s.mutex.Lock()
+	certificate := s.state.Certificates[id]
+	s.mutex.Unlock()
+	if certificate == nil || certificate.AccountID != request.account.ID {
+		return acmeError(http.StatusNotFound, "malformed", "certificate %s does not exist", id)
+	}
+
+	var buffer bytes.Buffer
+	_ = pem.Encode(&buffer, &pem.Block{Type: "CERTIFICATE", Bytes: certificate.DER})
+	for _, caCert := range s.issuer.Chain() {
+		if !isSelfSigned(caCert) {
+			_ = pem.Encode(&buffer, &pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+		}
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buffer.Bytes())
+	return nil
+}
+
+func (s *ACMEServer) handleRevokeCertificate(w http.ResponseWriter, request *acmeRequest) error {
+	var payload struct {
+		Certificate string `json:"certificate"`
+		Reason      int    `json:"reason"`
+	}
+	if err := decodeACMEPayload(request.payload, &payload); err != nil {
+		return err
+	}
+	der, err := base64.RawURLEncoding.DecodeString(payload.Certificate)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "certificate is not base64url encoded")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "failed to parse certificate: %v", err)
+	}
+	// Reason codes from RFC 5280 section 5.3.1; 7 is unused.
+	if payload.Reason < 0 || payload.Reason > 10 || payload.Reason == 7 {
+		return acmeError(http.StatusBadRequest, "badRevocationReason", "unsupported revocation reason %d", payload.Reason)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var record *acmeCertificate
+	for _, candidate := range s.state.Certificates {
+		if bytes.Equal(candidate.DER, der) {
+			record = candidate
+		}
+	}
+	if request.account != nil {
+		if record == nil || record.AccountID != request.account.ID {
+			return acmeError(http.StatusForbidden, "unauthorized", "certificate was not issued to this account")
+		}
+	} else if key, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !key.Equal(request.key) {
+		return acmeError(http.StatusForbidden, "unauthorized", "request must be signed by the account or the certificate key")
+	}
+
+	crlPath, err := s.issuer.Revoke(cert, payload.Reason)
+	s.audit(request, AuditACMERevoke, cert.Subject.String(), map[string]string{
+		"serial": cert.SerialNumber.Text(16),
+		"reason": strconv.Itoa(payload.Reason),
+	}, err)
+	if errors.Is(err, ErrAlreadyRevoked) {
+		return acmeError(http.StatusBadRequest, "alreadyRevoked", "%v", err)
+	}
+	if err != nil {
+		return acmeError(http.StatusForbidden, "unauthorized", "%v", err)
+	}
+	if record != nil {
+		record.Revoked = true
+		if err := s.saveState(); err != nil {
+			return err
+		}
+	}
+	s.options.Logf("ACME revoked serial %s (reason %d), CRL updated: %s", cert.SerialNumber.Text(16), payload.Reason, crlPath)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// handleKeyChange implements account key rollover: the payload is a JWS signed by the new key
+// whose payload names the account and its old key (RFC 8555 section 7.3.5).
+func (s *ACMEServer) handleKeyChange(w http.ResponseWriter, request *acmeRequest) error {
+	message, header, payload, err := parseJWS(request.payload)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "inner JWS: %v", err)
+	}
+	if len(header.JWK) == 0 || header.KeyID != "" {
+		return acmeError(http.StatusBadRequest, "malformed", "inner JWS must contain the new key as jwk")
+	}
+	if header.URL != request.header.URL {
+		return acmeError(http.StatusBadRequest, "malformed", "inner JWS url does not match the outer JWS")
+	}
+	newKey, err := parseJWK(header.JWK)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "badPublicKey", "%v", err)
+	}
+	if err := verifyJWS(message, header.Alg, newKey); err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "inner JWS: %v", err)
+	}
+	var keyChange struct {
+		Account string          `json:"account"`
+		OldKey  json.RawMessage `json:"oldKey"`
+	}
+	if err := decodeACMEPayload(payload, &keyChange); err != nil {
+		return err
+	}
+	if keyChange.Account != request.header.KeyID {
+		return acmeError(http.StatusBadRequest, "malformed", "key change is for a different account")
+	}
+	oldKey, err := parseJWK(keyChange.OldKey)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "oldKey: %v", err)
+	}
+	oldThumbprint, err := jwkThumbprint(oldKey)
+	if err != nil {
+		return err
+	}
+	newThumbprint, err := jwkThumbprint(newKey)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	account := request.account
+	if oldThumbprint != account.Thumbprint {
+		return acmeError(http.StatusBadRequest, "malformed", "oldKey is not the current account key")
+	}
+	for _, other := range s.state.Accounts {
+		if other.Thumbprint == newThumbprint {
+			w.Header().Set("Location", s.url("/account/"+other.ID))
+			return acmeError(http.StatusConflict, "malformed", "the new key is already in use by another account")
+		}
+	}
+	account.Key = header.JWK
+	account.Thumbprint = newThumbprint
+	if err := s.saveState(); err != nil {
+		return err
+	}
+	s.options.Logf("ACME account %s rolled over to a new key", account.ID)
+	s.writeJSON(w, http.StatusOK, s.url("/account/"+account.ID), s.accountView(account))
+	return nil
+}
+
+func (s *ACMEServer) validateChallenge(authorization *acmeAuthorization, challenge *acmeChallenge, thumbprint string) {
+	s.mutex.Lock()
+	identifier, challengeType, token := authorization.Identifier, challenge.Type, challenge.Token
+	s.mutex.Unlock()
+
+	keyAuthorization := token + "." + thumbprint
+	var problem *acmeProblem
+	if !s.options.SkipValidation {
+		switch challengeType {
+		case ACMEChallengeHTTP01:
+			problem = s.validateHTTP01(identifier.Value, token, keyAuthorization)
+		case ACMEChallengeDNS01:
+			problem = s.validateDNS01(identifier.Value, keyAuthorization)
+		case ACMEChallengeTLSALPN01:
+			problem = s.validateTLSALPN01(identifier.Value, keyAuthorization)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if problem != nil {
+		challenge.Status = acmeStatusInvalid
+		challenge.Error = problem
+		authorization.Status = acmeStatusInvalid
+		s.options.Logf("ACME %s for %s failed: %s", challengeType, identifier.Value, problem.Detail)
+	} else {
+		challenge.Status = acmeStatusValid
+		challenge.Validated = time.Now().UTC()
+		authorization.Status = acmeStatusValid
+		s.options.Logf("ACME %s for %s succeeded", challengeType, identifier.Value)
+	}
+	if err := s.saveState(); err != nil {
+		s.options.Logf("Failed to save ACME state: %v", err)
+	}
+}
+
+func (s *ACMEServer) validateHTTP01(host, token, keyAuthorization string) *acmeProblem {
+	address := host
+	if strings.Contains(host, ":") {
+		address = "[" + host + "]"
+	}
+	if s.options.HTTPPort != 80 {
+		address = net.JoinHostPort(host, strconv.Itoa(s.options.HTTPPort))
+	}
+	target := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", address, token)
+	client := &http.Client{Timeout: acmeValidationTimeout}
+	response, err := client.Get(target)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "connection", "failed to fetch %s: %v", target, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return acmeError(http.StatusForbidden, "unauthorized", "%s returned HTTP %d", target, response.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1024))
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "connection", "failed to read %s: %v", target, err)
+	}
+	if strings.TrimSpace(string(body)) != keyAuthorization {
+		return acmeError(http.StatusForbidden, "incorrectResponse", "%s returned %q instead of the key authorization", target, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (s *ACMEServer) validateDNS01(domain, keyAuthorization string) *acmeProblem {
+	resolver := net.DefaultResolver
+	if s.options.DNSResolver != "" {
+		address := s.options.DNSResolver
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, "53")
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, address)
+			},
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), acmeValidationTimeout)
+	defer cancel()
+	name := "_acme-challenge." + domain
+	records, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "dns", "TXT lookup for %s failed: %v", name, err)
+	}
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	expected := base64.RawURLEncoding.EncodeToString(digest[:])
+	for _, record := range records {
+		if strings.TrimSpace(record) == expected {
+			return nil
+		}
+	}
+	return acmeError(http.StatusForbidden, "incorrectResponse", "no TXT record at %s matches %s (found %d records)", name, expected, len(records))
+}
+
+func (s *ACMEServer) validateTLSALPN01(domain, keyAuthorization string) *acmeProblem {
+	address := net.JoinHostPort(domain, strconv.Itoa(s.options.TLSPort))
+	dialer := &net.Dialer{Timeout: acmeValidationTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{
+		ServerName:         domain,
+		NextProtos:         []string{acmeTLSALPNProtocol},
+		InsecureSkipVerify: true, // The challenge certificate is self-signed by design.
+		MinVersion:         tls.VersionTLS12,
+	})
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "tls", "TLS connection to %s failed: %v", address, err)
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != acmeTLSALPNProtocol {
+		return acmeError(http.StatusForbidden, "incorrectResponse", "%s did not negotiate the %s protocol", address, acmeTLSALPNProtocol)
+	}
+	leaf := state.PeerCertificates[0]
+	if len(leaf.DNSNames) != 1 || !strings.EqualFold(leaf.DNSNames[0], domain) || len(leaf.IPAddresses) > 0 {
+		return acmeError(http.StatusForbidden, "incorrectResponse", "challenge certificate must contain exactly the SAN %s", domain)
+	}
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	for _, extension := range leaf.Extensions {
+		if !extension.Id.Equal(oidACMEIdentifier) {
+			continue
+		}
+		var value []byte
+		if _, err := asn1.Unmarshal(extension.Value, &value); err != nil || !extension.Critical {
+			return acmeError(http.StatusForbidden, "incorrectResponse", "acmeIdentifier extension must be a critical OCTET STRING")
+		}
+		if !bytes.Equal(value, digest[:]) {
+			return acmeError(http.StatusForbidden, "incorrectResponse", "acmeIdentifier extension does not match the key authorization")
+		}
+		return nil
+	}
+	return acmeError(http.StatusForbidden, "incorrectResponse", "challenge certificate lacks the acmeIdentifier extension")
+}
+
+// refreshOrder moves an order to ready or invalid once its authorizations have been decided.
+func (s *ACMEServer) refreshOrder(order *acmeOrder) {
+	if order.Status != acmeStatusPending && order.Status != acmeStatusReady {
+		return
+	}
+	if time.Now().After(order.Expires) {
+		order.Status = acmeStatusInvalid
+		return
+	}
+	if order.Status == acmeStatusReady {
+		return
+	}
+	ready := true
+	for _, id := range order.Authorizations {
+		authorization := s.state.Authorizations[id]
+		if authorization == nil {
+			order.Status = acmeStatusInvalid
+			return
+		}
+		switch authorization.Status {
+		case acmeStatusValid:
+		case acmeStatusPending:
+			ready = false
+		default:
+			order.Status = acmeStatusInvalid
+			order.Error = acmeError(http.StatusForbidden, "unauthorized", "authorization for %s is %s", authorization.Identifier.Value, authorization.Status)
+			return
+		}
+	}
+	if ready {
+		order.Status = acmeStatusReady
+	}
+}
+
+func (s *ACMEServer) accountOrder(request *acmeRequest, id string) (*acmeOrder, error) {
+	order := s.state.Orders[id]
+	if order == nil || order.AccountID != request.account.ID {
+		return nil, acmeError(http.StatusNotFound, "malformed", "order %s does not exist", id)
+	}
+	return order, nil
+}
+
+func (s *ACMEServer) accountView(account *acmeAccount) map[string]any {
+	view := map[string]any{
+		"status": account.Status,
+		"orders": s.url("/account/" + account.ID + "/orders"),
+	}
+	if len(account.Contact) > 0 {
+		view["contact"] = account.Contact
+	}
+	if account.TermsOfServiceAgreed {
+		view["termsOfServiceAgreed"] = true
+	}
+	return view
+}
+
+func (s *ACMEServer) orderView(order *acmeOrder) map[string]any {
+	authorizations := make([]string, 0, len(order.Authorizations))
+	for _, id := range order.Authorizations {
+		authorizations = append(authorizations, s.url("/authz/"+id))
+	}
+	view := map[string]any{
+		"status":         order.Status,
+		"expires":        order.Expires.Format(time.RFC3339),
+		"identifiers":    order.Identifiers,
+		"authorizations": authorizations,
+		"finalize":       s.url("/order/" + order.ID + "/finalize"),
+	}
+	if !order.NotBefore.IsZero() {
+		view["notBefore"] = order.NotBefore.Format(time.RFC3339)
+	}
+	if !order.NotAfter.IsZero() {
+		view["notAfter"] = order.NotAfter.Format(time.RFC3339)
+	}
+	if order.CertificateID != "" {
+		view["certificate"] = s.url("/cert/" + order.CertificateID)
+	}
+	if order.Error != nil {
+		view["error"] = order.Error
+	}
+	return view
+}
+
+func (s *ACMEServer) authorizationView(authorization *acmeAuthorization) map[string]any {
+	challenges := make([]map[string]any, 0, len(authorization.Challenges))
+	for _, challenge := range authorization.Challenges {
+		challenges = append(challenges, s.challengeView(authorization, challenge))
+	}
+	view := map[string]any{
+		"identifier": authorization.Identifier,
+		"status":     authorization.Status,
+		"expires":    authorization.Expires.Format(time.RFC3339),
+		"challenges": challenges,
+	}
+	if authorization.Wildcard {
+		view["wildcard"] = true
+	}
+	return view
+}
+
+func (s *ACMEServer) challengeView(authorization *acmeAuthorization, challenge *acmeChallenge) map[string]any {
+	view := map[string]any{
+		"type":   challenge.Type,
+		"url":    s.url("/chall/" + authorization.ID + "/" + challenge.ID),
+		"token":  challenge.Token,
+		"status": challenge.Status,
+	}
+	if !challenge.Validated.IsZero() {
+		view["validated"] = challenge.Validated.Format(time.RFC3339)
+	}
+	if challenge.Error != nil {
+		view["error"] = challenge.Error
+	}
+	return view
+}
+
+func (s *ACMEServer) url(path string) string {
+	return s.baseURL + path
+}
+
+func (s *ACMEServer) newNonce() string {
+	nonce := rand.Text()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	if len(s.nonces) > 10000 {
+		for value, issued := range s.nonces {
+			if now.Sub(issued) > acmeNonceLifetime {
+				delete(s.nonces, value)
+			}
+		}
+	}
+	s.nonces[nonce] = now
+	return nonce
+}
+
+func (s *ACMEServer) consumeNonce(nonce string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	issued, ok := s.nonces[nonce]
+	delete(s.nonces, nonce)
+	return ok && time.Since(issued) <= acmeNonceLifetime
+}
+
+func (s *ACMEServer) writeJSON(w http.ResponseWriter, status int, location string, value any) {
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(value)
+}
+
+func (s *ACMEServer) writeProblem(w http.ResponseWriter, err error) {
+	var problem *acmeProblem
+	if !errors.As(err, &problem) {
+		s.options.Logf("ACME internal error: %v", err)
+		problem = acmeError(http.StatusInternalServerError, "serverInternal", "%v", err)
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	_ = json.NewEncoder(w).Encode(problem)
+}
+
+func (s *ACMEServer) loadState() error {
+	s.state = acmeState{
+		Accounts:       map[string]*acmeAccount{},
+		Orders:         map[string]*acmeOrder{},
+		Authorizations: map[string]*acmeAuthorization{},
+		Certificates:   map[string]*acmeCertificate{},
+	}
+	data, err := os.ReadFile(filepath.Join(s.outputDir, acmeStatePath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", acmeStatePath, err)
+	}
+	// Validations interrupted by a restart can be retried by the client.
+	for _, authorization := range s.state.Authorizations {
+		for _, challenge := range authorization.Challenges {
+			if challenge.Status == acmeStatusProcessing {
+				challenge.Status = acmeStatusPending
+			}
+		}
+	}
+	return nil
+}
+
+// saveState writes the state atomically; callers hold the mutex.
+func (s *ACMEServer) saveState() error {
+	statePath := filepath.Join(s.outputDir, acmeStatePath)
+	if err := ensureParentDir(statePath); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, statePath)
+}
+
+func decodeACMEPayload(payload []byte, value any) error {
+	if err := json.Unmarshal(payload, value); err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "invalid JSON payload: %v", err)
+	}
+	return nil
+}
+
+func parseACMETime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, acmeError(http.StatusBadRequest, "malformed", "invalid timestamp %q", value)
+	}
+	return parsed, nil
+}
+
+func validateACMEContacts(contacts []string) error {
+	for _, contact := range contacts {
+		if !strings.HasPrefix(contact, "mailto:") || len(contact) <= len("mailto:") {
+			return acmeError(http.StatusBadRequest, "invalidContact", "contact %q must be a mailto: URL", contact)
+		}
+	}
+	return nil
+}
+
+func normalizeACMEIdentifier(identifier acmeIdentifier) (acmeIdentifier, error) {
+	switch identifier.Type {
+	case "dns":
+		value := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(identifier.Value)), ".")
+		if ascii, err := idna.ToASCII(value); err == nil {
+			value = ascii
+		}
+		if net.ParseIP(value) != nil {
+			return acmeIdentifier{}, acmeError(http.StatusBadRequest, "malformed", "%q is an IP address; use an ip identifier", value)
+		}
+		if !acmeDNSNamePattern.MatchString(value) {
+			return acmeIdentifier{}, acmeError(http.StatusBadRequest, "rejectedIdentifier", "%q is not a valid DNS name", identifier.Value)
+		}
+		return acmeIdentifier{Type: "dns", Value: value}, nil
+	case "ip":
+		ip := net.ParseIP(strings.TrimSpace(identifier.Value))
+		if ip == nil {
+			return acmeIdentifier{}, acmeError(http.StatusBadRequest, "malformed", "%q is not a valid IP address", identifier.Value)
+		}
+		return acmeIdentifier{Type: "ip", Value: ip.String()}, nil
+	default:
+		return acmeIdentifier{}, acmeError(http.StatusBadRequest, "unsupportedIdentifier", "identifier type %q is not supported", identifier.Type)
+	}
+}
+
+// acmeChallengeTypes lists the challenges offered for an identifier: wildcards can only be
+// proven over DNS, and IP addresses (RFC 8738) only over HTTP.
+func acmeChallengeTypes(identifier acmeIdentifier, wildcard bool) []string {
+	switch {
+	case identifier.Type == "ip":
+		return []string{ACMEChallengeHTTP01}
+	case wildcard:
+		return []string{ACMEChallengeDNS01}
+	default:
+		return []string{ACMEChallengeHTTP01, ACMEChallengeDNS01, ACMEChallengeTLSALPN01}
+	}
+}
+
+// acmeToken returns a base64url token with 128 bits of entropy.
+func acmeToken() string {
+	token := make([]byte, 16)
+	_, _ = rand.Read(token)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// matchCSRToOrder checks that the CSR requests exactly the order's identifiers.
+func matchCSRToOrder(csr *x509.CertificateRequest, identifiers []acmeIdentifier) ([]string, []net.IP, error) {
+	requested := map[acmeIdentifier]bool{}
+	for _, name := range csr.DNSNames {
+		requested[acmeIdentifier{Type: "dns", Value: strings.ToLower(name)}] = true
+	}
+	for _, ip := range csr.IPAddresses {
+		requested[acmeIdentifier{Type: "ip", Value: ip.String()}] = true
+	}
+	if commonName := strings.ToLower(csr.Subject.CommonName); commonName != "" {
+		if ip := net.ParseIP(commonName); ip != nil {
+			requested[acmeIdentifier{Type: "ip", Value: ip.String()}] = true
+		} else {
+			requested[acmeIdentifier{Type: "dns", Value: commonName}] = true
+		}
+	}
+
+	var dnsNames []string
+	var ipAddresses []net.IP
+	for _, identifier := range identifiers {
+		if !requested[identifier] {
+			return nil, nil, acmeError(http.StatusBadRequest, "badCSR", "CSR does not request %s", identifier.Value)
+		}
+		delete(requested, identifier)
+		if identifier.Type == "ip" {
+			ipAddresses = append(ipAddresses, net.ParseIP(identifier.Value))
+		} else {
+			dnsNames = append(dnsNames, identifier.Value)
+		}
+	}
+	for identifier := range requested {
+		return nil, nil, acmeError(http.StatusBadRequest, "badCSR", "CSR requests %s, which is not part of the order", identifier.Value)
+	}
+	return dnsNames, ipAddresses, nil
+}
+
+func describeACMEIdentifiers(identifiers []acmeIdentifier) string {
+	values := make([]string, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		values = append(values, identifier.Value)
+	}
+	return strings.Join(values, ", ")
+}
+
+// audit records an issuance or revocation. Requests signed with a certificate key instead of an
+// account are attributed to the key.
+func (s *ACMEServer) audit(request *acmeRequest, action, target string, params map[string]string, err error) {
+	actor := "acme:certificate-key"
+	if request.account != nil {
+		actor = "acme:" + request.account.ID
+	}
+	if auditErr := RecordAudit(s.outputDir, AuditEvent{
+		Actor:  actor,
+		Source: request.source,
+		Action: action,
+		Target: target,
+		Params: params,
+		Err:    err,
+	}); auditErr != nil {
+		s.options.Logf("failed to audit %s: %v", action, auditErr)
+	}
+}
@@ -0,0 +1,362 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	auditLogFile = "audit.jsonl"
+
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+
+	// AuditSourceCLI is the source of entries written by cert-helper commands.
+	AuditSourceCLI = "cli"
+
+	auditRedacted       = "[redacted]"
+	auditMaxParamLength = 512
+	auditTailChunk      = 64 * 1024
+)
+
+// Audit actions. Creating a CA or a certificate implies generating its key pair.
+const (
+	AuditCreateRoot          = "ca.create_root"
+	AuditCreateIntermediate  = "ca.create_intermediate"
+	AuditIssueCertificate    = "certificate.issue"
+	AuditIssueInvalid        = "certificate.issue_invalid"
+	AuditRevokeCertificate   = "certificate.revoke"
+	AuditRenewCertificate    = "certificate.renew"
+	AuditExportCertificate   = "certificate.export"
+	AuditExportSupplicant    = "certificate.export_supplicant"
+	AuditDownloadFile        = "file.download"
+	AuditOpenFolder          = "file.open"
+	AuditSCEPEnroll          = "scep.enroll"
+	AuditSCEPStart           = "scep.start"
+	AuditSCEPStop            = "scep.stop"
+	AuditSCEPCreateChallenge = "scep.challenge_create"
+	AuditSCEPDeleteChallenge = "scep.challenge_delete"
+	AuditSCEPApproveRequest  = "scep.request_approve"
+	AuditSCEPRejectRequest   = "scep.request_reject"
+	AuditSCEPDeleteRequest   = "scep.request_delete"
+	AuditESTEnroll           = "est.enroll"
+	AuditACMEIssue           = "acme.issue"
+	AuditACMERevoke          = "acme.revoke"
+	AuditLogin               = "auth.login"
+	AuditAddUser             = "auth.user_add"
+	AuditDeleteUser          = "auth.user_delete"
+	AuditCreateToken         = "auth.token_create"
+	AuditDeleteToken         = "auth.token_delete"
+)
+
+// auditSecretParams are parameter names whose values never reach the log. Any name containing
+// "password" is redacted as well.
+var auditSecretParams = map[string]bool{
+	"challenge":  true,
+	"csrf_token": true,
+	"secret":     true,
+	"token":      true,
+}
+
+// AuditEntry is one line of the audit log. Hash covers every other field, including the hash of
+// the previous entry, so editing, removing or reordering lines breaks the chain.
+type AuditEntry struct {
+	Seq      int               `json:"seq"`
+	Time     time.Time         `json:"time"`
+	Actor    string            `json:"actor"`
+	Source   string            `json:"source,omitempty"`
+	Action   string            `json:"action"`
+	Target   string            `json:"target,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+	Result   string            `json:"result"`
+	Error    string            `json:"error,omitempty"`
+	PrevHash string            `json:"prev_hash"`
+	Hash     string            `json:"hash"`
+}
+
+// AuditEvent is what callers record; the log fills in the sequence, time and hashes.
+type AuditEvent struct {
+	Actor  string
+	Source string
+	Action string
+	Target string
+	Params map[string]string
+	// Err marks the operation as failed.
+	Err error
+}
+
+// AuditVerification summarizes a successful audit verify run.
+type AuditVerification struct {
+	Entries  int
+	LastHash string
+}
+
+// AuditChainError reports the first entry that does not continue the hash chain.
+type AuditChainError struct {
+	Line   int
+	Reason string
+}
+
+func (e *AuditChainError) Error() string {
+	return fmt.Sprintf("audit log line %d: %s", e.Line, e.Reason)
+}
+
+// auditMutex serializes appends within the process. Entries written concurrently by another
+// process can fork the chain, which audit verify reports.
+var auditMutex sync.Mutex
+
+// AuditLogPath returns the location of the audit log in the output directory.
+func AuditLogPath(outputDir string) string {
+	return filepath.Join(outputDir, auditLogFile)
+}
+
+// RecordAudit appends event to the audit log of the output directory.
+func RecordAudit(outputDir string, event AuditEvent) error {
+	entry := AuditEntry{
+		Time:   time.Now().UTC(),
+		Actor:  event.Actor,
+		Source: event.Source,
+		Action: event.Action,
+		Target: event.Target,
+		Params: sanitizeAuditParams(event.Params),
+		Result: AuditResultSuccess,
+	}
+	if entry.Actor == "" {
+		entry.Actor = "anonymous"
+	}
+	if event.Err != nil {
+		entry.Result = AuditResultFailure
+		entry.Error = event.Err.Error()
+	}
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	if err := os.MkdirAll(outputDir, 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(AuditLogPath(outputDir), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	last, err := lastAuditEntry(file)
+	if err != nil {
+		return err
+	}
+	entry.Seq = 1
+	if last != nil {
+		entry.Seq = last.Seq + 1
+		entry.PrevHash = last.Hash
+	}
+	entry.Hash, err = auditEntryHash(entry)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// RecordCLIAudit appends event as performed by the local user through a cert-helper command.
+func RecordCLIAudit(outputDir string, event AuditEvent) error {
+	event.Actor = CLIAuditActor()
+	event.Source = AuditSourceCLI
+	return RecordAudit(outputDir, event)
+}
+
+// CLIAuditActor names the local user running a cert-helper command.
+func CLIAuditActor() string {
+	if current, err := user.Current(); err == nil && current.Username != "" {
+		return current.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// RemoteIP returns the address of the client that sent r. Forwarding headers are ignored since
+// any client can set them.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ReadAuditLog returns the most recent entries of the audit log, newest first. A limit of zero
+// returns every entry. A missing log has no entries.
+func ReadAuditLog(outputDir string, limit int) ([]AuditEntry, error) {
+	file, err := os.Open(AuditLogPath(outputDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := newAuditScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+		if limit > 0 && len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// VerifyAuditLog checks every entry of the audit log: each must parse, be numbered in sequence,
+// reference the hash of the entry before it and match its own hash. The first violation is
+// returned as an *AuditChainError. Removing entries from the end cannot be detected from the log
+// alone; compare LastHash with a value recorded earlier for that.
+func VerifyAuditLog(outputDir string) (AuditVerification, error) {
+	var result AuditVerification
+	file, err := os.Open(AuditLogPath(outputDir))
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	defer file.Close()
+
+	previousHash := ""
+	scanner := newAuditScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var entry AuditEntry
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&entry); err != nil {
+			return result, &AuditChainError{Line: line, Reason: fmt.Sprintf("invalid entry: %v", err)}
+		}
+		if entry.Seq != line {
+			return result, &AuditChainError{Line: line, Reason: fmt.Sprintf("sequence number %d, expected %d", entry.Seq, line)}
+		}
+		if entry.PrevHash != previousHash {
+			return result, &AuditChainError{Line: line, Reason: "previous hash does not match the entry before it"}
+		}
+		hash, err := auditEntryHash(entry)
+		if err != nil {
+			return result, err
+		}
+		if entry.Hash != hash {
+			return result, &AuditChainError{Line: line, Reason: "entry hash does not match its contents"}
+		}
+		previousHash = entry.Hash
+		result.Entries = line
+		result.LastHash = entry.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// auditEntryHash hashes the entry with an empty Hash field. Map keys are encoded in sorted order,
+// so the encoding is stable.
+func auditEntryHash(entry AuditEntry) (string, error) {
+	entry.Hash = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func sanitizeAuditParams(params map[string]string) map[string]string {
+	if len(params) == 0 {
+		return nil
+	}
+	sanitized := make(map[string]string, len(params))
+	for name, value := range params {
+		lower := strings.ToLower(name)
+		switch {
+		case auditSecretParams[lower] || strings.Contains(lower, "password"):
+			if value != "" {
+				value = auditRedacted
+			}
+		case len(value) > auditMaxParamLength:
+			value = value[:auditMaxParamLength] + "..."
+		}
+		sanitized[name] = value
+	}
+	return sanitized
+}
+
+func newAuditScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, auditTailChunk), 16*auditTailChunk)
+	return scanner
+}
+
+// lastAuditEntry reads the final line of the log without scanning the whole file.
+func lastAuditEntry(file *os.File) (*AuditEntry, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	end := info.Size()
+	var tail []byte
+	for end > 0 {
+		start := max(end-auditTailChunk, 0)
+		chunk := make([]byte, end-start)
+		if _, err := file.ReadAt(chunk, start); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		tail = append(chunk, tail...)
+		end = start
+		trimmed := bytes.TrimRight(tail, "\n")
+		if index := bytes.LastIndexByte(trimmed, '\n'); index >= 0 {
+			tail = trimmed[index+1:]
+			break
+		}
+		if end == 0 {
+			tail = trimmed
+		}
+	}
+	tail = bytes.TrimSpace(tail)
+	if len(tail) == 0 {
+		return nil, nil
+	}
+	var entry AuditEntry
+	if err := json.Unmarshal(tail, &entry); err != nil {
+		return nil, fmt.Errorf("audit log ends with an invalid entry; run 'cert-helper audit verify': %w", err)
+	}
+	return &entry, nil
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestVerifyAuditLog(t *testing.T) {
+	tests := []struct {
+		name string
+		// tamper rewrites the lines of a log of three entries.
+		tamper func(lines [][]byte) [][]byte
+		// wantLine is the line reported as broken, or 0 when the log must verify.
+		wantLine int
+	}{
+		{
+			name:   "intact",
+			tamper: func(lines [][]byte) [][]byte { return lines },
+		},
+		{
+			name: "edited line",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte(`"target":"two"`), []byte(`"target":"TWO"`), 1)
+				return lines
+			},
+			wantLine: 2,
+		},
+		{
+			name: "removed middle line",
+			tamper: func(lines [][]byte) [][]byte {
+				return [][]byte{lines[0], lines[2]}
+			},
+			wantLine: 2,
+		},
+		{
+			name: "reordered lines",
+			tamper: func(lines [][]byte) [][]byte {
+				return [][]byte{lines[0], lines[2], lines[1]}
+			},
+			wantLine: 2,
+		},
+		{
+			name: "unknown field",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[2] = bytes.Replace(lines[2], []byte(`{`), []byte(`{"note":"x",`), 1)
+				return lines
+			},
+			wantLine: 3,
+		},
+		{
+			// Truncation is only caught by comparing LastHash with a value recorded earlier.
+			name: "removed last line",
+			tamper: func(lines [][]byte) [][]byte {
+				return lines[:2]
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputDir := t.TempDir()
+			for _, target := range []string{"one", "two", "three"} {
+				if err := RecordAudit(outputDir, AuditEvent{Actor: "admin", Action: AuditIssueCertificate, Target: target}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			data, err := os.ReadFile(AuditLogPath(outputDir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := tt.tamper(bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")))
+			data = append(bytes.Join(lines, []byte("\n")), '\n')
+			if err := os.WriteFile(AuditLogPath(outputDir), data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := VerifyAuditLog(outputDir)
+			if tt.wantLine == 0 {
+				if err != nil {
+					t.Fatalf("VerifyAuditLog() error = %v", err)
+				}
+				if result.Entries != len(lines) {
+					t.Errorf("VerifyAuditLog() verified %d entries, want %d", result.Entries, len(lines))
+				}
+				return
+			}
+			var chainErr *AuditChainError
+			if !errors.As(err, &chainErr) {
+				t.Fatalf("VerifyAuditLog() error = %v, want an *AuditChainError", err)
+			}
+			if chainErr.Line != tt.wantLine {
+				t.Errorf("VerifyAuditLog() reported line %d (%s), want line %d", chainErr.Line, chainErr.Reason, tt.wantLine)
+			}
+		})
+	}
+}
+
+func TestVerifyAuditLogWithoutLog(t *testing.T) {
+	result, err := VerifyAuditLog(t.TempDir())
+	if err != nil {
+		t.Fatalf("VerifyAuditLog() error = %v", err)
+	}
+	if result.Entries != 0 {
+		t.Errorf("VerifyAuditLog() verified %d entries, want 0", result.Entries)
+	}
+}
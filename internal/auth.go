@@ -0,0 +1,472 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	authStorePath = "auth.json"
+	authTokenTag  = "cht_"
+
+	RoleViewer  = "viewer"
+	RoleIssuer  = "issuer"
+	RoleCAAdmin = "ca-admin"
+
+	AuthMethodPassword   = "password"
+	AuthMethodToken      = "token"
+	AuthMethodClientCert = "client-cert"
+	AuthMethodSession    = "session"
+)
+
+// Roles lists the dashboard roles from least to most privileged. Each role includes the
+// permissions of the ones before it: viewers read, issuers also issue and revoke end-entity
+// certificates and export their keys, CA admins also manage CAs and their keys.
+var Roles = []string{RoleViewer, RoleIssuer, RoleCAAdmin}
+
+// AuthMethods lists the credentials the dashboard can accept.
+var AuthMethods = []string{AuthMethodPassword, AuthMethodToken, AuthMethodClientCert}
+
+// ErrInvalidCredentials is returned for unknown users, wrong passwords and unknown tokens alike.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+var authStoreMutex sync.Mutex
+
+// RoleAllows reports whether role grants the permissions of required.
+func RoleAllows(role, required string) bool {
+	have, want := slices.Index(Roles, role), slices.Index(Roles, required)
+	return have >= 0 && want >= 0 && have >= want
+}
+
+// NormalizeRole validates a role name.
+func NormalizeRole(value string) (string, error) {
+	role := strings.ToLower(strings.TrimSpace(value))
+	if !slices.Contains(Roles, role) {
+		return "", fmt.Errorf("unknown role %q (use %s)", value, strings.Join(Roles, ", "))
+	}
+	return role, nil
+}
+
+// IsAuthStore reports whether path, relative to the output directory, is the credential store or
+// the temporary file it is written through. Neither is ever served to clients.
+func IsAuthStore(relPath string) bool {
+	folded := FoldPath(relPath)
+	return folded == authStorePath || folded == authStorePath+".tmp"
+}
+
+// FoldPath returns a slash-separated path relative to the output directory in the form access
+// checks compare against. Case-insensitive filesystems, the default on macOS and Windows, open
+// AUTH.json as auth.json, and Windows also ignores trailing dots and spaces and the ::$DATA stream
+// suffix, so the path is lowercased and those are removed from every element.
+func FoldPath(relPath string) string {
+	elements := strings.Split(filepath.ToSlash(filepath.Clean(relPath)), "/")
+	for i, element := range elements {
+		element, _, _ = strings.Cut(element, ":")
+		elements[i] = strings.ToLower(strings.TrimRight(element, ". "))
+		if elements[i] == "" && element != "" {
+			// "." and ".." keep their meaning.
+			elements[i] = element
+		}
+	}
+	return strings.Join(elements, "/")
+}
+
+type AuthUser struct {
+	Name string `json:"name"`
+	// PasswordHash is a bcrypt hash. Users without one can only sign in with a client certificate.
+	PasswordHash string `json:"password_hash,omitempty"`
+	// ClientCertificates are the SHA-256 fingerprints, as returned by CertificateFingerprint, of the
+	// client certificates the user signs in with.
+	ClientCertificates []string  `json:"client_cert_sha256,omitempty"`
+	Role               string    `json:"role"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+type AuthToken struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Hash is the hex SHA-256 of the token; the token itself is only shown when it is created.
+	Hash      string    `json:"hash"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuthPrincipal is an authenticated dashboard client.
+type AuthPrincipal struct {
+	Name   string
+	Role   string
+	Method string
+}
+
+type authStoreData struct {
+	Users  []AuthUser  `json:"users"`
+	Tokens []AuthToken `json:"tokens"`
+}
+
+// AuthStore keeps the dashboard users and API tokens in auth.json in the output directory.
+type AuthStore struct {
+	outputDir string
+}
+
+func NewAuthStore(outputDir string) *AuthStore {
+	return &AuthStore{outputDir: outputDir}
+}
+
+func (s *AuthStore) Users() ([]AuthUser, error) {
+	authStoreMutex.Lock()
+	defer authStoreMutex.Unlock()
+	data, err := s.read()
+	return data.Users, err
+}
+
+func (s *AuthStore) Tokens() ([]AuthToken, error) {
+	authStoreMutex.Lock()
+	defer authStoreMutex.Unlock()
+	data, err := s.read()
+	return data.Tokens, err
+}
+
+// User returns the named user, or nil when there is none.
+func (s *AuthStore) User(name string) (*AuthUser, error) {
+	users, err := s.Users()
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if user.Name == name {
+			return &user, nil
+		}
+	}
+	return nil, nil
+}
+
+// AddUser creates a user, or updates the password and role of an existing one, keeping its pinned
+// client certificates. An empty password creates a user that can only sign in with a client
+// certificate pinned with PinClientCertificate.
+func (s *AuthStore) AddUser(name, password, role string) (AuthUser, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, ":\r\n") {
+		return AuthUser{}, fmt.Errorf("invalid user name %q", name)
+	}
+	role, err := NormalizeRole(role)
+	if err != nil {
+		return AuthUser{}, err
+	}
+	user := AuthUser{Name: name, Role: role, CreatedAt: time.Now().UTC()}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return AuthUser{}, fmt.Errorf("failed to hash password: %w", err)
+		}
+		user.PasswordHash = string(hash)
+	}
+
+	authStoreMutex.Lock()
+	defer authStoreMutex.Unlock()
+	data, err := s.read()
+	if err != nil {
+		return AuthUser{}, err
+	}
+	index := slices.IndexFunc(data.Users, func(existing AuthUser) bool { return existing.Name == name })
+	if index >= 0 {
+		user.CreatedAt = data.Users[index].CreatedAt
+		user.ClientCertificates = data.Users[index].ClientCertificates
+		data.Users[index] = user
+	} else {
+		data.Users = append(data.Users, user)
+	}
+	return user, s.write(data)
+}
+
+// PinClientCertificate lets the named user sign in with cert. Only pinned certificates are accepted:
+// the subject of a certificate says nothing about who may use it, since anyone allowed to enroll
+// can choose it.
+func (s *AuthStore) PinClientCertificate(name string, cert *x509.Certificate) error {
+	if cert.IsCA {
+		return fmt.Errorf("%s is a CA certificate and cannot be used as a client certificate", cert.Subject.CommonName)
+	}
+	fingerprint := CertificateFingerprint(cert)
+
+	authStoreMutex.Lock()
+	defer authStoreMutex.Unlock()
+	data, err := s.read()
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(data.Users, func(user AuthUser) bool { return user.Name == name })
+	if index < 0 {
+		return fmt.Errorf("user %q not found", name)
+	}
+	for _, user := range data.Users {
+		if slices.Contains(user.ClientCertificates, fingerprint) {
+			if user.Name == name {
+				return nil
+			}
+			return fmt.Errorf("client certificate %s is already pinned to user %q", fingerprint, user.Name)
+		}
+	}
+	data.Users[index].ClientCertificates = append(data.Users[index].ClientCertificates, fingerprint)
+	return s.write(data)
+}
+
+// userByClientCertificate returns the user the certificate is pinned to, or nil when there is none.
+func (s *AuthStore) userByClientCertificate(cert *x509.Certificate) (*AuthUser, error) {
+	users, err := s.Users()
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := CertificateFingerprint(cert)
+	for _, user := range users {
+		if slices.Contains(user.ClientCertificates, fingerprint) {
+			return &user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *AuthStore) DeleteUser(name string) error {
+	authStoreMutex.Lock()
+	defer authStoreMutex.Unlock()
+	data, err := s.read()
+	if err != nil {
+		return err
+	}
+	remaining := slices.DeleteFunc(slices.Clone(data.Users), func(user AuthUser) bool { return user.Name == name })
+	if len(remaining) == len(data.Users) {
+		return fmt.Errorf("user %q not found", name)
+	}
+	data.Users = remaining
+	return s.write(data)
+}
+
+// CreateToken stores a new API token and returns it with the secret to hand to the client.
+func (s *AuthStore) CreateToken(name, role string) (AuthToken, string, error) {
+	role, err := NormalizeRole(role)
+	if err != nil {
+		return AuthToken{}, "", err
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return AuthToken{}, "", err
+	}
+	secret := authTokenTag + base64.RawURLEncoding.EncodeToString(random)
+	token := AuthToken{
+		ID:        hex.EncodeToString(random[:4]),
+		Name:      strings.TrimSpace(name),
+		Hash:      hashAuthToken(secret),
+		Role:      role,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	authStoreMutex.Lock()
+	defer authStoreMutex.Unlock()
+	data, err := s.read()
+	if err != nil {
+		return AuthToken{}, "", err
+	}
+	data.Tokens = append(data.Tokens, token)
+	return token, secret, s.write(data)
+}
+
+func (s *AuthStore) DeleteToken(id string) error {
+	authStoreMutex.Lock()
+	defer authStoreMutex.Unlock()
+	data, err := s.read()
+	if err != nil {
+		return err
+	}
+	remaining := slices.DeleteFunc(slices.Clone(data.Tokens), func(token AuthToken) bool { return token.ID == id })
+	if len(remaining) == len(data.Tokens) {
+		return fmt.Errorf("token %s not found", id)
+	}
+	data.Tokens = remaining
+	return s.write(data)
+}
+
+// VerifyPassword checks the credentials of a password user.
+func (s *AuthStore) VerifyPassword(name, password string) (AuthUser, error) {
+	user, err := s.User(name)
+	if err != nil {
+		return AuthUser{}, err
+	}
+	if user == nil || user.PasswordHash == "" {
+		// Spend the same time as a real comparison so user names cannot be probed.
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return AuthUser{}, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return AuthUser{}, ErrInvalidCredentials
+	}
+	return *user, nil
+}
+
+// VerifyToken returns the stored token matching secret.
+func (s *AuthStore) VerifyToken(secret string) (AuthToken, error) {
+	tokens, err := s.Tokens()
+	if err != nil {
+		return AuthToken{}, err
+	}
+	hash := []byte(hashAuthToken(secret))
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(token.Hash)) == 1 {
+			return token, nil
+		}
+	}
+	return AuthToken{}, ErrInvalidCredentials
+}
+
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("cert-helper"), bcrypt.DefaultCost)
+	return hash
+})
+
+func hashAuthToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *AuthStore) read() (authStoreData, error) {
+	var data authStoreData
+	content, err := os.ReadFile(filepath.Join(s.outputDir, authStorePath))
+	if errors.Is(err, os.ErrNotExist) {
+		return data, nil
+	}
+	if err != nil {
+		return data, err
+	}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return data, fmt.Errorf("failed to parse %s: %w", authStorePath, err)
+	}
+	return data, nil
+}
+
+func (s *AuthStore) write(data authStoreData) error {
+	path := filepath.Join(s.outputDir, authStorePath)
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Authenticator checks one kind of credentials. Authenticate returns nil without an error when
+// the request carries none of its kind.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*AuthPrincipal, error)
+}
+
+// PasswordAuthenticator accepts HTTP basic credentials of password users.
+type PasswordAuthenticator struct {
+	Store *AuthStore
+}
+
+func (a PasswordAuthenticator) Authenticate(r *http.Request) (*AuthPrincipal, error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	user, err := a.Store.VerifyPassword(name, password)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthPrincipal{Name: user.Name, Role: user.Role, Method: AuthMethodPassword}, nil
+}
+
+// TokenAuthenticator accepts API tokens sent as "Authorization: Bearer <token>".
+type TokenAuthenticator struct {
+	Store *AuthStore
+}
+
+func (a TokenAuthenticator) Authenticate(r *http.Request) (*AuthPrincipal, error) {
+	scheme, secret, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, nil
+	}
+	token, err := a.Store.VerifyToken(strings.TrimSpace(secret))
+	if err != nil {
+		return nil, err
+	}
+	name := "token " + token.ID
+	if token.Name != "" {
+		name = token.Name + " (token " + token.ID + ")"
+	}
+	return &AuthPrincipal{Name: name, Role: token.Role, Method: AuthMethodToken}, nil
+}
+
+// ClientCertAuthenticator accepts TLS client certificates that chain to a trusted CA, are not
+// revoked and are pinned to a user with AuthStore.PinClientCertificate; the user's role applies.
+// The common name is ignored: every enrollment path of the output directory lets the enrollee
+// choose it.
+type ClientCertAuthenticator struct {
+	Store     *AuthStore
+	OutputDir string
+	// TrustCertPaths default to every CA in the output directory; pinning is what ties a
+	// certificate to a user.
+	TrustCertPaths []string
+}
+
+func (a ClientCertAuthenticator) Authenticate(r *http.Request) (*AuthPrincipal, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, nil
+	}
+	// The verifier is built per request so CAs and CRLs changed by the dashboard apply at once.
+	verifier, err := newClientCertVerifier(a.OutputDir, a.TrustCertPaths, nil)
+	if err != nil {
+		return nil, err
+	}
+	var rawCerts [][]byte
+	for _, cert := range r.TLS.PeerCertificates {
+		rawCerts = append(rawCerts, cert.Raw)
+	}
+	if err := verifier.verify(rawCerts, nil); err != nil {
+		return nil, err
+	}
+	leaf := r.TLS.PeerCertificates[0]
+	user, err := a.Store.userByClientCertificate(leaf)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("client certificate %q (%s) is not pinned to a dashboard user", leaf.Subject.CommonName, CertificateFingerprint(leaf))
+	}
+	return &AuthPrincipal{Name: user.Name, Role: user.Role, Method: AuthMethodClientCert}, nil
+}
+
+// NewAuthenticators returns the authenticators for the given methods.
+func NewAuthenticators(outputDir string, methods []string, trustCertPaths []string) ([]Authenticator, error) {
+	store := NewAuthStore(outputDir)
+	var authenticators []Authenticator
+	for _, method := range methods {
+		switch strings.ToLower(strings.TrimSpace(method)) {
+		case AuthMethodPassword:
+			authenticators = append(authenticators, PasswordAuthenticator{Store: store})
+		case AuthMethodToken:
+			authenticators = append(authenticators, TokenAuthenticator{Store: store})
+		case AuthMethodClientCert:
+			authenticators = append(authenticators, ClientCertAuthenticator{Store: store, OutputDir: outputDir, TrustCertPaths: trustCertPaths})
+		default:
+			return nil, fmt.Errorf("unknown authentication method %q (use %s)", method, strings.Join(AuthMethods, ", "))
+		}
+	}
+	return authenticators, nil
+}
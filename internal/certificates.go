@@ -34,6 +34,7 @@ type CertificateOptions struct {
 	ExtKeyUsage      []x509.ExtKeyUsage
 	KeyType          string
 	ExportPrivateKey bool
+	PFXEncoding      string
 }
 
 func DefaultCertificateOptions() CertificateOptions {
@@ -41,6 +42,7 @@ func DefaultCertificateOptions() CertificateOptions {
 		KeyBits:          DefaultKeyBits,
 		KeyType:          KeyTypeRSA,
 		ExportPrivateKey: true,
+		PFXEncoding:      PFXEncodingLegacy,
 	}
 }
 
@@ -218,50 +220,20 @@ func GenerateCertificateWithOptions(outputDir, issuerType, rootName, issuerName
 		return "", "", "", fmt.Errorf("common name is required")
 	}
 
-	issuerType = strings.ToLower(strings.TrimSpace(issuerType))
-	if issuerType == "" {
-		issuerType = "root"
+	pfxEncoding, err := NormalizePFXEncoding(options.PFXEncoding)
+	if err != nil {
+		return "", "", "", err
+	}
+	if pfxEncoding == PFXEncodingPasswordless && pfxPassword != "" {
+		return "", "", "", fmt.Errorf("passwordless PKCS#12 bundles cannot use a password")
 	}
 
-	var (
-		caCert   *x509.Certificate
-		caKey    *rsa.PrivateKey
-		certDir  string
-		certPath string
-		keyPath  string
-		pfxPath  string
-		err      error
-	)
-
-	switch issuerType {
-	case "intermediate":
-		rootName = NormalizeName(rootName, "default")
-		if issuerName == "" {
-			return "", "", "", fmt.Errorf("intermediate CA name is required")
-		}
-		caCertPath, caKeyPath := intermediateCAPaths(outputDir, rootName, issuerName)
-		caKey, err = LoadCAPrivateKey(caKeyPath)
-		if err != nil {
-			return "", "", "", fmt.Errorf("failed to load intermediate CA private key: %w", err)
-		}
-		caCert, err = LoadCACertificate(caCertPath)
-		if err != nil {
-			return "", "", "", fmt.Errorf("failed to load intermediate CA certificate: %w", err)
-		}
-		certDir = intermediateCertDir(outputDir, rootName, issuerName)
-	default:
-		issuerName = NormalizeName(issuerName, "default")
-		caCertPath, caKeyPath := rootCAPaths(outputDir, issuerName)
-		caKey, err = LoadCAPrivateKey(caKeyPath)
-		if err != nil {
-			return "", "", "", fmt.Errorf("failed to load root CA private key: %w", err)
-		}
-		caCert, err = LoadCACertificate(caCertPath)
-		if err != nil {
-			return "", "", "", fmt.Errorf("failed to load root CA certificate: %w", err)
-		}
-		certDir = rootCertDir(outputDir, issuerName)
+	issuer, err := loadIssuer(outputDir, issuerType, rootName, issuerName)
+	if err != nil {
+		return "", "", "", err
 	}
+	caCert, caKey, certDir := issuer.cert, issuer.key, issuer.certDir
+	var certPath, keyPath, pfxPath string
 
 	privateKey, publicKey, err := GenerateKeyPair(options.KeyType, options.KeyBits)
 	if err != nil {
@@ -315,6 +287,9 @@ func GenerateCertificateWithOptions(outputDir, issuerType, rootName, issuerName
 	if err := WriteCertificatePEM(certPath, certDER); err != nil {
 		return "", "", "", err
 	}
+	if err := RemoveInventory(outputDir, certPath); err != nil {
+		return "", "", "", err
+	}
 	if options.ExportPrivateKey {
 		if err := WritePrivateKeyPEM(keyPath, privateKey); err != nil {
 			return "", "", "", err
@@ -323,7 +298,18 @@ func GenerateCertificateWithOptions(outputDir, issuerType, rootName, issuerName
 		if err != nil {
 			return "", "", "", err
 		}
-		if err := WritePFX(pfxPath, privateKey, cert, pfxPassword); err != nil {
+		chain := []*x509.Certificate{caCert}
+		if issuers, err := BuildChain(outputDir, caCert); err == nil {
+			chain = append(chain, issuers...)
+		}
+		if err := WritePFX(pfxPath, privateKey, cert, chain, pfxPassword, pfxEncoding); err != nil {
+			return "", "", "", err
+		}
+		if err := RecordInventory(outputDir, InventoryRecord{
+			Path:        pfxPath,
+			Kind:        InventoryKindPFX,
+			PFXEncoding: pfxEncoding,
+		}); err != nil {
 			return "", "", "", err
 		}
 	} else {
@@ -334,6 +320,62 @@ func GenerateCertificateWithOptions(outputDir, issuerType, rootName, issuerName
 	return certPath, keyPath, pfxPath, nil
 }
 
+// issuerMaterial is a CA from the output directory that can sign certificates, together with
+// the directory its certificates are written to.
+type issuerMaterial struct {
+	cert     *x509.Certificate
+	key      *rsa.PrivateKey
+	certPath string
+	certDir  string
+}
+
+func loadIssuer(outputDir, issuerType, rootName, issuerName string) (issuerMaterial, error) {
+	issuerType = strings.ToLower(strings.TrimSpace(issuerType))
+	if issuerType == "" {
+		issuerType = "root"
+	}
+
+	var issuer issuerMaterial
+	switch issuerType {
+	case "intermediate":
+		rootName = NormalizeName(rootName, "default")
+		if issuerName == "" {
+			return issuerMaterial{}, fmt.Errorf("intermediate CA name is required")
+		}
+		caCertPath, caKeyPath := intermediateCAPaths(outputDir, rootName, issuerName)
+		caKey, err := LoadCAPrivateKey(caKeyPath)
+		if err != nil {
+			return issuerMaterial{}, fmt.Errorf("failed to load intermediate CA private key: %w", err)
+		}
+		caCert, err := LoadCACertificate(caCertPath)
+		if err != nil {
+			return issuerMaterial{}, fmt.Errorf("failed to load intermediate CA certificate: %w", err)
+		}
+		issuer = issuerMaterial{cert: caCert, key: caKey, certPath: caCertPath, certDir: intermediateCertDir(outputDir, rootName, issuerName)}
+	default:
+		issuerName = NormalizeName(issuerName, "default")
+		caCertPath, caKeyPath := rootCAPaths(outputDir, issuerName)
+		caKey, err := LoadCAPrivateKey(caKeyPath)
+		if err != nil {
+			return issuerMaterial{}, fmt.Errorf("failed to load root CA private key: %w", err)
+		}
+		caCert, err := LoadCACertificate(caCertPath)
+		if err != nil {
+			return issuerMaterial{}, fmt.Errorf("failed to load root CA certificate: %w", err)
+		}
+		issuer = issuerMaterial{cert: caCert, key: caKey, certPath: caCertPath, certDir: rootCertDir(outputDir, issuerName)}
+	}
+	return issuer, nil
+}
+
+func issuerChain(outputDir string, issuer issuerMaterial) []*x509.Certificate {
+	chain := []*x509.Certificate{issuer.cert}
+	if parents, err := BuildChain(outputDir, issuer.cert); err == nil {
+		chain = append(chain, parents...)
+	}
+	return chain
+}
+
 func ListRootCAs(outputDir string) ([]string, error) {
 	var roots []string
 	defaultCert := filepath.Join(outputDir, "ca.pem")
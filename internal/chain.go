@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	IssuerTypeRoot         = "root"
+	IssuerTypeIntermediate = "intermediate"
+	maxChainDepth          = 8
+)
+
+type CAInfo struct {
+	Type     string
+	RootName string
+	Name     string
+	CertPath string
+	KeyPath  string
+	Cert     *x509.Certificate
+}
+
+// Selector returns the "root:<name>" or "intermediate:<root>:<name>" identifier of the CA,
+// matching the issuer values used by the dashboard.
+func (ca CAInfo) Selector() string {
+	if ca.Type == IssuerTypeIntermediate {
+		return fmt.Sprintf("%s:%s:%s", IssuerTypeIntermediate, ca.RootName, ca.Name)
+	}
+	return fmt.Sprintf("%s:%s", IssuerTypeRoot, ca.Name)
+}
+
+// FindCA resolves a CA selector such as "root:default" or "intermediate:default:issuing".
+func FindCA(outputDir, selector string) (CAInfo, error) {
+	cas, err := ListCAs(outputDir)
+	if err != nil {
+		return CAInfo{}, err
+	}
+	selector = strings.TrimSpace(selector)
+	for _, ca := range cas {
+		if ca.Selector() == selector {
+			return ca, nil
+		}
+	}
+	return CAInfo{}, fmt.Errorf("CA %q not found (use root:<name> or intermediate:<root>:<name>)", selector)
+}
+
+// FindCACertPaths resolves CA selectors to their certificate paths.
+func FindCACertPaths(outputDir string, selectors []string) ([]string, error) {
+	var paths []string
+	for _, selector := range selectors {
+		ca, err := FindCA(outputDir, selector)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, ca.CertPath)
+	}
+	return paths, nil
+}
+
+// ListCAs loads every root and intermediate CA certificate stored in the output directory.
+func ListCAs(outputDir string) ([]CAInfo, error) {
+	var cas []CAInfo
+	roots, err := ListRootCAs(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		certPath, keyPath := rootCAPaths(outputDir, root)
+		cert, err := LoadCertificate(certPath)
+		if err != nil {
+			continue
+		}
+		cas = append(cas, CAInfo{
+			Type:     IssuerTypeRoot,
+			RootName: root,
+			Name:     root,
+			CertPath: certPath,
+			KeyPath:  keyPath,
+			Cert:     cert,
+		})
+	}
+
+	intermediates, err := ListAllIntermediateCAs(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, intermediate := range intermediates {
+		certPath, keyPath := intermediateCAPaths(outputDir, intermediate.RootName, intermediate.Name)
+		cert, err := LoadCertificate(certPath)
+		if err != nil {
+			continue
+		}
+		cas = append(cas, CAInfo{
+			Type:     IssuerTypeIntermediate,
+			RootName: intermediate.RootName,
+			Name:     intermediate.Name,
+			CertPath: certPath,
+			KeyPath:  keyPath,
+			Cert:     cert,
+		})
+	}
+	return cas, nil
+}
+
+// BuildChain returns the issuing certificates of cert, ordered from its direct issuer up to the root.
+// The certificate itself is not included.
+func BuildChain(outputDir string, cert *x509.Certificate) ([]*x509.Certificate, error) {
+	cas, err := ListCAs(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	return buildChainFrom(cas, cert)
+}
+
+func buildChainFrom(cas []CAInfo, cert *x509.Certificate) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	current := cert
+	for depth := 0; depth < maxChainDepth; depth++ {
+		if isSelfSigned(current) {
+			return chain, nil
+		}
+		issuer := findIssuer(cas, current)
+		if issuer == nil {
+			return chain, fmt.Errorf("issuer %q not found in output directory", current.Issuer.String())
+		}
+		chain = append(chain, issuer)
+		current = issuer
+	}
+	return chain, fmt.Errorf("certificate chain exceeds %d levels", maxChainDepth)
+}
+
+// FindIssuingCA returns the CA in the output directory that signed cert.
+func FindIssuingCA(outputDir string, cert *x509.Certificate) (CAInfo, error) {
+	cas, err := ListCAs(outputDir)
+	if err != nil {
+		return CAInfo{}, err
+	}
+	ca := findIssuerCA(cas, cert)
+	if ca == nil {
+		return CAInfo{}, fmt.Errorf("issuer %q not found in output directory", cert.Issuer.String())
+	}
+	return *ca, nil
+}
+
+func findIssuer(cas []CAInfo, cert *x509.Certificate) *x509.Certificate {
+	if ca := findIssuerCA(cas, cert); ca != nil {
+		return ca.Cert
+	}
+	return nil
+}
+
+func findIssuerCA(cas []CAInfo, cert *x509.Certificate) *CAInfo {
+	for i, ca := range cas {
+		if ca.Cert.Equal(cert) {
+			continue
+		}
+		if !bytes.Equal(ca.Cert.RawSubject, cert.RawIssuer) {
+			continue
+		}
+		if cert.CheckSignatureFrom(ca.Cert) == nil {
+			return &cas[i]
+		}
+	}
+	return nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawSubject, cert.RawIssuer) {
+		return false
+	}
+	return cert.CheckSignatureFrom(cert) == nil
+}
+
+// RootCertificates returns every root CA certificate in the output directory.
+func RootCertificates(outputDir string) ([]*x509.Certificate, error) {
+	cas, err := ListCAs(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	var roots []*x509.Certificate
+	for _, ca := range cas {
+		if ca.Type == IssuerTypeRoot {
+			roots = append(roots, ca.Cert)
+		}
+	}
+	return roots, nil
+}
+
+// ResolveOutputPath resolves a path relative to the output directory and rejects paths outside of it.
+func ResolveOutputPath(outputDir, target string) (string, error) {
+	absBase, err := filepath.Abs(outputDir)
+	if err != nil {
+		return "", err
+	}
+	cleaned := filepath.FromSlash(strings.TrimSpace(target))
+	if cleaned == "" {
+		return "", fmt.Errorf("path is required")
+	}
+	if !filepath.IsAbs(cleaned) {
+		cleaned = filepath.Join(absBase, cleaned)
+	}
+	cleaned = filepath.Clean(cleaned)
+	rel, err := filepath.Rel(absBase, cleaned)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %s is outside the output directory", target)
+	}
+	return cleaned, nil
+}
+
+// PrivateKeyPathForCertificate returns the key file stored next to a certificate PEM.
+func PrivateKeyPathForCertificate(certPath string) string {
+	return strings.TrimSuffix(certPath, filepath.Ext(certPath)) + ".key"
+}
+
+// PFXPathForCertificate returns the PKCS#12 bundle stored next to a certificate PEM.
+func PFXPathForCertificate(certPath string) string {
+	return strings.TrimSuffix(certPath, filepath.Ext(certPath)) + ".pfx"
+}
+
+// CertificatePath returns the PEM path of a certificate issued by the given CA.
+func CertificatePath(outputDir, issuerType, rootName, issuerName, commonName string) string {
+	var certDir string
+	if strings.ToLower(strings.TrimSpace(issuerType)) == IssuerTypeIntermediate {
+		certDir = intermediateCertDir(outputDir, rootName, issuerName)
+	} else {
+		certDir = rootCertDir(outputDir, issuerName)
+	}
+	return filepath.Join(certDir, fmt.Sprintf("cert_%s.pem", NormalizeName(commonName, "certificate")))
+}
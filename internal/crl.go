@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const crlValidity = 30 * 24 * time.Hour
+
+// IssuerCRLPath returns where the CRL of the CA stored at caCertPath is kept ("ca.crl" next to "ca.pem").
+func IssuerCRLPath(caCertPath string) string {
+	return strings.TrimSuffix(caCertPath, filepath.Ext(caCertPath)) + ".crl"
+}
+
+// RevocationReasons maps the RFC 5280 CRL reason names accepted by the revocation commands to
+// their codes.
+var RevocationReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"cACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
+}
+
+// RevokeIssuedCertificate adds cert to the CRL of the CA in the output directory that signed it
+// and returns the CRL path.
+func RevokeIssuedCertificate(outputDir string, cert *x509.Certificate, reason int) (string, error) {
+	if isSelfSigned(cert) {
+		return "", fmt.Errorf("self-signed certificates cannot be revoked")
+	}
+	ca, err := FindIssuingCA(outputDir, cert)
+	if err != nil {
+		return "", err
+	}
+	issuer, err := NewCSRIssuer(outputDir, ca.Type, ca.RootName, ca.Name)
+	if err != nil {
+		return "", err
+	}
+	return issuer.Revoke(cert, reason)
+}
+
+// IsCertificateRevoked reports whether the CRL of the CA that signed cert lists it.
+func IsCertificateRevoked(outputDir string, cert *x509.Certificate) bool {
+	ca, err := FindIssuingCA(outputDir, cert)
+	if err != nil {
+		return false
+	}
+	return isRevoked(issuerMaterial{cert: ca.Cert, certPath: ca.CertPath}, cert.SerialNumber)
+}
+
+// RevocationSet holds the certificates listed on the CRLs of the CAs in the output directory.
+type RevocationSet map[string]bool
+
+// LoadRevocationSet reads the CRL of every CA in the output directory once, which is cheaper than
+// calling IsCertificateRevoked for many certificates. CRLs not signed by their CA are ignored.
+func LoadRevocationSet(outputDir string) (RevocationSet, error) {
+	cas, err := ListCAs(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	set := make(RevocationSet)
+	for _, ca := range cas {
+		for _, crl := range issuerRevocationLists(issuerMaterial{cert: ca.Cert, certPath: ca.CertPath}) {
+			for _, entry := range crl.RevokedCertificateEntries {
+				set[revocationKey(crl.RawIssuer, entry.SerialNumber)] = true
+			}
+		}
+	}
+	return set, nil
+}
+
+// Contains reports whether cert is listed on the CRL of a CA with the subject of its issuer.
+func (s RevocationSet) Contains(cert *x509.Certificate) bool {
+	return s[revocationKey(cert.RawIssuer, cert.SerialNumber)]
+}
+
+func revocationKey(rawIssuer []byte, serial *big.Int) string {
+	return string(rawIssuer) + "/" + serial.Text(16)
+}
+
+// revokeCertificate adds cert to the issuer CRL with the given RFC 5280 reason code, keeping
+// earlier entries, and returns its path.
+func revokeCertificate(outputDir string, issuer issuerMaterial, cert *x509.Certificate, reason int) (string, error) {
+	crlPath := IssuerCRLPath(issuer.certPath)
+	number := big.NewInt(1)
+	var entries []x509.RevocationListEntry
+	for _, crl := range issuerRevocationLists(issuer) {
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+				entries = append(entries, x509.RevocationListEntry{SerialNumber: entry.SerialNumber, RevocationTime: entry.RevocationTime, ReasonCode: entry.ReasonCode})
+			}
+		}
+		if crl.Number != nil && crl.Number.Cmp(number) >= 0 {
+			number = new(big.Int).Add(crl.Number, big.NewInt(1))
+		}
+	}
+	now := time.Now()
+	entries = append(entries, x509.RevocationListEntry{SerialNumber: cert.SerialNumber, RevocationTime: now, ReasonCode: reason})
+
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    number,
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(crlValidity),
+		RevokedCertificateEntries: entries,
+	}, issuer.cert, issuer.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign CRL: %w", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	if err := os.WriteFile(crlPath, data, 0o644); err != nil {
+		return "", err
+	}
+	if err := RecordInventory(outputDir, InventoryRecord{Path: crlPath, Kind: InventoryKindCRL}); err != nil {
+		return "", err
+	}
+	return crlPath, nil
+}
+
+// isRevoked reports whether the issuer CRL lists the serial number.
+func isRevoked(issuer issuerMaterial, serial *big.Int) bool {
+	for _, crl := range issuerRevocationLists(issuer) {
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(serial) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// issuerRevocationLists returns the CRLs next to the issuer that it actually signed.
+func issuerRevocationLists(issuer issuerMaterial) []*x509.RevocationList {
+	existing, err := loadRevocationLists(IssuerCRLPath(issuer.certPath))
+	if err != nil {
+		return nil
+	}
+	var lists []*x509.RevocationList
+	for _, crl := range existing {
+		if bytes.Equal(crl.RawIssuer, issuer.cert.RawSubject) && crl.CheckSignatureFrom(issuer.cert) == nil {
+			lists = append(lists, crl)
+		}
+	}
+	return lists
+}
@@ -0,0 +1,862 @@
+package internal
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	defaultEAPTLSTimeout = 10 * time.Second
+	maxEAPTLSRoundTrips  = 64
+)
+
+type EAPTLSTestOptions struct {
+	// ServerCertPath is the certificate presented by the RADIUS server; its key must sit next to it.
+	ServerCertPath string
+	// ClientCertPath is a PKCS#12 bundle (.pfx/.p12) or a PEM certificate with its key next to it.
+	ClientCertPath string
+	ClientPassword string
+	// TrustCertPaths are the CAs the server validates clients against. Defaults to every CA in
+	// the output directory.
+	TrustCertPaths []string
+	// CRLFiles are PEM or DER CRLs checked against the client chain. Defaults to the CRLs kept
+	// next to the trusted CAs.
+	CRLFiles []string
+	// ServerName, when set, must match the server certificate like a supplicant's domain match.
+	ServerName string
+	Identity   string
+	// TLSVersion is "1.2" (default), "1.3" or a legacy version.
+	TLSVersion string
+	Timeout    time.Duration
+}
+
+type EAPTLSTestResult struct {
+	Accepted bool
+	// Reason explains why the server rejected the client.
+	Reason string
+	// ClientError is the supplicant-side failure, for example an untrusted server certificate.
+	ClientError   string
+	Identity      string
+	ClientSubject string
+	TLSVersion    string
+	CipherSuite   string
+	RoundTrips    int
+}
+
+// RunEAPTLSTest starts a RADIUS server on loopback that performs EAP-TLS with the given server
+// certificate and authenticates the client certificate against it. Setup problems are returned
+// as errors; authentication failures are reported in the result.
+func RunEAPTLSTest(outputDir string, options EAPTLSTestOptions) (EAPTLSTestResult, error) {
+	if options.Timeout <= 0 {
+		options.Timeout = defaultEAPTLSTimeout
+	}
+	version, err := ParseTLSVersion(options.TLSVersion, tls.VersionTLS12)
+	if err != nil {
+		return EAPTLSTestResult{}, err
+	}
+
+	serverCert, err := LoadServerCertificate(outputDir, options.ServerCertPath)
+	if err != nil {
+		return EAPTLSTestResult{}, err
+	}
+	clientCert, err := loadClientCertificate(outputDir, options.ClientCertPath, options.ClientPassword)
+	if err != nil {
+		return EAPTLSTestResult{}, err
+	}
+	verifier, err := newClientCertVerifier(outputDir, options.TrustCertPaths, options.CRLFiles)
+	if err != nil {
+		return EAPTLSTestResult{}, err
+	}
+	serverRoots := x509.NewCertPool()
+	for _, cert := range verifier.rootCerts {
+		serverRoots.AddCert(cert)
+	}
+
+	identity := options.Identity
+	if identity == "" {
+		identity = clientCert.Leaf.Subject.CommonName
+	}
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return EAPTLSTestResult{}, err
+	}
+
+	server := &eapTLSServer{
+		secret:   secret,
+		timeout:  options.Timeout,
+		sessions: map[string]*eapTLSServerSession{},
+		config: &tls.Config{
+			Certificates:           []tls.Certificate{serverCert},
+			ClientAuth:             tls.RequireAnyClientCert,
+			VerifyPeerCertificate:  verifier.verify,
+			MinVersion:             version,
+			MaxVersion:             version,
+			SessionTicketsDisabled: true,
+		},
+	}
+	if err := server.listen("127.0.0.1:0"); err != nil {
+		return EAPTLSTestResult{}, err
+	}
+	defer server.close()
+	go server.serve()
+
+	client := &eapTLSClient{
+		secret:   secret,
+		identity: identity,
+		timeout:  options.Timeout,
+		config: &tls.Config{
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return &clientCert, nil
+			},
+			InsecureSkipVerify: true,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				return verifyServerCertificate(rawCerts, serverRoots, options.ServerName)
+			},
+			MinVersion: version,
+			MaxVersion: version,
+		},
+	}
+
+	result := EAPTLSTestResult{
+		Identity:      identity,
+		ClientSubject: clientCert.Leaf.Subject.String(),
+	}
+	accepted, clientErr := client.authenticate(server.addr())
+	result.Accepted = accepted
+	result.RoundTrips = client.roundTrips
+	if clientErr != nil {
+		result.ClientError = clientErr.Error()
+	}
+
+	outcome := server.outcome()
+	if outcome.err != nil {
+		result.Reason = outcome.err.Error()
+	}
+	if outcome.state != nil {
+		result.TLSVersion = tls.VersionName(outcome.state.Version)
+		result.CipherSuite = tls.CipherSuiteName(outcome.state.CipherSuite)
+	}
+	if !result.Accepted && result.Reason == "" && result.ClientError == "" {
+		result.Reason = "access rejected"
+	}
+	return result, nil
+}
+
+// LoadServerCertificate loads a certificate with the key stored next to it and its issuing chain.
+func LoadServerCertificate(outputDir, certPath string) (tls.Certificate, error) {
+	cert, err := LoadCertificate(certPath)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	key, err := LoadPrivateKey(PrivateKeyPathForCertificate(certPath))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("server private key is not available: %w", err)
+	}
+	chain, err := BuildChain(outputDir, cert)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to build server chain: %w", err)
+	}
+	return tlsCertificate(cert, chain, key), nil
+}
+
+func loadClientCertificate(outputDir, certPath, password string) (tls.Certificate, error) {
+	switch strings.ToLower(filepath.Ext(certPath)) {
+	case ".pfx", ".p12":
+		data, err := os.ReadFile(certPath)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to read client PKCS#12: %w", err)
+		}
+		key, cert, caCerts, err := pkcs12.DecodeChain(data, password)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to decode client PKCS#12: %w", err)
+		}
+		return tlsCertificate(cert, caCerts, key), nil
+	default:
+		cert, err := LoadCertificate(certPath)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		key, err := LoadPrivateKey(PrivateKeyPathForCertificate(certPath))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("client private key is not available: %w", err)
+		}
+		// A client whose issuer is unknown still authenticates, so the server can report the
+		// chain failure; it just sends the leaf alone.
+		chain, _ := BuildChain(outputDir, cert)
+		return tlsCertificate(cert, chain, key), nil
+	}
+}
+
+// tlsCertificate builds the chain sent on the wire; self-signed CAs are left out like most TLS stacks do.
+func tlsCertificate(leaf *x509.Certificate, chain []*x509.Certificate, key crypto.PrivateKey) tls.Certificate {
+	certificate := tls.Certificate{Certificate: [][]byte{leaf.Raw}, PrivateKey: key, Leaf: leaf}
+	for _, cert := range chain {
+		if !isSelfSigned(cert) {
+			certificate.Certificate = append(certificate.Certificate, cert.Raw)
+		}
+	}
+	return certificate
+}
+
+// clientCertVerifier performs the checks a RADIUS server applies to EAP-TLS client certificates,
+// one at a time, so the first failure can be reported precisely.
+type clientCertVerifier struct {
+	rootCerts     []*x509.Certificate
+	roots         *x509.CertPool
+	intermediates []*x509.Certificate
+	crls          []*x509.RevocationList
+}
+
+func newClientCertVerifier(outputDir string, trustPaths, crlFiles []string) (*clientCertVerifier, error) {
+	var trusted []*x509.Certificate
+	if len(trustPaths) == 0 {
+		cas, err := ListCAs(outputDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list CAs: %w", err)
+		}
+		for _, ca := range cas {
+			trusted = append(trusted, ca.Cert)
+			trustPaths = append(trustPaths, ca.CertPath)
+		}
+	} else {
+		certs, err := loadCertificates(trustPaths)
+		if err != nil {
+			return nil, err
+		}
+		trusted = certs
+	}
+
+	verifier := &clientCertVerifier{roots: x509.NewCertPool()}
+	for _, cert := range trusted {
+		if isSelfSigned(cert) {
+			verifier.rootCerts = append(verifier.rootCerts, cert)
+			verifier.roots.AddCert(cert)
+		} else {
+			verifier.intermediates = append(verifier.intermediates, cert)
+		}
+	}
+	if len(verifier.rootCerts) == 0 {
+		return nil, fmt.Errorf("no trusted root CA found")
+	}
+
+	if len(crlFiles) == 0 {
+		// Fall back to the CRLs cert-helper maintains next to each trusted CA.
+		for _, path := range trustPaths {
+			if crlPath := IssuerCRLPath(path); fileExists(crlPath) {
+				crlFiles = append(crlFiles, crlPath)
+			}
+		}
+	}
+	for _, file := range crlFiles {
+		crls, err := loadRevocationLists(file)
+		if err != nil {
+			return nil, err
+		}
+		verifier.crls = append(verifier.crls, crls...)
+	}
+	return verifier, nil
+}
+
+func (v *clientCertVerifier) verify(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("client did not send a certificate")
+	}
+	var peerCerts []*x509.Certificate
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("client sent an unparsable certificate: %w", err)
+		}
+		peerCerts = append(peerCerts, cert)
+	}
+	leaf := peerCerts[0]
+	name := leaf.Subject.CommonName
+
+	now := time.Now()
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("client certificate %q expired on %s", name, leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("client certificate %q is not valid before %s", name, leaf.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if !hasExtKeyUsage(leaf, x509.ExtKeyUsageClientAuth) {
+		return fmt.Errorf("client certificate %q lacks the ClientAuth extended key usage (1.3.6.1.5.5.7.3.2)", name)
+	}
+	if leaf.KeyUsage != 0 && leaf.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return fmt.Errorf("client certificate %q lacks the digitalSignature key usage", name)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range append(peerCerts[1:], v.intermediates...) {
+		intermediates.AddCert(cert)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		CurrentTime:   now,
+	})
+	if err != nil {
+		return fmt.Errorf("client certificate %q does not chain to a trusted CA: %w", name, err)
+	}
+	return v.checkRevocation(chains[0])
+}
+
+func (v *clientCertVerifier) checkRevocation(chain []*x509.Certificate) error {
+	for i := 0; i < len(chain)-1; i++ {
+		cert, issuer := chain[i], chain[i+1]
+		for _, crl := range v.crls {
+			if !bytes.Equal(crl.RawIssuer, issuer.RawSubject) || crl.CheckSignatureFrom(issuer) != nil {
+				continue
+			}
+			for _, entry := range crl.RevokedCertificateEntries {
+				if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return fmt.Errorf("certificate %q (serial %s) was revoked on %s by %q",
+						cert.Subject.CommonName, hex.EncodeToString(cert.SerialNumber.Bytes()),
+						entry.RevocationTime.UTC().Format(time.RFC3339), issuer.Subject.CommonName)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func loadRevocationLists(filename string) ([]*x509.RevocationList, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL: %w", err)
+	}
+	var crls []*x509.RevocationList
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CRL %s: %w", filename, err)
+		}
+		return append(crls, crl), nil
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CRL %s: %w", filename, err)
+		}
+		crls = append(crls, crl)
+	}
+	if len(crls) == 0 {
+		return nil, fmt.Errorf("no CRL found in %s", filename)
+	}
+	return crls, nil
+}
+
+func verifyServerCertificate(rawCerts [][]byte, roots *x509.CertPool, serverName string) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("server did not send a certificate")
+	}
+	leaf, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("server sent an unparsable certificate: %w", err)
+	}
+	intermediates := x509.NewCertPool()
+	for _, raw := range rawCerts[1:] {
+		if cert, err := x509.ParseCertificate(raw); err == nil {
+			intermediates.AddCert(cert)
+		}
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}); err != nil {
+		return fmt.Errorf("server certificate %q rejected: %w", leaf.Subject.CommonName, err)
+	}
+	if serverName != "" {
+		if err := leaf.VerifyHostname(serverName); err != nil {
+			return fmt.Errorf("server certificate does not match %q: %w", serverName, err)
+		}
+	}
+	return nil
+}
+
+// eapTLSPipe is the net.Conn a crypto/tls engine runs over. Records written by the engine are
+// collected until it blocks for input, and records received in EAP-TLS fragments are fed back in.
+type eapTLSPipe struct {
+	in        chan []byte
+	idle      chan struct{}
+	done      chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	out     bytes.Buffer
+	pending []byte
+
+	finished bool
+	result   error
+}
+
+func newEAPTLSPipe() *eapTLSPipe {
+	return &eapTLSPipe{
+		in:     make(chan []byte),
+		idle:   make(chan struct{}),
+		done:   make(chan error, 1),
+		closed: make(chan struct{}),
+	}
+}
+
+// start runs the TLS engine and returns what it writes before first waiting for input.
+func (p *eapTLSPipe) start(run func() error, timeout time.Duration) ([]byte, error) {
+	go func() { p.done <- run() }()
+	return p.wait(timeout)
+}
+
+// exchange feeds a complete TLS message to the engine and returns its response.
+func (p *eapTLSPipe) exchange(input []byte, timeout time.Duration) ([]byte, error) {
+	if !p.finished {
+		select {
+		case p.in <- input:
+		case err := <-p.done:
+			p.finish(err)
+			return p.drain(), nil
+		case <-time.After(timeout):
+			return nil, fmt.Errorf("timed out waiting for the TLS engine")
+		}
+	}
+	return p.wait(timeout)
+}
+
+func (p *eapTLSPipe) wait(timeout time.Duration) ([]byte, error) {
+	if !p.finished {
+		select {
+		case <-p.idle:
+		case err := <-p.done:
+			p.finish(err)
+		case <-time.After(timeout):
+			return nil, fmt.Errorf("timed out waiting for the TLS engine")
+		}
+	}
+	return p.drain(), nil
+}
+
+func (p *eapTLSPipe) finish(err error) {
+	p.finished = true
+	p.result = err
+}
+
+func (p *eapTLSPipe) drain() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	data := append([]byte{}, p.out.Bytes()...)
+	p.out.Reset()
+	return data
+}
+
+func (p *eapTLSPipe) Read(b []byte) (int, error) {
+	for len(p.pending) == 0 {
+		select {
+		case p.idle <- struct{}{}:
+		case <-p.closed:
+			return 0, io.EOF
+		}
+		select {
+		case data := <-p.in:
+			p.pending = data
+		case <-p.closed:
+			return 0, io.EOF
+		}
+	}
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+func (p *eapTLSPipe) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.out.Write(b)
+}
+
+func (p *eapTLSPipe) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	return nil
+}
+
+func (p *eapTLSPipe) LocalAddr() net.Addr              { return eapTLSAddr{} }
+func (p *eapTLSPipe) RemoteAddr() net.Addr             { return eapTLSAddr{} }
+func (p *eapTLSPipe) SetDeadline(time.Time) error      { return nil }
+func (p *eapTLSPipe) SetReadDeadline(time.Time) error  { return nil }
+func (p *eapTLSPipe) SetWriteDeadline(time.Time) error { return nil }
+
+type eapTLSAddr struct{}
+
+func (eapTLSAddr) Network() string { return "eap-tls" }
+func (eapTLSAddr) String() string  { return "eap-tls" }
+
+type eapTLSOutcome struct {
+	err   error
+	state *tls.ConnectionState
+}
+
+type eapTLSServer struct {
+	conn     *net.UDPConn
+	secret   []byte
+	config   *tls.Config
+	timeout  time.Duration
+	sessions map[string]*eapTLSServerSession
+
+	stopped chan struct{}
+
+	mu   sync.Mutex
+	last eapTLSOutcome
+}
+
+type eapTLSServerSession struct {
+	pipe   *eapTLSPipe
+	tls    *tls.Conn
+	stream eapTLSStream
+	eapID  byte
+}
+
+func (s *eapTLSServer) listen(address string) error {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return err
+	}
+	s.conn, err = net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start RADIUS listener: %w", err)
+	}
+	s.stopped = make(chan struct{})
+	return nil
+}
+
+func (s *eapTLSServer) addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *eapTLSServer) close() {
+	_ = s.conn.Close()
+	<-s.stopped
+	for _, session := range s.sessions {
+		_ = session.pipe.Close()
+	}
+}
+
+func (s *eapTLSServer) outcome() eapTLSOutcome {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+func (s *eapTLSServer) record(outcome eapTLSOutcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = outcome
+}
+
+func (s *eapTLSServer) serve() {
+	defer close(s.stopped)
+	buf := make([]byte, radiusMaxPacket)
+	for {
+		n, peer, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		request, err := parseRADIUSPacket(buf[:n], s.secret, nil)
+		if err != nil || request.Code != radiusAccessRequest {
+			continue
+		}
+		response := s.handle(request)
+		data, err := response.marshal(s.secret, &request.Authenticator)
+		if err != nil {
+			s.record(eapTLSOutcome{err: err})
+			continue
+		}
+		_, _ = s.conn.WriteToUDP(data, peer)
+	}
+}
+
+func (s *eapTLSServer) handle(request *radiusPacket) *radiusPacket {
+	eap, err := parseEAPPacket(request.eapMessage())
+	if err != nil || eap.Code != eapResponse {
+		return s.reject(request, nil, fmt.Errorf("invalid EAP response: %v", err))
+	}
+
+	state := string(request.attribute(radiusAttrState))
+	session := s.sessions[state]
+	switch {
+	case eap.Type == eapTypeIdentity:
+		session, state, err = s.newSession()
+		if err != nil {
+			return s.reject(request, nil, err)
+		}
+		session.eapID = eap.Identifier + 1
+		return s.challenge(request, state, session, []byte{eapTLSFlagStart})
+	case session == nil:
+		return s.reject(request, nil, fmt.Errorf("unknown EAP session"))
+	case eap.Type == eapTypeNak:
+		return s.reject(request, session, fmt.Errorf("client refused EAP-TLS"))
+	case eap.Type != eapTypeTLS:
+		return s.reject(request, session, fmt.Errorf("unexpected EAP type %d", eap.Type))
+	}
+
+	session.eapID = eap.Identifier + 1
+	flags, complete, err := session.stream.receive(eap.Data)
+	if err != nil {
+		return s.reject(request, session, err)
+	}
+	if !complete {
+		return s.challenge(request, state, session, []byte{0})
+	}
+	if session.stream.hasOutgoing() {
+		return s.challenge(request, state, session, session.stream.nextFragment())
+	}
+
+	input := session.stream.takeIncoming()
+	if len(input) == 0 && flags == 0 {
+		// The client acknowledged our final flight.
+		if session.pipe.finished {
+			if session.pipe.result != nil {
+				return s.reject(request, session, session.pipe.result)
+			}
+			return s.accept(request, session)
+		}
+		return s.reject(request, session, fmt.Errorf("client acknowledged an incomplete handshake"))
+	}
+
+	output, err := session.pipe.exchange(input, s.timeout)
+	if err != nil {
+		return s.reject(request, session, err)
+	}
+	if len(output) == 0 {
+		if session.pipe.finished && session.pipe.result != nil {
+			return s.reject(request, session, session.pipe.result)
+		}
+		return s.reject(request, session, fmt.Errorf("TLS handshake stalled"))
+	}
+	session.stream.queue(output)
+	return s.challenge(request, state, session, session.stream.nextFragment())
+}
+
+func (s *eapTLSServer) newSession() (*eapTLSServerSession, string, error) {
+	stateBytes := make([]byte, 16)
+	if _, err := rand.Read(stateBytes); err != nil {
+		return nil, "", err
+	}
+	state := string(stateBytes)
+	session := &eapTLSServerSession{pipe: newEAPTLSPipe()}
+	session.tls = tls.Server(session.pipe, s.config)
+	_, err := session.pipe.start(func() error {
+		if err := session.tls.Handshake(); err != nil {
+			return err
+		}
+		if session.tls.ConnectionState().Version == tls.VersionTLS13 {
+			// RFC 9190 protected success indication.
+			if _, err := session.tls.Write([]byte{0}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, s.timeout)
+	if err != nil {
+		return nil, "", err
+	}
+	s.sessions[state] = session
+	return session, state, nil
+}
+
+func (s *eapTLSServer) challenge(request *radiusPacket, state string, session *eapTLSServerSession, payload []byte) *radiusPacket {
+	response := &radiusPacket{Code: radiusAccessChallenge, Identifier: request.Identifier}
+	response.setEAPMessage(eapPacket{Code: eapRequest, Identifier: session.eapID, Type: eapTypeTLS, Data: payload}.marshal())
+	response.addAttribute(radiusAttrState, []byte(state))
+	return response
+}
+
+func (s *eapTLSServer) accept(request *radiusPacket, session *eapTLSServerSession) *radiusPacket {
+	state := session.tls.ConnectionState()
+	s.record(eapTLSOutcome{state: &state})
+	response := &radiusPacket{Code: radiusAccessAccept, Identifier: request.Identifier}
+	response.setEAPMessage(eapPacket{Code: eapSuccess, Identifier: session.eapID - 1}.marshal())
+	return response
+}
+
+func (s *eapTLSServer) reject(request *radiusPacket, session *eapTLSServerSession, reason error) *radiusPacket {
+	s.record(eapTLSOutcome{err: reason})
+	var eapID byte
+	if session != nil {
+		eapID = session.eapID - 1
+	}
+	response := &radiusPacket{Code: radiusAccessReject, Identifier: request.Identifier}
+	response.setEAPMessage(eapPacket{Code: eapFailure, Identifier: eapID}.marshal())
+	response.addAttribute(radiusAttrReplyMessage, []byte(truncateReplyMessage(reason.Error())))
+	return response
+}
+
+func truncateReplyMessage(message string) string {
+	if len(message) > radiusMaxAttrPayload {
+		return message[:radiusMaxAttrPayload]
+	}
+	return message
+}
+
+// eapTLSClient plays the supplicant and the NAS: it wraps EAP in RADIUS Access-Requests.
+type eapTLSClient struct {
+	secret   []byte
+	identity string
+	config   *tls.Config
+	timeout  time.Duration
+
+	roundTrips int
+}
+
+func (c *eapTLSClient) authenticate(serverAddr net.Addr) (bool, error) {
+	conn, err := net.Dial("udp", serverAddr.String())
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	pipe := newEAPTLSPipe()
+	defer pipe.Close()
+	tlsConn := tls.Client(pipe, c.config)
+	var stream eapTLSStream
+	var state []byte
+
+	response := eapPacket{Code: eapResponse, Type: eapTypeIdentity, Data: []byte(c.identity)}
+	for c.roundTrips < maxEAPTLSRoundTrips {
+		reply, err := c.exchange(conn, byte(c.roundTrips), response, state)
+		if err != nil {
+			return false, err
+		}
+		switch reply.Code {
+		case radiusAccessAccept:
+			return true, c.clientResult(pipe)
+		case radiusAccessReject:
+			return false, c.clientResult(pipe)
+		case radiusAccessChallenge:
+		default:
+			return false, fmt.Errorf("unexpected RADIUS code %d", reply.Code)
+		}
+		state = reply.attribute(radiusAttrState)
+
+		request, err := parseEAPPacket(reply.eapMessage())
+		if err != nil {
+			return false, err
+		}
+		if request.Code != eapRequest || request.Type != eapTypeTLS {
+			return false, fmt.Errorf("server requested unsupported EAP type %d", request.Type)
+		}
+		response = eapPacket{Code: eapResponse, Identifier: request.Identifier, Type: eapTypeTLS}
+
+		flags, complete, err := stream.receive(request.Data)
+		if err != nil {
+			return false, err
+		}
+		var output []byte
+		switch {
+		case flags&eapTLSFlagStart != 0:
+			stream.takeIncoming()
+			output, err = pipe.start(func() error {
+				if err := tlsConn.Handshake(); err != nil {
+					return err
+				}
+				if tlsConn.ConnectionState().Version == tls.VersionTLS13 {
+					marker := make([]byte, 1)
+					if _, err := io.ReadFull(tlsConn, marker); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, c.timeout)
+		case !complete:
+			response.Data = []byte{0}
+			continue
+		case stream.hasOutgoing():
+			response.Data = stream.nextFragment()
+			continue
+		default:
+			output, err = pipe.exchange(stream.takeIncoming(), c.timeout)
+		}
+		if err != nil {
+			return false, err
+		}
+		if len(output) == 0 {
+			// Handshake finished (or failed) on our side; acknowledge so the server can decide.
+			response.Data = []byte{0}
+			continue
+		}
+		stream.queue(output)
+		response.Data = stream.nextFragment()
+	}
+	return false, fmt.Errorf("no RADIUS decision after %d round trips", maxEAPTLSRoundTrips)
+}
+
+func (c *eapTLSClient) exchange(conn net.Conn, identifier byte, eap eapPacket, state []byte) (*radiusPacket, error) {
+	c.roundTrips++
+	request := &radiusPacket{Code: radiusAccessRequest, Identifier: identifier}
+	if _, err := rand.Read(request.Authenticator[:]); err != nil {
+		return nil, err
+	}
+	request.addAttribute(radiusAttrUserName, []byte(c.identity))
+	request.setEAPMessage(eap.marshal())
+	if state != nil {
+		request.addAttribute(radiusAttrState, state)
+	}
+	data, err := request.marshal(c.secret, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(data); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, radiusMaxPacket)
+	n, err := conn.Read(buf)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, fmt.Errorf("RADIUS server did not answer within %s", c.timeout)
+		}
+		return nil, err
+	}
+	reply, err := parseRADIUSPacket(buf[:n], c.secret, &request.Authenticator)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Identifier != identifier {
+		return nil, fmt.Errorf("RADIUS reply identifier mismatch")
+	}
+	return reply, nil
+}
+
+// clientResult reports the supplicant's own TLS failure, if any, once the server has decided.
+func (c *eapTLSClient) clientResult(pipe *eapTLSPipe) error {
+	if pipe.finished {
+		return pipe.result
+	}
+	return nil
+}
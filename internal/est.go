@@ -0,0 +1,314 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/smallstep/pkcs7"
+)
+
+const (
+	estPathPrefix      = "/.well-known/est"
+	estMaxRequestSize  = 64 << 10
+	estBase64LineWidth = 76
+)
+
+// oidSHA256WithRSA is advertised by /csrattrs as the signature algorithm CSRs should use.
+var oidSHA256WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+
+type ESTServerOptions struct {
+	// CertPath is the TLS certificate presented by the server; its key must sit next to it.
+	CertPath     string
+	Address      string
+	IssuerType   string
+	RootName     string
+	IssuerName   string
+	ValidityDays int
+	// Users are the HTTP basic credentials accepted for enrollment, keyed by user name.
+	Users map[string]string
+	// ClientCertAuth also accepts a valid TLS client certificate as enrollment credentials.
+	// Re-enrollment always requires the certificate being renewed.
+	ClientCertAuth bool
+	// TrustCertPaths are the CAs client certificates must chain to. Defaults to every CA in the
+	// output directory.
+	TrustCertPaths []string
+	// CRLFiles default to the CRLs kept next to the trusted CAs.
+	CRLFiles []string
+	Logf     func(format string, args ...any)
+}
+
+// ESTServer implements the RFC 7030 operations /cacerts, /simpleenroll, /simplereenroll and
+// /csrattrs under /.well-known/est over TLS. Issued certificates are stored by CSRIssuer.
+type ESTServer struct {
+	options  ESTServerOptions
+	issuer   *CSRIssuer
+	verifier *clientCertVerifier
+	listener net.Listener
+	server   *http.Server
+}
+
+func ListenEST(outputDir string, options ESTServerOptions) (*ESTServer, error) {
+	if len(options.Users) == 0 && !options.ClientCertAuth {
+		return nil, fmt.Errorf("enrollment needs HTTP basic users or client certificate authentication")
+	}
+	if options.ValidityDays <= 0 {
+		options.ValidityDays = 365
+	}
+	if options.Logf == nil {
+		options.Logf = func(string, ...any) {}
+	}
+	serverCert, err := LoadServerCertificate(outputDir, options.CertPath)
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := NewCSRIssuer(outputDir, options.IssuerType, options.RootName, options.IssuerName)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := newClientCertVerifier(outputDir, options.TrustCertPaths, options.CRLFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", options.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", options.Address, err)
+	}
+	s := &ESTServer{options: options, issuer: issuer, verifier: verifier, listener: listener}
+	s.server = &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: defaultTLSHandshakeTimeout,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			// Client certificates are checked per request so basic auth clients are not refused.
+			ClientAuth: tls.RequestClientCert,
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+	return s, nil
+}
+
+func (s *ESTServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve handles requests until Close is called.
+func (s *ESTServer) Serve() error {
+	err := s.server.ServeTLS(s.listener, "", "")
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *ESTServer) Close() error {
+	return s.server.Close()
+}
+
+func (s *ESTServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path, ok := strings.CutPrefix(r.URL.Path, estPathPrefix+"/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// An optional CA label ("/.well-known/est/<label>/cacerts") selects nothing here: the server
+	// has a single issuing CA.
+	segments := strings.Split(path, "/")
+	operation := segments[len(segments)-1]
+	if len(segments) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch operation {
+	case "cacerts":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.writeCertificates(w, s.issuer.Chain())
+	case "csrattrs":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		der, err := asn1.Marshal([]asn1.ObjectIdentifier{oidSHA256WithRSA})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeBase64Body(w, "application/csrattrs", der)
+	case "simpleenroll", "simplereenroll":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleEnroll(w, r, operation == "simplereenroll")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *ESTServer) handleEnroll(w http.ResponseWriter, r *http.Request, reenroll bool) {
+	clientCert, clientErr := s.verifiedClientCertificate(r)
+	user, userOK := s.basicAuthUser(r)
+	client := user
+	switch {
+	case reenroll && clientCert == nil:
+		s.reject(w, r, http.StatusUnauthorized, "re-enrollment requires the current certificate for TLS client authentication: %v", clientErr)
+		return
+	case !reenroll && !userOK && (clientCert == nil || !s.options.ClientCertAuth):
+		if len(s.options.Users) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="estrealm"`)
+		}
+		reason := "missing or invalid credentials"
+		if clientErr != nil && s.options.ClientCertAuth {
+			reason = clientErr.Error()
+		}
+		s.reject(w, r, http.StatusUnauthorized, "%s", reason)
+		return
+	}
+	if clientCert != nil && client == "" {
+		client = clientCert.Subject.String()
+	}
+
+	csr, err := readESTRequest(r)
+	if err != nil {
+		s.reject(w, r, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if reenroll {
+		// Issued subjects are re-encoded from the parsed CSR, so compare names rather than raw DER.
+		if csr.Subject.String() != clientCert.Subject.String() {
+			s.reject(w, r, http.StatusBadRequest, "re-enrollment CSR subject %q differs from the current certificate %q", csr.Subject, clientCert.Subject)
+			return
+		}
+		if !slices.Equal(csr.DNSNames, clientCert.DNSNames) || !slices.EqualFunc(csr.IPAddresses, clientCert.IPAddresses, net.IP.Equal) {
+			s.reject(w, r, http.StatusBadRequest, "re-enrollment CSR subject alternative names differ from the current certificate")
+			return
+		}
+	}
+
+	issued, err := s.issuer.Issue(CSRIssueRequest{
+		CSR:          csr,
+		ValidityDays: s.options.ValidityDays,
+		Source:       InventorySourceEST,
+	})
+	operation := "enrolled"
+	if reenroll {
+		operation = "re-enrolled"
+	}
+	audit := AuditEvent{
+		Actor:  client,
+		Source: RemoteIP(r),
+		Action: AuditESTEnroll,
+		Target: csr.Subject.String(),
+		Params: map[string]string{"operation": operation, "issuer": s.issuer.Certificate().Subject.String()},
+		Err:    err,
+	}
+	if err == nil {
+		audit.Params["serial"] = issued.Certificate.SerialNumber.Text(16)
+	}
+	if auditErr := RecordAudit(s.issuer.outputDir, audit); auditErr != nil {
+		s.options.Logf("failed to audit EST enrollment: %v", auditErr)
+	}
+	if err != nil {
+		s.reject(w, r, http.StatusBadRequest, "%v", err)
+		return
+	}
+	s.options.Logf("EST %s %q for %s: %s (serial %s)", operation, issued.Certificate.Subject.CommonName, client, issued.Path, issued.Certificate.SerialNumber.Text(16))
+	s.writeCertificates(w, []*x509.Certificate{issued.Certificate})
+}
+
+func (s *ESTServer) verifiedClientCertificate(r *http.Request) (*x509.Certificate, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("no client certificate was presented")
+	}
+	var rawCerts [][]byte
+	for _, cert := range r.TLS.PeerCertificates {
+		rawCerts = append(rawCerts, cert.Raw)
+	}
+	if err := s.verifier.verify(rawCerts, nil); err != nil {
+		return nil, err
+	}
+	return r.TLS.PeerCertificates[0], nil
+}
+
+func (s *ESTServer) basicAuthUser(r *http.Request) (string, bool) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	expected, known := s.options.Users[user]
+	if !known || subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
+		return "", false
+	}
+	return user, true
+}
+
+func (s *ESTServer) reject(w http.ResponseWriter, r *http.Request, status int, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	s.options.Logf("EST %s from %s rejected: %s", r.URL.Path, r.RemoteAddr, message)
+	http.Error(w, message, status)
+}
+
+func (s *ESTServer) writeCertificates(w http.ResponseWriter, certs []*x509.Certificate) {
+	var der []byte
+	for _, cert := range certs {
+		der = append(der, cert.Raw...)
+	}
+	degenerate, err := pkcs7.DegenerateCertificate(der)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeBase64Body(w, "application/pkcs7-mime; smime-type=certs-only", degenerate)
+}
+
+// readESTRequest decodes the base64 PKCS#10 body of an enrollment request.
+func readESTRequest(r *http.Request) (*x509.CertificateRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, estMaxRequestSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request: %w", err)
+	}
+	body = bytes.TrimPrefix(body, []byte("-----BEGIN CERTIFICATE REQUEST-----"))
+	body = bytes.TrimSuffix(bytes.TrimSpace(body), []byte("-----END CERTIFICATE REQUEST-----"))
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		return nil, fmt.Errorf("request body is not a base64 encoded PKCS#10 CSR")
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSR: %w", err)
+	}
+	return csr, nil
+}
+
+func writeBase64Body(w http.ResponseWriter, contentType string, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var body strings.Builder
+	for len(encoded) > estBase64LineWidth {
+		body.WriteString(encoded[:estBase64LineWidth] + "\r\n")
+		encoded = encoded[estBase64LineWidth:]
+	}
+	body.WriteString(encoded + "\r\n")
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, body.String())
+}
@@ -0,0 +1,234 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/smallstep/pkcs7"
+)
+
+const (
+	ExportFullChain   = "fullchain"
+	ExportChain       = "chain"
+	ExportDER         = "der"
+	ExportPKCS7       = "p7b"
+	ExportPKCS12      = "pfx"
+	ExportTrustBundle = "trust-bundle"
+)
+
+// ExportFormats lists the supported export formats in the order they are offered to users.
+var ExportFormats = []string{
+	ExportFullChain,
+	ExportChain,
+	ExportDER,
+	ExportPKCS7,
+	ExportPKCS12,
+	ExportJKS,
+	ExportTrustBundle,
+	ExportTruststoreJKS,
+	ExportTruststorePKCS12,
+}
+
+type ExportOptions struct {
+	Format       string
+	Password     string
+	FriendlyName string
+	Alias        string
+	PFXEncoding  string
+}
+
+type ExportResult struct {
+	FileName    string
+	ContentType string
+	Data        []byte
+	PFXEncoding string
+	Private     bool
+}
+
+func NormalizeExportFormat(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case ExportFullChain, "full-chain", "fullchain.pem":
+		return ExportFullChain, nil
+	case ExportChain, "chain.pem":
+		return ExportChain, nil
+	case ExportDER, "cer", "crt":
+		return ExportDER, nil
+	case ExportPKCS7, "pkcs7", "p7c":
+		return ExportPKCS7, nil
+	case ExportPKCS12, "pkcs12", "p12":
+		return ExportPKCS12, nil
+	case ExportJKS, "keystore", "keystore-jks":
+		return ExportJKS, nil
+	case ExportTrustBundle, "trust", "bundle", "ca-bundle":
+		return ExportTrustBundle, nil
+	case ExportTruststoreJKS, "truststore":
+		return ExportTruststoreJKS, nil
+	case ExportTruststorePKCS12, "truststore-pkcs12", "truststore-pfx":
+		return ExportTruststorePKCS12, nil
+	default:
+		return "", fmt.Errorf("unsupported export format %q (supported: %s)", value, strings.Join(ExportFormats, ", "))
+	}
+}
+
+// IsStoreFormat reports whether a format bundles CA certificates without needing a selected leaf.
+func IsStoreFormat(format string) bool {
+	return format == ExportTrustBundle || format == ExportTruststoreJKS || format == ExportTruststorePKCS12
+}
+
+// ExportCertificate renders the certificate stored at certPath, together with its chain from the
+// output directory, in the requested format.
+func ExportCertificate(outputDir, certPath string, options ExportOptions) (ExportResult, error) {
+	format, err := NormalizeExportFormat(options.Format)
+	if err != nil {
+		return ExportResult{}, err
+	}
+	switch format {
+	case ExportTrustBundle:
+		return ExportTrustBundleFile(outputDir)
+	case ExportTruststoreJKS, ExportTruststorePKCS12:
+		return ExportTruststore(outputDir, []string{certPath}, format, options)
+	case ExportJKS:
+		return ExportJavaKeystore(outputDir, certPath, options)
+	}
+
+	cert, err := LoadCertificate(certPath)
+	if err != nil {
+		return ExportResult{}, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	chain, err := BuildChain(outputDir, cert)
+	if err != nil {
+		return ExportResult{}, fmt.Errorf("failed to build certificate chain: %w", err)
+	}
+
+	baseName := strings.TrimSuffix(filepath.Base(certPath), filepath.Ext(certPath))
+	switch format {
+	case ExportFullChain:
+		return ExportResult{
+			FileName:    baseName + "_fullchain.pem",
+			ContentType: "application/x-pem-file",
+			Data:        encodeCertificatesPEM(append([]*x509.Certificate{cert}, chain...)),
+		}, nil
+	case ExportChain:
+		if len(chain) == 0 {
+			return ExportResult{}, fmt.Errorf("%s is self-signed and has no issuing chain", cert.Subject.CommonName)
+		}
+		return ExportResult{
+			FileName:    baseName + "_chain.pem",
+			ContentType: "application/x-pem-file",
+			Data:        encodeCertificatesPEM(chain),
+		}, nil
+	case ExportDER:
+		return ExportResult{
+			FileName:    baseName + ".der",
+			ContentType: "application/pkix-cert",
+			Data:        cert.Raw,
+		}, nil
+	case ExportPKCS7:
+		var der []byte
+		for _, c := range append([]*x509.Certificate{cert}, chain...) {
+			der = append(der, c.Raw...)
+		}
+		data, err := pkcs7.DegenerateCertificate(der)
+		if err != nil {
+			return ExportResult{}, err
+		}
+		return ExportResult{
+			FileName:    baseName + ".p7b",
+			ContentType: "application/x-pkcs7-certificates",
+			Data:        data,
+		}, nil
+	default:
+		privateKey, err := LoadPrivateKey(PrivateKeyPathForCertificate(certPath))
+		if err != nil {
+			return ExportResult{}, fmt.Errorf("private key is not available for %s: %w", cert.Subject.CommonName, err)
+		}
+		friendlyName := options.FriendlyName
+		if friendlyName == "" {
+			friendlyName = options.Alias
+		}
+		if friendlyName == "" {
+			friendlyName = cert.Subject.CommonName
+		}
+		encoding, err := NormalizePFXEncoding(options.PFXEncoding)
+		if err != nil {
+			return ExportResult{}, err
+		}
+		data, err := EncodePFX(privateKey, cert, chain, options.Password, friendlyName, encoding)
+		if err != nil {
+			return ExportResult{}, err
+		}
+		return ExportResult{
+			FileName:    baseName + "_fullchain.pfx",
+			ContentType: "application/x-pkcs12",
+			Data:        data,
+			PFXEncoding: encoding,
+			Private:     true,
+		}, nil
+	}
+}
+
+// ExportTrustBundleFile returns a PEM bundle of every root CA in the output directory.
+func ExportTrustBundleFile(outputDir string) (ExportResult, error) {
+	roots, err := RootCertificates(outputDir)
+	if err != nil {
+		return ExportResult{}, err
+	}
+	if len(roots) == 0 {
+		return ExportResult{}, fmt.Errorf("no root CAs found in %s", outputDir)
+	}
+	return ExportResult{
+		FileName:    "trust-bundle.pem",
+		ContentType: "application/x-pem-file",
+		Data:        encodeCertificatesPEM(roots),
+	}, nil
+}
+
+// WriteExport stores an export result, keeping private key material readable by the owner only.
+// Exports written inside the output directory are recorded in the inventory: PKCS#12 bundles with
+// their encoding, everything else as an export so that a chain or trust bundle holding a single
+// certificate is not listed as an issued certificate.
+func WriteExport(outputDir, filename string, result ExportResult) error {
+	if err := ensureParentDir(filename); err != nil {
+		return err
+	}
+	absPath, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	_, outsideErr := ResolveOutputPath(outputDir, absPath)
+	inside := outsideErr == nil
+	if inside && result.PFXEncoding == "" {
+		// Recorded before the file exists, so that the certificate index never sees it unrecorded.
+		if err := RecordInventory(outputDir, InventoryRecord{Path: absPath, Kind: InventoryKindExport}); err != nil {
+			return err
+		}
+	}
+	mode := os.FileMode(0o644)
+	if result.Private {
+		mode = 0o600
+	}
+	if err := os.WriteFile(filename, result.Data, mode); err != nil {
+		return err
+	}
+	if !inside || result.PFXEncoding == "" {
+		return nil
+	}
+	return RecordInventory(outputDir, InventoryRecord{
+		Path:        absPath,
+		Kind:        InventoryKindPFX,
+		PFXEncoding: result.PFXEncoding,
+	})
+}
+
+func encodeCertificatesPEM(certs []*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
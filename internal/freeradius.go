@@ -0,0 +1,348 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const freeRADIUSCertDir = "cert-helper"
+
+type FreeRADIUSOptions struct {
+	// ServerCertPath is the PEM certificate presented by RADIUS; its key must sit next to it.
+	ServerCertPath string
+	// TrustCertPaths are the CAs clients are validated against. Defaults to the server chain.
+	TrustCertPaths []string
+	// ClientIssuerPaths restrict which CAs may issue accepted client certificates. Defaults to
+	// the issuing (non-root) CAs among the trusted certificates, or the roots if there are none.
+	ClientIssuerPaths []string
+	CRLFile           string
+	OCSPURL           string
+	TLSMinVersion     string
+	Destination       string
+}
+
+type freeRADIUSTemplateData struct {
+	CertDir       string
+	TLSMinVersion string
+	CheckCRL      bool
+	// CheckAllCRL makes FreeRADIUS require a CRL for every CA of the client chain, which only holds
+	// when the CA file has a single CA; otherwise the one CRL given covers the issuing CA only.
+	CheckAllCRL      bool
+	OCSPEnabled      bool
+	OCSPURL          string
+	CheckCertIssuer  string
+	ClientIssuers    []string
+	ServerCommonName string
+}
+
+// GenerateFreeRADIUSConfig writes an EAP-TLS configuration for FreeRADIUS 3 laid out like a
+// raddb directory, so that the destination can be mounted over /etc/freeradius or /etc/raddb.
+// It returns the written files.
+func GenerateFreeRADIUSConfig(outputDir string, options FreeRADIUSOptions) ([]string, error) {
+	if options.Destination == "" {
+		return nil, fmt.Errorf("destination directory is required")
+	}
+	serverCert, err := LoadCertificate(options.ServerCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	serverKey, err := os.ReadFile(PrivateKeyPathForCertificate(options.ServerCertPath))
+	if err != nil {
+		return nil, fmt.Errorf("server private key is not available: %w", err)
+	}
+	if !hasExtKeyUsage(serverCert, x509.ExtKeyUsageServerAuth) {
+		return nil, fmt.Errorf("%s lacks the serverAuth extended key usage required by most supplicants", serverCert.Subject.CommonName)
+	}
+	serverChain, err := BuildChain(outputDir, serverCert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build server chain: %w", err)
+	}
+
+	trusted := serverChain
+	if len(options.TrustCertPaths) > 0 {
+		trusted, err = loadCertificates(options.TrustCertPaths)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(trusted) == 0 {
+		return nil, fmt.Errorf("no trusted CA certificates selected")
+	}
+
+	var clientIssuers []*x509.Certificate
+	if len(options.ClientIssuerPaths) > 0 {
+		if clientIssuers, err = loadCertificates(options.ClientIssuerPaths); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, cert := range trusted {
+			if !isSelfSigned(cert) {
+				clientIssuers = append(clientIssuers, cert)
+			}
+		}
+		if len(clientIssuers) == 0 {
+			clientIssuers = trusted
+		}
+	}
+
+	caFile := encodeCertificatesPEM(trusted)
+	if options.CRLFile != "" {
+		crl, err := os.ReadFile(options.CRLFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CRL: %w", err)
+		}
+		caFile = append(caFile, crl...)
+	}
+
+	data := freeRADIUSTemplateData{
+		CertDir:          freeRADIUSCertDir,
+		TLSMinVersion:    options.TLSMinVersion,
+		CheckCRL:         options.CRLFile != "",
+		CheckAllCRL:      options.CRLFile != "" && len(trusted) == 1,
+		OCSPEnabled:      options.OCSPURL != "",
+		OCSPURL:          options.OCSPURL,
+		ServerCommonName: serverCert.Subject.CommonName,
+	}
+	if data.TLSMinVersion == "" {
+		data.TLSMinVersion = "1.2"
+	}
+	for _, issuer := range clientIssuers {
+		data.ClientIssuers = append(data.ClientIssuers, OpenSSLOneLine(issuer.Subject))
+	}
+	if len(data.ClientIssuers) == 1 {
+		data.CheckCertIssuer = data.ClientIssuers[0]
+	}
+
+	files := []struct {
+		path     string
+		content  []byte
+		template string
+		mode     os.FileMode
+	}{
+		{path: filepath.Join("certs", freeRADIUSCertDir, "server.pem"), content: encodeCertificatesPEM(append([]*x509.Certificate{serverCert}, serverChain...)), mode: 0o644},
+		{path: filepath.Join("certs", freeRADIUSCertDir, "server.key"), content: serverKey, mode: 0o640},
+		{path: filepath.Join("certs", freeRADIUSCertDir, "ca.pem"), content: caFile, mode: 0o644},
+		{path: filepath.Join("mods-available", "eap"), template: freeRADIUSEAPTemplate, mode: 0o640},
+		{path: filepath.Join("sites-available", "check-eap-tls"), template: freeRADIUSCheckEAPTLSTemplate, mode: 0o640},
+		{path: filepath.Join("policy.d", "cert-helper"), template: freeRADIUSPolicyTemplate, mode: 0o640},
+	}
+
+	var written []string
+	for _, file := range files {
+		content := file.content
+		if file.template != "" {
+			content, err = renderFreeRADIUSTemplate(file.template, data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		target := filepath.Join(options.Destination, file.path)
+		if err := ensureParentDir(target); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(target, content, file.mode); err != nil {
+			return nil, err
+		}
+		written = append(written, target)
+	}
+	return written, nil
+}
+
+func renderFreeRADIUSTemplate(text string, data freeRADIUSTemplateData) ([]byte, error) {
+	tmpl, err := template.New("freeradius").Funcs(template.FuncMap{
+		"quote": freeRADIUSQuote,
+	}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// freeRADIUSQuote escapes a value for a double-quoted unlang string.
+func freeRADIUSQuote(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+func loadCertificates(paths []string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, certPath := range paths {
+		cert, err := LoadCertificate(certPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", certPath, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+		return true
+	}
+	for _, eku := range cert.ExtKeyUsage {
+		if eku == usage || eku == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+var openSSLShortNames = map[string]string{
+	"2.5.4.3":                    "CN",
+	"2.5.4.5":                    "serialNumber",
+	"2.5.4.6":                    "C",
+	"2.5.4.7":                    "L",
+	"2.5.4.8":                    "ST",
+	"2.5.4.9":                    "street",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"0.9.2342.19200300.100.1.25": "DC",
+	"1.2.840.113549.1.9.1":       "emailAddress",
+}
+
+// OpenSSLOneLine formats a name like OpenSSL's X509_NAME_oneline, which is how FreeRADIUS
+// exposes TLS-Client-Cert-Issuer and compares check_cert_issuer.
+func OpenSSLOneLine(name pkix.Name) string {
+	var b strings.Builder
+	for _, rdn := range name.ToRDNSequence() {
+		for _, atv := range rdn {
+			key, ok := openSSLShortNames[atv.Type.String()]
+			if !ok {
+				key = atv.Type.String()
+			}
+			b.WriteString("/")
+			b.WriteString(key)
+			b.WriteString("=")
+			b.WriteString(attributeValueString(atv.Value))
+		}
+	}
+	return b.String()
+}
+
+func attributeValueString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case asn1.RawValue:
+		return string(v.Bytes)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+const freeRADIUSEAPTemplate = `# Generated by cert-helper for EAP-TLS testing.
+# Enable with: ln -s ../mods-available/eap mods-enabled/eap
+eap {
+	default_eap_type = tls
+	timer_expire = 60
+	ignore_unknown_eap_types = no
+	cisco_accounting_username_bug = no
+	max_sessions = ${max_requests}
+
+	tls-config tls-common {
+		private_key_file = ${certdir}/{{.CertDir}}/server.key
+		certificate_file = ${certdir}/{{.CertDir}}/server.pem
+		ca_file = ${certdir}/{{.CertDir}}/ca.pem
+
+		# Client certificates must chain to the CAs in ca_file.
+		auto_chain = no
+		{{- if .CheckCertIssuer}}
+		check_cert_issuer = {{quote .CheckCertIssuer}}
+		{{- end}}
+
+		cipher_list = "DEFAULT"
+		cipher_server_preference = no
+		tls_min_version = "{{.TLSMinVersion}}"
+		tls_max_version = "1.3"
+		ecdh_curve = "prime256v1"
+
+		{{- if .CheckCRL}}
+		# The CRL is appended to ca_file.
+		check_crl = yes
+		{{- if .CheckAllCRL}}
+		check_all_crl = yes
+		{{- else}}
+		# Only the issuing CA has a CRL in ca_file; requiring one for every CA of the chain would
+		# reject all clients.
+		check_all_crl = no
+		{{- end}}
+		{{- else}}
+		check_crl = no
+		{{- end}}
+
+		cache {
+			enable = no
+		}
+
+		verify {
+		}
+
+		ocsp {
+			{{- if .OCSPEnabled}}
+			enable = yes
+			override_cert_url = yes
+			url = {{quote .OCSPURL}}
+			softfail = no
+			{{- else}}
+			enable = no
+			{{- end}}
+		}
+	}
+
+	tls {
+		tls = tls-common
+		virtual_server = check-eap-tls
+	}
+}
+`
+
+const freeRADIUSCheckEAPTLSTemplate = `# Generated by cert-helper. Runs after the client certificate chain has been verified.
+# Enable with: ln -s ../sites-available/check-eap-tls sites-enabled/check-eap-tls
+server check-eap-tls {
+	authorize {
+		cert_helper_check_client_cert
+	}
+}
+`
+
+const freeRADIUSPolicyTemplate = `# Generated by cert-helper. Mirrors the certificate profile issued for EAP-TLS clients
+# (server {{.ServerCommonName}}).
+cert_helper_check_client_cert {
+	# EAP-TLS client certificates need the clientAuth extended key usage.
+	if (!(&TLS-Client-Cert-X509v3-Extended-Key-Usage =~ /TLS Web Client Authentication/)) {
+		update reply {
+			&Reply-Message := "Client certificate lacks the clientAuth EKU"
+		}
+		update config {
+			&Auth-Type := Reject
+		}
+	}
+	{{- if .ClientIssuers}}
+	elsif (!({{range $i, $issuer := .ClientIssuers}}{{if $i}} || {{end}}&TLS-Client-Cert-Issuer == {{quote $issuer}}{{end}})) {
+		update reply {
+			&Reply-Message := "Client certificate issued by an unexpected CA: %{TLS-Client-Cert-Issuer}"
+		}
+		update config {
+			&Auth-Type := Reject
+		}
+	}
+	{{- end}}
+	else {
+		update config {
+			&Auth-Type := Accept
+		}
+	}
+}
+`
@@ -0,0 +1,275 @@
+package internal
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Certificate changes reported by CertificateIndex.Refresh.
+const (
+	ChangeIssued   = "issued"
+	ChangeRenewed  = "renewed"
+	ChangeRemoved  = "removed"
+	ChangeRevoked  = "revoked"
+	ChangeExpiring = "expiring"
+	ChangeExpired  = "expired"
+)
+
+// IndexedCertificate is a certificate of a CertificateIndex with its state at the last refresh.
+type IndexedCertificate struct {
+	CertificateFile
+	// Name is the subject common name, or the file name when it has none.
+	Name string
+	// Status is the expiry state returned by ExpiryStatus.
+	Status  string
+	Revoked bool
+}
+
+// CertificateChange describes what happened to one certificate between two refreshes.
+type CertificateChange struct {
+	Action string
+	Path   string
+	Name   string
+	// Source is the inventory source of the certificate, such as InventorySourceSCEP. It is empty
+	// for certificates issued from the dashboard, the API or the CLI.
+	Source string
+}
+
+// IndexUpdate is the outcome of a refresh that found changes.
+type IndexUpdate struct {
+	Certificates []CertificateChange
+	// FilesChanged is set when any file was added, modified or removed, certificate or not.
+	FilesChanged bool
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// CertificateIndex keeps the certificates of an output directory parsed in memory. A refresh only
+// stats the files and parses the ones whose size or modification time changed, so readers can
+// refresh on every request and still see changes made by other processes right away.
+type CertificateIndex struct {
+	outputDir string
+	notify    func(IndexUpdate)
+
+	mu           sync.Mutex
+	scanned      bool
+	files        map[string]fileStamp
+	certificates map[string]IndexedCertificate
+	revocations  RevocationSet
+	inventory    map[string]InventoryRecord
+}
+
+// NewCertificateIndex returns an empty index of outputDir. notify, when not nil, is called with
+// the changes found by every refresh after the first, which only loads the initial state.
+func NewCertificateIndex(outputDir string, notify func(IndexUpdate)) *CertificateIndex {
+	return &CertificateIndex{outputDir: outputDir, notify: notify}
+}
+
+// Refresh brings the index up to date with the output directory.
+func (x *CertificateIndex) Refresh(now time.Time) error {
+	x.mu.Lock()
+	update, err := x.refresh(now)
+	x.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if x.notify != nil && (update.FilesChanged || len(update.Certificates) > 0) {
+		x.notify(update)
+	}
+	return nil
+}
+
+// IndexSnapshot is the content of a CertificateIndex after a refresh.
+type IndexSnapshot struct {
+	// Certificates are ordered by path.
+	Certificates []IndexedCertificate
+	// Inventory holds the inventory records, which are only read again when the inventory file
+	// changes. The map is shared between snapshots and must not be modified.
+	Inventory map[string]InventoryRecord
+}
+
+// Snapshot refreshes the index and returns its content.
+func (x *CertificateIndex) Snapshot() (IndexSnapshot, error) {
+	if err := x.Refresh(time.Now()); err != nil {
+		return IndexSnapshot{}, err
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	snapshot := IndexSnapshot{
+		Certificates: make([]IndexedCertificate, 0, len(x.certificates)),
+		Inventory:    x.inventory,
+	}
+	for _, certificate := range x.certificates {
+		snapshot.Certificates = append(snapshot.Certificates, certificate)
+	}
+	sort.Slice(snapshot.Certificates, func(i, j int) bool {
+		return snapshot.Certificates[i].Path < snapshot.Certificates[j].Path
+	})
+	return snapshot, nil
+}
+
+// Certificate returns the certificate at relPath, a slash-separated path relative to the output
+// directory.
+func (s IndexSnapshot) Certificate(relPath string) (IndexedCertificate, bool) {
+	i := sort.Search(len(s.Certificates), func(i int) bool { return s.Certificates[i].Path >= relPath })
+	if i < len(s.Certificates) && s.Certificates[i].Path == relPath {
+		return s.Certificates[i], true
+	}
+	return IndexedCertificate{}, false
+}
+
+// Tree arranges the certificates of the snapshot like BuildCertificateTree.
+func (s IndexSnapshot) Tree(now time.Time) CertificateTree {
+	files := make([]CertificateFile, len(s.Certificates))
+	for i, certificate := range s.Certificates {
+		files[i] = certificate.CertificateFile
+	}
+	return buildCertificateTree(files, now)
+}
+
+func (x *CertificateIndex) refresh(now time.Time) (IndexUpdate, error) {
+	files := make(map[string]fileStamp)
+	err := filepath.WalkDir(x.outputDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(x.outputDir, filePath)
+		if err != nil {
+			return nil
+		}
+		// The audit log grows with every download; it is not a file the dashboard lists.
+		if IsAuthStore(relPath) || filePath == AuditLogPath(x.outputDir) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files[filepath.ToSlash(relPath)] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	if err != nil {
+		return IndexUpdate{}, err
+	}
+
+	initial := !x.scanned
+	changed := make(map[string]bool)
+	for relPath, stamp := range files {
+		if previous, ok := x.files[relPath]; !ok || previous != stamp {
+			changed[relPath] = true
+		}
+	}
+	for relPath := range x.files {
+		if _, ok := files[relPath]; !ok {
+			changed[relPath] = true
+		}
+	}
+	update := IndexUpdate{FilesChanged: len(changed) > 0}
+	revocationsChanged := initial
+	for relPath := range changed {
+		// A new CA certificate may be the one that signed an existing CRL.
+		if previous, ok := x.certificates[relPath]; strings.HasSuffix(relPath, ".crl") || ok && previous.Certificate.IsCA {
+			revocationsChanged = true
+		}
+	}
+
+	// Exports are recorded before they are written, so the inventory is read first.
+	if initial || changed[inventoryFile] {
+		inventory, err := LoadInventory(x.outputDir)
+		if err != nil {
+			return IndexUpdate{}, err
+		}
+		x.inventory = inventory
+	}
+
+	certificates := make(map[string]IndexedCertificate, len(x.certificates))
+	for relPath := range files {
+		if x.inventory[relPath].Kind == InventoryKindExport {
+			continue
+		}
+		previous, known := x.certificates[relPath]
+		if !changed[relPath] {
+			if known {
+				certificates[relPath] = previous
+			}
+			continue
+		}
+		file, ok := LoadCertificateFile(x.outputDir, filepath.Join(x.outputDir, filepath.FromSlash(relPath)))
+		if !ok {
+			if known {
+				// Most likely caught in the middle of a write; the next refresh sees the new content.
+				certificates[relPath] = previous
+			}
+			continue
+		}
+		if file.Certificate.IsCA {
+			revocationsChanged = true
+		}
+		name := file.Certificate.Subject.CommonName
+		if name == "" {
+			name = filepath.Base(relPath)
+		}
+		certificates[relPath] = IndexedCertificate{CertificateFile: file, Name: name, Status: previous.Status, Revoked: previous.Revoked}
+	}
+
+	if revocationsChanged {
+		revocations, err := LoadRevocationSet(x.outputDir)
+		if err != nil {
+			return IndexUpdate{}, err
+		}
+		x.revocations = revocations
+	}
+
+	for relPath, current := range certificates {
+		previous, known := x.certificates[relPath]
+		current.Status = ExpiryStatus(current.Certificate.NotAfter, now)
+		current.Revoked = x.revocations.Contains(current.Certificate)
+		certificates[relPath] = current
+		if initial {
+			continue
+		}
+
+		change := CertificateChange{Path: relPath, Name: current.Name, Source: x.inventory[relPath].Source}
+		switch {
+		case !known:
+			change.Action = ChangeIssued
+		case !current.Certificate.Equal(previous.Certificate):
+			change.Action = ChangeRenewed
+		case current.Revoked && !previous.Revoked:
+			change.Action = ChangeRevoked
+		case current.Status != previous.Status && current.Status == ExpiryExpiring:
+			change.Action = ChangeExpiring
+		case current.Status != previous.Status && current.Status == ExpiryExpired:
+			change.Action = ChangeExpired
+		default:
+			continue
+		}
+		update.Certificates = append(update.Certificates, change)
+	}
+	for relPath, previous := range x.certificates {
+		if _, ok := certificates[relPath]; !ok && !initial {
+			update.Certificates = append(update.Certificates, CertificateChange{Action: ChangeRemoved, Path: relPath, Name: previous.Name})
+		}
+	}
+	sort.Slice(update.Certificates, func(i, j int) bool {
+		return update.Certificates[i].Path < update.Certificates[j].Path
+	})
+
+	x.files = files
+	x.certificates = certificates
+	x.scanned = true
+	if initial {
+		return IndexUpdate{}, nil
+	}
+	return update, nil
+}
@@ -0,0 +1,299 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CertificateDetails is the decoded content of a certificate, ready for display.
+type CertificateDetails struct {
+	Version            int
+	Serial             string
+	Subject            string
+	Issuer             string
+	NotBefore          time.Time
+	NotAfter           time.Time
+	SignatureAlgorithm string
+	PublicKey          string
+	IsCA               bool
+	SubjectAltNames    []CertificateName
+	Extensions         []CertificateExtension
+	SHA256Fingerprint  string
+	SHA1Fingerprint    string
+	PEM                string
+}
+
+// CertificateName is a subject alternative name with its type (DNS, IP, Email or URI).
+type CertificateName struct {
+	Type  string
+	Value string
+}
+
+// CertificateExtension is an X.509 extension with its decoded values. Extensions without a
+// decoder show their raw value in hex.
+type CertificateExtension struct {
+	Name     string
+	OID      string
+	Critical bool
+	Values   []string
+}
+
+// ChainValidation is the result of verifying a certificate against the CAs of the output directory.
+type ChainValidation struct {
+	// Chain starts with the certificate itself and ends with the furthest issuer found.
+	Chain []ChainLink
+	// Err is nil when the chain verifies up to a root of the output directory at the current time.
+	Err error
+}
+
+// ChainLink is one certificate of a chain and what, if anything, is wrong with it on its own.
+type ChainLink struct {
+	Certificate *x509.Certificate
+	Problem     string
+}
+
+var extensionNames = map[string]string{
+	"2.5.29.14":          "Subject Key Identifier",
+	"2.5.29.15":          "Key Usage",
+	"2.5.29.17":          "Subject Alternative Name",
+	"2.5.29.19":          "Basic Constraints",
+	"2.5.29.30":          "Name Constraints",
+	"2.5.29.31":          "CRL Distribution Points",
+	"2.5.29.32":          "Certificate Policies",
+	"2.5.29.35":          "Authority Key Identifier",
+	"2.5.29.37":          "Extended Key Usage",
+	"1.3.6.1.5.5.7.1.1":  "Authority Information Access",
+	"1.3.6.1.5.5.7.1.31": "ACME Identifier",
+}
+
+var keyUsageLabels = []struct {
+	usage x509.KeyUsage
+	label string
+}{
+	{x509.KeyUsageDigitalSignature, "Digital Signature"},
+	{x509.KeyUsageContentCommitment, "Content Commitment"},
+	{x509.KeyUsageKeyEncipherment, "Key Encipherment"},
+	{x509.KeyUsageDataEncipherment, "Data Encipherment"},
+	{x509.KeyUsageKeyAgreement, "Key Agreement"},
+	{x509.KeyUsageCertSign, "Certificate Sign"},
+	{x509.KeyUsageCRLSign, "CRL Sign"},
+	{x509.KeyUsageEncipherOnly, "Encipher Only"},
+	{x509.KeyUsageDecipherOnly, "Decipher Only"},
+}
+
+// DescribeCertificate decodes cert for display.
+func DescribeCertificate(cert *x509.Certificate) CertificateDetails {
+	sha1Sum := sha1.Sum(cert.Raw)
+	details := CertificateDetails{
+		Version:            cert.Version,
+		Serial:             formatHexBytes(cert.SerialNumber.Bytes()),
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		PublicKey:          DescribePublicKey(cert),
+		IsCA:               cert.IsCA,
+		SHA256Fingerprint:  CertificateFingerprint(cert),
+		SHA1Fingerprint:    formatHexBytes(sha1Sum[:]),
+		PEM:                string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+		SubjectAltNames:    subjectAltNames(cert),
+	}
+	for _, extension := range cert.Extensions {
+		oid := extension.Id.String()
+		name := extensionNames[oid]
+		if name == "" {
+			name = "Unknown extension"
+		}
+		details.Extensions = append(details.Extensions, CertificateExtension{
+			Name:     name,
+			OID:      oid,
+			Critical: extension.Critical,
+			Values:   describeExtension(cert, oid, extension.Value),
+		})
+	}
+	return details
+}
+
+func subjectAltNames(cert *x509.Certificate) []CertificateName {
+	var names []CertificateName
+	for _, name := range cert.DNSNames {
+		names = append(names, CertificateName{Type: "DNS", Value: name})
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, CertificateName{Type: "IP", Value: ip.String()})
+	}
+	for _, email := range cert.EmailAddresses {
+		names = append(names, CertificateName{Type: "Email", Value: email})
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, CertificateName{Type: "URI", Value: uri.String()})
+	}
+	return names
+}
+
+// DescribePublicKey returns the algorithm and size of the public key of cert, such as "RSA 2048 bits".
+func DescribePublicKey(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d bits", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA %s", key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return cert.PublicKeyAlgorithm.String()
+	}
+}
+
+func describeExtension(cert *x509.Certificate, oid string, value []byte) []string {
+	switch oid {
+	case "2.5.29.14":
+		return []string{formatHexBytes(cert.SubjectKeyId)}
+	case "2.5.29.35":
+		return []string{"Key ID " + formatHexBytes(cert.AuthorityKeyId)}
+	case "2.5.29.15":
+		var labels []string
+		for _, entry := range keyUsageLabels {
+			if cert.KeyUsage&entry.usage != 0 {
+				labels = append(labels, entry.label)
+			}
+		}
+		return labels
+	case "2.5.29.37":
+		return ExtKeyUsageNames(cert)
+	case "2.5.29.19":
+		if !cert.IsCA {
+			return []string{"CA: false"}
+		}
+		switch {
+		case cert.MaxPathLen > 0 || cert.MaxPathLenZero:
+			return []string{"CA: true", fmt.Sprintf("Path length: %d", cert.MaxPathLen)}
+		default:
+			return []string{"CA: true", "Path length: unlimited"}
+		}
+	case "2.5.29.17":
+		var names []string
+		for _, name := range subjectAltNames(cert) {
+			names = append(names, name.Type+": "+name.Value)
+		}
+		return names
+	case "2.5.29.31":
+		return cert.CRLDistributionPoints
+	case "1.3.6.1.5.5.7.1.1":
+		var values []string
+		for _, server := range cert.OCSPServer {
+			values = append(values, "OCSP: "+server)
+		}
+		for _, issuer := range cert.IssuingCertificateURL {
+			values = append(values, "CA Issuers: "+issuer)
+		}
+		return values
+	case "2.5.29.32":
+		var values []string
+		for _, policy := range cert.Policies {
+			values = append(values, policy.String())
+		}
+		return values
+	case "2.5.29.30":
+		var values []string
+		for _, name := range cert.PermittedDNSDomains {
+			values = append(values, "Permitted DNS: "+name)
+		}
+		for _, name := range cert.ExcludedDNSDomains {
+			values = append(values, "Excluded DNS: "+name)
+		}
+		for _, network := range cert.PermittedIPRanges {
+			values = append(values, "Permitted IP: "+network.String())
+		}
+		for _, network := range cert.ExcludedIPRanges {
+			values = append(values, "Excluded IP: "+network.String())
+		}
+		return values
+	}
+	const maxRawBytes = 64
+	if len(value) > maxRawBytes {
+		return []string{formatHexBytes(value[:maxRawBytes]) + fmt.Sprintf(" ... (%d bytes)", len(value))}
+	}
+	return []string{formatHexBytes(value)}
+}
+
+// ValidateChain builds the chain of cert from the CAs of the output directory and verifies it
+// at the current time, including the CRLs of every issuer.
+func ValidateChain(outputDir string, cert *x509.Certificate) ChainValidation {
+	now := time.Now()
+	result := ChainValidation{Chain: []ChainLink{{Certificate: cert, Problem: certificateProblem(outputDir, cert, now)}}}
+	issuers, chainErr := BuildChain(outputDir, cert)
+	for _, issuer := range issuers {
+		result.Chain = append(result.Chain, ChainLink{Certificate: issuer, Problem: certificateProblem(outputDir, issuer, now)})
+	}
+	if chainErr != nil {
+		result.Err = chainErr
+		return result
+	}
+
+	// A self-signed certificate only anchors the chain when it is a root CA of the output directory.
+	last := result.Chain[len(result.Chain)-1].Certificate
+	trusted, err := RootCertificates(outputDir)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if !slices.ContainsFunc(trusted, last.Equal) {
+		result.Err = fmt.Errorf("%q is self-signed but not a root CA of the output directory", last.Subject.String())
+		return result
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(last)
+	intermediates := x509.NewCertPool()
+	for _, issuer := range issuers {
+		intermediates.AddCert(issuer)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		result.Err = err
+		return result
+	}
+	for _, link := range result.Chain {
+		if link.Problem != "" {
+			result.Err = fmt.Errorf("%s: %s", link.Certificate.Subject.String(), link.Problem)
+			break
+		}
+	}
+	return result
+}
+
+func certificateProblem(outputDir string, cert *x509.Certificate, now time.Time) string {
+	switch {
+	case now.After(cert.NotAfter):
+		return "expired on " + cert.NotAfter.Format("2006-01-02")
+	case now.Before(cert.NotBefore):
+		return "not valid before " + cert.NotBefore.Format("2006-01-02")
+	case !isSelfSigned(cert) && IsCertificateRevoked(outputDir, cert):
+		return "revoked"
+	}
+	return ""
+}
+
+// formatHexBytes returns data as uppercase colon-separated hex bytes.
+func formatHexBytes(data []byte) string {
+	encoded := strings.ToUpper(hex.EncodeToString(data))
+	parts := make([]string, 0, len(data))
+	for i := 0; i < len(encoded); i += 2 {
+		parts = append(parts, encoded[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,268 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	DefectExpired              = "expired"
+	DefectNotYetValid          = "not-yet-valid"
+	DefectWrongEKU             = "wrong-eku"
+	DefectMissingKeyUsage      = "missing-key-usage"
+	DefectWrongIssuerSignature = "wrong-issuer-signature"
+	DefectUntrustedRoot        = "untrusted-root"
+	DefectRevoked              = "revoked"
+	DefectCALeaf               = "ca-leaf"
+	DefectSANMismatch          = "san-mismatch"
+	DefectWeakRSA              = "weak-rsa-1024"
+	DefectSHA1Signature        = "sha1-signature"
+)
+
+// CertificateDefects lists the flaws GenerateInvalidCertificate can produce.
+var CertificateDefects = []string{
+	DefectExpired,
+	DefectNotYetValid,
+	DefectWrongEKU,
+	DefectMissingKeyUsage,
+	DefectWrongIssuerSignature,
+	DefectUntrustedRoot,
+	DefectRevoked,
+	DefectCALeaf,
+	DefectSANMismatch,
+	DefectWeakRSA,
+	DefectSHA1Signature,
+}
+
+var certificateDefectDescriptions = map[string]string{
+	DefectExpired:              "validity ended yesterday",
+	DefectNotYetValid:          "validity starts in 30 days",
+	DefectWrongEKU:             "emailProtection EKU only, no clientAuth/serverAuth",
+	DefectMissingKeyUsage:      "no KeyUsage extension at all",
+	DefectWrongIssuerSignature: "names the selected CA as issuer but is signed by another key",
+	DefectUntrustedRoot:        "issued by a throwaway root that is not in the output directory",
+	DefectRevoked:              "listed in the issuer CRL",
+	DefectCALeaf:               "end-entity certificate with CA:TRUE",
+	DefectSANMismatch:          "SAN does not contain the common name",
+	DefectWeakRSA:              "1024-bit RSA key",
+	DefectSHA1Signature:        "signed with sha1WithRSAEncryption",
+}
+
+func CertificateDefectDescription(defect string) string {
+	return certificateDefectDescriptions[defect]
+}
+
+func NormalizeCertificateDefect(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if _, ok := certificateDefectDescriptions[value]; ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown defect %q (supported: %s)", value, strings.Join(CertificateDefects, ", "))
+}
+
+type InvalidCertificateOptions struct {
+	Defect          string
+	IssuerType      string
+	RootName        string
+	IssuerName      string
+	Subject         Subject
+	SubjectAltNames []string
+	ValidityDays    int
+	PFXPassword     string
+	PFXEncoding     string
+}
+
+type InvalidCertificateResult struct {
+	Defect   string
+	CertPath string
+	KeyPath  string
+	PFXPath  string
+	// CRLPath is the updated issuer CRL for revoked certificates.
+	CRLPath string
+}
+
+// GenerateInvalidCertificate issues a certificate with one deliberate defect from the selected
+// CA, so relying parties can be checked for rejecting it. The defect is recorded in the inventory.
+// The common name defaults to "invalid-<defect>".
+func GenerateInvalidCertificate(outputDir string, options InvalidCertificateOptions) (InvalidCertificateResult, error) {
+	defect, err := NormalizeCertificateDefect(options.Defect)
+	if err != nil {
+		return InvalidCertificateResult{}, err
+	}
+	pfxEncoding, err := NormalizePFXEncoding(options.PFXEncoding)
+	if err != nil {
+		return InvalidCertificateResult{}, err
+	}
+	if pfxEncoding == PFXEncodingPasswordless && options.PFXPassword != "" {
+		return InvalidCertificateResult{}, fmt.Errorf("passwordless PKCS#12 bundles cannot use a password")
+	}
+	subject := options.Subject
+	if subject.CommonName == "" {
+		subject.CommonName = "invalid-" + defect
+	}
+	validity := time.Duration(options.ValidityDays) * 24 * time.Hour
+	if validity <= 0 {
+		validity = 365 * 24 * time.Hour
+	}
+
+	issuer, err := loadIssuer(outputDir, options.IssuerType, options.RootName, options.IssuerName)
+	if err != nil {
+		return InvalidCertificateResult{}, err
+	}
+
+	keyBits := DefaultKeyBits
+	if defect == DefectWeakRSA {
+		keyBits = 1024
+	}
+	privateKey, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return InvalidCertificateResult{}, err
+	}
+
+	sans, err := normalizeSANs(append([]string{subject.CommonName}, options.SubjectAltNames...))
+	if err != nil {
+		return InvalidCertificateResult{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		Subject:               subject.PKIXName(),
+		SerialNumber:          GenerateSerialNumber(),
+		NotBefore:             now.Add(-24 * time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           DefaultExtKeyUsage(),
+		BasicConstraintsValid: true,
+	}
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, san)
+		}
+	}
+
+	parent := issuer.cert
+	var signer crypto.Signer = issuer.key
+	chain := []*x509.Certificate{issuer.cert}
+	if issuers, err := BuildChain(outputDir, issuer.cert); err == nil {
+		chain = append(chain, issuers...)
+	}
+
+	switch defect {
+	case DefectExpired:
+		template.NotAfter = now.Add(-24 * time.Hour)
+		template.NotBefore = template.NotAfter.Add(-validity)
+	case DefectNotYetValid:
+		template.NotBefore = now.Add(30 * 24 * time.Hour)
+		template.NotAfter = template.NotBefore.Add(validity)
+	case DefectWrongEKU:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}
+	case DefectMissingKeyUsage:
+		template.KeyUsage = 0
+	case DefectWrongIssuerSignature:
+		// Keep the issuer's name and key identifier but sign with an unrelated key.
+		rogueKey, err := rsa.GenerateKey(rand.Reader, DefaultKeyBits)
+		if err != nil {
+			return InvalidCertificateResult{}, err
+		}
+		impostor := *issuer.cert
+		impostor.PublicKey = &rogueKey.PublicKey
+		parent, signer = &impostor, rogueKey
+	case DefectUntrustedRoot:
+		rogueRoot, rogueKey, err := createThrowawayRoot()
+		if err != nil {
+			return InvalidCertificateResult{}, err
+		}
+		parent, signer = rogueRoot, rogueKey
+		chain = []*x509.Certificate{rogueRoot}
+	case DefectCALeaf:
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	case DefectSANMismatch:
+		template.DNSNames = []string{"mismatch-" + strings.ToLower(NormalizeName(subject.CommonName, "certificate")) + ".invalid"}
+		template.IPAddresses = nil
+	case DefectSHA1Signature:
+		template.SignatureAlgorithm = x509.SHA1WithRSA
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, parent, &privateKey.PublicKey, signer)
+	if err != nil {
+		return InvalidCertificateResult{}, err
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return InvalidCertificateResult{}, err
+	}
+
+	if err := os.MkdirAll(issuer.certDir, 0o700); err != nil {
+		return InvalidCertificateResult{}, err
+	}
+	safeCommonName := NormalizeName(subject.CommonName, "certificate")
+	result := InvalidCertificateResult{
+		Defect:   defect,
+		CertPath: filepath.Join(issuer.certDir, fmt.Sprintf("cert_%s.pem", safeCommonName)),
+		KeyPath:  filepath.Join(issuer.certDir, fmt.Sprintf("cert_%s.key", safeCommonName)),
+		PFXPath:  filepath.Join(issuer.certDir, fmt.Sprintf("cert_%s.pfx", safeCommonName)),
+	}
+	if err := WriteCertificatePEM(result.CertPath, certDER); err != nil {
+		return InvalidCertificateResult{}, err
+	}
+	if err := WritePrivateKeyPEM(result.KeyPath, privateKey); err != nil {
+		return InvalidCertificateResult{}, err
+	}
+	if err := WritePFX(result.PFXPath, privateKey, cert, chain, options.PFXPassword, pfxEncoding); err != nil {
+		return InvalidCertificateResult{}, err
+	}
+
+	if defect == DefectRevoked {
+		result.CRLPath, err = revokeCertificate(outputDir, issuer, cert, 0)
+		if err != nil {
+			return InvalidCertificateResult{}, err
+		}
+	}
+
+	records := []InventoryRecord{
+		{Path: result.CertPath, Kind: InventoryKindCertificate, Defect: defect},
+		{Path: result.PFXPath, Kind: InventoryKindPFX, PFXEncoding: pfxEncoding, Defect: defect},
+	}
+	for _, record := range records {
+		if err := RecordInventory(outputDir, record); err != nil {
+			return InvalidCertificateResult{}, err
+		}
+	}
+	return result, nil
+}
+
+func createThrowawayRoot() (*x509.Certificate, *rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, DefaultKeyBits)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "cert-helper Untrusted Root", Organization: []string{"cert-helper"}},
+		SerialNumber:          GenerateSerialNumber(),
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              DefaultCAKeyUsage,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	inventoryFile            = "inventory.json"
+	InventoryKindPFX         = "pfx"
+	InventoryKindCertificate = "certificate"
+	InventoryKindCRL         = "crl"
+	// InventoryKindExport marks certificate bundles and profiles written by an export, which are
+	// copies rather than issued certificates.
+	InventoryKindExport = "export"
+)
+
+// InventoryRecord holds metadata about a file in the output directory that cannot be
+// derived from the file itself.
+type InventoryRecord struct {
+	Path        string    `json:"path"`
+	Kind        string    `json:"kind"`
+	PFXEncoding string    `json:"pfx_encoding,omitempty"`
+	Defect      string    `json:"defect,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	Serial      string    `json:"serial,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+var inventoryMutex sync.Mutex
+
+// RecordInventory stores or replaces the record for a file. Record paths are kept relative
+// to the output directory so that the directory can be moved.
+func RecordInventory(outputDir string, record InventoryRecord) error {
+	inventoryMutex.Lock()
+	defer inventoryMutex.Unlock()
+
+	relPath, err := inventoryKey(outputDir, record.Path)
+	if err != nil {
+		return err
+	}
+	records, err := readInventory(outputDir)
+	if err != nil {
+		return err
+	}
+	record.Path = relPath
+	if record.UpdatedAt.IsZero() {
+		record.UpdatedAt = time.Now().UTC()
+	}
+	records[relPath] = record
+	return writeInventory(outputDir, records)
+}
+
+// RemoveInventory drops the record for a file, for example when it is regenerated without the
+// metadata that was recorded for the previous version.
+func RemoveInventory(outputDir, path string) error {
+	inventoryMutex.Lock()
+	defer inventoryMutex.Unlock()
+
+	relPath, err := inventoryKey(outputDir, path)
+	if err != nil {
+		return err
+	}
+	records, err := readInventory(outputDir)
+	if err != nil {
+		return err
+	}
+	if _, ok := records[relPath]; !ok {
+		return nil
+	}
+	delete(records, relPath)
+	return writeInventory(outputDir, records)
+}
+
+// LoadInventory returns all inventory records keyed by their slash-separated path relative to
+// the output directory.
+func LoadInventory(outputDir string) (map[string]InventoryRecord, error) {
+	inventoryMutex.Lock()
+	defer inventoryMutex.Unlock()
+	return readInventory(outputDir)
+}
+
+func inventoryKey(outputDir, path string) (string, error) {
+	absBase, err := filepath.Abs(outputDir)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	relPath, err := filepath.Rel(absBase, absPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(relPath), nil
+}
+
+func readInventory(outputDir string) (map[string]InventoryRecord, error) {
+	records := map[string]InventoryRecord{}
+	data, err := os.ReadFile(filepath.Join(outputDir, inventoryFile))
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []InventoryRecord
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, record := range list {
+		records[record.Path] = record
+	}
+	return records, nil
+}
+
+func writeInventory(outputDir string, records map[string]InventoryRecord) error {
+	list := make([]InventoryRecord, 0, len(records))
+	for _, record := range records {
+		list = append(list, record)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Path < list[j].Path
+	})
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(outputDir, inventoryFile+".tmp")
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(outputDir, inventoryFile))
+}
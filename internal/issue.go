@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Inventory sources of certificates issued for CSRs received by the enrollment servers.
+const (
+	InventorySourceACME = "acme"
+	InventorySourceEST  = "est"
+	InventorySourceSCEP = "scep"
+)
+
+// ErrAlreadyRevoked is returned by CSRIssuer.Revoke for certificates already on the CRL.
+var ErrAlreadyRevoked = errors.New("certificate is already revoked")
+
+// CSRIssuer signs certificate requests received by the enrollment servers with a CA from the
+// output directory and stores the results in the same layout as GenerateCertificateWithOptions.
+type CSRIssuer struct {
+	outputDir string
+	issuer    issuerMaterial
+	chain     []*x509.Certificate
+	mutex     sync.Mutex
+}
+
+// CSRIssueRequest describes a certificate to issue for a CSR. Empty fields are taken from the
+// CSR; the validity defaults to ValidityDays and is capped at the issuer's expiry.
+type CSRIssueRequest struct {
+	CSR          *x509.CertificateRequest
+	Subject      *pkix.Name
+	DNSNames     []string
+	IPAddresses  []net.IP
+	NotBefore    time.Time
+	NotAfter     time.Time
+	ValidityDays int
+	ExtKeyUsage  []x509.ExtKeyUsage
+	Source       string
+}
+
+type IssuedCertificate struct {
+	Certificate *x509.Certificate
+	Path        string
+}
+
+func NewCSRIssuer(outputDir, issuerType, rootName, issuerName string) (*CSRIssuer, error) {
+	issuer, err := loadIssuer(outputDir, issuerType, rootName, issuerName)
+	if err != nil {
+		return nil, err
+	}
+	return &CSRIssuer{outputDir: outputDir, issuer: issuer, chain: issuerChain(outputDir, issuer)}, nil
+}
+
+// Certificate returns the issuing CA certificate.
+func (i *CSRIssuer) Certificate() *x509.Certificate {
+	return i.issuer.cert
+}
+
+// Chain returns the issuing CA followed by its parents up to the root.
+func (i *CSRIssuer) Chain() []*x509.Certificate {
+	return i.chain
+}
+
+func (i *CSRIssuer) Issue(request CSRIssueRequest) (IssuedCertificate, error) {
+	csr := request.CSR
+	if csr == nil {
+		return IssuedCertificate{}, fmt.Errorf("certificate request is required")
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return IssuedCertificate{}, fmt.Errorf("invalid CSR signature: %w", err)
+	}
+
+	subject := csr.Subject
+	if request.Subject != nil {
+		subject = *request.Subject
+	}
+	dnsNames, ipAddresses := csr.DNSNames, csr.IPAddresses
+	if request.DNSNames != nil || request.IPAddresses != nil {
+		dnsNames, ipAddresses = request.DNSNames, request.IPAddresses
+	}
+	if subject.CommonName == "" && len(dnsNames) == 0 && len(ipAddresses) == 0 {
+		return IssuedCertificate{}, fmt.Errorf("CSR has neither a common name nor subject alternative names")
+	}
+
+	now := time.Now()
+	notBefore, notAfter := request.NotBefore, request.NotAfter
+	if notBefore.IsZero() {
+		notBefore = now.Add(-time.Hour)
+	}
+	if notAfter.IsZero() {
+		validityDays := request.ValidityDays
+		if validityDays <= 0 {
+			validityDays = 365
+		}
+		notAfter = now.Add(time.Duration(validityDays) * 24 * time.Hour)
+	}
+	if notAfter.After(i.issuer.cert.NotAfter) {
+		notAfter = i.issuer.cert.NotAfter
+	}
+	if !notAfter.After(notBefore) {
+		return IssuedCertificate{}, fmt.Errorf("requested validity ends before it starts")
+	}
+
+	extKeyUsage := request.ExtKeyUsage
+	if len(extKeyUsage) == 0 {
+		extKeyUsage = DefaultExtKeyUsage()
+	}
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	template := &x509.Certificate{
+		Subject:               subject,
+		SerialNumber:          GenerateSerialNumber(),
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           extKeyUsage,
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		IPAddresses:           ipAddresses,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, i.issuer.cert, csr.PublicKey, i.issuer.key)
+	if err != nil {
+		return IssuedCertificate{}, err
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return IssuedCertificate{}, err
+	}
+
+	certPath, err := i.store(cert, request.Source)
+	if err != nil {
+		return IssuedCertificate{}, err
+	}
+	return IssuedCertificate{Certificate: cert, Path: certPath}, nil
+}
+
+// Revoke adds a certificate signed by this CA to its CRL and returns the CRL path.
+func (i *CSRIssuer) Revoke(cert *x509.Certificate, reason int) (string, error) {
+	if err := cert.CheckSignatureFrom(i.issuer.cert); err != nil {
+		return "", fmt.Errorf("certificate was not issued by %s", i.issuer.cert.Subject.CommonName)
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if isRevoked(i.issuer, cert.SerialNumber) {
+		return "", ErrAlreadyRevoked
+	}
+	return revokeCertificate(i.outputDir, i.issuer, cert, reason)
+}
+
+// store writes a certificate signed by this CA next to the others it issued and records it in the
+// inventory with its source.
+func (i *CSRIssuer) store(cert *x509.Certificate, source string) (string, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	certPath, err := i.storagePath(cert)
+	if err != nil {
+		return "", err
+	}
+	if err := i.archive(certPath, cert); err != nil {
+		return "", err
+	}
+	if err := WriteCertificatePEM(certPath, cert.Raw); err != nil {
+		return "", err
+	}
+	if err := RecordInventory(i.outputDir, InventoryRecord{
+		Path:   certPath,
+		Kind:   InventoryKindCertificate,
+		Source: source,
+		Serial: cert.SerialNumber.Text(16),
+	}); err != nil {
+		return "", err
+	}
+	return certPath, nil
+}
+
+// storagePath names the certificate after its common name (or first SAN). When a key for a
+// different certificate already sits under that name, the serial number is appended instead of
+// leaving a mismatched key next to the new certificate.
+func (i *CSRIssuer) storagePath(cert *x509.Certificate) (string, error) {
+	if err := os.MkdirAll(i.issuer.certDir, 0o700); err != nil {
+		return "", err
+	}
+	safeName := certificateFileName(cert)
+	certPath := filepath.Join(i.issuer.certDir, fmt.Sprintf("cert_%s.pem", safeName))
+	if key, err := LoadPrivateKey(PrivateKeyPathForCertificate(certPath)); err == nil && !publicKeyMatches(key, cert.PublicKey) {
+		certPath = serialCertificatePath(i.issuer.certDir, safeName, cert)
+	}
+	return certPath, nil
+}
+
+// archive keeps the certificate currently stored at certPath under its serial number before it is
+// replaced, carrying its inventory record along.
+func (i *CSRIssuer) archive(certPath string, replacement *x509.Certificate) error {
+	previous, err := LoadCertificate(certPath)
+	if err != nil || previous.SerialNumber.Cmp(replacement.SerialNumber) == 0 {
+		return nil
+	}
+	archivePath := serialCertificatePath(filepath.Dir(certPath), certificateFileName(previous), previous)
+	if err := os.Rename(certPath, archivePath); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", certPath, err)
+	}
+	records, err := LoadInventory(i.outputDir)
+	if err != nil {
+		return err
+	}
+	key, err := inventoryKey(i.outputDir, certPath)
+	if err != nil {
+		return err
+	}
+	record, ok := records[key]
+	if !ok {
+		record = InventoryRecord{Kind: InventoryKindCertificate}
+	}
+	record.Path = archivePath
+	record.Serial = previous.SerialNumber.Text(16)
+	return RecordInventory(i.outputDir, record)
+}
+
+// certificateFileName is the sanitized name issued certificates are stored under: the common name,
+// or the first SAN when the subject has none.
+func certificateFileName(cert *x509.Certificate) string {
+	name := cert.Subject.CommonName
+	if name == "" && len(cert.DNSNames) > 0 {
+		name = cert.DNSNames[0]
+	}
+	if name == "" && len(cert.IPAddresses) > 0 {
+		name = cert.IPAddresses[0].String()
+	}
+	return NormalizeName(name, "certificate")
+}
+
+func serialCertificatePath(certDir, safeName string, cert *x509.Certificate) string {
+	return filepath.Join(certDir, fmt.Sprintf("cert_%s_%s.pem", safeName, cert.SerialNumber.Text(16)))
+}
+
+func publicKeyMatches(key crypto.PrivateKey, publicKey crypto.PublicKey) bool {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return false
+	}
+	comparable, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && comparable.Equal(publicKey)
+}
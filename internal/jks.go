@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	jksMagic            = 0xFEEDFEED
+	jksVersion          = 2
+	jksPrivateKeyTag    = 1
+	jksTrustedCertTag   = 2
+	jksDigestWhitener   = "Mighty Aphrodite"
+	jksKeyProtectorSalt = 20
+)
+
+// oidJavaKeyProtector identifies Sun's proprietary JKS key protection algorithm.
+var oidJavaKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+type jksEntry struct {
+	alias      string
+	privateKey crypto.PrivateKey
+	chain      []*x509.Certificate
+}
+
+type jksEncryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// encodeJKS serialises entries into a Java KeyStore. Entries with a private key become key
+// entries protected by the store password; all other entries become trusted certificates.
+func encodeJKS(entries []jksEntry, password string) ([]byte, error) {
+	var buf bytes.Buffer
+	writeUint32(&buf, jksMagic)
+	writeUint32(&buf, jksVersion)
+	writeUint32(&buf, uint32(len(entries)))
+
+	timestamp := uint64(time.Now().UnixMilli())
+	for _, entry := range entries {
+		if entry.privateKey == nil {
+			writeUint32(&buf, jksTrustedCertTag)
+			if err := writeJavaUTF(&buf, entry.alias); err != nil {
+				return nil, err
+			}
+			writeUint64(&buf, timestamp)
+			if err := writeJKSCertificate(&buf, entry.chain[0]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		protectedKey, err := protectJKSKey(entry.privateKey, password)
+		if err != nil {
+			return nil, err
+		}
+		writeUint32(&buf, jksPrivateKeyTag)
+		if err := writeJavaUTF(&buf, entry.alias); err != nil {
+			return nil, err
+		}
+		writeUint64(&buf, timestamp)
+		writeUint32(&buf, uint32(len(protectedKey)))
+		buf.Write(protectedKey)
+		writeUint32(&buf, uint32(len(entry.chain)))
+		for _, cert := range entry.chain {
+			if err := writeJKSCertificate(&buf, cert); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	digest := sha1.New()
+	digest.Write(javaPasswordBytes(password))
+	digest.Write([]byte(jksDigestWhitener))
+	digest.Write(buf.Bytes())
+	buf.Write(digest.Sum(nil))
+	return buf.Bytes(), nil
+}
+
+// protectJKSKey implements the key protector used by the JDK for JKS private key entries:
+// the PKCS#8 key is XORed with a SHA-1 keystream derived from the password and a random salt.
+func protectJKSKey(privateKey crypto.PrivateKey, password string) ([]byte, error) {
+	plainKey, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	passwordBytes := javaPasswordBytes(password)
+
+	salt := make([]byte, jksKeyProtectorSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	keystream := make([]byte, 0, len(plainKey)+sha1.Size)
+	digest := salt
+	for len(keystream) < len(plainKey) {
+		sum := sha1.Sum(append(append([]byte{}, passwordBytes...), digest...))
+		digest = sum[:]
+		keystream = append(keystream, digest...)
+	}
+
+	encrypted := make([]byte, len(plainKey))
+	for i := range plainKey {
+		encrypted[i] = plainKey[i] ^ keystream[i]
+	}
+	check := sha1.Sum(append(append([]byte{}, passwordBytes...), plainKey...))
+
+	protected := make([]byte, 0, len(salt)+len(encrypted)+len(check))
+	protected = append(protected, salt...)
+	protected = append(protected, encrypted...)
+	protected = append(protected, check[:]...)
+
+	return asn1.Marshal(jksEncryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidJavaKeyProtector,
+			Parameters: asn1.NullRawValue,
+		},
+		EncryptedData: protected,
+	})
+}
+
+func writeJKSCertificate(buf *bytes.Buffer, cert *x509.Certificate) error {
+	if err := writeJavaUTF(buf, "X.509"); err != nil {
+		return err
+	}
+	writeUint32(buf, uint32(len(cert.Raw)))
+	buf.Write(cert.Raw)
+	return nil
+}
+
+// javaPasswordBytes encodes a password the way the JDK does for keystore digests: each UTF-16
+// code unit as two big-endian bytes, without a terminator.
+func javaPasswordBytes(password string) []byte {
+	units := utf16.Encode([]rune(password))
+	out := make([]byte, 0, len(units)*2)
+	for _, unit := range units {
+		out = append(out, byte(unit>>8), byte(unit))
+	}
+	return out
+}
+
+// writeJavaUTF writes a string in the length-prefixed encoding of DataOutputStream.writeUTF.
+// Aliases are restricted to ASCII, so modified UTF-8 equals plain UTF-8 here.
+func writeJavaUTF(buf *bytes.Buffer, value string) error {
+	if len(value) > 0xFFFF {
+		return fmt.Errorf("keystore string too long: %d bytes", len(value))
+	}
+	for _, r := range value {
+		if r == 0 || r > 0x7F {
+			return fmt.Errorf("keystore alias %q must contain printable ASCII characters only", value)
+		}
+	}
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(value)))
+	buf.Write(length[:])
+	buf.WriteString(value)
+	return nil
+}
+
+func writeUint32(buf *bytes.Buffer, value uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], value)
+	buf.Write(b[:])
+}
+
+func writeUint64(buf *bytes.Buffer, value uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], value)
+	buf.Write(b[:])
+}
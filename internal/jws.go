@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// jwsMessage is a JWS in the flattened JSON serialization used by ACME (RFC 8555 section 6.2).
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type jwsHeader struct {
+	Alg   string          `json:"alg"`
+	Nonce string          `json:"nonce,omitempty"`
+	URL   string          `json:"url"`
+	KeyID string          `json:"kid,omitempty"`
+	JWK   json.RawMessage `json:"jwk,omitempty"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+var errJWSSignature = errors.New("JWS signature is invalid")
+
+func parseJWS(data []byte) (jwsMessage, jwsHeader, []byte, error) {
+	var message jwsMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return jwsMessage{}, jwsHeader{}, nil, fmt.Errorf("request is not a flattened JWS: %w", err)
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(message.Protected)
+	if err != nil {
+		return jwsMessage{}, jwsHeader{}, nil, fmt.Errorf("invalid JWS protected header encoding: %w", err)
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return jwsMessage{}, jwsHeader{}, nil, fmt.Errorf("invalid JWS protected header: %w", err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(message.Payload)
+	if err != nil {
+		return jwsMessage{}, jwsHeader{}, nil, fmt.Errorf("invalid JWS payload encoding: %w", err)
+	}
+	return message, header, payload, nil
+}
+
+// verifyJWS checks the message signature with key for the algorithms ACME clients use:
+// RS256, ES256, ES384, ES512 and EdDSA.
+func verifyJWS(message jwsMessage, alg string, key crypto.PublicKey) error {
+	signature, err := base64.RawURLEncoding.DecodeString(message.Signature)
+	if err != nil {
+		return errJWSSignature
+	}
+	signingInput := []byte(message.Protected + "." + message.Payload)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return fmt.Errorf("algorithm %q does not match an RSA key", alg)
+		}
+		digest := sha256.Sum256(signingInput)
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return errJWSSignature
+		}
+	case *ecdsa.PublicKey:
+		var digest []byte
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		switch {
+		case alg == "ES256" && pub.Curve == elliptic.P256():
+			sum := sha256.Sum256(signingInput)
+			digest = sum[:]
+		case alg == "ES384" && pub.Curve == elliptic.P384():
+			sum := sha512.Sum384(signingInput)
+			digest = sum[:]
+		case alg == "ES512" && pub.Curve == elliptic.P521():
+			sum := sha512.Sum512(signingInput)
+			digest = sum[:]
+		default:
+			return fmt.Errorf("algorithm %q does not match an ECDSA %s key", alg, pub.Curve.Params().Name)
+		}
+		if len(signature) != 2*size {
+			return errJWSSignature
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errJWSSignature
+		}
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return fmt.Errorf("algorithm %q does not match an Ed25519 key", alg)
+		}
+		if !ed25519.Verify(pub, signingInput, signature) {
+			return errJWSSignature
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	return nil
+}
+
+func parseJWK(data []byte) (crypto.PublicKey, error) {
+	var jwk jsonWebKey
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, fmt.Errorf("invalid JWK: %w", err)
+	}
+	decode := func(value string) (*big.Int, error) {
+		raw, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(raw) == 0 {
+			return nil, fmt.Errorf("invalid JWK parameter encoding")
+		}
+		return new(big.Int).SetBytes(raw), nil
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("RSA exponent is too large")
+		}
+		if n.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA account keys must have at least 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		point := make([]byte, 1+2*size)
+		point[0] = 4
+		x.FillBytes(point[1 : 1+size])
+		y.FillBytes(point[1+size:])
+		key, err := ecdsa.ParseUncompressedPublicKey(curve, point)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC public key: %w", err)
+		}
+		return key, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", jwk.Crv)
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(raw), nil
+	default:
+		return nil, fmt.Errorf("unsupported JWK key type %q", jwk.Kty)
+	}
+}
+
+// jwkThumbprint returns the base64url SHA-256 thumbprint of the key (RFC 7638), which ACME
+// uses in key authorizations.
+func jwkThumbprint(key crypto.PublicKey) (string, error) {
+	var canonical string
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		e := big.NewInt(int64(pub.E)).Bytes()
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+			base64.RawURLEncoding.EncodeToString(e), base64.RawURLEncoding.EncodeToString(pub.N.Bytes()))
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		point, err := pub.Bytes()
+		if err != nil {
+			return "", err
+		}
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, pub.Curve.Params().Name,
+			base64.RawURLEncoding.EncodeToString(point[1:1+size]), base64.RawURLEncoding.EncodeToString(point[1+size:]))
+	case ed25519.PublicKey:
+		canonical = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, base64.RawURLEncoding.EncodeToString(pub))
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
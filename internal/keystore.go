@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	ExportJKS              = "jks"
+	ExportTruststoreJKS    = "truststore-jks"
+	ExportTruststorePKCS12 = "truststore-p12"
+	DefaultStorePassword   = "changeit"
+)
+
+// ExportJavaKeystore writes the certificate, its private key and its chain into a JKS keystore.
+func ExportJavaKeystore(outputDir, certPath string, options ExportOptions) (ExportResult, error) {
+	cert, err := LoadCertificate(certPath)
+	if err != nil {
+		return ExportResult{}, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	chain, err := BuildChain(outputDir, cert)
+	if err != nil {
+		return ExportResult{}, fmt.Errorf("failed to build certificate chain: %w", err)
+	}
+	privateKey, err := LoadPrivateKey(PrivateKeyPathForCertificate(certPath))
+	if err != nil {
+		return ExportResult{}, fmt.Errorf("private key is not available for %s: %w", cert.Subject.CommonName, err)
+	}
+
+	password := storePassword(options.Password)
+	alias := keystoreAlias(options.Alias, cert.Subject.CommonName)
+	data, err := encodeJKS([]jksEntry{{
+		alias:      alias,
+		privateKey: privateKey,
+		chain:      append([]*x509.Certificate{cert}, chain...),
+	}}, password)
+	if err != nil {
+		return ExportResult{}, err
+	}
+	baseName := strings.TrimSuffix(filepath.Base(certPath), filepath.Ext(certPath))
+	return ExportResult{
+		FileName:    baseName + ".jks",
+		ContentType: "application/x-java-keystore",
+		Data:        data,
+		Private:     true,
+	}, nil
+}
+
+// ExportTruststore builds a JKS or PKCS#12 truststore from the given CA certificates. When no
+// certificates are passed, every root CA in the output directory is included. Certificates that are
+// not CAs are refused rather than turned into trust anchors.
+func ExportTruststore(outputDir string, certPaths []string, format string, options ExportOptions) (ExportResult, error) {
+	var certs []*x509.Certificate
+	var names []string
+	if len(certPaths) == 0 {
+		cas, err := ListCAs(outputDir)
+		if err != nil {
+			return ExportResult{}, err
+		}
+		for _, ca := range cas {
+			if ca.Type == IssuerTypeRoot {
+				certs = append(certs, ca.Cert)
+				names = append(names, ca.Type+"-"+ca.Name)
+			}
+		}
+	}
+	for _, certPath := range certPaths {
+		cert, err := LoadCertificate(certPath)
+		if err != nil {
+			return ExportResult{}, fmt.Errorf("failed to load %s: %w", certPath, err)
+		}
+		if !cert.IsCA {
+			return ExportResult{}, fmt.Errorf("%s is not a CA certificate and cannot be added to a truststore", cert.Subject.CommonName)
+		}
+		certs = append(certs, cert)
+		names = append(names, cert.Subject.CommonName)
+	}
+	if len(certs) == 0 {
+		return ExportResult{}, fmt.Errorf("no CA certificates selected for the truststore")
+	}
+
+	aliases := make([]string, len(certs))
+	for i := range certs {
+		switch {
+		case options.Alias != "" && len(certs) == 1:
+			aliases[i] = keystoreAlias(options.Alias, names[i])
+		case options.Alias != "":
+			aliases[i] = keystoreAlias(fmt.Sprintf("%s-%d", options.Alias, i+1), names[i])
+		default:
+			aliases[i] = keystoreAlias("", names[i])
+		}
+	}
+	// CAs sharing a common name would otherwise overwrite each other in the store.
+	used := make(map[string]bool, len(aliases))
+	for i, alias := range aliases {
+		unique := alias
+		for n := 2; used[unique]; n++ {
+			unique = fmt.Sprintf("%s-%d", alias, n)
+		}
+		aliases[i] = unique
+		used[unique] = true
+	}
+
+	if format == ExportTruststoreJKS {
+		entries := make([]jksEntry, len(certs))
+		for i, cert := range certs {
+			entries[i] = jksEntry{alias: aliases[i], chain: []*x509.Certificate{cert}}
+		}
+		data, err := encodeJKS(entries, storePassword(options.Password))
+		if err != nil {
+			return ExportResult{}, err
+		}
+		return ExportResult{
+			FileName:    "truststore.jks",
+			ContentType: "application/x-java-keystore",
+			Data:        data,
+		}, nil
+	}
+
+	encoding, err := NormalizePFXEncoding(options.PFXEncoding)
+	if err != nil {
+		return ExportResult{}, err
+	}
+	entries := make([]pkcs12.TrustStoreEntry, len(certs))
+	for i, cert := range certs {
+		entries[i] = pkcs12.TrustStoreEntry{Cert: cert, FriendlyName: aliases[i]}
+	}
+	data, err := EncodeTruststorePKCS12(entries, options.Password, encoding)
+	if err != nil {
+		return ExportResult{}, err
+	}
+	return ExportResult{
+		FileName:    "truststore.p12",
+		ContentType: "application/x-pkcs12",
+		Data:        data,
+		PFXEncoding: encoding,
+	}, nil
+}
+
+func storePassword(password string) string {
+	if password == "" {
+		return DefaultStorePassword
+	}
+	return password
+}
+
+// keystoreAlias lowercases and sanitises an alias the way keytool stores it.
+func keystoreAlias(alias, fallback string) string {
+	return strings.ToLower(NormalizeName(alias, NormalizeName(fallback, "certificate")))
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"crypto"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -22,7 +23,32 @@ func LoadCAPrivateKey(filename string) (*rsa.PrivateKey, error) {
 	return x509.ParsePKCS1PrivateKey(block.Bytes)
 }
 
+func LoadPrivateKey(filename string) (crypto.PrivateKey, error) {
+	keyData, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+}
+
 func LoadCACertificate(filename string) (*x509.Certificate, error) {
+	return LoadCertificate(filename)
+}
+
+func LoadCertificate(filename string) (*x509.Certificate, error) {
 	certData, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
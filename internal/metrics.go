@@ -0,0 +1,263 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsContentType is the content type of the Prometheus text exposition format.
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricsSourceManual labels certificates issued without an inventory source: from the dashboard,
+// the API or the CLI.
+const metricsSourceManual = "manual"
+
+// scepOperations are the SCEP operations counted separately; anything else is counted as "other" so
+// that clients cannot create series at will.
+var scepOperations = []string{"GetCACaps", "GetCACert", "GetNextCACert", "PKIOperation"}
+
+// scepDurationBuckets are the upper bounds, in seconds, of the SCEP request duration histogram.
+var scepDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type scepRequestKey struct {
+	operation string
+	code      int
+}
+
+type durationHistogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// Metrics counts certificate and SCEP activity for a Prometheus /metrics endpoint. Counters start
+// at zero when the process starts; per-certificate gauges are computed from a certificate index on
+// every scrape.
+type Metrics struct {
+	mu            sync.Mutex
+	issued        map[string]uint64
+	revoked       uint64
+	scepRequests  map[scepRequestKey]uint64
+	scepDurations map[string]*durationHistogram
+}
+
+// NewMetrics returns metrics with every counter at zero.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		issued:        make(map[string]uint64),
+		scepRequests:  make(map[scepRequestKey]uint64),
+		scepDurations: make(map[string]*durationHistogram),
+	}
+}
+
+// RecordIndexUpdate counts the certificates issued, replaced and revoked in a certificate index
+// update, whichever process wrote them.
+func (m *Metrics) RecordIndexUpdate(update IndexUpdate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, change := range update.Certificates {
+		switch change.Action {
+		case ChangeIssued, ChangeRenewed:
+			source := change.Source
+			if source == "" {
+				source = metricsSourceManual
+			}
+			m.issued[source]++
+		case ChangeRevoked:
+			m.revoked++
+		}
+	}
+}
+
+// InstrumentSCEP counts the requests served by a SCEP handler and how long they took, by operation
+// and status code.
+func (m *Metrics) InstrumentSCEP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		m.observeSCEPRequest(r.URL.Query().Get("operation"), recorder.status, time.Since(started))
+	})
+}
+
+func (m *Metrics) observeSCEPRequest(operation string, status int, duration time.Duration) {
+	known := false
+	for _, candidate := range scepOperations {
+		if operation == candidate {
+			known = true
+			break
+		}
+	}
+	if !known {
+		operation = "other"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scepRequests[scepRequestKey{operation: operation, code: status}]++
+	histogram := m.scepDurations[operation]
+	if histogram == nil {
+		histogram = &durationHistogram{buckets: make([]uint64, len(scepDurationBuckets))}
+		m.scepDurations[operation] = histogram
+	}
+	seconds := duration.Seconds()
+	for i, bound := range scepDurationBuckets {
+		if seconds <= bound {
+			histogram.buckets[i]++
+		}
+	}
+	histogram.sum += seconds
+	histogram.count++
+}
+
+// Handler serves the metrics with the certificates of index in the Prometheus text format.
+func (m *Metrics) Handler(index *CertificateIndex) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		snapshot, err := index.Snapshot()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read certificates: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", MetricsContentType)
+		if r.Method == http.MethodHead {
+			return
+		}
+		_ = m.Write(w, snapshot)
+	})
+}
+
+// Write writes the metrics in the Prometheus text exposition format. Certificate counts by status
+// use the same expiry states as the dashboard summary, including revoked certificates.
+func (m *Metrics) Write(w io.Writer, snapshot IndexSnapshot) error {
+	out := bufio.NewWriter(w)
+
+	writeMetricHeader(out, "cert_helper_certificate_expiry_timestamp_seconds", "gauge",
+		"Time at which the certificate expires, in seconds since the Unix epoch.")
+	statuses := map[string]int{ExpiryValid: 0, ExpiryExpiring: 0, ExpiryExpired: 0}
+	revoked := 0
+	for _, certificate := range snapshot.Certificates {
+		cert := certificate.Certificate
+		writeMetric(out, "cert_helper_certificate_expiry_timestamp_seconds", []string{
+			"path", certificate.Path,
+			"name", certificate.Name,
+			"serial", cert.SerialNumber.Text(16),
+			"issuer", cert.Issuer.CommonName,
+			"ca", strconv.FormatBool(cert.IsCA),
+			"revoked", strconv.FormatBool(certificate.Revoked),
+		}, float64(cert.NotAfter.Unix()))
+		statuses[certificate.Status]++
+		if certificate.Revoked {
+			revoked++
+		}
+	}
+
+	writeMetricHeader(out, "cert_helper_certificates", "gauge",
+		fmt.Sprintf("Certificates in the output directory by expiry status; expiring means within %d days.", ExpiringSoonDays))
+	for _, status := range []string{ExpiryValid, ExpiryExpiring, ExpiryExpired} {
+		writeMetric(out, "cert_helper_certificates", []string{"status", status}, float64(statuses[status]))
+	}
+	writeMetricHeader(out, "cert_helper_certificates_revoked", "gauge",
+		"Certificates in the output directory listed on the CRL of their issuer.")
+	writeMetric(out, "cert_helper_certificates_revoked", nil, float64(revoked))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetricHeader(out, "cert_helper_certificates_issued_total", "counter",
+		"Certificates issued or replaced in the output directory since the server started, by source.")
+	for _, source := range sortedKeys(m.issued) {
+		writeMetric(out, "cert_helper_certificates_issued_total", []string{"source", source}, float64(m.issued[source]))
+	}
+	writeMetricHeader(out, "cert_helper_certificates_revoked_total", "counter",
+		"Certificates revoked since the server started.")
+	writeMetric(out, "cert_helper_certificates_revoked_total", nil, float64(m.revoked))
+
+	writeMetricHeader(out, "cert_helper_scep_requests_total", "counter",
+		"SCEP requests served, by operation and HTTP status code.")
+	requestKeys := make([]scepRequestKey, 0, len(m.scepRequests))
+	for key := range m.scepRequests {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		if requestKeys[i].operation != requestKeys[j].operation {
+			return requestKeys[i].operation < requestKeys[j].operation
+		}
+		return requestKeys[i].code < requestKeys[j].code
+	})
+	for _, key := range requestKeys {
+		writeMetric(out, "cert_helper_scep_requests_total", []string{"operation", key.operation, "code", strconv.Itoa(key.code)}, float64(m.scepRequests[key]))
+	}
+
+	writeMetricHeader(out, "cert_helper_scep_request_duration_seconds", "histogram",
+		"Time taken to answer SCEP requests, by operation.")
+	for _, operation := range sortedKeys(m.scepDurations) {
+		histogram := m.scepDurations[operation]
+		for i, bound := range scepDurationBuckets {
+			writeMetric(out, "cert_helper_scep_request_duration_seconds_bucket", []string{"operation", operation, "le", formatMetricValue(bound)}, float64(histogram.buckets[i]))
+		}
+		writeMetric(out, "cert_helper_scep_request_duration_seconds_bucket", []string{"operation", operation, "le", "+Inf"}, float64(histogram.count))
+		writeMetric(out, "cert_helper_scep_request_duration_seconds_sum", []string{"operation", operation}, histogram.sum)
+		writeMetric(out, "cert_helper_scep_request_duration_seconds_count", []string{"operation", operation}, float64(histogram.count))
+	}
+	return out.Flush()
+}
+
+func writeMetricHeader(out *bufio.Writer, name, metricType, help string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// writeMetric writes one sample; labels alternate between names and values.
+func writeMetric(out *bufio.Writer, name string, labels []string, value float64) {
+	out.WriteString(name)
+	if len(labels) > 0 {
+		escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+		out.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			fmt.Fprintf(out, `%s="%s"`, labels[i], escaper.Replace(labels[i+1]))
+		}
+		out.WriteByte('}')
+	}
+	out.WriteByte(' ')
+	out.WriteString(formatMetricValue(value))
+	out.WriteByte('\n')
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
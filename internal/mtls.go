@@ -0,0 +1,369 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ClientAuthNone    = "none"
+	ClientAuthRequest = "request"
+	ClientAuthRequire = "require"
+
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	probeResponseWait          = time.Second
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion accepts "1.0" to "1.3" (optionally prefixed with "TLS"); empty returns fallback.
+func ParseTLSVersion(value string, fallback uint16) (uint16, error) {
+	value = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(value)), "tls")
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fallback, nil
+	}
+	if version, ok := tlsVersions[value]; ok {
+		return version, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q (supported: 1.0, 1.1, 1.2, 1.3)", value)
+}
+
+// ParseCipherSuites resolves IANA cipher suite names, including the insecure ones Go still
+// implements, so legacy clients can be emulated. TLS 1.3 suites are not configurable.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range names {
+		id, ok := known[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+type TLSHandshakeReport struct {
+	Remote      string
+	Version     string
+	CipherSuite string
+	ServerName  string
+	PeerChain   []*x509.Certificate
+	// VerifyError is the peer certificate validation failure, if any.
+	VerifyError error
+	// HandshakeError is set when the handshake did not complete.
+	HandshakeError error
+	Duration       time.Duration
+	// Response is the first line the server sent after the handshake, if any.
+	Response string
+}
+
+type MTLSServerOptions struct {
+	// CertPath is the certificate presented by the server; its key must sit next to it.
+	CertPath string
+	// TrustCertPaths are the CAs client certificates must chain to. Defaults to every CA in the
+	// output directory.
+	TrustCertPaths []string
+	// CRLFiles default to the CRLs kept next to the trusted CAs.
+	CRLFiles   []string
+	Address    string
+	ClientAuth string
+	// AllowUntrusted completes handshakes with invalid client certificates and only reports them.
+	AllowUntrusted bool
+	MinVersion     string
+	MaxVersion     string
+	CipherSuites   []string
+}
+
+type MTLSServer struct {
+	listener net.Listener
+	config   *tls.Config
+	verifier *clientCertVerifier
+	options  MTLSServerOptions
+
+	wg sync.WaitGroup
+}
+
+// ListenMTLS prepares a TLS server that presents a certificate from the output directory and
+// validates client certificates the way the RADIUS harness does.
+func ListenMTLS(outputDir string, options MTLSServerOptions) (*MTLSServer, error) {
+	switch options.ClientAuth {
+	case "":
+		options.ClientAuth = ClientAuthRequire
+	case ClientAuthNone, ClientAuthRequest, ClientAuthRequire:
+	default:
+		return nil, fmt.Errorf("unsupported client auth mode %q (supported: none, request, require)", options.ClientAuth)
+	}
+	config, err := baseTLSConfig(options.MinVersion, options.MaxVersion, options.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	serverCert, err := LoadServerCertificate(outputDir, options.CertPath)
+	if err != nil {
+		return nil, err
+	}
+	config.Certificates = []tls.Certificate{serverCert}
+	switch options.ClientAuth {
+	case ClientAuthRequire:
+		config.ClientAuth = tls.RequireAnyClientCert
+	case ClientAuthRequest:
+		config.ClientAuth = tls.RequestClientCert
+	}
+
+	verifier, err := newClientCertVerifier(outputDir, options.TrustCertPaths, options.CRLFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", options.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", options.Address, err)
+	}
+	return &MTLSServer{listener: listener, config: config, verifier: verifier, options: options}, nil
+}
+
+func (s *MTLSServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *MTLSServer) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+// Serve accepts connections until the listener is closed and reports every handshake. Clients
+// that complete the handshake receive a one-line summary before the connection is closed.
+func (s *MTLSServer) Serve(report func(TLSHandshakeReport)) error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			report(s.handle(conn))
+		}()
+	}
+}
+
+func (s *MTLSServer) handle(conn net.Conn) TLSHandshakeReport {
+	defer conn.Close()
+	report := TLSHandshakeReport{Remote: conn.RemoteAddr().String()}
+
+	config := s.config.Clone()
+	if s.options.ClientAuth != ClientAuthNone {
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 && s.options.ClientAuth == ClientAuthRequest {
+				return nil
+			}
+			report.VerifyError = s.verifier.verify(rawCerts, nil)
+			if s.options.AllowUntrusted {
+				return nil
+			}
+			return report.VerifyError
+		}
+	}
+
+	tlsConn := tls.Server(conn, config)
+	_ = conn.SetDeadline(time.Now().Add(defaultTLSHandshakeTimeout))
+	start := time.Now()
+	err := tlsConn.Handshake()
+	report.Duration = time.Since(start)
+	state := tlsConn.ConnectionState()
+	report.PeerChain = state.PeerCertificates
+	report.ServerName = state.ServerName
+	if err != nil {
+		report.HandshakeError = err
+		return report
+	}
+	report.Version = tls.VersionName(state.Version)
+	report.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+
+	client := "anonymous client"
+	if len(state.PeerCertificates) > 0 {
+		client = state.PeerCertificates[0].Subject.String()
+	}
+	status := "verified"
+	if report.VerifyError != nil {
+		status = "NOT verified: " + report.VerifyError.Error()
+	} else if len(state.PeerCertificates) == 0 {
+		status = "no client certificate"
+	}
+	_, _ = fmt.Fprintf(tlsConn, "cert-helper: %s, %s, %s (%s)\n", report.Version, report.CipherSuite, client, status)
+	return report
+}
+
+type MTLSProbeOptions struct {
+	Address string
+	// ClientCertPath is an optional PKCS#12 bundle or PEM certificate with its key next to it.
+	ClientCertPath string
+	ClientPassword string
+	// TrustCertPaths are the CAs the server certificate must chain to. Defaults to every root CA
+	// in the output directory.
+	TrustCertPaths []string
+	// ServerName is sent as SNI and, when set, checked against the server certificate.
+	ServerName string
+	// Insecure completes the handshake with an untrusted server and only reports the failure.
+	Insecure     bool
+	MinVersion   string
+	MaxVersion   string
+	CipherSuites []string
+	Timeout      time.Duration
+}
+
+// ProbeTLS connects to a TLS server, optionally presenting a client certificate, and reports
+// the negotiated parameters. Setup problems are returned as errors; handshake and verification
+// failures are part of the report.
+func ProbeTLS(outputDir string, options MTLSProbeOptions) (TLSHandshakeReport, error) {
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTLSHandshakeTimeout
+	}
+	config, err := baseTLSConfig(options.MinVersion, options.MaxVersion, options.CipherSuites)
+	if err != nil {
+		return TLSHandshakeReport{}, err
+	}
+	if options.ClientCertPath != "" {
+		clientCert, err := loadClientCertificate(outputDir, options.ClientCertPath, options.ClientPassword)
+		if err != nil {
+			return TLSHandshakeReport{}, err
+		}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &clientCert, nil
+		}
+	}
+
+	roots, err := trustedRootPool(outputDir, options.TrustCertPaths)
+	if err != nil {
+		return TLSHandshakeReport{}, err
+	}
+
+	report := TLSHandshakeReport{Remote: options.Address, ServerName: options.ServerName}
+	config.ServerName = options.ServerName
+	config.InsecureSkipVerify = true
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		report.VerifyError = verifyServerCertificate(rawCerts, roots, options.ServerName)
+		if options.Insecure {
+			return nil
+		}
+		return report.VerifyError
+	}
+
+	dialer := &net.Dialer{Timeout: options.Timeout}
+	conn, err := dialer.Dial("tcp", options.Address)
+	if err != nil {
+		return TLSHandshakeReport{}, fmt.Errorf("failed to connect to %s: %w", options.Address, err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(options.Timeout))
+
+	tlsConn := tls.Client(conn, config)
+	start := time.Now()
+	err = tlsConn.Handshake()
+	report.Duration = time.Since(start)
+	state := tlsConn.ConnectionState()
+	report.PeerChain = state.PeerCertificates
+	if err != nil {
+		report.HandshakeError = err
+		return report, nil
+	}
+	report.Version = tls.VersionName(state.Version)
+	report.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+
+	// With TLS 1.3 the client finishes its handshake before the server has checked the client
+	// certificate, so a rejection only shows up as an alert on the first read.
+	_ = conn.SetReadDeadline(time.Now().Add(min(options.Timeout, probeResponseWait)))
+	line, err := bufio.NewReader(io.LimitReader(tlsConn, 512)).ReadString('\n')
+	report.Response = strings.TrimSpace(line)
+	var netErr net.Error
+	if err != nil && !errors.Is(err, io.EOF) && !(errors.As(err, &netErr) && netErr.Timeout()) {
+		report.HandshakeError = err
+	}
+	return report, nil
+}
+
+func baseTLSConfig(minVersion, maxVersion string, cipherSuites []string) (*tls.Config, error) {
+	minimum, err := ParseTLSVersion(minVersion, tls.VersionTLS12)
+	if err != nil {
+		return nil, err
+	}
+	maximum, err := ParseTLSVersion(maxVersion, tls.VersionTLS13)
+	if err != nil {
+		return nil, err
+	}
+	if minimum > maximum {
+		return nil, fmt.Errorf("minimum TLS version is above the maximum")
+	}
+	suites, err := ParseCipherSuites(cipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{MinVersion: minimum, MaxVersion: maximum, CipherSuites: suites}, nil
+}
+
+func trustedRootPool(outputDir string, trustPaths []string) (*x509.CertPool, error) {
+	var certs []*x509.Certificate
+	var err error
+	if len(trustPaths) == 0 {
+		certs, err = RootCertificates(outputDir)
+	} else {
+		certs, err = loadCertificates(trustPaths)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+// ExtKeyUsageNames returns readable names for the extended key usages of a certificate.
+func ExtKeyUsageNames(cert *x509.Certificate) []string {
+	names := map[x509.ExtKeyUsage]string{
+		x509.ExtKeyUsageAny:             "any",
+		x509.ExtKeyUsageServerAuth:      "serverAuth",
+		x509.ExtKeyUsageClientAuth:      "clientAuth",
+		x509.ExtKeyUsageCodeSigning:     "codeSigning",
+		x509.ExtKeyUsageEmailProtection: "emailProtection",
+		x509.ExtKeyUsageTimeStamping:    "timeStamping",
+		x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+	}
+	var result []string
+	for _, usage := range cert.ExtKeyUsage {
+		if name, ok := names[usage]; ok {
+			result = append(result, name)
+		} else {
+			result = append(result, fmt.Sprintf("%d", usage))
+		}
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		result = append(result, oid.String())
+	}
+	return result
+}
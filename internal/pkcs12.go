@@ -0,0 +1,291 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+	"strings"
+	"unicode/utf16"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	PFXEncodingLegacy       = "legacy"
+	PFXEncodingModern2023   = "modern2023"
+	PFXEncodingPasswordless = "passwordless"
+)
+
+// PFXEncodings lists the supported PKCS#12 encodings, legacy first since it is the default.
+var PFXEncodings = []string{
+	PFXEncodingLegacy,
+	PFXEncodingModern2023,
+	PFXEncodingPasswordless,
+}
+
+var (
+	oidDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidFriendlyName    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidSHA1            = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe pfxContentInfo
+	MacData  pfxMacData `asn1:"optional"`
+}
+
+type pfxContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type pfxMacData struct {
+	Mac        pfxDigestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type pfxDigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pfxSafeBag struct {
+	Id         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pfxBagAttribute `asn1:"set,optional"`
+}
+
+type pfxBagAttribute struct {
+	Id    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+// NormalizePFXEncoding maps user input to one of PFXEncodings; an empty value selects legacy.
+func NormalizePFXEncoding(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", PFXEncodingLegacy, "3des":
+		return PFXEncodingLegacy, nil
+	case PFXEncodingModern2023, "modern", "aes":
+		return PFXEncodingModern2023, nil
+	case PFXEncodingPasswordless, "none":
+		return PFXEncodingPasswordless, nil
+	default:
+		return "", fmt.Errorf("unsupported PKCS#12 encoding %q (supported: %s)", value, strings.Join(PFXEncodings, ", "))
+	}
+}
+
+// PFXEncodingDescription describes the algorithms behind a PKCS#12 encoding.
+func PFXEncodingDescription(encoding string) string {
+	switch encoding {
+	case PFXEncodingModern2023:
+		return "PBES2 AES-256-CBC, PBKDF2 HMAC-SHA-256, SHA-256 MAC"
+	case PFXEncodingPasswordless:
+		return "unencrypted, no MAC"
+	default:
+		return "pbeWithSHAAnd3-KeyTripleDES-CBC, SHA-1 MAC"
+	}
+}
+
+func pfxEncoder(encoding string) *pkcs12.Encoder {
+	switch encoding {
+	case PFXEncodingModern2023:
+		return pkcs12.Modern2023
+	case PFXEncodingPasswordless:
+		return pkcs12.Passwordless
+	default:
+		return pkcs12.Legacy
+	}
+}
+
+// EncodePFX builds a PKCS#12 bundle holding the private key, the certificate and its CA chain.
+// When friendlyName is set it is attached to every unencrypted bag carrying the local key ID,
+// which always includes the private key bag.
+func EncodePFX(privateKey crypto.PrivateKey, cert *x509.Certificate, caCerts []*x509.Certificate, password, friendlyName, encoding string) ([]byte, error) {
+	encoding, err := NormalizePFXEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	if encoding == PFXEncodingPasswordless && password != "" {
+		return nil, fmt.Errorf("passwordless PKCS#12 bundles cannot use a password")
+	}
+	pfxData, err := pfxEncoder(encoding).Encode(privateKey, cert, caCerts, password)
+	if err != nil {
+		return nil, err
+	}
+	if friendlyName == "" {
+		return pfxData, nil
+	}
+	return setPFXFriendlyName(pfxData, password, friendlyName)
+}
+
+// EncodeTruststorePKCS12 builds a PKCS#12 truststore whose entries are marked as trusted for Java.
+func EncodeTruststorePKCS12(entries []pkcs12.TrustStoreEntry, password, encoding string) ([]byte, error) {
+	encoding, err := NormalizePFXEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	if encoding == PFXEncodingPasswordless && password != "" {
+		return nil, fmt.Errorf("passwordless PKCS#12 bundles cannot use a password")
+	}
+	return pfxEncoder(encoding).EncodeTrustStoreEntries(entries, password)
+}
+
+func setPFXFriendlyName(pfxData []byte, password, friendlyName string) ([]byte, error) {
+	var pfx pfxPdu
+	if _, err := asn1.Unmarshal(pfxData, &pfx); err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS#12 data: %w", err)
+	}
+	var authSafeBytes []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafeBytes); err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS#12 authenticated safe: %w", err)
+	}
+	var authSafe []pfxContentInfo
+	if _, err := asn1.Unmarshal(authSafeBytes, &authSafe); err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS#12 safe contents: %w", err)
+	}
+
+	nameAttribute, err := friendlyNameAttribute(friendlyName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range authSafe {
+		if !authSafe[i].ContentType.Equal(oidDataContentType) {
+			continue
+		}
+		var bagsBytes []byte
+		if _, err := asn1.Unmarshal(authSafe[i].Content.Bytes, &bagsBytes); err != nil {
+			return nil, err
+		}
+		var bags []pfxSafeBag
+		if _, err := asn1.Unmarshal(bagsBytes, &bags); err != nil {
+			return nil, err
+		}
+		for j := range bags {
+			if hasBagAttribute(bags[j], oidLocalKeyID) && !hasBagAttribute(bags[j], oidFriendlyName) {
+				bags[j].Attributes = append(bags[j].Attributes, nameAttribute)
+			}
+		}
+		if bagsBytes, err = asn1.Marshal(bags); err != nil {
+			return nil, err
+		}
+		authSafe[i].Content = asn1.RawValue{Class: 2, Tag: 0, IsCompound: true}
+		if authSafe[i].Content.Bytes, err = asn1.Marshal(bagsBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	if authSafeBytes, err = asn1.Marshal(authSafe); err != nil {
+		return nil, err
+	}
+	if len(pfx.MacData.Mac.Digest) > 0 {
+		digest, err := pfxMac(pfx.MacData, authSafeBytes, password)
+		if err != nil {
+			return nil, err
+		}
+		pfx.MacData.Mac.Digest = digest
+	}
+	pfx.AuthSafe.Content = asn1.RawValue{Class: 2, Tag: 0, IsCompound: true}
+	if pfx.AuthSafe.Content.Bytes, err = asn1.Marshal(authSafeBytes); err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfx)
+}
+
+func hasBagAttribute(bag pfxSafeBag, id asn1.ObjectIdentifier) bool {
+	for _, attribute := range bag.Attributes {
+		if attribute.Id.Equal(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func friendlyNameAttribute(name string) (pfxBagAttribute, error) {
+	encoded, err := asn1.Marshal(asn1.RawValue{
+		Class: 0,
+		Tag:   asn1.TagBMPString,
+		Bytes: bmpString(name, false),
+	})
+	if err != nil {
+		return pfxBagAttribute{}, err
+	}
+	return pfxBagAttribute{
+		Id: oidFriendlyName,
+		Value: asn1.RawValue{
+			Class:      0,
+			Tag:        asn1.TagSet,
+			IsCompound: true,
+			Bytes:      encoded,
+		},
+	}, nil
+}
+
+// bmpString encodes s as big-endian UTF-16, optionally with the trailing NUL PKCS#12 uses for passwords.
+func bmpString(s string, zeroTerminated bool) []byte {
+	units := utf16.Encode([]rune(s))
+	if zeroTerminated {
+		units = append(units, 0)
+	}
+	out := make([]byte, 0, len(units)*2)
+	for _, unit := range units {
+		out = append(out, byte(unit>>8), byte(unit))
+	}
+	return out
+}
+
+// pfxMac recomputes the PKCS#12 integrity MAC (RFC 7292 appendix B) over the authenticated safe.
+func pfxMac(macData pfxMacData, message []byte, password string) ([]byte, error) {
+	var newHash func() hash.Hash
+	switch {
+	case macData.Mac.Algorithm.Algorithm.Equal(oidSHA1):
+		newHash = sha1.New
+	case macData.Mac.Algorithm.Algorithm.Equal(oidSHA256):
+		newHash = sha256.New
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#12 MAC algorithm %s", macData.Mac.Algorithm.Algorithm)
+	}
+
+	const blockSize = 64
+	encodedPassword := bmpString(password, true)
+	input := make([]byte, 0, blockSize*3)
+	for i := 0; i < blockSize; i++ {
+		input = append(input, 3)
+	}
+	input = append(input, fillBlocks(macData.MacSalt, blockSize)...)
+	input = append(input, fillBlocks(encodedPassword, blockSize)...)
+
+	h := newHash()
+	h.Write(input)
+	key := h.Sum(nil)
+	for i := 1; i < macData.Iterations; i++ {
+		h.Reset()
+		h.Write(key)
+		key = h.Sum(nil)
+	}
+
+	mac := hmac.New(newHash, key)
+	mac.Write(message)
+	return mac.Sum(nil), nil
+}
+
+func fillBlocks(pattern []byte, blockSize int) []byte {
+	if len(pattern) == 0 {
+		return nil
+	}
+	size := blockSize * ((len(pattern) + blockSize - 1) / blockSize)
+	out := make([]byte, size)
+	for i := range out {
+		out[i] = pattern[i%len(pattern)]
+	}
+	return out
+}
@@ -0,0 +1,300 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+)
+
+// Minimal RADIUS (RFC 2865) and EAP over RADIUS (RFC 3579) encoding, enough to drive an
+// EAP-TLS exchange between the in-process test server and client.
+
+const (
+	radiusAccessRequest   = 1
+	radiusAccessAccept    = 2
+	radiusAccessReject    = 3
+	radiusAccessChallenge = 11
+
+	radiusAttrUserName             = 1
+	radiusAttrReplyMessage         = 18
+	radiusAttrState                = 24
+	radiusAttrEAPMessage           = 79
+	radiusAttrMessageAuthenticator = 80
+
+	radiusHeaderLength   = 20
+	radiusMaxPacket      = 4096
+	radiusMaxAttrPayload = 253
+)
+
+const (
+	eapRequest  = 1
+	eapResponse = 2
+	eapSuccess  = 3
+	eapFailure  = 4
+
+	eapTypeIdentity = 1
+	eapTypeNak      = 3
+	eapTypeTLS      = 13
+)
+
+const (
+	eapTLSFlagLength = 0x80
+	eapTLSFlagMore   = 0x40
+	eapTLSFlagStart  = 0x20
+
+	// eapTLSFragmentSize keeps every RADIUS packet comfortably below common path MTUs.
+	eapTLSFragmentSize = 1000
+)
+
+type radiusAttribute struct {
+	Type  byte
+	Value []byte
+}
+
+type radiusPacket struct {
+	Code          byte
+	Identifier    byte
+	Authenticator [16]byte
+	Attributes    []radiusAttribute
+}
+
+func (p *radiusPacket) attribute(attrType byte) []byte {
+	for _, attr := range p.Attributes {
+		if attr.Type == attrType {
+			return attr.Value
+		}
+	}
+	return nil
+}
+
+func (p *radiusPacket) addAttribute(attrType byte, value []byte) {
+	p.Attributes = append(p.Attributes, radiusAttribute{Type: attrType, Value: value})
+}
+
+// eapMessage reassembles the EAP packet carried in one or more EAP-Message attributes.
+func (p *radiusPacket) eapMessage() []byte {
+	var message []byte
+	for _, attr := range p.Attributes {
+		if attr.Type == radiusAttrEAPMessage {
+			message = append(message, attr.Value...)
+		}
+	}
+	return message
+}
+
+func (p *radiusPacket) setEAPMessage(message []byte) {
+	for len(message) > 0 {
+		n := min(len(message), radiusMaxAttrPayload)
+		p.addAttribute(radiusAttrEAPMessage, message[:n])
+		message = message[n:]
+	}
+}
+
+// marshal encodes the packet with a Message-Authenticator. Responses pass the authenticator of
+// the request they answer so the Response Authenticator can be computed.
+func (p *radiusPacket) marshal(secret []byte, requestAuthenticator *[16]byte) ([]byte, error) {
+	var attrs bytes.Buffer
+	for _, attr := range p.Attributes {
+		if len(attr.Value) > radiusMaxAttrPayload {
+			return nil, fmt.Errorf("RADIUS attribute %d is too long", attr.Type)
+		}
+		attrs.WriteByte(attr.Type)
+		attrs.WriteByte(byte(len(attr.Value) + 2))
+		attrs.Write(attr.Value)
+	}
+	attrs.Write([]byte{radiusAttrMessageAuthenticator, 18})
+	macOffset := radiusHeaderLength + attrs.Len()
+	attrs.Write(make([]byte, md5.Size))
+
+	length := radiusHeaderLength + attrs.Len()
+	if length > radiusMaxPacket {
+		return nil, fmt.Errorf("RADIUS packet exceeds %d bytes", radiusMaxPacket)
+	}
+	data := make([]byte, length)
+	data[0] = p.Code
+	data[1] = p.Identifier
+	binary.BigEndian.PutUint16(data[2:4], uint16(length))
+	if requestAuthenticator != nil {
+		copy(data[4:20], requestAuthenticator[:])
+	} else {
+		copy(data[4:20], p.Authenticator[:])
+	}
+	copy(data[radiusHeaderLength:], attrs.Bytes())
+
+	mac := hmac.New(md5.New, secret)
+	mac.Write(data)
+	copy(data[macOffset:], mac.Sum(nil))
+
+	if requestAuthenticator != nil {
+		sum := md5.Sum(append(append([]byte{}, data...), secret...))
+		copy(data[4:20], sum[:])
+		copy(p.Authenticator[:], sum[:])
+	}
+	return data, nil
+}
+
+// parseRADIUSPacket decodes and authenticates a packet. Responses are checked against the
+// authenticator of the request they answer.
+func parseRADIUSPacket(data, secret []byte, requestAuthenticator *[16]byte) (*radiusPacket, error) {
+	if len(data) < radiusHeaderLength {
+		return nil, fmt.Errorf("RADIUS packet too short")
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if length < radiusHeaderLength || length > len(data) {
+		return nil, fmt.Errorf("invalid RADIUS packet length %d", length)
+	}
+	data = data[:length]
+
+	packet := &radiusPacket{Code: data[0], Identifier: data[1]}
+	copy(packet.Authenticator[:], data[4:20])
+
+	macOffset := -1
+	for offset := radiusHeaderLength; offset < length; {
+		if offset+2 > length {
+			return nil, fmt.Errorf("truncated RADIUS attribute")
+		}
+		attrType, attrLength := data[offset], int(data[offset+1])
+		if attrLength < 2 || offset+attrLength > length {
+			return nil, fmt.Errorf("invalid RADIUS attribute length")
+		}
+		value := data[offset+2 : offset+attrLength]
+		if attrType == radiusAttrMessageAuthenticator {
+			if len(value) != md5.Size {
+				return nil, fmt.Errorf("invalid Message-Authenticator length")
+			}
+			macOffset = offset + 2
+		} else {
+			packet.addAttribute(attrType, value)
+		}
+		offset += attrLength
+	}
+
+	if requestAuthenticator != nil {
+		check := append([]byte{}, data...)
+		copy(check[4:20], requestAuthenticator[:])
+		sum := md5.Sum(append(check, secret...))
+		if !hmac.Equal(sum[:], data[4:20]) {
+			return nil, fmt.Errorf("invalid RADIUS response authenticator (shared secret mismatch?)")
+		}
+	}
+
+	if macOffset < 0 {
+		if packet.attribute(radiusAttrEAPMessage) != nil {
+			return nil, fmt.Errorf("EAP-Message without Message-Authenticator")
+		}
+		return packet, nil
+	}
+	check := append([]byte{}, data...)
+	copy(check[macOffset:macOffset+md5.Size], make([]byte, md5.Size))
+	if requestAuthenticator != nil {
+		copy(check[4:20], requestAuthenticator[:])
+	}
+	mac := hmac.New(md5.New, secret)
+	mac.Write(check)
+	if !hmac.Equal(mac.Sum(nil), data[macOffset:macOffset+md5.Size]) {
+		return nil, fmt.Errorf("invalid Message-Authenticator")
+	}
+	return packet, nil
+}
+
+type eapPacket struct {
+	Code       byte
+	Identifier byte
+	Type       byte
+	Data       []byte
+}
+
+func parseEAPPacket(data []byte) (eapPacket, error) {
+	if len(data) < 4 {
+		return eapPacket{}, fmt.Errorf("EAP packet too short")
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if length < 4 || length > len(data) {
+		return eapPacket{}, fmt.Errorf("invalid EAP packet length %d", length)
+	}
+	packet := eapPacket{Code: data[0], Identifier: data[1]}
+	if packet.Code == eapRequest || packet.Code == eapResponse {
+		if length < 5 {
+			return eapPacket{}, fmt.Errorf("EAP packet without type")
+		}
+		packet.Type = data[4]
+		packet.Data = data[5:length]
+	}
+	return packet, nil
+}
+
+func (p eapPacket) marshal() []byte {
+	if p.Code == eapSuccess || p.Code == eapFailure {
+		return []byte{p.Code, p.Identifier, 0, 4}
+	}
+	data := make([]byte, 5+len(p.Data))
+	data[0] = p.Code
+	data[1] = p.Identifier
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+	data[4] = p.Type
+	copy(data[5:], p.Data)
+	return data
+}
+
+// eapTLSStream fragments outgoing TLS records and reassembles incoming ones (RFC 5216 §2.1.5).
+type eapTLSStream struct {
+	outgoing      []byte
+	outgoingTotal int
+	sentFirst     bool
+	incoming      []byte
+}
+
+func (s *eapTLSStream) queue(data []byte) {
+	s.outgoing = data
+	s.outgoingTotal = len(data)
+	s.sentFirst = false
+}
+
+func (s *eapTLSStream) hasOutgoing() bool {
+	return len(s.outgoing) > 0
+}
+
+// nextFragment returns the EAP-TLS payload (flags, optional length, data) for the next fragment.
+func (s *eapTLSStream) nextFragment() []byte {
+	n := min(len(s.outgoing), eapTLSFragmentSize)
+	var flags byte
+	if n < len(s.outgoing) {
+		flags |= eapTLSFlagMore
+	}
+	payload := []byte{0}
+	if !s.sentFirst && flags&eapTLSFlagMore != 0 {
+		flags |= eapTLSFlagLength
+		payload = binary.BigEndian.AppendUint32(payload, uint32(s.outgoingTotal))
+	}
+	payload[0] = flags
+	payload = append(payload, s.outgoing[:n]...)
+	s.outgoing = s.outgoing[n:]
+	s.sentFirst = true
+	return payload
+}
+
+// receive appends an incoming fragment and reports whether the TLS message is complete. Empty
+// fragments are acknowledgements and carry no data.
+func (s *eapTLSStream) receive(payload []byte) (flags byte, complete bool, err error) {
+	if len(payload) == 0 {
+		return 0, false, fmt.Errorf("empty EAP-TLS payload")
+	}
+	flags = payload[0]
+	data := payload[1:]
+	if flags&eapTLSFlagLength != 0 {
+		if len(data) < 4 {
+			return 0, false, fmt.Errorf("truncated EAP-TLS length")
+		}
+		data = data[4:]
+	}
+	s.incoming = append(s.incoming, data...)
+	return flags, flags&eapTLSFlagMore == 0, nil
+}
+
+func (s *eapTLSStream) takeIncoming() []byte {
+	data := s.incoming
+	s.incoming = nil
+	return data
+}
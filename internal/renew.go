@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RenewOptions controls how RenewCertificate re-issues a certificate.
+type RenewOptions struct {
+	PFXPassword string
+	// RevokePrevious adds the certificate being renewed to its issuer's CRL as superseded.
+	RevokePrevious bool
+}
+
+// RenewResult describes the certificate issued by RenewCertificate.
+type RenewResult struct {
+	CertPath string
+	KeyPath  string
+	PFXPath  string
+	// CRLPath is set when the previous certificate was revoked.
+	CRLPath string
+}
+
+// RenewCertificate issues a new certificate with a fresh key pair from the CA that signed the
+// certificate at certPath, keeping its subject, subject alternative names, key type, key usages and
+// validity period. The new files replace the old ones in the issuer's certificate directory.
+// CA certificates are not renewed this way.
+func RenewCertificate(outputDir, certPath string, options RenewOptions) (RenewResult, error) {
+	cert, err := LoadCertificate(certPath)
+	if err != nil {
+		return RenewResult{}, err
+	}
+	if cert.IsCA {
+		return RenewResult{}, fmt.Errorf("CA certificates cannot be renewed; create a new CA instead")
+	}
+	ca, err := FindIssuingCA(outputDir, cert)
+	if err != nil {
+		return RenewResult{}, err
+	}
+
+	certOptions := DefaultCertificateOptions()
+	certOptions.KeyUsage = cert.KeyUsage
+	certOptions.ExtKeyUsage = cert.ExtKeyUsage
+	switch key := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		certOptions.KeyType = KeyTypeECDSAP256
+	case *rsa.PublicKey:
+		certOptions.KeyBits = NormalizeKeyBits(key.N.BitLen())
+	}
+	if inventory, err := LoadInventory(outputDir); err == nil {
+		if relPath, err := filepath.Rel(outputDir, PFXPathForCertificate(certPath)); err == nil {
+			if encoding := inventory[filepath.ToSlash(relPath)].PFXEncoding; encoding != "" {
+				certOptions.PFXEncoding = encoding
+			}
+		}
+	}
+	if certOptions.PFXEncoding == PFXEncodingPasswordless {
+		options.PFXPassword = ""
+	}
+
+	subject := Subject{CommonName: cert.Subject.CommonName}
+	subject.Organization = strings.Join(cert.Subject.Organization, " ")
+	subject.OrganizationalUnit = strings.Join(cert.Subject.OrganizationalUnit, " ")
+	subject.Country = strings.Join(cert.Subject.Country, " ")
+	subject.Province = strings.Join(cert.Subject.Province, " ")
+	subject.Locality = strings.Join(cert.Subject.Locality, " ")
+	if subject.CommonName == "" {
+		return RenewResult{}, fmt.Errorf("certificates without a common name cannot be renewed")
+	}
+	var sans []string
+	for _, name := range cert.DNSNames {
+		if !strings.EqualFold(name, subject.CommonName) {
+			sans = append(sans, name)
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		if ip.String() != subject.CommonName {
+			sans = append(sans, ip.String())
+		}
+	}
+
+	// Certificates from GenerateCertificateWithOptions start a day early; do not count that day.
+	validityDays := int(cert.NotAfter.Sub(cert.NotBefore).Round(24*time.Hour)/(24*time.Hour)) - 1
+	validityDays = max(validityDays, 1)
+
+	var result RenewResult
+	result.CertPath, result.KeyPath, result.PFXPath, err = GenerateCertificateWithOptions(outputDir, ca.Type, ca.RootName, ca.Name, subject, sans, validityDays, options.PFXPassword, certOptions)
+	if err != nil {
+		return RenewResult{}, err
+	}
+	if options.RevokePrevious {
+		result.CRLPath, err = RevokeIssuedCertificate(outputDir, cert, RevocationReasons["superseded"])
+		if err != nil {
+			return result, fmt.Errorf("renewed certificate, but failed to revoke the previous one: %w", err)
+		}
+	}
+	return result, nil
+}
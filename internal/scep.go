@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// reasonSuperseded is the CRL reason code recorded for certificates replaced by a re-enrollment.
+const reasonSuperseded = 4
+
+type SCEPDepotOptions struct {
+	// RevokeSuperseded revokes the previous certificates of a subject when it re-enrolls, even
+	// when the signer does not ask for it.
+	RevokeSuperseded bool
+	Logf             func(format string, args ...any)
+}
+
+// SCEPDepot is a depot for the micromdm/scep signer that stores enrollments with the other
+// certificates of the signing CA, keeping earlier certificates of a subject under their serial
+// numbers and recording each enrollment in the inventory.
+type SCEPDepot struct {
+	issuer  *CSRIssuer
+	options SCEPDepotOptions
+
+	mu sync.Mutex
+	// revokeOnPut holds the subjects whose signer asked HasCN to revoke their previous
+	// certificates; Put revokes them once the new certificate is stored.
+	revokeOnPut map[string]bool
+}
+
+func NewSCEPDepot(outputDir, issuerType, rootName, issuerName string, options SCEPDepotOptions) (*SCEPDepot, error) {
+	issuer, err := NewCSRIssuer(outputDir, issuerType, rootName, issuerName)
+	if err != nil {
+		return nil, err
+	}
+	if options.Logf == nil {
+		options.Logf = func(string, ...any) {}
+	}
+	return &SCEPDepot{issuer: issuer, options: options, revokeOnPut: make(map[string]bool)}, nil
+}
+
+// CA returns the signing CA first, followed by its parents.
+func (d *SCEPDepot) CA(pass []byte) ([]*x509.Certificate, *rsa.PrivateKey, error) {
+	return d.issuer.Chain(), d.issuer.issuer.key, nil
+}
+
+func (d *SCEPDepot) Serial() (*big.Int, error) {
+	return GenerateSerialNumber(), nil
+}
+
+// HasCN reports whether valid certificates with the subject of cert were already issued. Like the
+// micromdm file depot, it refuses the enrollment while such a certificate is valid for more than
+// allowTime days (unless allowTime is 0). When revokeOldCertificate is set, they are revoked by Put
+// after the new certificate is stored, so a failed enrollment leaves them valid.
+func (d *SCEPDepot) HasCN(cn string, allowTime int, cert *x509.Certificate, revokeOldCertificate bool) (bool, error) {
+	existing, err := d.validCertificates(cert)
+	if err != nil || len(existing) == 0 {
+		return false, err
+	}
+	renewableAfter := time.Now().AddDate(0, 0, allowTime)
+	for _, previous := range existing {
+		if allowTime > 0 && previous.NotAfter.After(renewableAfter) {
+			return true, fmt.Errorf("%s already has a certificate valid until %s; renewal opens %d days before expiry",
+				previous.Subject, previous.NotAfter.UTC().Format(time.RFC3339), allowTime)
+		}
+	}
+	if revokeOldCertificate {
+		d.mu.Lock()
+		d.revokeOnPut[cert.Subject.String()] = true
+		d.mu.Unlock()
+	}
+	return true, nil
+}
+
+// Put stores an issued certificate and then revokes the certificates it supersedes, when the
+// signer or RevokeSuperseded asks for it.
+func (d *SCEPDepot) Put(name string, crt *x509.Certificate) error {
+	subject := crt.Subject.String()
+	d.mu.Lock()
+	revoke := d.revokeOnPut[subject] || d.options.RevokeSuperseded
+	delete(d.revokeOnPut, subject)
+	d.mu.Unlock()
+
+	certPath, err := d.issuer.store(crt, InventorySourceSCEP)
+	if err != nil {
+		return fmt.Errorf("failed to store certificate: %w", err)
+	}
+	d.options.Logf("stored certificate %s (serial %s) at %s", crt.Subject, crt.SerialNumber.Text(16), certPath)
+	if revoke {
+		d.revokeSuperseded(crt)
+	}
+	return nil
+}
+
+// revokeSuperseded revokes the other valid certificates with the subject of crt. Failures are
+// logged and audited rather than returned: the new certificate is already stored and is what the
+// client receives.
+func (d *SCEPDepot) revokeSuperseded(crt *x509.Certificate) {
+	existing, err := d.validCertificates(crt)
+	if err != nil {
+		d.options.Logf("failed to find certificates superseded by %s: %v", crt.Subject, err)
+		return
+	}
+	for _, previous := range existing {
+		if previous.SerialNumber.Cmp(crt.SerialNumber) == 0 {
+			continue
+		}
+		crlPath, err := d.issuer.Revoke(previous, reasonSuperseded)
+		if errors.Is(err, ErrAlreadyRevoked) {
+			continue
+		}
+		if auditErr := RecordAudit(d.issuer.outputDir, AuditEvent{
+			Actor:  "scep",
+			Action: AuditRevokeCertificate,
+			Target: previous.Subject.String(),
+			Params: map[string]string{"serial": previous.SerialNumber.Text(16), "reason": "superseded"},
+			Err:    err,
+		}); auditErr != nil {
+			d.options.Logf("failed to audit revocation: %v", auditErr)
+		}
+		if err != nil {
+			d.options.Logf("failed to revoke superseded certificate %s (serial %s): %v", previous.Subject, previous.SerialNumber.Text(16), err)
+			continue
+		}
+		d.options.Logf("revoked superseded certificate %s (serial %s) in %s", previous.Subject, previous.SerialNumber.Text(16), crlPath)
+	}
+}
+
+// validCertificates returns the unexpired, unrevoked certificates this CA issued for the subject of
+// cert, including earlier ones kept under their serial numbers.
+func (d *SCEPDepot) validCertificates(cert *x509.Certificate) ([]*x509.Certificate, error) {
+	issuer := d.issuer.issuer
+	paths, err := filepath.Glob(filepath.Join(issuer.certDir, fmt.Sprintf("cert_%s*.pem", certificateFileName(cert))))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var valid []*x509.Certificate
+	for _, path := range paths {
+		previous, err := LoadCertificate(path)
+		if err != nil || previous.Subject.String() != cert.Subject.String() {
+			continue
+		}
+		if previous.CheckSignatureFrom(issuer.cert) != nil || now.After(previous.NotAfter) || isRevoked(issuer, previous.SerialNumber) {
+			continue
+		}
+		valid = append(valid, previous)
+	}
+	return valid, nil
+}
@@ -0,0 +1,243 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	scepserver "github.com/micromdm/scep/v2/server"
+	"github.com/smallstep/pkcs7"
+	"github.com/smallstep/scep"
+	"github.com/smallstep/scep/x509util"
+)
+
+// SCEP message attributes (RFC 8894 section 3.2.1).
+var (
+	oidSCEPMessageType    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+	oidSCEPPKIStatus      = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+	oidSCEPFailInfo       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+	oidSCEPSenderNonce    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+	oidSCEPRecipientNonce = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+	oidSCEPTransactionID  = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+)
+
+type SCEPApprovalOptions struct {
+	// Cert and Key decrypt requests and sign responses: the RA when one is configured, otherwise
+	// the signing CA.
+	Cert *x509.Certificate
+	Key  *rsa.PrivateKey
+	// Signer issues approved requests. Challenges are checked by Validator before queueing, so
+	// Signer should not check them again.
+	Signer    scepserver.CSRSignerContext
+	Validator SCEPChallengeValidator
+	Queue     *SCEPRequestQueue
+	Logf      func(format string, args ...any)
+	// OnEvent is called when a request is queued.
+	OnEvent func(SCEPEvent)
+}
+
+// SCEPApprovalService answers enrollment requests with PENDING and queues them until they are
+// approved or rejected. Clients learn the outcome from their next GetCertInitial poll or from
+// resending the original request, both of which carry the original transaction ID. The other
+// operations are served by the wrapped service.
+type SCEPApprovalService struct {
+	scepserver.Service
+	options SCEPApprovalOptions
+	// issueMutex keeps concurrent polls from issuing an approved request twice.
+	issueMutex sync.Mutex
+}
+
+func NewSCEPApprovalService(next scepserver.Service, options SCEPApprovalOptions) *SCEPApprovalService {
+	if options.Logf == nil {
+		options.Logf = func(string, ...any) {}
+	}
+	if options.OnEvent == nil {
+		options.OnEvent = func(SCEPEvent) {}
+	}
+	return &SCEPApprovalService{Service: next, options: options}
+}
+
+// scepPKIMessage is a verified SCEP request. scep.ParsePKIMessage does not support CertPoll, so
+// requests are parsed here.
+type scepPKIMessage struct {
+	p7            *pkcs7.PKCS7
+	messageType   scep.MessageType
+	transactionID scep.TransactionID
+	senderNonce   scep.SenderNonce
+}
+
+func parseSCEPPKIMessage(data []byte) (*scepPKIMessage, error) {
+	p7, err := pkcs7.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := p7.Verify(); err != nil {
+		return nil, err
+	}
+	msg := &scepPKIMessage{p7: p7}
+	if err := p7.UnmarshalSignedAttribute(oidSCEPMessageType, &msg.messageType); err != nil {
+		return nil, err
+	}
+	if err := p7.UnmarshalSignedAttribute(oidSCEPTransactionID, &msg.transactionID); err != nil {
+		return nil, err
+	}
+	if err := p7.UnmarshalSignedAttribute(oidSCEPSenderNonce, &msg.senderNonce); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (s *SCEPApprovalService) PKIOperation(ctx context.Context, data []byte) ([]byte, error) {
+	msg, err := parseSCEPPKIMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	request, err := s.options.Queue.find(string(msg.transactionID))
+	if err != nil {
+		return nil, err
+	}
+
+	switch msg.messageType {
+	case scep.PKCSReq, scep.RenewalReq, scep.UpdateReq:
+		if request == nil {
+			return s.enqueue(ctx, msg)
+		}
+	case scep.CertPoll:
+		if request == nil {
+			s.options.Logf("poll for unknown transaction %s", msg.transactionID)
+			return s.certRep(msg, scep.FAILURE, scep.BadCertID, nil)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported SCEP message type %s", msg.messageType)
+	}
+	return s.respond(ctx, msg, *request)
+}
+
+func (s *SCEPApprovalService) enqueue(ctx context.Context, msg *scepPKIMessage) ([]byte, error) {
+	envelope, err := pkcs7.Parse(msg.p7.Content)
+	if err != nil {
+		return nil, err
+	}
+	der, err := envelope.Decrypt(s.options.Cert, s.options.Key)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSR: %w", err)
+	}
+	password, err := x509util.ParseChallengePassword(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse challenge password: %w", err)
+	}
+	if err := s.options.Validator.Validate(ctx, password, csr); err != nil {
+		return s.certRep(msg, scep.FAILURE, scep.BadRequest, nil)
+	}
+
+	request, err := s.options.Queue.add(string(msg.transactionID), csr)
+	if err != nil {
+		return nil, err
+	}
+	s.options.Logf("queued request %s from %s for approval", request.ID, request.Subject)
+	s.options.OnEvent(SCEPEvent{Time: time.Now(), Action: SCEPEventQueued, Subject: request.Subject, Detail: "request " + request.ID, Source: scepSource(ctx)})
+	return s.certRep(msg, scep.PENDING, "", nil)
+}
+
+func (s *SCEPApprovalService) respond(ctx context.Context, msg *scepPKIMessage, request SCEPRequest) ([]byte, error) {
+	switch request.Status {
+	case SCEPRequestStatusPending:
+		return s.certRep(msg, scep.PENDING, "", nil)
+	case SCEPRequestStatusApproved:
+		cert, err := s.issue(ctx, request)
+		if err != nil {
+			return s.certRep(msg, scep.FAILURE, scep.BadRequest, nil)
+		}
+		return s.certRep(msg, scep.SUCCESS, "", cert)
+	case SCEPRequestStatusIssued:
+		cert, err := request.certificate()
+		if err != nil {
+			return nil, err
+		}
+		return s.certRep(msg, scep.SUCCESS, "", cert)
+	default:
+		return s.certRep(msg, scep.FAILURE, scep.BadRequest, nil)
+	}
+}
+
+func (s *SCEPApprovalService) issue(ctx context.Context, request SCEPRequest) (*x509.Certificate, error) {
+	s.issueMutex.Lock()
+	defer s.issueMutex.Unlock()
+	current, err := s.options.Queue.find(request.TransactionID)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.Status == SCEPRequestStatusIssued {
+		return current.certificate()
+	}
+
+	csr, err := request.certificateRequest()
+	if err != nil {
+		return nil, err
+	}
+	cert, signErr := s.options.Signer.SignCSRContext(ctx, &scep.CSRReqMessage{CSR: csr})
+	if signErr == nil && cert == nil {
+		signErr = errors.New("no signed certificate")
+	}
+	if err := s.options.Queue.complete(request.ID, cert, signErr); err != nil {
+		return nil, err
+	}
+	if signErr != nil {
+		s.options.Logf("failed to issue approved request %s: %v", request.ID, signErr)
+		return nil, signErr
+	}
+	s.options.Logf("issued approved request %s for %s (serial %s)", request.ID, cert.Subject, cert.SerialNumber.Text(16))
+	return cert, nil
+}
+
+// certRep builds the signed CertRep answering msg. A successful response carries cert encrypted to
+// the certificates the client signed msg with.
+func (s *SCEPApprovalService) certRep(msg *scepPKIMessage, status scep.PKIStatus, failInfo scep.FailInfo, cert *x509.Certificate) ([]byte, error) {
+	senderNonce := make([]byte, 16)
+	if _, err := rand.Read(senderNonce); err != nil {
+		return nil, err
+	}
+	attributes := []pkcs7.Attribute{
+		{Type: oidSCEPTransactionID, Value: msg.transactionID},
+		{Type: oidSCEPPKIStatus, Value: status},
+		{Type: oidSCEPMessageType, Value: scep.CertRep},
+		{Type: oidSCEPSenderNonce, Value: scep.SenderNonce(senderNonce)},
+		{Type: oidSCEPRecipientNonce, Value: scep.RecipientNonce(msg.senderNonce)},
+	}
+	if status == scep.FAILURE {
+		attributes = append(attributes, pkcs7.Attribute{Type: oidSCEPFailInfo, Value: failInfo})
+	}
+
+	var content []byte
+	if cert != nil {
+		degenerate, err := pkcs7.DegenerateCertificate(cert.Raw)
+		if err != nil {
+			return nil, err
+		}
+		if content, err = pkcs7.Encrypt(degenerate, msg.p7.Certificates); err != nil {
+			return nil, err
+		}
+	}
+	signedData, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil {
+		// Clients expect the issued certificate first.
+		signedData.AddCertificate(cert)
+	}
+	if err := signedData.AddSigner(s.options.Cert, s.options.Key, pkcs7.SignerInfoConfig{ExtraSignedAttributes: attributes}); err != nil {
+		return nil, err
+	}
+	return signedData.Finish()
+}
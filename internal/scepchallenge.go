@@ -0,0 +1,345 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	scepChallengesPath          = "scep/challenges.json"
+	DefaultSCEPChallengeTTL     = time.Hour
+	defaultSCEPValidatorTimeout = 10 * time.Second
+
+	SCEPChallengeModeStatic  = "static"
+	SCEPChallengeModeDynamic = "dynamic"
+	SCEPChallengeModeWebhook = "webhook"
+	SCEPChallengeModeExec    = "exec"
+
+	SCEPChallengeStatusActive  = "active"
+	SCEPChallengeStatusUsed    = "used"
+	SCEPChallengeStatusExpired = "expired"
+)
+
+var (
+	SCEPChallengeModes = []string{SCEPChallengeModeStatic, SCEPChallengeModeDynamic, SCEPChallengeModeWebhook, SCEPChallengeModeExec}
+
+	errInvalidSCEPChallenge = errors.New("invalid challenge")
+	scepChallengeMutex      sync.Mutex
+)
+
+// SCEPChallengeValidator decides whether the challenge password of a SCEP request authorizes its CSR.
+type SCEPChallengeValidator interface {
+	Validate(ctx context.Context, password string, csr *x509.CertificateRequest) error
+}
+
+// StaticSCEPChallenge accepts a single shared password for every request.
+type StaticSCEPChallenge string
+
+func (c StaticSCEPChallenge) Validate(_ context.Context, password string, _ *x509.CertificateRequest) error {
+	if subtle.ConstantTimeCompare([]byte(c), []byte(password)) != 1 {
+		return errInvalidSCEPChallenge
+	}
+	return nil
+}
+
+// SCEPChallenge is a one-time challenge password, optionally bound to the common name and SANs
+// the CSR must carry. A CSR for a challenge with bound SANs must request exactly those SANs; one
+// bound to a common name only may request no SAN other than that name.
+type SCEPChallenge struct {
+	ID         string    `json:"id"`
+	Password   string    `json:"password"`
+	CommonName string    `json:"common_name,omitempty"`
+	SANs       []string  `json:"sans,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UsedAt     time.Time `json:"used_at,omitzero"`
+	UsedBy     string    `json:"used_by,omitempty"`
+}
+
+func (c SCEPChallenge) Status(now time.Time) string {
+	switch {
+	case !c.UsedAt.IsZero():
+		return SCEPChallengeStatusUsed
+	case now.After(c.ExpiresAt):
+		return SCEPChallengeStatusExpired
+	default:
+		return SCEPChallengeStatusActive
+	}
+}
+
+// Binding lists the common name and SANs the challenge is restricted to, or "-" for none.
+func (c SCEPChallenge) Binding() string {
+	binding := slices.DeleteFunc(append([]string{c.CommonName}, c.SANs...), func(value string) bool { return value == "" })
+	if len(binding) == 0 {
+		return "-"
+	}
+	return strings.Join(binding, ", ")
+}
+
+type SCEPChallengeOptions struct {
+	TTL        time.Duration
+	CommonName string
+	SANs       []string
+}
+
+// SCEPChallengeStore keeps one-time challenge passwords in scep/challenges.json in the output
+// directory, so challenges created by the CLI or the dashboard are seen by a running server.
+type SCEPChallengeStore struct {
+	outputDir string
+}
+
+func NewSCEPChallengeStore(outputDir string) *SCEPChallengeStore {
+	return &SCEPChallengeStore{outputDir: outputDir}
+}
+
+func (s *SCEPChallengeStore) Create(options SCEPChallengeOptions) (SCEPChallenge, error) {
+	if options.TTL <= 0 {
+		options.TTL = DefaultSCEPChallengeTTL
+	}
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return SCEPChallenge{}, err
+	}
+	now := time.Now().UTC()
+	challenge := SCEPChallenge{
+		ID:         hex.EncodeToString(id),
+		Password:   rand.Text(),
+		CommonName: strings.TrimSpace(options.CommonName),
+		SANs:       options.SANs,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(options.TTL),
+	}
+
+	scepChallengeMutex.Lock()
+	defer scepChallengeMutex.Unlock()
+	challenges, err := s.read()
+	if err != nil {
+		return SCEPChallenge{}, err
+	}
+	challenges = append(challenges, challenge)
+	return challenge, s.write(challenges)
+}
+
+// List returns the challenges, newest first.
+func (s *SCEPChallengeStore) List() ([]SCEPChallenge, error) {
+	scepChallengeMutex.Lock()
+	defer scepChallengeMutex.Unlock()
+	challenges, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(challenges, func(i, j int) bool {
+		return challenges[i].CreatedAt.After(challenges[j].CreatedAt)
+	})
+	return challenges, nil
+}
+
+func (s *SCEPChallengeStore) Delete(id string) error {
+	scepChallengeMutex.Lock()
+	defer scepChallengeMutex.Unlock()
+	challenges, err := s.read()
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(challenges, func(c SCEPChallenge) bool { return c.ID == id })
+	if index < 0 {
+		return fmt.Errorf("challenge %q not found", id)
+	}
+	return s.write(slices.Delete(challenges, index, index+1))
+}
+
+// Validate accepts an active challenge whose bindings match the CSR and marks it as used.
+func (s *SCEPChallengeStore) Validate(_ context.Context, password string, csr *x509.CertificateRequest) error {
+	scepChallengeMutex.Lock()
+	defer scepChallengeMutex.Unlock()
+	challenges, err := s.read()
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(challenges, func(c SCEPChallenge) bool {
+		return subtle.ConstantTimeCompare([]byte(c.Password), []byte(password)) == 1
+	})
+	if index < 0 {
+		return errInvalidSCEPChallenge
+	}
+	challenge := &challenges[index]
+	now := time.Now().UTC()
+	if status := challenge.Status(now); status != SCEPChallengeStatusActive {
+		return fmt.Errorf("challenge %s is %s", challenge.ID, status)
+	}
+	if challenge.CommonName != "" && !strings.EqualFold(challenge.CommonName, csr.Subject.CommonName) {
+		return fmt.Errorf("challenge %s is bound to common name %q, not %q", challenge.ID, challenge.CommonName, csr.Subject.CommonName)
+	}
+	// Bound SANs must all be requested, and nothing else may be requested alongside them; a
+	// challenge bound to a common name only allows that name as a SAN.
+	allowed := challenge.SANs
+	if len(allowed) == 0 && challenge.CommonName != "" {
+		allowed = []string{challenge.CommonName}
+	}
+	requested := csrSANs(csr)
+	for _, san := range challenge.SANs {
+		if !slices.ContainsFunc(requested, func(value string) bool { return strings.EqualFold(value, san) }) {
+			return fmt.Errorf("challenge %s requires subject alternative name %q", challenge.ID, san)
+		}
+	}
+	if len(allowed) > 0 {
+		for _, san := range requested {
+			if !slices.ContainsFunc(allowed, func(value string) bool { return strings.EqualFold(value, san) }) {
+				return fmt.Errorf("challenge %s does not allow subject alternative name %q", challenge.ID, san)
+			}
+		}
+	}
+	challenge.UsedAt = now
+	challenge.UsedBy = csr.Subject.String()
+	return s.write(challenges)
+}
+
+func (s *SCEPChallengeStore) read() ([]SCEPChallenge, error) {
+	data, err := os.ReadFile(filepath.Join(s.outputDir, scepChallengesPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var challenges []SCEPChallenge
+	if err := json.Unmarshal(data, &challenges); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", scepChallengesPath, err)
+	}
+	return challenges, nil
+}
+
+func (s *SCEPChallengeStore) write(challenges []SCEPChallenge) error {
+	path := filepath.Join(s.outputDir, scepChallengesPath)
+	if err := ensureParentDir(path); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(challenges, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// scepChallengeRequest describes a SCEP request to external validators.
+type scepChallengeRequest struct {
+	Challenge  string   `json:"challenge"`
+	Subject    string   `json:"subject"`
+	CommonName string   `json:"common_name"`
+	SANs       []string `json:"sans"`
+	CSR        string   `json:"csr"`
+}
+
+func newSCEPChallengeRequest(password string, csr *x509.CertificateRequest) scepChallengeRequest {
+	return scepChallengeRequest{
+		Challenge:  password,
+		Subject:    csr.Subject.String(),
+		CommonName: csr.Subject.CommonName,
+		SANs:       csrSANs(csr),
+		CSR:        string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw})),
+	}
+}
+
+// SCEPChallengeWebhook POSTs every request as JSON to URL and accepts it on a 2xx response. The
+// body of any other response is reported as the reason.
+type SCEPChallengeWebhook struct {
+	URL    string
+	Client *http.Client
+}
+
+func (v SCEPChallengeWebhook) Validate(ctx context.Context, password string, csr *x509.CertificateRequest) error {
+	body, err := json.Marshal(newSCEPChallengeRequest(password, csr))
+	if err != nil {
+		return err
+	}
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultSCEPValidatorTimeout}
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, v.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("challenge webhook failed: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+	reason, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	return fmt.Errorf("challenge rejected by webhook (%s): %s", response.Status, strings.TrimSpace(string(reason)))
+}
+
+// SCEPChallengeCommand runs Command (split on spaces, no shell) for every request with the CSR as
+// PEM on stdin and the request details in CERT_HELPER_SCEP_* environment variables. Exit status 0
+// accepts the request; the output of a failing command is reported as the reason.
+type SCEPChallengeCommand struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (v SCEPChallengeCommand) Validate(ctx context.Context, password string, csr *x509.CertificateRequest) error {
+	args := strings.Fields(v.Command)
+	if len(args) == 0 {
+		return fmt.Errorf("challenge command is empty")
+	}
+	timeout := v.Timeout
+	if timeout <= 0 {
+		timeout = defaultSCEPValidatorTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	request := newSCEPChallengeRequest(password, csr)
+	command := exec.CommandContext(ctx, args[0], args[1:]...)
+	command.Stdin = strings.NewReader(request.CSR)
+	command.Env = append(os.Environ(),
+		"CERT_HELPER_SCEP_CHALLENGE="+request.Challenge,
+		"CERT_HELPER_SCEP_SUBJECT="+request.Subject,
+		"CERT_HELPER_SCEP_COMMON_NAME="+request.CommonName,
+		"CERT_HELPER_SCEP_SANS="+strings.Join(request.SANs, ","),
+	)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("challenge rejected by %s (%v): %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func csrSANs(csr *x509.CertificateRequest) []string {
+	var sans []string
+	sans = append(sans, csr.DNSNames...)
+	for _, ip := range csr.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, csr.EmailAddresses...)
+	for _, uri := range csr.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
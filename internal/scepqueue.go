@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	scepRequestsPath = "scep/requests.json"
+
+	SCEPRequestStatusPending  = "pending"
+	SCEPRequestStatusApproved = "approved"
+	SCEPRequestStatusRejected = "rejected"
+	SCEPRequestStatusIssued   = "issued"
+	SCEPRequestStatusFailed   = "failed"
+)
+
+var scepRequestMutex sync.Mutex
+
+// SCEPRequest is an enrollment held for manual approval. The client keeps polling with the same
+// transaction ID until the request is issued, rejected or failed.
+type SCEPRequest struct {
+	ID            string    `json:"id"`
+	TransactionID string    `json:"transaction_id"`
+	Subject       string    `json:"subject"`
+	CommonName    string    `json:"common_name,omitempty"`
+	SANs          []string  `json:"sans,omitempty"`
+	CSR           string    `json:"csr"`
+	Status        string    `json:"status"`
+	Reason        string    `json:"reason,omitempty"`
+	Certificate   string    `json:"certificate,omitempty"`
+	ReceivedAt    time.Time `json:"received_at"`
+	DecidedAt     time.Time `json:"decided_at,omitzero"`
+}
+
+func (r SCEPRequest) certificateRequest() (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(r.CSR))
+	if block == nil {
+		return nil, fmt.Errorf("request %s has no CSR", r.ID)
+	}
+	return x509.ParseCertificateRequest(block.Bytes)
+}
+
+func (r SCEPRequest) certificate() (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(r.Certificate))
+	if block == nil {
+		return nil, fmt.Errorf("request %s has no certificate", r.ID)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// SCEPRequestQueue keeps the requests awaiting approval in scep/requests.json in the output
+// directory, so decisions made by the CLI or the dashboard are seen by a running server.
+type SCEPRequestQueue struct {
+	outputDir string
+}
+
+func NewSCEPRequestQueue(outputDir string) *SCEPRequestQueue {
+	return &SCEPRequestQueue{outputDir: outputDir}
+}
+
+// List returns the queued requests, newest first.
+func (q *SCEPRequestQueue) List() ([]SCEPRequest, error) {
+	scepRequestMutex.Lock()
+	defer scepRequestMutex.Unlock()
+	requests, err := q.read()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].ReceivedAt.After(requests[j].ReceivedAt)
+	})
+	return requests, nil
+}
+
+func (q *SCEPRequestQueue) Approve(id string) (SCEPRequest, error) {
+	return q.decide(id, SCEPRequestStatusApproved, "")
+}
+
+func (q *SCEPRequestQueue) Reject(id, reason string) (SCEPRequest, error) {
+	return q.decide(id, SCEPRequestStatusRejected, strings.TrimSpace(reason))
+}
+
+func (q *SCEPRequestQueue) Delete(id string) error {
+	scepRequestMutex.Lock()
+	defer scepRequestMutex.Unlock()
+	requests, err := q.read()
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(requests, func(r SCEPRequest) bool { return r.ID == id })
+	if index < 0 {
+		return fmt.Errorf("request %q not found", id)
+	}
+	return q.write(slices.Delete(requests, index, index+1))
+}
+
+func (q *SCEPRequestQueue) decide(id, status, reason string) (SCEPRequest, error) {
+	scepRequestMutex.Lock()
+	defer scepRequestMutex.Unlock()
+	requests, err := q.read()
+	if err != nil {
+		return SCEPRequest{}, err
+	}
+	index := slices.IndexFunc(requests, func(r SCEPRequest) bool { return r.ID == id })
+	if index < 0 {
+		return SCEPRequest{}, fmt.Errorf("request %q not found", id)
+	}
+	request := &requests[index]
+	if request.Status != SCEPRequestStatusPending {
+		return SCEPRequest{}, fmt.Errorf("request %s is already %s", request.ID, request.Status)
+	}
+	request.Status = status
+	request.Reason = reason
+	request.DecidedAt = time.Now().UTC()
+	return *request, q.write(requests)
+}
+
+// find returns the request with the transaction ID, or nil when none was queued.
+func (q *SCEPRequestQueue) find(transactionID string) (*SCEPRequest, error) {
+	scepRequestMutex.Lock()
+	defer scepRequestMutex.Unlock()
+	requests, err := q.read()
+	if err != nil {
+		return nil, err
+	}
+	index := slices.IndexFunc(requests, func(r SCEPRequest) bool { return r.TransactionID == transactionID })
+	if index < 0 {
+		return nil, nil
+	}
+	return &requests[index], nil
+}
+
+func (q *SCEPRequestQueue) add(transactionID string, csr *x509.CertificateRequest) (SCEPRequest, error) {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return SCEPRequest{}, err
+	}
+	request := SCEPRequest{
+		ID:            hex.EncodeToString(id),
+		TransactionID: transactionID,
+		Subject:       csr.Subject.String(),
+		CommonName:    csr.Subject.CommonName,
+		SANs:          csrSANs(csr),
+		CSR:           string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw})),
+		Status:        SCEPRequestStatusPending,
+		ReceivedAt:    time.Now().UTC(),
+	}
+
+	scepRequestMutex.Lock()
+	defer scepRequestMutex.Unlock()
+	requests, err := q.read()
+	if err != nil {
+		return SCEPRequest{}, err
+	}
+	requests = append(requests, request)
+	return request, q.write(requests)
+}
+
+// complete records the outcome of signing an approved request.
+func (q *SCEPRequestQueue) complete(id string, cert *x509.Certificate, signErr error) error {
+	scepRequestMutex.Lock()
+	defer scepRequestMutex.Unlock()
+	requests, err := q.read()
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(requests, func(r SCEPRequest) bool { return r.ID == id })
+	if index < 0 {
+		return fmt.Errorf("request %q not found", id)
+	}
+	request := &requests[index]
+	if signErr != nil {
+		request.Status = SCEPRequestStatusFailed
+		request.Reason = signErr.Error()
+	} else {
+		request.Status = SCEPRequestStatusIssued
+		request.Certificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	}
+	return q.write(requests)
+}
+
+func (q *SCEPRequestQueue) read() ([]SCEPRequest, error) {
+	data, err := os.ReadFile(filepath.Join(q.outputDir, scepRequestsPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var requests []SCEPRequest
+	if err := json.Unmarshal(data, &requests); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", scepRequestsPath, err)
+	}
+	return requests, nil
+}
+
+func (q *SCEPRequestQueue) write(requests []SCEPRequest) error {
+	path := filepath.Join(q.outputDir, scepRequestsPath)
+	if err := ensureParentDir(path); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(requests, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
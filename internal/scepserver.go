@@ -0,0 +1,290 @@
+package internal
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	scepdepot "github.com/micromdm/scep/v2/depot"
+	scepserver "github.com/micromdm/scep/v2/server"
+	"github.com/smallstep/scep"
+)
+
+const (
+	DefaultSCEPChallenge = "very-secure-challenge"
+
+	SCEPEventIssued   = "issued"
+	SCEPEventRejected = "rejected"
+	SCEPEventFailed   = "failed"
+	SCEPEventQueued   = "queued"
+)
+
+type SCEPServerOptions struct {
+	IssuerType string
+	RootName   string
+	IssuerName string
+	// RACertPath is relative to the output directory. Its RSA key must sit next to it.
+	RACertPath       string
+	ValidityDays     int
+	AllowRenewalDays int
+	RevokeSuperseded bool
+
+	ChallengeMode    string
+	Challenge        string
+	ChallengeWebhook string
+	ChallengeExec    string
+	ManualApproval   bool
+
+	Logger log.Logger
+	// OnEvent is called for every enrollment outcome.
+	OnEvent func(SCEPEvent)
+}
+
+// SCEPEvent describes the outcome of an enrollment request.
+type SCEPEvent struct {
+	Time    time.Time
+	Action  string
+	Subject string
+	Detail  string
+	// Source is the address of the enrolling client.
+	Source string
+}
+
+type scepSourceContextKey struct{}
+
+// scepSource returns the client address ServeHTTP stored in the request context.
+func scepSource(ctx context.Context) string {
+	source, _ := ctx.Value(scepSourceContextKey{}).(string)
+	return source
+}
+
+// SCEPServer serves the SCEP protocol at /scep, signing with the selected CA.
+type SCEPServer struct {
+	handler     http.Handler
+	options     SCEPServerOptions
+	chain       []*x509.Certificate
+	serviceCert *x509.Certificate
+}
+
+func NewSCEPServer(outputDir string, options SCEPServerOptions) (*SCEPServer, error) {
+	if options.Logger == nil {
+		options.Logger = log.NewNopLogger()
+	}
+	if options.OnEvent == nil {
+		options.OnEvent = func(SCEPEvent) {}
+	}
+	onEvent := options.OnEvent
+	if options.ValidityDays <= 0 {
+		options.ValidityDays = 365
+	}
+	if options.ChallengeMode == "" {
+		options.ChallengeMode = SCEPChallengeModeStatic
+	}
+	if options.IssuerType == "intermediate" && options.RootName == "" {
+		options.RootName = "default"
+	}
+	logger := options.Logger
+	issuerSelector := "root:" + options.IssuerName
+	if options.IssuerType == "intermediate" {
+		issuerSelector = "intermediate:" + options.RootName + ":" + options.IssuerName
+	}
+	// Every enrollment outcome is audited before it is reported to the caller.
+	options.OnEvent = func(event SCEPEvent) {
+		recordSCEPAudit(outputDir, issuerSelector, event, logger)
+		onEvent(event)
+	}
+
+	depot, err := NewSCEPDepot(outputDir, options.IssuerType, options.RootName, options.IssuerName, SCEPDepotOptions{
+		RevokeSuperseded: options.RevokeSuperseded,
+		Logf: func(format string, args ...any) {
+			_ = logger.Log("component", "depot", "msg", fmt.Sprintf(format, args...))
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing CA: %w", err)
+	}
+	chain, caKey, _ := depot.CA(nil)
+
+	// SCEP messages are encrypted to and signed by the RA when one is configured, otherwise by the CA.
+	serviceCert, serviceKey := chain[0], caKey
+	caCerts := chain[1:]
+	if options.RACertPath != "" {
+		serviceCert, serviceKey, err = loadSCEPRACertificate(outputDir, options.RACertPath, chain)
+		if err != nil {
+			return nil, err
+		}
+		caCerts = chain
+	}
+
+	validator, err := NewSCEPChallengeValidator(outputDir, options)
+	if err != nil {
+		return nil, err
+	}
+	validator = scepEventValidator{next: validator, onEvent: options.OnEvent, logger: logger}
+	signer := scepEventSigner(scepserver.SignCSRAdapter(scepdepot.NewSigner(depot,
+		scepdepot.WithValidityDays(options.ValidityDays),
+		scepdepot.WithAllowRenewalDays(options.AllowRenewalDays),
+	)), options.OnEvent)
+
+	serviceOptions := []scepserver.ServiceOption{scepserver.WithLogger(logger)}
+	for _, caCert := range caCerts {
+		serviceOptions = append(serviceOptions, scepserver.WithAddlCA(caCert))
+	}
+	svc, err := scepserver.NewService(serviceCert, serviceKey, scepChallengeSigner(validator, signer), serviceOptions...)
+	if err != nil {
+		return nil, err
+	}
+	if options.ManualApproval {
+		svc = NewSCEPApprovalService(svc, SCEPApprovalOptions{
+			Cert:      serviceCert,
+			Key:       serviceKey,
+			Signer:    signer,
+			Validator: validator,
+			Queue:     NewSCEPRequestQueue(outputDir),
+			Logf: func(format string, args ...any) {
+				_ = logger.Log("component", "approval", "msg", fmt.Sprintf(format, args...))
+			},
+			OnEvent: options.OnEvent,
+		})
+	}
+
+	return &SCEPServer{
+		handler:     scepserver.MakeHTTPHandler(scepserver.MakeServerEndpoints(svc), svc, log.With(logger, "component", "http")),
+		options:     options,
+		chain:       chain,
+		serviceCert: serviceCert,
+	}, nil
+}
+
+func (s *SCEPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scepSourceContextKey{}, RemoteIP(r))))
+}
+
+func (s *SCEPServer) Options() SCEPServerOptions {
+	return s.options
+}
+
+func (s *SCEPServer) SigningCA() *x509.Certificate {
+	return s.chain[0]
+}
+
+// RACertificate returns the registration authority certificate, or nil when the CA signs SCEP
+// messages itself.
+func (s *SCEPServer) RACertificate() *x509.Certificate {
+	if s.options.RACertPath == "" {
+		return nil
+	}
+	return s.serviceCert
+}
+
+// NewSCEPChallengeValidator returns the validator for options.ChallengeMode.
+func NewSCEPChallengeValidator(outputDir string, options SCEPServerOptions) (SCEPChallengeValidator, error) {
+	switch options.ChallengeMode {
+	case SCEPChallengeModeStatic:
+		return StaticSCEPChallenge(options.Challenge), nil
+	case SCEPChallengeModeDynamic:
+		return NewSCEPChallengeStore(outputDir), nil
+	case SCEPChallengeModeWebhook:
+		if options.ChallengeWebhook == "" {
+			return nil, fmt.Errorf("a challenge webhook URL is required in webhook mode")
+		}
+		return SCEPChallengeWebhook{URL: options.ChallengeWebhook}, nil
+	case SCEPChallengeModeExec:
+		if options.ChallengeExec == "" {
+			return nil, fmt.Errorf("a challenge command is required in exec mode")
+		}
+		return SCEPChallengeCommand{Command: options.ChallengeExec}, nil
+	default:
+		return nil, fmt.Errorf("unknown challenge mode %q (use %s)", options.ChallengeMode, strings.Join(SCEPChallengeModes, ", "))
+	}
+}
+
+// scepEventValidator reports refused challenges.
+type scepEventValidator struct {
+	next    SCEPChallengeValidator
+	onEvent func(SCEPEvent)
+	logger  log.Logger
+}
+
+func (v scepEventValidator) Validate(ctx context.Context, password string, csr *x509.CertificateRequest) error {
+	if err := v.next.Validate(ctx, password, csr); err != nil {
+		_ = v.logger.Log("component", "challenge", "subject", csr.Subject.String(), "err", err)
+		v.onEvent(SCEPEvent{Time: time.Now(), Action: SCEPEventRejected, Subject: csr.Subject.String(), Detail: err.Error(), Source: scepSource(ctx)})
+		return err
+	}
+	return nil
+}
+
+// scepChallengeSigner validates the challenge password against the CSR before next signs it.
+func scepChallengeSigner(validator SCEPChallengeValidator, next scepserver.CSRSignerContext) scepserver.CSRSignerContextFunc {
+	return func(ctx context.Context, m *scep.CSRReqMessage) (*x509.Certificate, error) {
+		if err := validator.Validate(ctx, m.ChallengePassword, m.CSR); err != nil {
+			return nil, err
+		}
+		return next.SignCSRContext(ctx, m)
+	}
+}
+
+// scepEventSigner reports issued certificates and signing failures.
+func scepEventSigner(next scepserver.CSRSignerContext, onEvent func(SCEPEvent)) scepserver.CSRSignerContextFunc {
+	return func(ctx context.Context, m *scep.CSRReqMessage) (*x509.Certificate, error) {
+		cert, err := next.SignCSRContext(ctx, m)
+		event := SCEPEvent{Time: time.Now(), Action: SCEPEventIssued, Subject: m.CSR.Subject.String(), Source: scepSource(ctx)}
+		switch {
+		case err != nil:
+			event.Action, event.Detail = SCEPEventFailed, err.Error()
+		case cert != nil:
+			event.Detail = "serial " + cert.SerialNumber.Text(16)
+		}
+		onEvent(event)
+		return cert, err
+	}
+}
+
+// loadSCEPRACertificate loads the registration authority certificate and its RSA key. The RA must
+// be issued by a CA of the signing chain so clients can validate it from the GetCACert response.
+func loadSCEPRACertificate(outputDir, certPath string, chain []*x509.Certificate) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certPath, err := ResolveOutputPath(outputDir, certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	raCert, err := LoadCertificate(certPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load RA certificate: %w", err)
+	}
+	raKey, err := LoadCAPrivateKey(PrivateKeyPathForCertificate(certPath))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load RA private key (SCEP requires an RSA key next to the certificate): %w", err)
+	}
+	for _, caCert := range chain {
+		if raCert.CheckSignatureFrom(caCert) == nil {
+			return raCert, raKey, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("RA certificate %q is not issued by the signing CA chain", raCert.Subject.CommonName)
+}
+
+func recordSCEPAudit(outputDir, issuer string, event SCEPEvent, logger log.Logger) {
+	audit := AuditEvent{
+		Actor:  "scep",
+		Source: event.Source,
+		Action: AuditSCEPEnroll,
+		Target: event.Subject,
+		Params: map[string]string{"issuer": issuer, "outcome": event.Action},
+	}
+	switch event.Action {
+	case SCEPEventIssued, SCEPEventQueued:
+		audit.Params["detail"] = event.Detail
+	default:
+		audit.Err = errors.New(event.Detail)
+	}
+	if err := RecordAudit(outputDir, audit); err != nil {
+		_ = logger.Log("component", "audit", "err", err)
+	}
+}
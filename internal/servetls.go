@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultServeTLSValidityDays keeps self-issued dashboard certificates within the
+	// 398-day limit browsers enforce for server certificates.
+	DefaultServeTLSValidityDays = 397
+
+	serveTLSRenewBefore = 30 * 24 * time.Hour
+)
+
+type ServeTLSOptions struct {
+	// CertPath is an explicit server certificate. Certificates after the first PEM block are sent
+	// as its chain; otherwise the chain is built from the output directory when possible.
+	CertPath string
+	// KeyPath defaults to the key stored next to CertPath.
+	KeyPath string
+	// CA issues (or reuses) a certificate for Hosts when CertPath is empty, as root:<name> or
+	// intermediate:<root>:<name>.
+	CA           string
+	Hosts        []string
+	ValidityDays int
+	ClientAuth   string
+	// TrustCertPaths are the CAs client certificates must chain to when ClientAuth is "require".
+	// Defaults to every CA in the output directory.
+	TrustCertPaths []string
+}
+
+type ServeTLS struct {
+	Config   *tls.Config
+	CertPath string
+	// Issued reports whether a new certificate was issued for this run.
+	Issued bool
+	Leaf   *x509.Certificate
+	// Root is the certificate clients must trust: the root of the chain, or the leaf itself when
+	// its issuer is unknown.
+	Root *x509.Certificate
+}
+
+// NewServeTLS prepares the TLS configuration of an HTTPS server from an explicit certificate or
+// from one issued by a cert-helper CA. With ClientAuth "require", handshakes fail unless the
+// client presents a certificate that chains to TrustCertPaths and is not revoked; with "request",
+// the certificate is only asked for and left to the application to verify.
+func NewServeTLS(outputDir string, options ServeTLSOptions) (*ServeTLS, error) {
+	switch options.ClientAuth {
+	case "":
+		options.ClientAuth = ClientAuthNone
+	case ClientAuthNone, ClientAuthRequest, ClientAuthRequire:
+	default:
+		return nil, fmt.Errorf("unsupported client auth mode %q (supported: none, request, require)", options.ClientAuth)
+	}
+
+	result := &ServeTLS{CertPath: options.CertPath}
+	if result.CertPath == "" {
+		certPath, issued, err := EnsureServerCertificate(outputDir, options.CA, options.Hosts, options.ValidityDays)
+		if err != nil {
+			return nil, err
+		}
+		result.CertPath = certPath
+		result.Issued = issued
+	}
+	keyPath := options.KeyPath
+	if keyPath == "" {
+		keyPath = PrivateKeyPathForCertificate(result.CertPath)
+	}
+	certificate, err := tls.LoadX509KeyPair(result.CertPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	result.Leaf = certificate.Leaf
+	result.Root = result.Leaf
+	chain, chainErr := BuildChain(outputDir, result.Leaf)
+	if len(chain) > 0 && chainErr == nil {
+		result.Root = chain[len(chain)-1]
+	}
+	if len(certificate.Certificate) == 1 && chainErr == nil {
+		certificate = tlsCertificate(result.Leaf, chain, certificate.PrivateKey)
+	}
+
+	config, err := baseTLSConfig("", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	config.Certificates = []tls.Certificate{certificate}
+	switch options.ClientAuth {
+	case ClientAuthRequest:
+		config.ClientAuth = tls.RequestClientCert
+	case ClientAuthRequire:
+		verifier, err := newClientCertVerifier(outputDir, options.TrustCertPaths, nil)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAnyClientCert
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifier.verify(rawCerts, nil)
+		}
+	}
+	result.Config = config
+	return result, nil
+}
+
+// EnsureServerCertificate returns a server certificate for hosts issued by the CA selected with
+// caSelector. The certificate left by a previous run is reused while it still covers every host,
+// is not revoked and is more than 30 days away from expiry; otherwise a new one is issued.
+func EnsureServerCertificate(outputDir, caSelector string, hosts []string, validityDays int) (string, bool, error) {
+	if len(hosts) == 0 {
+		return "", false, fmt.Errorf("at least one host is required")
+	}
+	ca, err := FindCA(outputDir, caSelector)
+	if err != nil {
+		return "", false, err
+	}
+	if validityDays <= 0 {
+		validityDays = DefaultServeTLSValidityDays
+	}
+
+	certPath := CertificatePath(outputDir, ca.Type, ca.RootName, ca.Name, hosts[0])
+	if reusableServerCertificate(outputDir, ca, certPath, hosts) {
+		return certPath, false, nil
+	}
+
+	options := DefaultCertificateOptions()
+	options.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	options.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	certPath, _, _, err = GenerateCertificateWithOptions(outputDir, ca.Type, ca.RootName, ca.Name, Subject{CommonName: hosts[0]}, hosts[1:], validityDays, "", options)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to issue server certificate: %w", err)
+	}
+	return certPath, true, nil
+}
+
+func reusableServerCertificate(outputDir string, ca CAInfo, certPath string, hosts []string) bool {
+	if _, err := os.Stat(PrivateKeyPathForCertificate(certPath)); err != nil {
+		return false
+	}
+	cert, err := LoadCertificate(certPath)
+	if err != nil {
+		return false
+	}
+	if cert.CheckSignatureFrom(ca.Cert) != nil || !hasExtKeyUsage(cert, x509.ExtKeyUsageServerAuth) {
+		return false
+	}
+	if time.Until(cert.NotAfter) < serveTLSRenewBefore || time.Now().Before(cert.NotBefore) {
+		return false
+	}
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return !IsCertificateRevoked(outputDir, cert)
+}
+
+// ServeTLSHosts returns the names a server certificate for the listen host must cover. Wildcard
+// and loopback listen addresses also get the machine name and the loopback addresses.
+func ServeTLSHosts(listenHost string) []string {
+	listenHost = strings.Trim(strings.TrimSpace(listenHost), "[]")
+	var hosts []string
+	add := func(values ...string) {
+		for _, value := range values {
+			if value != "" && !containsFold(hosts, value) {
+				hosts = append(hosts, value)
+			}
+		}
+	}
+	ip := net.ParseIP(listenHost)
+	switch {
+	case listenHost == "" || (ip != nil && ip.IsUnspecified()):
+		if name, err := os.Hostname(); err == nil {
+			add(strings.ToLower(name))
+		}
+		add("localhost", "127.0.0.1", "::1")
+	case strings.EqualFold(listenHost, "localhost") || (ip != nil && ip.IsLoopback()):
+		add(listenHost, "localhost", "127.0.0.1", "::1")
+	default:
+		add(listenHost)
+	}
+	return hosts
+}
+
+func containsFold(values []string, value string) bool {
+	for _, existing := range values {
+		if strings.EqualFold(existing, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of cert as colon-separated hex bytes,
+// the form browsers and openssl display.
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return formatHexBytes(sum[:])
+}
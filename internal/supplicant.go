@@ -0,0 +1,475 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/smallstep/pkcs7"
+)
+
+const (
+	SupplicantWPA            = "wpa_supplicant"
+	SupplicantNetworkManager = "networkmanager"
+	SupplicantMobileConfig   = "mobileconfig"
+)
+
+// SupplicantFormats lists the supported supplicant configuration formats.
+var SupplicantFormats = []string{
+	SupplicantWPA,
+	SupplicantNetworkManager,
+	SupplicantMobileConfig,
+}
+
+type SupplicantOptions struct {
+	Format      string
+	SSID        string
+	ServerNames []string
+	Identity    string
+	// Password protects the embedded PKCS#12 bundle (NetworkManager and mobileconfig).
+	Password    string
+	PFXEncoding string
+	// SignerCertPath optionally selects a certificate with a private key that signs the mobileconfig.
+	SignerCertPath string
+}
+
+func NormalizeSupplicantFormat(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case SupplicantWPA, "wpa", "wpa-supplicant":
+		return SupplicantWPA, nil
+	case SupplicantNetworkManager, "nm", "nmconnection", "network-manager":
+		return SupplicantNetworkManager, nil
+	case SupplicantMobileConfig, "apple", "ios", "macos":
+		return SupplicantMobileConfig, nil
+	default:
+		return "", fmt.Errorf("unsupported supplicant format %q (supported: %s)", value, strings.Join(SupplicantFormats, ", "))
+	}
+}
+
+type supplicantMaterial struct {
+	cert       *x509.Certificate
+	chain      []*x509.Certificate
+	root       *x509.Certificate
+	privateKey any
+	identity   string
+	baseName   string
+}
+
+// ExportSupplicantConfig renders a self-contained EAP-TLS Wi-Fi configuration for the client
+// certificate at certPath, embedding the certificate, key and trusted root.
+func ExportSupplicantConfig(outputDir, certPath string, options SupplicantOptions) (ExportResult, error) {
+	format, err := NormalizeSupplicantFormat(options.Format)
+	if err != nil {
+		return ExportResult{}, err
+	}
+	if strings.TrimSpace(options.SSID) == "" {
+		return ExportResult{}, fmt.Errorf("SSID is required")
+	}
+	if _, err := NormalizePFXEncoding(options.PFXEncoding); err != nil {
+		return ExportResult{}, err
+	}
+
+	material, err := loadSupplicantMaterial(outputDir, certPath, options.Identity)
+	if err != nil {
+		return ExportResult{}, err
+	}
+	if !hasExtKeyUsage(material.cert, x509.ExtKeyUsageClientAuth) {
+		return ExportResult{}, fmt.Errorf("%s lacks the clientAuth extended key usage required for EAP-TLS", material.cert.Subject.CommonName)
+	}
+
+	switch format {
+	case SupplicantWPA:
+		return wpaSupplicantConfig(material, options)
+	case SupplicantNetworkManager:
+		return networkManagerConfig(material, options)
+	default:
+		return appleMobileConfig(outputDir, material, options)
+	}
+}
+
+func loadSupplicantMaterial(outputDir, certPath, identity string) (supplicantMaterial, error) {
+	cert, err := LoadCertificate(certPath)
+	if err != nil {
+		return supplicantMaterial{}, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	if cert.IsCA {
+		return supplicantMaterial{}, fmt.Errorf("%s is a CA certificate and cannot be used as a client certificate", cert.Subject.CommonName)
+	}
+	chain, err := BuildChain(outputDir, cert)
+	if err != nil {
+		return supplicantMaterial{}, fmt.Errorf("failed to build certificate chain: %w", err)
+	}
+	if len(chain) == 0 {
+		return supplicantMaterial{}, fmt.Errorf("%s is self-signed and cannot be used as a client certificate", cert.Subject.CommonName)
+	}
+	privateKey, err := LoadPrivateKey(PrivateKeyPathForCertificate(certPath))
+	if err != nil {
+		return supplicantMaterial{}, fmt.Errorf("private key is not available for %s: %w", cert.Subject.CommonName, err)
+	}
+	if identity == "" {
+		identity = cert.Subject.CommonName
+	}
+	return supplicantMaterial{
+		cert:       cert,
+		chain:      chain,
+		root:       chain[len(chain)-1],
+		privateKey: privateKey,
+		identity:   identity,
+		baseName:   strings.TrimSuffix(filepath.Base(certPath), filepath.Ext(certPath)),
+	}, nil
+}
+
+func wpaSupplicantConfig(material supplicantMaterial, options SupplicantOptions) (ExportResult, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(material.privateKey)
+	if err != nil {
+		return ExportResult{}, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by cert-helper: EAP-TLS network for %s\n", wpaQuote(material.identity))
+	b.WriteString("network={\n")
+	fmt.Fprintf(&b, "\tssid=%s\n", wpaQuote(options.SSID))
+	b.WriteString("\tkey_mgmt=WPA-EAP\n")
+	b.WriteString("\teap=TLS\n")
+	fmt.Fprintf(&b, "\tidentity=%s\n", wpaQuote(material.identity))
+	b.WriteString("\tca_cert=\"blob://cert-helper-ca\"\n")
+	b.WriteString("\tclient_cert=\"blob://cert-helper-client\"\n")
+	b.WriteString("\tprivate_key=\"blob://cert-helper-key\"\n")
+	if len(options.ServerNames) > 0 {
+		fmt.Fprintf(&b, "\tdomain_suffix_match=%s\n", wpaQuote(strings.Join(options.ServerNames, ";")))
+	}
+	b.WriteString("}\n")
+	writeWPABlob(&b, "cert-helper-ca", material.root.Raw)
+	writeWPABlob(&b, "cert-helper-client", material.cert.Raw)
+	writeWPABlob(&b, "cert-helper-key", keyDER)
+
+	return ExportResult{
+		FileName:    material.baseName + "_wpa_supplicant.conf",
+		ContentType: "text/plain; charset=utf-8",
+		Data:        []byte(b.String()),
+		Private:     true,
+	}, nil
+}
+
+// wpaQuote formats a string value of wpa_supplicant.conf. Values a quoted string cannot hold
+// unchanged, such as ones with quotes or control characters, are written in hex, which
+// wpa_supplicant accepts for the same fields.
+func wpaQuote(value string) string {
+	if strings.ContainsFunc(value, func(r rune) bool { return r == '"' || r < 0x20 || r == 0x7f }) {
+		return hex.EncodeToString([]byte(value))
+	}
+	return `"` + value + `"`
+}
+
+func writeWPABlob(b *strings.Builder, name string, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	fmt.Fprintf(b, "blob-base64-%s={\n", name)
+	for len(encoded) > 64 {
+		b.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	b.WriteString(encoded + "\n}\n")
+}
+
+func networkManagerConfig(material supplicantMaterial, options SupplicantOptions) (ExportResult, error) {
+	if options.Password == "" {
+		return ExportResult{}, fmt.Errorf("NetworkManager requires a password for the embedded PKCS#12 key")
+	}
+	// Keyfile values cannot span lines, and domain-suffix-match separates names with ";".
+	for _, field := range []struct{ name, value string }{
+		{"SSID", options.SSID},
+		{"identity", material.identity},
+		{"password", options.Password},
+	} {
+		if strings.ContainsAny(field.value, "\r\n") {
+			return ExportResult{}, fmt.Errorf("the %s of a NetworkManager profile cannot contain line breaks", field.name)
+		}
+	}
+	for _, serverName := range options.ServerNames {
+		if strings.ContainsAny(serverName, ";\r\n") {
+			return ExportResult{}, fmt.Errorf("invalid server name %q", serverName)
+		}
+	}
+	encoding, err := NormalizePFXEncoding(options.PFXEncoding)
+	if err != nil {
+		return ExportResult{}, err
+	}
+	pfxData, err := EncodePFX(material.privateKey, material.cert, material.chain[:len(material.chain)-1], options.Password, material.identity, encoding)
+	if err != nil {
+		return ExportResult{}, err
+	}
+	uuid, err := randomUUID()
+	if err != nil {
+		return ExportResult{}, err
+	}
+
+	pfxBlob := "data:;base64," + base64.StdEncoding.EncodeToString(pfxData)
+	var b strings.Builder
+	b.WriteString("[connection]\n")
+	fmt.Fprintf(&b, "id=%s\n", keyfileEscape(options.SSID))
+	fmt.Fprintf(&b, "uuid=%s\n", uuid)
+	b.WriteString("type=wifi\n\n")
+	b.WriteString("[wifi]\n")
+	b.WriteString("mode=infrastructure\n")
+	fmt.Fprintf(&b, "ssid=%s\n\n", keyfileEscape(options.SSID))
+	b.WriteString("[wifi-security]\n")
+	b.WriteString("key-mgmt=wpa-eap\n\n")
+	b.WriteString("[802-1x]\n")
+	b.WriteString("eap=tls;\n")
+	fmt.Fprintf(&b, "identity=%s\n", keyfileEscape(material.identity))
+	fmt.Fprintf(&b, "ca-cert=data:;base64,%s\n", base64.StdEncoding.EncodeToString(encodeCertificatesPEM([]*x509.Certificate{material.root})))
+	fmt.Fprintf(&b, "client-cert=%s\n", pfxBlob)
+	fmt.Fprintf(&b, "private-key=%s\n", pfxBlob)
+	fmt.Fprintf(&b, "private-key-password=%s\n", keyfileEscape(options.Password))
+	if len(options.ServerNames) > 0 {
+		fmt.Fprintf(&b, "domain-suffix-match=%s\n", keyfileEscape(strings.Join(options.ServerNames, ";")))
+	}
+	b.WriteString("\n[ipv4]\nmethod=auto\n\n[ipv6]\nmethod=auto\n")
+
+	return ExportResult{
+		FileName:    material.baseName + "_" + NormalizeName(options.SSID, "wifi") + ".nmconnection",
+		ContentType: "text/plain; charset=utf-8",
+		Data:        []byte(b.String()),
+		PFXEncoding: encoding,
+		Private:     true,
+	}, nil
+}
+
+// keyfileEscape escapes a string value of a GKeyFile, the format of NetworkManager keyfiles. Line
+// breaks are rejected by the caller.
+func keyfileEscape(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, "\t", `\t`).Replace(value)
+	if strings.HasPrefix(value, " ") {
+		// Leading spaces are trimmed unless escaped.
+		value = `\s` + value[1:]
+	}
+	return value
+}
+
+func appleMobileConfig(outputDir string, material supplicantMaterial, options SupplicantOptions) (ExportResult, error) {
+	encoding, err := NormalizePFXEncoding(options.PFXEncoding)
+	if err != nil {
+		return ExportResult{}, err
+	}
+	pfxData, err := EncodePFX(material.privateKey, material.cert, nil, options.Password, material.identity, encoding)
+	if err != nil {
+		return ExportResult{}, err
+	}
+
+	profileUUID, err := randomUUID()
+	if err != nil {
+		return ExportResult{}, err
+	}
+	pfxUUID, err := randomUUID()
+	if err != nil {
+		return ExportResult{}, err
+	}
+	wifiUUID, err := randomUUID()
+	if err != nil {
+		return ExportResult{}, err
+	}
+	identifier := "cert-helper." + NormalizeName(options.SSID, "wifi")
+
+	pfxPayload := plistDict{
+		{"PayloadType", "com.apple.security.pkcs12"},
+		{"PayloadVersion", 1},
+		{"PayloadIdentifier", identifier + ".identity"},
+		{"PayloadUUID", pfxUUID},
+		{"PayloadDisplayName", material.identity},
+		{"PayloadCertificateFileName", material.baseName + ".pfx"},
+		{"PayloadContent", pfxData},
+	}
+	if options.Password != "" {
+		pfxPayload = append(pfxPayload, plistEntry{"Password", options.Password})
+	}
+
+	payloads := []any{pfxPayload}
+	var anchorUUIDs []any
+	for i, caCert := range material.chain {
+		caUUID, err := randomUUID()
+		if err != nil {
+			return ExportResult{}, err
+		}
+		payloadType := "com.apple.security.pkcs1"
+		if caCert == material.root {
+			payloadType = "com.apple.security.root"
+		}
+		payloads = append(payloads, plistDict{
+			{"PayloadType", payloadType},
+			{"PayloadVersion", 1},
+			{"PayloadIdentifier", fmt.Sprintf("%s.ca%d", identifier, i)},
+			{"PayloadUUID", caUUID},
+			{"PayloadDisplayName", caCert.Subject.CommonName},
+			{"PayloadCertificateFileName", NormalizeName(caCert.Subject.CommonName, "ca") + ".cer"},
+			{"PayloadContent", caCert.Raw},
+		})
+		anchorUUIDs = append(anchorUUIDs, caUUID)
+	}
+
+	eapConfig := plistDict{
+		{"AcceptEAPTypes", []any{13}},
+		{"UserName", material.identity},
+		{"PayloadCertificateAnchorUUID", anchorUUIDs},
+	}
+	if len(options.ServerNames) > 0 {
+		var names []any
+		for _, name := range options.ServerNames {
+			names = append(names, name)
+		}
+		eapConfig = append(eapConfig, plistEntry{"TLSTrustedServerNames", names})
+	}
+	payloads = append(payloads, plistDict{
+		{"PayloadType", "com.apple.wifi.managed"},
+		{"PayloadVersion", 1},
+		{"PayloadIdentifier", identifier + ".wifi"},
+		{"PayloadUUID", wifiUUID},
+		{"PayloadDisplayName", "Wi-Fi (" + options.SSID + ")"},
+		{"SSID_STR", options.SSID},
+		{"HIDDEN_NETWORK", false},
+		{"AutoJoin", true},
+		{"EncryptionType", "WPA2"},
+		{"PayloadCertificateUUID", pfxUUID},
+		{"EAPClientConfiguration", eapConfig},
+	})
+
+	profile := plistDict{
+		{"PayloadType", "Configuration"},
+		{"PayloadVersion", 1},
+		{"PayloadIdentifier", identifier},
+		{"PayloadUUID", profileUUID},
+		{"PayloadDisplayName", options.SSID + " EAP-TLS (cert-helper)"},
+		{"PayloadOrganization", "cert-helper"},
+		{"PayloadRemovalDisallowed", false},
+		{"PayloadContent", payloads},
+	}
+	data, err := encodePlist(profile)
+	if err != nil {
+		return ExportResult{}, err
+	}
+
+	if options.SignerCertPath != "" {
+		data, err = signMobileConfig(outputDir, options.SignerCertPath, data)
+		if err != nil {
+			return ExportResult{}, err
+		}
+	}
+
+	return ExportResult{
+		FileName:    material.baseName + "_" + NormalizeName(options.SSID, "wifi") + ".mobileconfig",
+		ContentType: "application/x-apple-aspen-config",
+		Data:        data,
+		PFXEncoding: encoding,
+		Private:     true,
+	}, nil
+}
+
+// signMobileConfig wraps the profile in a CMS SignedData structure, which Apple devices show as
+// a verified profile when they trust the signer's root.
+func signMobileConfig(outputDir, signerCertPath string, profile []byte) ([]byte, error) {
+	signerCert, err := LoadCertificate(signerCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing certificate: %w", err)
+	}
+	signerKey, err := LoadPrivateKey(PrivateKeyPathForCertificate(signerCertPath))
+	if err != nil {
+		return nil, fmt.Errorf("signing certificate has no private key: %w", err)
+	}
+	chain, err := BuildChain(outputDir, signerCert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build signing chain: %w", err)
+	}
+	signed, err := pkcs7.NewSignedData(profile)
+	if err != nil {
+		return nil, err
+	}
+	signed.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := signed.AddSigner(signerCert, signerKey, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	for _, cert := range chain {
+		signed.AddCertificate(cert)
+	}
+	return signed.Finish()
+}
+
+func randomUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])), nil
+}
+
+type plistEntry struct {
+	key   string
+	value any
+}
+
+// plistDict keeps keys in insertion order so generated profiles are stable and readable.
+type plistDict []plistEntry
+
+func encodePlist(root plistDict) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	buf.WriteString(`<plist version="1.0">` + "\n")
+	if err := writePlistValue(&buf, root, 0); err != nil {
+		return nil, err
+	}
+	buf.WriteString("</plist>\n")
+	return buf.Bytes(), nil
+}
+
+func writePlistValue(buf *bytes.Buffer, value any, depth int) error {
+	indent := strings.Repeat("\t", depth)
+	switch v := value.(type) {
+	case plistDict:
+		buf.WriteString(indent + "<dict>\n")
+		for _, entry := range v {
+			buf.WriteString(indent + "\t<key>")
+			if err := xml.EscapeText(buf, []byte(entry.key)); err != nil {
+				return err
+			}
+			buf.WriteString("</key>\n")
+			if err := writePlistValue(buf, entry.value, depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(indent + "</dict>\n")
+	case []any:
+		buf.WriteString(indent + "<array>\n")
+		for _, item := range v {
+			if err := writePlistValue(buf, item, depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(indent + "</array>\n")
+	case string:
+		buf.WriteString(indent + "<string>")
+		if err := xml.EscapeText(buf, []byte(v)); err != nil {
+			return err
+		}
+		buf.WriteString("</string>\n")
+	case int:
+		fmt.Fprintf(buf, "%s<integer>%d</integer>\n", indent, v)
+	case bool:
+		if v {
+			buf.WriteString(indent + "<true/>\n")
+		} else {
+			buf.WriteString(indent + "<false/>\n")
+		}
+	case []byte:
+		buf.WriteString(indent + "<data>" + base64.StdEncoding.EncodeToString(v) + "</data>\n")
+	default:
+		return fmt.Errorf("unsupported plist value %T", value)
+	}
+	return nil
+}
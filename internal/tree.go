@@ -0,0 +1,294 @@
+package internal
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExpiringSoonDays is how close to its expiry a certificate counts as expiring soon.
+const ExpiringSoonDays = 30
+
+// Expiry states returned by ExpiryStatus.
+const (
+	ExpiryValid    = "valid"
+	ExpiryExpiring = "expiring"
+	ExpiryExpired  = "expired"
+)
+
+// ExpiryStatus classifies a certificate expiring at notAfter as valid, expiring soon or expired at now.
+func ExpiryStatus(notAfter, now time.Time) string {
+	switch {
+	case notAfter.Before(now):
+		return ExpiryExpired
+	case notAfter.Before(now.AddDate(0, 0, ExpiringSoonDays)):
+		return ExpiryExpiring
+	default:
+		return ExpiryValid
+	}
+}
+
+// CertificateFile is a certificate stored in the output directory.
+type CertificateFile struct {
+	// Path is slash-separated and relative to the output directory.
+	Path        string
+	FullPath    string
+	Certificate *x509.Certificate
+}
+
+// LoadCertificateFiles parses every PEM certificate file in the output directory, in lexical
+// order. Bundles, files recorded as exports and files that do not start with a certificate are
+// skipped.
+func LoadCertificateFiles(outputDir string) ([]CertificateFile, error) {
+	inventory, err := LoadInventory(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	var files []CertificateFile
+	err = filepath.WalkDir(outputDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		file, ok := LoadCertificateFile(outputDir, filePath)
+		if ok && inventory[file.Path].Kind != InventoryKindExport {
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// LoadCertificateFile parses the certificate stored in filePath, reporting false for files that are
+// not PEM files starting with a certificate and for bundles of several certificates, such as
+// exported chains. Files recorded as exports in the inventory are not checked here.
+func LoadCertificateFile(outputDir, filePath string) (CertificateFile, bool) {
+	if strings.ToLower(filepath.Ext(filePath)) != ".pem" {
+		return CertificateFile{}, false
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return CertificateFile{}, false
+	}
+	block, rest := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return CertificateFile{}, false
+	}
+	for next, remaining := pem.Decode(rest); next != nil; next, remaining = pem.Decode(remaining) {
+		if next.Type == "CERTIFICATE" {
+			return CertificateFile{}, false
+		}
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return CertificateFile{}, false
+	}
+	relPath, err := filepath.Rel(outputDir, filePath)
+	if err != nil {
+		return CertificateFile{}, false
+	}
+	return CertificateFile{Path: filepath.ToSlash(relPath), FullPath: filePath, Certificate: cert}, true
+}
+
+// CertificateTree is the CA hierarchy of the output directory. Certificates are placed under the
+// CA whose key signed them, found through the authority and subject key identifiers, so the
+// folder a certificate is stored in does not matter.
+type CertificateTree struct {
+	// Roots holds the self-signed certificates, followed by certificates whose issuer is not in
+	// the output directory.
+	Roots         []*CertificateTreeNode
+	RootCAs       int
+	Intermediates int
+	Leaves        int
+	Orphans       int
+	Valid         int
+	Expiring      int
+	Expired       int
+}
+
+// CertificateTreeNode is one certificate of a CertificateTree.
+type CertificateTreeNode struct {
+	Name        string
+	Path        string
+	Certificate *x509.Certificate
+	KeyType     string
+	// Status is the expiry state returned by ExpiryStatus.
+	Status string
+	// Orphan marks a certificate that is neither self-signed nor signed by a CA of the output directory.
+	Orphan   bool
+	Children []*CertificateTreeNode
+	// Intermediates and Leaves count every CA and end-entity certificate below the node.
+	Intermediates int
+	Leaves        int
+}
+
+// Kind describes the position of the certificate in the hierarchy.
+func (n *CertificateTreeNode) Kind() string {
+	switch {
+	case !n.Certificate.IsCA:
+		return "Certificate"
+	case n.Orphan || !isSelfSigned(n.Certificate):
+		return "Intermediate CA"
+	default:
+		return "Root CA"
+	}
+}
+
+// BuildCertificateTree loads every certificate of the output directory and arranges them by issuer.
+// A certificate cross-signed by several CAs appears once per issuing certificate file; certificates
+// it signed are placed under the first of them.
+func BuildCertificateTree(outputDir string) (CertificateTree, error) {
+	files, err := LoadCertificateFiles(outputDir)
+	if err != nil {
+		return CertificateTree{}, err
+	}
+	return buildCertificateTree(files, time.Now()), nil
+}
+
+func buildCertificateTree(files []CertificateFile, now time.Time) CertificateTree {
+	var nodes []*CertificateTreeNode
+	seen := make(map[string]bool)
+	bySubjectKeyID := make(map[string][]*CertificateTreeNode)
+	bySubject := make(map[string][]*CertificateTreeNode)
+	for _, file := range files {
+		cert := file.Certificate
+		if seen[string(cert.Raw)] {
+			continue
+		}
+		seen[string(cert.Raw)] = true
+		name := cert.Subject.CommonName
+		if name == "" {
+			name = filepath.Base(file.FullPath)
+		}
+		node := &CertificateTreeNode{
+			Name:        name,
+			Path:        file.Path,
+			Certificate: cert,
+			KeyType:     DescribePublicKey(cert),
+			Status:      ExpiryStatus(cert.NotAfter, now),
+		}
+		nodes = append(nodes, node)
+		if cert.IsCA {
+			if len(cert.SubjectKeyId) > 0 {
+				bySubjectKeyID[string(cert.SubjectKeyId)] = append(bySubjectKeyID[string(cert.SubjectKeyId)], node)
+			}
+			bySubject[string(cert.RawSubject)] = append(bySubject[string(cert.RawSubject)], node)
+		}
+	}
+
+	parents := make(map[*CertificateTreeNode]*CertificateTreeNode)
+	var tree CertificateTree
+	for _, node := range nodes {
+		cert := node.Certificate
+		if isSelfSigned(cert) {
+			tree.Roots = append(tree.Roots, node)
+			continue
+		}
+		var candidates []*CertificateTreeNode
+		if len(cert.AuthorityKeyId) > 0 {
+			candidates = bySubjectKeyID[string(cert.AuthorityKeyId)]
+		}
+		if len(candidates) == 0 {
+			candidates = bySubject[string(cert.RawIssuer)]
+		}
+		var parent *CertificateTreeNode
+		for _, candidate := range candidates {
+			if candidate == node || isTreeAncestor(parents, node, candidate) {
+				continue
+			}
+			if cert.CheckSignatureFrom(candidate.Certificate) == nil {
+				parent = candidate
+				break
+			}
+		}
+		if parent == nil {
+			node.Orphan = true
+			tree.Roots = append(tree.Roots, node)
+			continue
+		}
+		parents[node] = parent
+		parent.Children = append(parent.Children, node)
+	}
+
+	sortTreeNodes(tree.Roots)
+	for _, root := range tree.Roots {
+		countTreeNode(root)
+		tree.countNode(root)
+	}
+	return tree
+}
+
+// isTreeAncestor reports whether node is candidate or one of its issuers, which would make a cycle.
+func isTreeAncestor(parents map[*CertificateTreeNode]*CertificateTreeNode, node, candidate *CertificateTreeNode) bool {
+	for current := candidate; current != nil; current = parents[current] {
+		if current == node {
+			return true
+		}
+	}
+	return false
+}
+
+// sortTreeNodes orders self-signed certificates before orphans, CAs before end-entity
+// certificates, and then by name.
+func sortTreeNodes(nodes []*CertificateTreeNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].Orphan != nodes[j].Orphan {
+			return !nodes[i].Orphan
+		}
+		if nodes[i].Certificate.IsCA != nodes[j].Certificate.IsCA {
+			return nodes[i].Certificate.IsCA
+		}
+		return strings.ToLower(nodes[i].Name) < strings.ToLower(nodes[j].Name)
+	})
+	for _, node := range nodes {
+		sortTreeNodes(node.Children)
+	}
+}
+
+func countTreeNode(node *CertificateTreeNode) {
+	for _, child := range node.Children {
+		countTreeNode(child)
+		if child.Certificate.IsCA {
+			node.Intermediates++
+		} else {
+			node.Leaves++
+		}
+		node.Intermediates += child.Intermediates
+		node.Leaves += child.Leaves
+	}
+}
+
+func (t *CertificateTree) countNode(node *CertificateTreeNode) {
+	switch node.Kind() {
+	case "Root CA":
+		t.RootCAs++
+	case "Intermediate CA":
+		t.Intermediates++
+	default:
+		t.Leaves++
+	}
+	if node.Orphan {
+		t.Orphans++
+	}
+	switch node.Status {
+	case ExpiryExpired:
+		t.Expired++
+	case ExpiryExpiring:
+		t.Expiring++
+	default:
+		t.Valid++
+	}
+	for _, child := range node.Children {
+		t.countNode(child)
+	}
+}
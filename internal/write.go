@@ -8,8 +8,6 @@ import (
 	"encoding/pem"
 	"errors"
 	"os"
-
-	"software.sslmate.com/src/go-pkcs12"
 )
 
 func WriteCertificatePEM(filename string, certDER []byte) error {
@@ -83,16 +81,8 @@ func pemBlockForPrivateKey(privateKey crypto.PrivateKey) (*pem.Block, error) {
 	}
 }
 
-func WritePFX(filename string, privateKey crypto.PrivateKey, cert *x509.Certificate, password string) error {
-	var pfxData []byte
-	var err error
-
-	if password != "" {
-		pfxData, err = pkcs12.Legacy.Encode(privateKey, cert, nil, password)
-	} else {
-		pfxData, err = pkcs12.Legacy.Encode(privateKey, cert, nil, "")
-	}
-
+func WritePFX(filename string, privateKey crypto.PrivateKey, cert *x509.Certificate, caCerts []*x509.Certificate, password, encoding string) error {
+	pfxData, err := EncodePFX(privateKey, cert, caCerts, password, cert.Subject.CommonName, encoding)
 	if err != nil {
 		return err
 	}